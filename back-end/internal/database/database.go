@@ -49,6 +49,17 @@ import (
 
 var db *sql.DB
 
+// Customer phone numbers and emails are encrypted with a key held by the
+// handlers package, which sets these so migrations and the reminder job can
+// read them.
+var (
+	// DecryptField decrypts an encrypted customer field.
+	DecryptField func(ciphertext []byte) (string, error)
+	// ContactHash is the keyed hash of a phone number ("sms") or email
+	// ("email") kept in phone_hash or email_hash, or "" if it isn't valid.
+	ContactHash func(channel, contact string) string
+)
+
 // InitDB initializes the database connection and runs all migrations.
 func InitDB(filepath string) (*sql.DB, error) {
 	var err error
@@ -122,21 +133,1209 @@ func createTables() error {
 		FOREIGN KEY(customer_id) REFERENCES customers(id)
 	);`
 
-	// Add more tables for services, invoice_items etc. in a full application
+	// Line items and payments recorded against an invoice.
+	createInvoiceItemTableSQL := `
+	CREATE TABLE IF NOT EXISTS invoice_items (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"invoice_id" INTEGER NOT NULL,
+		"description" TEXT NOT NULL,
+		"quantity" REAL NOT NULL DEFAULT 1,
+		"unit_price" REAL NOT NULL,
+		"line_total" REAL NOT NULL,
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id)
+	);`
+
+	createInvoicePaymentTableSQL := `
+	CREATE TABLE IF NOT EXISTS invoice_payments (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"invoice_id" INTEGER NOT NULL,
+		"method" TEXT NOT NULL,
+		"amount" REAL NOT NULL,
+		"reference" TEXT,
+		"paid_at" DATETIME,
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id)
+	);`
+
+	// Receipts sent to customers. view_token backs the secure link option.
+	createInvoiceDeliveryTableSQL := `
+	CREATE TABLE IF NOT EXISTS invoice_deliveries (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"invoice_id" INTEGER NOT NULL,
+		"channel" TEXT NOT NULL,
+		"delivery" TEXT NOT NULL,
+		"recipient" TEXT,
+		"view_token" TEXT UNIQUE,
+		"expires_at" DATETIME,
+		"status" TEXT NOT NULL,
+		"error" TEXT,
+		"created_at" DATETIME,
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id)
+	);`
+
+	// Owner-defined tax rates (e.g. GST 18%) and the service catalogue they
+	// are assigned to.
+	createTaxRateTableSQL := `
+	CREATE TABLE IF NOT EXISTS tax_rates (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"rate" REAL NOT NULL,
+		"active" INTEGER NOT NULL DEFAULT 1,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	createServiceTableSQL := `
+	CREATE TABLE IF NOT EXISTS services (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"price" REAL NOT NULL,
+		"tax_rate_id" INTEGER,
+		"active" INTEGER NOT NULL DEFAULT 1,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(tax_rate_id) REFERENCES tax_rates(id)
+	);`
+
+	// Per-rate tax breakdown of an invoice, snapshotted at creation so later
+	// rate changes don't alter issued invoices.
+	createInvoiceTaxTableSQL := `
+	CREATE TABLE IF NOT EXISTS invoice_taxes (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"invoice_id" INTEGER NOT NULL,
+		"tax_rate_id" INTEGER,
+		"name" TEXT NOT NULL,
+		"rate" REAL NOT NULL,
+		"taxable_amount" REAL NOT NULL,
+		"tax_amount" REAL NOT NULL,
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id)
+	);`
+
+	// Owner-managed promotions. rule is "code" for coupon codes, an
+	// automatic rule such as "birthday_month", or "single_use" for offers
+	// redeemed with codes from promotion_codes.
+	createPromotionTableSQL := `
+	CREATE TABLE IF NOT EXISTS promotions (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"code" TEXT,
+		"rule" TEXT NOT NULL,
+		"kind" TEXT NOT NULL,
+		"value" REAL NOT NULL,
+		"starts_on" DATE,
+		"ends_on" DATE,
+		"min_spend" REAL DEFAULT 0,
+		"max_uses" INTEGER,
+		"max_uses_per_customer" INTEGER,
+		"active" INTEGER NOT NULL DEFAULT 1,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		UNIQUE(owner_id, code)
+	);`
+
+	createPromotionServiceTableSQL := `
+	CREATE TABLE IF NOT EXISTS promotion_services (
+		"promotion_id" INTEGER NOT NULL,
+		"service_id" INTEGER NOT NULL,
+		PRIMARY KEY(promotion_id, service_id),
+		FOREIGN KEY(promotion_id) REFERENCES promotions(id),
+		FOREIGN KEY(service_id) REFERENCES services(id)
+	);`
+
+	createInvoicePromotionTableSQL := `
+	CREATE TABLE IF NOT EXISTS invoice_promotions (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"invoice_id" INTEGER NOT NULL,
+		"promotion_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"code" TEXT,
+		"discount_amount" REAL NOT NULL,
+		"created_at" DATETIME,
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id),
+		FOREIGN KEY(promotion_id) REFERENCES promotions(id)
+	);`
+
+	// Gift cards and their balance ledger. Every change to a card's balance is
+	// written to gift_card_transactions alongside the new balance.
+	createGiftCardTableSQL := `
+	CREATE TABLE IF NOT EXISTS gift_cards (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"code" TEXT NOT NULL UNIQUE,
+		"initial_amount" REAL NOT NULL,
+		"balance" REAL NOT NULL,
+		"customer_id" INTEGER,
+		"invoice_id" INTEGER,
+		"expires_on" DATE,
+		"status" TEXT NOT NULL DEFAULT 'active',
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id)
+	);`
+
+	createGiftCardTransactionTableSQL := `
+	CREATE TABLE IF NOT EXISTS gift_card_transactions (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"gift_card_id" INTEGER NOT NULL,
+		"kind" TEXT NOT NULL,
+		"amount" REAL NOT NULL,
+		"balance_after" REAL NOT NULL,
+		"invoice_id" INTEGER,
+		"customer_id" INTEGER,
+		"note" TEXT,
+		"created_at" DATETIME,
+		FOREIGN KEY(gift_card_id) REFERENCES gift_cards(id)
+	);`
+
+	// Prepaid service packages ("10 blow-dries for the price of 8"). Selling a
+	// package credits the customer with a customer_packages row; each session
+	// used or credited is written to customer_package_transactions.
+	createPackageTableSQL := `
+	CREATE TABLE IF NOT EXISTS packages (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"price" REAL NOT NULL,
+		"sessions" INTEGER NOT NULL,
+		"validity_days" INTEGER NOT NULL,
+		"active" INTEGER NOT NULL DEFAULT 1,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	createPackageServiceTableSQL := `
+	CREATE TABLE IF NOT EXISTS package_services (
+		"package_id" INTEGER NOT NULL,
+		"service_id" INTEGER NOT NULL,
+		PRIMARY KEY(package_id, service_id),
+		FOREIGN KEY(package_id) REFERENCES packages(id),
+		FOREIGN KEY(service_id) REFERENCES services(id)
+	);`
+
+	createCustomerPackageTableSQL := `
+	CREATE TABLE IF NOT EXISTS customer_packages (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"package_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"sessions_total" INTEGER NOT NULL,
+		"sessions_remaining" INTEGER NOT NULL,
+		"expires_on" DATE,
+		"invoice_id" INTEGER,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(package_id) REFERENCES packages(id),
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id)
+	);`
+
+	createCustomerPackageTransactionTableSQL := `
+	CREATE TABLE IF NOT EXISTS customer_package_transactions (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"customer_package_id" INTEGER NOT NULL,
+		"kind" TEXT NOT NULL,
+		"sessions" INTEGER NOT NULL,
+		"remaining_after" INTEGER NOT NULL,
+		"invoice_id" INTEGER,
+		"service_id" INTEGER,
+		"note" TEXT,
+		"created_at" DATETIME,
+		FOREIGN KEY(customer_package_id) REFERENCES customer_packages(id)
+	);`
+
+	// Membership plans and customer subscriptions. interval_unit is "week",
+	// "month" or "year"; next_billing_on is when the billing job raises the
+	// next invoice.
+	createMembershipPlanTableSQL := `
+	CREATE TABLE IF NOT EXISTS membership_plans (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"price" REAL NOT NULL,
+		"interval_unit" TEXT NOT NULL,
+		"interval_count" INTEGER NOT NULL DEFAULT 1,
+		"discount_percent" REAL NOT NULL DEFAULT 0,
+		"tax_rate_id" INTEGER,
+		"active" INTEGER NOT NULL DEFAULT 1,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(tax_rate_id) REFERENCES tax_rates(id)
+	);`
+
+	// Services included in a plan. A NULL quantity means unlimited use in
+	// each billing period.
+	createMembershipPlanServiceTableSQL := `
+	CREATE TABLE IF NOT EXISTS membership_plan_services (
+		"plan_id" INTEGER NOT NULL,
+		"service_id" INTEGER NOT NULL,
+		"quantity" INTEGER,
+		PRIMARY KEY(plan_id, service_id),
+		FOREIGN KEY(plan_id) REFERENCES membership_plans(id),
+		FOREIGN KEY(service_id) REFERENCES services(id)
+	);`
+
+	createMembershipTableSQL := `
+	CREATE TABLE IF NOT EXISTS memberships (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"plan_id" INTEGER NOT NULL,
+		"status" TEXT NOT NULL DEFAULT 'active',
+		"period_start" DATE,
+		"period_end" DATE,
+		"next_billing_on" DATE,
+		"ends_on" DATE,
+		"paused_at" DATETIME,
+		"cancelled_at" DATETIME,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(plan_id) REFERENCES membership_plans(id)
+	);`
+
+	// Loyalty programme settings, one row per salon. Customers earn
+	// points_per_unit points for each currency unit spent, and each point is
+	// worth point_value when redeemed. expiry_days of 0 means points never
+	// expire.
+	createLoyaltySettingsTableSQL := `
+	CREATE TABLE IF NOT EXISTS loyalty_settings (
+		"owner_id" INTEGER NOT NULL PRIMARY KEY,
+		"enabled" INTEGER NOT NULL DEFAULT 0,
+		"points_per_unit" REAL NOT NULL DEFAULT 0,
+		"point_value" REAL NOT NULL DEFAULT 0,
+		"birthday_bonus" INTEGER NOT NULL DEFAULT 0,
+		"expiry_days" INTEGER NOT NULL DEFAULT 0,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	createLoyaltyServiceBonusTableSQL := `
+	CREATE TABLE IF NOT EXISTS loyalty_service_bonuses (
+		"owner_id" INTEGER NOT NULL,
+		"service_id" INTEGER NOT NULL,
+		"points" INTEGER NOT NULL,
+		PRIMARY KEY(owner_id, service_id),
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(service_id) REFERENCES services(id)
+	);`
+
+	createLoyaltyTierTableSQL := `
+	CREATE TABLE IF NOT EXISTS loyalty_tiers (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"min_points" INTEGER NOT NULL,
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	// The points ledger. Earned rows track how many of their points are still
+	// unspent in "remaining"; redemptions and expiry use up the oldest first.
+	createLoyaltyTransactionTableSQL := `
+	CREATE TABLE IF NOT EXISTS loyalty_transactions (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"kind" TEXT NOT NULL,
+		"points" INTEGER NOT NULL,
+		"remaining" INTEGER NOT NULL DEFAULT 0,
+		"expires_on" DATE,
+		"invoice_id" INTEGER,
+		"note" TEXT,
+		"created_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id)
+	);`
+
+	createStaffTableSQL := `
+	CREATE TABLE IF NOT EXISTS staff (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"email" TEXT,
+		"phone" TEXT,
+		"active" INTEGER NOT NULL DEFAULT 1,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	// Commission rules. A NULL staff_id or category matches everyone or
+	// every category; the most specific matching rule wins. The rate for each
+	// band of a pay period's sales comes from commission_rule_tiers.
+	createCommissionRuleTableSQL := `
+	CREATE TABLE IF NOT EXISTS commission_rules (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"staff_id" INTEGER,
+		"category" TEXT,
+		"active" INTEGER NOT NULL DEFAULT 1,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(staff_id) REFERENCES staff(id)
+	);`
+
+	createCommissionTierTableSQL := `
+	CREATE TABLE IF NOT EXISTS commission_rule_tiers (
+		"rule_id" INTEGER NOT NULL,
+		"min_sales" REAL NOT NULL,
+		"rate" REAL NOT NULL,
+		PRIMARY KEY(rule_id, min_sales),
+		FOREIGN KEY(rule_id) REFERENCES commission_rules(id)
+	);`
+
+	createInvoiceTipTableSQL := `
+	CREATE TABLE IF NOT EXISTS invoice_tips (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"invoice_id" INTEGER NOT NULL,
+		"staff_id" INTEGER NOT NULL,
+		"amount" REAL NOT NULL,
+		"created_at" DATETIME,
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id),
+		FOREIGN KEY(staff_id) REFERENCES staff(id)
+	);`
+
+	// Retail products sold over the counter. stock_on_hand is kept in step
+	// with stock_movements, the ledger of every sale and adjustment.
+	createProductTableSQL := `
+	CREATE TABLE IF NOT EXISTS products (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"sku" TEXT,
+		"barcode" TEXT,
+		"category" TEXT,
+		"cost_price" REAL NOT NULL DEFAULT 0,
+		"retail_price" REAL NOT NULL,
+		"tax_rate_id" INTEGER,
+		"stock_on_hand" INTEGER NOT NULL DEFAULT 0,
+		"low_stock_threshold" INTEGER,
+		"active" INTEGER NOT NULL DEFAULT 1,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		UNIQUE(owner_id, sku),
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(tax_rate_id) REFERENCES tax_rates(id)
+	);`
+
+	createStockMovementTableSQL := `
+	CREATE TABLE IF NOT EXISTS stock_movements (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"product_id" INTEGER NOT NULL,
+		"kind" TEXT NOT NULL,
+		"quantity" INTEGER NOT NULL,
+		"stock_after" INTEGER NOT NULL,
+		"reason" TEXT,
+		"invoice_id" INTEGER,
+		"note" TEXT,
+		"created_at" DATETIME,
+		FOREIGN KEY(product_id) REFERENCES products(id),
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id)
+	);`
+
+	createSupplierTableSQL := `
+	CREATE TABLE IF NOT EXISTS suppliers (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"contact_name" TEXT,
+		"email" TEXT,
+		"phone" TEXT,
+		"address" TEXT,
+		"notes" TEXT,
+		"active" INTEGER NOT NULL DEFAULT 1,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	// Purchase orders move from draft to sent, then to partially_received
+	// and received as stock arrives; draft and sent orders can be cancelled.
+	createPurchaseOrderTableSQL := `
+	CREATE TABLE IF NOT EXISTS purchase_orders (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"supplier_id" INTEGER NOT NULL,
+		"po_number" TEXT,
+		"status" TEXT NOT NULL DEFAULT 'draft',
+		"order_date" DATE NOT NULL,
+		"expected_on" DATE,
+		"notes" TEXT,
+		"total" REAL NOT NULL DEFAULT 0,
+		"sent_at" DATETIME,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(supplier_id) REFERENCES suppliers(id)
+	);`
+
+	createPurchaseOrderItemTableSQL := `
+	CREATE TABLE IF NOT EXISTS purchase_order_items (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"purchase_order_id" INTEGER NOT NULL,
+		"product_id" INTEGER NOT NULL,
+		"quantity_ordered" INTEGER NOT NULL,
+		"quantity_received" INTEGER NOT NULL DEFAULT 0,
+		"unit_cost" REAL NOT NULL,
+		FOREIGN KEY(purchase_order_id) REFERENCES purchase_orders(id),
+		FOREIGN KEY(product_id) REFERENCES products(id)
+	);`
+
+	// Backroom products a service uses up, in the product's unit (e.g. 60 g
+	// of colour), and what was actually used on each invoiced line.
+	createServiceRecipeTableSQL := `
+	CREATE TABLE IF NOT EXISTS service_recipes (
+		"service_id" INTEGER NOT NULL,
+		"product_id" INTEGER NOT NULL,
+		"amount" INTEGER NOT NULL,
+		PRIMARY KEY(service_id, product_id),
+		FOREIGN KEY(service_id) REFERENCES services(id),
+		FOREIGN KEY(product_id) REFERENCES products(id)
+	);`
+
+	createInvoiceItemUsageTableSQL := `
+	CREATE TABLE IF NOT EXISTS invoice_item_usage (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"invoice_item_id" INTEGER NOT NULL,
+		"invoice_id" INTEGER NOT NULL,
+		"product_id" INTEGER NOT NULL,
+		"amount" INTEGER NOT NULL,
+		"unit_cost" REAL NOT NULL,
+		"created_at" DATETIME,
+		FOREIGN KEY(invoice_item_id) REFERENCES invoice_items(id),
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id),
+		FOREIGN KEY(product_id) REFERENCES products(id)
+	);`
+
+	// Opening hours, staff schedules and time off drive the availability
+	// engine. Days of the week are 0 (Sunday) to 6 and clock times are
+	// "HH:MM" in the salon's time zone. A day with no rows is a day off.
+	createSalonHoursTableSQL := `
+	CREATE TABLE IF NOT EXISTS salon_hours (
+		"owner_id" INTEGER NOT NULL,
+		"weekday" INTEGER NOT NULL,
+		"opens" TEXT NOT NULL,
+		"closes" TEXT NOT NULL,
+		PRIMARY KEY(owner_id, weekday, opens),
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	createSalonClosureTableSQL := `
+	CREATE TABLE IF NOT EXISTS salon_closures (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"starts_on" TEXT NOT NULL,
+		"ends_on" TEXT NOT NULL,
+		"reason" TEXT,
+		"created_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	createStaffScheduleTableSQL := `
+	CREATE TABLE IF NOT EXISTS staff_schedules (
+		"staff_id" INTEGER NOT NULL,
+		"weekday" INTEGER NOT NULL,
+		"starts" TEXT NOT NULL,
+		"ends" TEXT NOT NULL,
+		PRIMARY KEY(staff_id, weekday, starts),
+		FOREIGN KEY(staff_id) REFERENCES staff(id)
+	);`
+
+	// Time off and appointment times are "YYYY-MM-DD HH:MM" wall-clock
+	// times in the salon's time zone, kept as TEXT so they compare as
+	// strings and aren't converted by the driver.
+	createStaffTimeOffTableSQL := `
+	CREATE TABLE IF NOT EXISTS staff_time_off (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"staff_id" INTEGER NOT NULL,
+		"kind" TEXT NOT NULL,
+		"starts_at" TEXT NOT NULL,
+		"ends_at" TEXT NOT NULL,
+		"reason" TEXT,
+		"created_at" DATETIME,
+		FOREIGN KEY(staff_id) REFERENCES staff(id)
+	);`
+
+	createAppointmentTableSQL := `
+	CREATE TABLE IF NOT EXISTS appointments (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"staff_id" INTEGER NOT NULL,
+		"service_id" INTEGER NOT NULL,
+		"starts_at" TEXT NOT NULL,
+		"ends_at" TEXT NOT NULL,
+		"buffer_minutes" INTEGER NOT NULL DEFAULT 0,
+		"status" TEXT NOT NULL DEFAULT 'booked',
+		"source" TEXT NOT NULL DEFAULT 'front_desk',
+		"notes" TEXT,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(staff_id) REFERENCES staff(id),
+		FOREIGN KEY(service_id) REFERENCES services(id)
+	);`
+
+	// One-time codes sent to customers booking online. The contact is kept
+	// encrypted like customer records; contact_hash lets requests be
+	// counted per phone number or email for rate limiting.
+	createBookingVerificationTableSQL := `
+	CREATE TABLE IF NOT EXISTS booking_verifications (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"token" TEXT NOT NULL UNIQUE,
+		"channel" TEXT NOT NULL,
+		"contact" BLOB NOT NULL,
+		"contact_hash" TEXT NOT NULL,
+		"code_hash" TEXT NOT NULL,
+		"attempts" INTEGER NOT NULL DEFAULT 0,
+		"ip" TEXT,
+		"expires_at" DATETIME NOT NULL,
+		"verified_at" DATETIME,
+		"customer_id" INTEGER,
+		"appointment_id" INTEGER,
+		"created_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	// Message templates per owner and event (birthday, anniversary and the
+	// appointment messages), with [CustomerName]-style placeholders.
+	createReminderTemplateTableSQL := `
+	CREATE TABLE IF NOT EXISTS reminder_templates (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"event_type" TEXT NOT NULL,
+		"template" TEXT NOT NULL,
+		UNIQUE(owner_id, event_type),
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	// Birthday and anniversary texts sent. One row per customer, event and
+	// year stops the reminder job sending the same greeting twice.
+	createReminderSendTableSQL := `
+	CREATE TABLE IF NOT EXISTS reminder_sends (
+		"customer_id" INTEGER NOT NULL,
+		"event_type" TEXT NOT NULL,
+		"year" INTEGER NOT NULL,
+		"sent_at" DATETIME,
+		PRIMARY KEY(customer_id, event_type, year),
+		FOREIGN KEY(customer_id) REFERENCES customers(id)
+	);`
+
+	// Confirmations and reminders sent for appointments. One row per
+	// appointment, kind and offset stops a message going out twice;
+	// recipient_hash matches SMS replies back to the appointment.
+	createAppointmentNotificationTableSQL := `
+	CREATE TABLE IF NOT EXISTS appointment_notifications (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"appointment_id" INTEGER NOT NULL,
+		"kind" TEXT NOT NULL,
+		"offset_minutes" INTEGER NOT NULL DEFAULT 0,
+		"channel" TEXT,
+		"recipient" TEXT,
+		"recipient_hash" TEXT,
+		"status" TEXT NOT NULL,
+		"error" TEXT,
+		"created_at" DATETIME,
+		UNIQUE(appointment_id, kind, offset_minutes),
+		FOREIGN KEY(appointment_id) REFERENCES appointments(id)
+	);`
+
+	// Customers waiting for a slot: a service, optionally a staff member, and
+	// the window of start times they can make. status is waiting, booked or
+	// cancelled.
+	createWaitlistEntryTableSQL := `
+	CREATE TABLE IF NOT EXISTS waitlist_entries (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"service_id" INTEGER NOT NULL,
+		"staff_id" INTEGER,
+		"earliest" TEXT NOT NULL,
+		"latest" TEXT NOT NULL,
+		"notes" TEXT,
+		"status" TEXT NOT NULL DEFAULT 'waiting',
+		"appointment_id" INTEGER,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(service_id) REFERENCES services(id),
+		FOREIGN KEY(staff_id) REFERENCES staff(id),
+		FOREIGN KEY(appointment_id) REFERENCES appointments(id)
+	);`
+
+	// Time-limited offers of a freed slot to waitlisted customers. The same
+	// slot goes to several entries at once and the first to accept books it;
+	// the rest are marked missed.
+	createWaitlistOfferTableSQL := `
+	CREATE TABLE IF NOT EXISTS waitlist_offers (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"entry_id" INTEGER NOT NULL,
+		"token" TEXT NOT NULL UNIQUE,
+		"staff_id" INTEGER NOT NULL,
+		"starts_at" TEXT NOT NULL,
+		"channel" TEXT,
+		"recipient" TEXT,
+		"recipient_hash" TEXT,
+		"status" TEXT NOT NULL,
+		"error" TEXT,
+		"expires_at" DATETIME NOT NULL,
+		"responded_at" DATETIME,
+		"created_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(entry_id) REFERENCES waitlist_entries(id)
+	);`
+
+	// Recurring appointments. frequency is weekly (every interval_count
+	// weeks) or monthly_weekday (every interval_count months on the same
+	// weekday of the month as the first occurrence, e.g. the 2nd Tuesday).
+	// Occurrences are booked up front as appointments with series_id set,
+	// until ends_on or for occurrences appointments.
+	createAppointmentSeriesTableSQL := `
+	CREATE TABLE IF NOT EXISTS appointment_series (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"service_id" INTEGER NOT NULL,
+		"staff_id" INTEGER,
+		"frequency" TEXT NOT NULL,
+		"interval_count" INTEGER NOT NULL DEFAULT 1,
+		"starts_at" TEXT NOT NULL,
+		"ends_on" TEXT,
+		"occurrences" INTEGER,
+		"notes" TEXT,
+		"status" TEXT NOT NULL DEFAULT 'active',
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(service_id) REFERENCES services(id),
+		FOREIGN KEY(staff_id) REFERENCES staff(id)
+	);`
+
+	// Rooms, stations and equipment that services can need alongside a
+	// stylist. capacity is how many appointments can use one at once.
+	createResourceTableSQL := `
+	CREATE TABLE IF NOT EXISTS resources (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"kind" TEXT NOT NULL DEFAULT 'room',
+		"capacity" INTEGER NOT NULL DEFAULT 1,
+		"active" INTEGER NOT NULL DEFAULT 1,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	createServiceResourceTableSQL := `
+	CREATE TABLE IF NOT EXISTS service_resources (
+		"service_id" INTEGER NOT NULL,
+		"resource_id" INTEGER NOT NULL,
+		"quantity" INTEGER NOT NULL DEFAULT 1,
+		PRIMARY KEY(service_id, resource_id),
+		FOREIGN KEY(service_id) REFERENCES services(id),
+		FOREIGN KEY(resource_id) REFERENCES resources(id)
+	);`
+
+	// Resources reserved by each appointment, kept so later changes to a
+	// service's needs don't affect bookings already made.
+	createAppointmentResourceTableSQL := `
+	CREATE TABLE IF NOT EXISTS appointment_resources (
+		"appointment_id" INTEGER NOT NULL,
+		"resource_id" INTEGER NOT NULL,
+		"quantity" INTEGER NOT NULL DEFAULT 1,
+		PRIMARY KEY(appointment_id, resource_id),
+		FOREIGN KEY(appointment_id) REFERENCES appointments(id),
+		FOREIGN KEY(resource_id) REFERENCES resources(id)
+	);`
+
+	// Walk-in customers queueing at reception. name is shown on the queue
+	// board until a customer record is linked when service starts. status is
+	// waiting, called, in_service, done or left; assigned_staff_id is who
+	// called or is serving them and staff_id who they asked for.
+	createWalkInTableSQL := `
+	CREATE TABLE IF NOT EXISTS walk_ins (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER,
+		"name" TEXT NOT NULL,
+		"service_id" INTEGER NOT NULL,
+		"staff_id" INTEGER,
+		"assigned_staff_id" INTEGER,
+		"appointment_id" INTEGER,
+		"status" TEXT NOT NULL DEFAULT 'waiting',
+		"notes" TEXT,
+		"joined_at" DATETIME NOT NULL,
+		"called_at" DATETIME,
+		"started_at" DATETIME,
+		"finished_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(service_id) REFERENCES services(id),
+		FOREIGN KEY(staff_id) REFERENCES staff(id),
+		FOREIGN KEY(assigned_staff_id) REFERENCES staff(id),
+		FOREIGN KEY(appointment_id) REFERENCES appointments(id)
+	);`
+
+	// Post-visit surveys, one per paid invoice. staff_id is the stylist the
+	// visit is credited to. status is sending, sent, failed, skipped or
+	// answered; score is 0-10.
+	createFeedbackRequestTableSQL := `
+	CREATE TABLE IF NOT EXISTS feedback_requests (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"invoice_id" INTEGER NOT NULL UNIQUE,
+		"customer_id" INTEGER NOT NULL,
+		"staff_id" INTEGER,
+		"channel" TEXT,
+		"recipient" TEXT,
+		"recipient_hash" TEXT,
+		"status" TEXT NOT NULL,
+		"error" TEXT,
+		"score" INTEGER,
+		"comment" TEXT,
+		"expires_at" DATETIME NOT NULL,
+		"responded_at" DATETIME,
+		"alerted_at" DATETIME,
+		"created_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(staff_id) REFERENCES staff(id)
+	);`
+
+	// Codes for single_use promotions, each issued to one customer and
+	// redeemed on at most one invoice.
+	createPromotionCodeTableSQL := `
+	CREATE TABLE IF NOT EXISTS promotion_codes (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"promotion_id" INTEGER NOT NULL,
+		"customer_id" INTEGER,
+		"code" TEXT NOT NULL,
+		"expires_on" DATE,
+		"invoice_id" INTEGER,
+		"used_at" DATETIME,
+		"created_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(promotion_id) REFERENCES promotions(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(invoice_id) REFERENCES invoices(id),
+		UNIQUE(owner_id, code)
+	);`
+
+	// Win-back messages to lapsed customers. last_visit is the date of the
+	// invoice the customer lapsed after, so each lapse is messaged once.
+	// status is sending, sent, failed or skipped.
+	createWinBackMessageTableSQL := `
+	CREATE TABLE IF NOT EXISTS winback_messages (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"last_visit" DATE NOT NULL,
+		"promotion_code_id" INTEGER,
+		"channel" TEXT,
+		"recipient" TEXT,
+		"recipient_hash" TEXT,
+		"status" TEXT NOT NULL,
+		"error" TEXT,
+		"created_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		FOREIGN KEY(promotion_code_id) REFERENCES promotion_codes(id)
+	);`
+
+	// Free-form labels on customers, used to target campaigns.
+	createCustomerTagTableSQL := `
+	CREATE TABLE IF NOT EXISTS customer_tags (
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"tag" TEXT NOT NULL,
+		PRIMARY KEY(customer_id, tag),
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id)
+	);`
+
+	// Broadcast campaigns to a segment of customers. segment is the
+	// JSON-encoded audience filter. status is draft, scheduled, sending, sent
+	// or cancelled.
+	createCampaignTableSQL := `
+	CREATE TABLE IF NOT EXISTS campaigns (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"subject" TEXT,
+		"message" TEXT NOT NULL,
+		"segment" TEXT NOT NULL,
+		"status" TEXT NOT NULL DEFAULT 'draft',
+		"scheduled_at" DATETIME,
+		"started_at" DATETIME,
+		"finished_at" DATETIME,
+		"created_at" DATETIME,
+		"updated_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id)
+	);`
+
+	// The send queue of a campaign, one row per customer. status is queued,
+	// sending, sent, failed, skipped or cancelled.
+	createCampaignRecipientTableSQL := `
+	CREATE TABLE IF NOT EXISTS campaign_recipients (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"campaign_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"channel" TEXT,
+		"recipient" TEXT,
+		"recipient_hash" TEXT,
+		"status" TEXT NOT NULL DEFAULT 'queued',
+		"error" TEXT,
+		"sent_at" DATETIME,
+		FOREIGN KEY(campaign_id) REFERENCES campaigns(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id),
+		UNIQUE(campaign_id, customer_id)
+	);`
+
+	// Each customer's current consent to messages on a channel, sms or
+	// email. status is opted_in or opted_out; customers without a row are
+	// messaged as before. source is where the latest change came from.
+	createCustomerConsentTableSQL := `
+	CREATE TABLE IF NOT EXISTS customer_consents (
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"channel" TEXT NOT NULL,
+		"status" TEXT NOT NULL,
+		"source" TEXT NOT NULL,
+		"updated_at" DATETIME,
+		PRIMARY KEY(customer_id, channel),
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id)
+	);`
+
+	// Every change to a customer's consent, kept for compliance requests.
+	createConsentLogTableSQL := `
+	CREATE TABLE IF NOT EXISTS consent_log (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"owner_id" INTEGER NOT NULL,
+		"customer_id" INTEGER NOT NULL,
+		"channel" TEXT NOT NULL,
+		"status" TEXT NOT NULL,
+		"source" TEXT NOT NULL,
+		"note" TEXT,
+		"created_at" DATETIME,
+		FOREIGN KEY(owner_id) REFERENCES owners(id),
+		FOREIGN KEY(customer_id) REFERENCES customers(id)
+	);`
 
 	log.Println("Creating tables...")
-	if _, err := db.Exec(createOwnerTableSQL); err != nil {
+	for _, stmt := range []string{
+		createOwnerTableSQL,
+		createCustomerTableSQL,
+		createInvoiceTableSQL,
+		createInvoiceItemTableSQL,
+		createInvoicePaymentTableSQL,
+		createInvoiceDeliveryTableSQL,
+		createTaxRateTableSQL,
+		createServiceTableSQL,
+		createInvoiceTaxTableSQL,
+		createPromotionTableSQL,
+		createPromotionServiceTableSQL,
+		createInvoicePromotionTableSQL,
+		createGiftCardTableSQL,
+		createGiftCardTransactionTableSQL,
+		createPackageTableSQL,
+		createPackageServiceTableSQL,
+		createCustomerPackageTableSQL,
+		createCustomerPackageTransactionTableSQL,
+		createMembershipPlanTableSQL,
+		createMembershipPlanServiceTableSQL,
+		createMembershipTableSQL,
+		createLoyaltySettingsTableSQL,
+		createLoyaltyServiceBonusTableSQL,
+		createLoyaltyTierTableSQL,
+		createLoyaltyTransactionTableSQL,
+		createStaffTableSQL,
+		createCommissionRuleTableSQL,
+		createCommissionTierTableSQL,
+		createInvoiceTipTableSQL,
+		createProductTableSQL,
+		createStockMovementTableSQL,
+		createSupplierTableSQL,
+		createPurchaseOrderTableSQL,
+		createPurchaseOrderItemTableSQL,
+		createServiceRecipeTableSQL,
+		createInvoiceItemUsageTableSQL,
+		createSalonHoursTableSQL,
+		createSalonClosureTableSQL,
+		createStaffScheduleTableSQL,
+		createStaffTimeOffTableSQL,
+		createAppointmentTableSQL,
+		createBookingVerificationTableSQL,
+		createReminderTemplateTableSQL,
+		createReminderSendTableSQL,
+		createAppointmentNotificationTableSQL,
+		createWaitlistEntryTableSQL,
+		createWaitlistOfferTableSQL,
+		createAppointmentSeriesTableSQL,
+		createResourceTableSQL,
+		createServiceResourceTableSQL,
+		createAppointmentResourceTableSQL,
+		createWalkInTableSQL,
+		createFeedbackRequestTableSQL,
+		createPromotionCodeTableSQL,
+		createWinBackMessageTableSQL,
+		createCustomerTagTableSQL,
+		createCampaignTableSQL,
+		createCampaignRecipientTableSQL,
+		createCustomerConsentTableSQL,
+		createConsentLogTableSQL,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err := migrateColumns(); err != nil {
 		return err
 	}
-	if _, err := db.Exec(createCustomerTableSQL); err != nil {
+	if err := migrateMarketingOptOut(); err != nil {
 		return err
 	}
-	if _, err := db.Exec(createInvoiceTableSQL); err != nil {
+	// Gift card payments used to keep the whole card code as reference;
+	// only the last 4 characters are kept now.
+	if _, err := db.Exec(`
+		UPDATE invoice_payments SET reference = '****' || substr(replace(reference, '-', ''), -4)
+		WHERE method = 'gift_card' AND reference NOT LIKE '****%'`); err != nil {
 		return err
 	}
 	log.Println("Tables created successfully or already exist.")
 	return nil
 }
+
+// migrateColumns adds columns introduced after a table was first created.
+// New columns on existing tables belong here rather than in the CREATE
+// statements so that older salon.db files pick them up too.
+func migrateColumns() error {
+	columns := []struct{ table, column, definition string }{
+		{"owners", "logo", "BLOB"},
+		{"owners", "invoice_footer", "TEXT"},
+		{"invoices", "invoice_number", "TEXT"},
+		{"invoices", "subtotal", "REAL DEFAULT 0"},
+		{"invoices", "discount_amount", "REAL DEFAULT 0"},
+		{"invoices", "tax_amount", "REAL DEFAULT 0"},
+		{"owners", "prices_include_tax", "INTEGER DEFAULT 0"},
+		{"invoices", "tax_inclusive", "INTEGER DEFAULT 0"},
+		{"invoice_items", "service_id", "INTEGER"},
+		{"invoice_items", "tax_rate_id", "INTEGER"},
+		{"invoice_items", "tax_name", "TEXT"},
+		{"invoice_items", "tax_rate", "REAL DEFAULT 0"},
+		{"invoice_items", "tax_amount", "REAL DEFAULT 0"},
+		{"invoice_items", "customer_package_id", "INTEGER"},
+		{"invoices", "membership_id", "INTEGER"},
+		{"invoice_items", "membership_id", "INTEGER"},
+		{"services", "category", "TEXT"},
+		{"invoice_items", "staff_id", "INTEGER"},
+		{"invoice_items", "net_amount", "REAL"},
+		{"invoices", "tip_amount", "REAL DEFAULT 0"},
+		{"invoice_items", "product_id", "INTEGER"},
+		{"products", "supplier_id", "INTEGER"},
+		{"products", "unit", "TEXT"},
+		{"products", "backroom", "INTEGER DEFAULT 0"},
+		{"owners", "timezone", "TEXT"},
+		{"owners", "slot_interval", "INTEGER DEFAULT 15"},
+		{"services", "duration_minutes", "INTEGER DEFAULT 30"},
+		{"services", "buffer_minutes", "INTEGER DEFAULT 0"},
+		{"services", "online_booking", "INTEGER DEFAULT 0"},
+		{"owners", "booking_slug", "TEXT"},
+		{"owners", "online_booking", "INTEGER DEFAULT 0"},
+		{"owners", "booking_notice_hours", "INTEGER DEFAULT 2"},
+		{"owners", "booking_window_days", "INTEGER DEFAULT 60"},
+		{"owners", "phone_country_code", "TEXT"},
+		{"customers", "phone_hash", "TEXT"},
+		{"customers", "email_hash", "TEXT"},
+		{"owners", "appointment_confirmations", "INTEGER DEFAULT 1"},
+		{"owners", "reminder_offsets", "TEXT DEFAULT '1440,120'"},
+		{"appointments", "confirmed_at", "DATETIME"},
+		{"owners", "cancel_window_hours", "INTEGER DEFAULT 24"},
+		{"owners", "late_cancel_fee", "REAL DEFAULT 0"},
+		{"owners", "no_show_fee", "REAL DEFAULT 0"},
+		{"owners", "policy_fee_type", "TEXT DEFAULT 'percent'"},
+		{"owners", "deposit_percent", "REAL DEFAULT 0"},
+		{"owners", "deposit_min_price", "REAL DEFAULT 0"},
+		{"owners", "no_show_flag_after", "INTEGER DEFAULT 2"},
+		{"customers", "requires_deposit", "INTEGER DEFAULT 0"},
+		{"appointments", "deposit_amount", "REAL DEFAULT 0"},
+		{"appointments", "deposit_invoice_id", "INTEGER"},
+		{"appointments", "deposit_used", "REAL DEFAULT 0"},
+		{"appointments", "fee_invoice_id", "INTEGER"},
+		{"owners", "waitlist_offer_minutes", "INTEGER DEFAULT 30"},
+		{"owners", "waitlist_max_offers", "INTEGER DEFAULT 5"},
+		{"appointments", "series_id", "INTEGER"},
+		{"owners", "calendar_token", "TEXT"},
+		{"staff", "calendar_token", "TEXT"},
+		{"staff_time_off", "source_uid", "TEXT"},
+		{"owners", "feedback_requests", "INTEGER DEFAULT 0"},
+		{"owners", "feedback_delay_minutes", "INTEGER DEFAULT 120"},
+		{"owners", "feedback_alert_score", "INTEGER DEFAULT 6"},
+		{"owners", "winback_enabled", "INTEGER DEFAULT 0"},
+		{"owners", "winback_after_days", "INTEGER DEFAULT 90"},
+		{"owners", "winback_daily_limit", "INTEGER DEFAULT 20"},
+		{"owners", "winback_promotion_id", "INTEGER"},
+		{"owners", "winback_code_days", "INTEGER DEFAULT 30"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	for _, index := range []string{
+		"CREATE INDEX IF NOT EXISTS idx_customers_phone_hash ON customers(owner_id, phone_hash)",
+		"CREATE INDEX IF NOT EXISTS idx_customers_email_hash ON customers(owner_id, email_hash)",
+	} {
+		if _, err := db.Exec(index); err != nil {
+			return err
+		}
+	}
+	return backfillContactHashes()
+}
+
+// backfillContactHashes fills in phone_hash and email_hash for customers
+// saved before they existed. Contacts that can't be hashed get "" so they
+// aren't decrypted again on the next start.
+func backfillContactHashes() error {
+	if DecryptField == nil || ContactHash == nil {
+		return nil
+	}
+	type customerContacts struct {
+		id           int64
+		phone, email []byte
+	}
+	rows, err := db.Query("SELECT id, phone, email FROM customers WHERE phone_hash IS NULL OR email_hash IS NULL")
+	if err != nil {
+		return err
+	}
+	var customers []customerContacts
+	for rows.Next() {
+		var c customerContacts
+		if err := rows.Scan(&c.id, &c.phone, &c.email); err != nil {
+			rows.Close()
+			return err
+		}
+		customers = append(customers, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(customers) == 0 {
+		return err
+	}
+
+	hash := func(channel string, encrypted []byte) string {
+		if len(encrypted) == 0 {
+			return ""
+		}
+		plain, err := DecryptField(encrypted)
+		if err != nil || plain == "" {
+			return ""
+		}
+		return ContactHash(channel, plain)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, c := range customers {
+		if _, err := tx.Exec("UPDATE customers SET phone_hash = ?, email_hash = ? WHERE id = ?",
+			hash("sms", c.phone), hash("email", c.email), c.id); err != nil {
+			return err
+		}
+	}
+	log.Printf("Backfilled contact hashes for %d customers.", len(customers))
+	return tx.Commit()
+}
+
+// migrateMarketingOptOut replaces the per-customer marketing opt-out flag
+// that came before customer_consents: flagged customers are opted out of
+// both channels, with the change logged, and the column is dropped.
+func migrateMarketingOptOut() error {
+	found, err := hasColumn("customers", "marketing_opt_out")
+	if err != nil || !found {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	for _, channel := range []string{"sms", "email"} {
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO customer_consents (owner_id, customer_id, channel, status, source, updated_at)
+			SELECT owner_id, id, ?, 'opted_out', 'migrated', ? FROM customers WHERE marketing_opt_out = 1`, channel, now)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO consent_log (owner_id, customer_id, channel, status, source, note, created_at)
+			SELECT owner_id, id, ?, 'opted_out', 'migrated', 'Marketing opt-out', ? FROM customers WHERE marketing_opt_out = 1`,
+			channel, now)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("ALTER TABLE customers DROP COLUMN marketing_opt_out"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// hasColumn reports whether a table has a column.
+func hasColumn(table, column string) (bool, error) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	found := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	return found, rows.Err()
+}
+
+// addColumnIfMissing runs ALTER TABLE ... ADD COLUMN unless the column
+// already exists. SQLite has no ADD COLUMN IF NOT EXISTS.
+func addColumnIfMissing(table, column, definition string) error {
+	found, err := hasColumn(table, column)
+	if err != nil || found {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN \"" + column + "\" " + definition)
+	return err
+}
 func BackupDB() error {
 	// Ensure the backup directory exists
 	if _, err := os.Stat("backup"); os.IsNotExist(err) {
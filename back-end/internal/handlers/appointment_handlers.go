@@ -0,0 +1,616 @@
+// internal/handlers/appointment_handlers.go
+// Handlers for booking appointments into the free slots found by the
+// availability engine, listing them and changing their status.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// errSlotUnavailable is returned by bookAppointment when the requested time
+// has been taken, or was never free.
+var errSlotUnavailable = errors.New("That time is no longer available")
+
+type appointment struct {
+	ID            int64  `json:"id"`
+	CustomerID    int64  `json:"customer_id"`
+	CustomerName  string `json:"customer_name"`
+	StaffID       int64  `json:"staff_id"`
+	StaffName     string `json:"staff_name"`
+	ServiceID     int64  `json:"service_id"`
+	ServiceName   string `json:"service_name"`
+	Start         string `json:"start"`
+	End           string `json:"end"`
+	BufferMinutes int    `json:"buffer_minutes"`
+	Status        string `json:"status"`
+	Source        string `json:"source"`
+	Notes         string `json:"notes"`
+	ConfirmedAt   string `json:"confirmed_at,omitempty"`
+	SeriesID      *int64 `json:"series_id,omitempty"`
+}
+
+// appointmentBooking is a request to book a service for a customer. With no
+// staff member, the first one free at the start time is chosen.
+type appointmentBooking struct {
+	CustomerID int64  `json:"customer_id"`
+	ServiceID  int64  `json:"service_id"`
+	StaffID    int64  `json:"staff_id,omitempty"`
+	Start      string `json:"start"`
+	Notes      string `json:"notes"`
+	SeriesID   int64  `json:"-"`
+}
+
+// bookingSlot is where a booking fits: its start and end, the staff member
+// free then, the cleanup buffer after it and the resources it reserves.
+type bookingSlot struct {
+	start, end time.Time
+	staffID    int64
+	buffer     int
+	needs      []resourceNeed
+}
+
+// findBookingSlot checks a booking against the availability engine,
+// ignoring the appointment exclude when one is being moved. Invalid bookings
+// are reported as invoiceInputError and a taken slot as errSlotUnavailable.
+func findBookingSlot(tx *sql.Tx, ownerID int, sched *salonSchedule, b appointmentBooking, now time.Time, exclude int64) (bookingSlot, error) {
+	var slot bookingSlot
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", b.CustomerID, ownerID).Scan(&count); err != nil {
+		return slot, err
+	}
+	if count == 0 {
+		return slot, invoiceInputError("Customer not found")
+	}
+	_, duration, buffer, err := loadServiceTiming(tx, ownerID, b.ServiceID)
+	if err == sql.ErrNoRows {
+		return slot, invoiceInputError("Service not found")
+	} else if err != nil {
+		return slot, err
+	}
+	start, err := parseSalonTime(b.Start, sched.loc)
+	if err != nil {
+		return slot, invoiceInputError("Invalid start time")
+	}
+	if len(b.Notes) > 500 {
+		return slot, invoiceInputError("Notes are too long (max 500 characters)")
+	}
+	needs, err := loadServiceResources(tx, b.ServiceID)
+	if err != nil {
+		return slot, err
+	}
+
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, sched.loc)
+	slots, err := findAvailableSlots(tx, ownerID, sched, b.StaffID, duration, buffer, needs, day, day, now, exclude)
+	if err != nil {
+		return slot, err
+	}
+	want := start.Format(time.RFC3339)
+	for _, s := range slots {
+		if s.Start == want {
+			slot.staffID = s.StaffID
+			break
+		}
+	}
+	if slot.staffID == 0 {
+		return slot, errSlotUnavailable
+	}
+	slot.start, slot.end, slot.buffer, slot.needs = start, start.Add(time.Duration(duration)*time.Minute), buffer, needs
+	return slot, nil
+}
+
+// bookAppointment checks the booking still fits the availability engine and
+// stores it with the given status, "booked" or "pending" for online requests
+// awaiting the salon's confirmation, returning the new appointment's ID and
+// the deposit the cancellation policy asks for. Errors are as for
+// findBookingSlot.
+func bookAppointment(tx *sql.Tx, ownerID int, sched *salonSchedule, b appointmentBooking, source, status string, now time.Time) (int64, float64, error) {
+	slot, err := findBookingSlot(tx, ownerID, sched, b, now, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	policy, err := loadCancellationPolicy(tx, ownerID)
+	if err != nil {
+		return 0, 0, err
+	}
+	deposit, err := depositFor(tx, b.CustomerID, b.ServiceID, policy)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	seriesID := sql.NullInt64{Int64: b.SeriesID, Valid: b.SeriesID != 0}
+	res, err := tx.Exec(`
+        INSERT INTO appointments (owner_id, customer_id, staff_id, service_id, starts_at, ends_at, buffer_minutes,
+            status, source, notes, deposit_amount, series_id, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, b.CustomerID, slot.staffID, b.ServiceID, slot.start.Format(dateTimeLayout), slot.end.Format(dateTimeLayout),
+		slot.buffer, status, source, nullIfEmpty(b.Notes), deposit, seriesID, now, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, _ := res.LastInsertId()
+	if err := reserveResources(tx, id, slot.needs); err != nil {
+		return 0, 0, err
+	}
+	return id, deposit, nil
+}
+
+// --- API: List Appointments ---
+// Lists appointments between the "start" and "end" dates (the coming week by
+// default), optionally for one "staff_id" or "status".
+func APIGetAppointments(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch appointments", http.StatusInternalServerError)
+		return
+	}
+	from, to, err := availabilityRange(r, sched.loc, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        SELECT a.id, a.customer_id, c.name, a.staff_id, st.name, a.service_id, sv.name, a.starts_at, a.ends_at,
+            a.buffer_minutes, a.status, a.source, COALESCE(a.notes, ''), a.confirmed_at, a.series_id
+        FROM appointments a
+        JOIN customers c ON a.customer_id = c.id
+        JOIN staff st ON a.staff_id = st.id
+        JOIN services sv ON a.service_id = sv.id
+        WHERE a.owner_id = ? AND a.starts_at >= ? AND a.starts_at < ?`
+	args := []interface{}{ownerID, from.Format(dateTimeLayout), to.AddDate(0, 0, 1).Format(dateTimeLayout)}
+	if v := r.URL.Query().Get("staff_id"); v != "" {
+		query += " AND a.staff_id = ?"
+		args = append(args, v)
+	}
+	if v := r.URL.Query().Get("status"); v != "" {
+		query += " AND a.status = ?"
+		args = append(args, v)
+	}
+	rows, err := db.Query(query+" ORDER BY a.starts_at, st.name", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch appointments", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	appointments := []appointment{}
+	for rows.Next() {
+		var a appointment
+		var confirmedAt sql.NullTime
+		var seriesID sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.CustomerID, &a.CustomerName, &a.StaffID, &a.StaffName, &a.ServiceID, &a.ServiceName,
+			&a.Start, &a.End, &a.BufferMinutes, &a.Status, &a.Source, &a.Notes, &confirmedAt, &seriesID); err != nil {
+			log.Printf("Failed to scan appointment: %v", err)
+			continue
+		}
+		if confirmedAt.Valid {
+			a.ConfirmedAt = confirmedAt.Time.Format(time.RFC3339)
+		}
+		if seriesID.Valid {
+			a.SeriesID = &seriesID.Int64
+		}
+		if t, err := time.ParseInLocation(dateTimeLayout, a.Start, sched.loc); err == nil {
+			a.Start = t.Format(time.RFC3339)
+		}
+		if t, err := time.ParseInLocation(dateTimeLayout, a.End, sched.loc); err == nil {
+			a.End = t.Format(time.RFC3339)
+		}
+		appointments = append(appointments, a)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(appointments)
+}
+
+// --- API: Book Appointment ---
+// Takes an appointmentBooking. When the cancellation policy asks for a
+// deposit, the booking must also carry the "deposit" payment or
+// "waive_deposit".
+func APIAddAppointment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		appointmentBooking
+		Deposit      *invoicePaymentInput `json:"deposit"`
+		WaiveDeposit bool                 `json:"waive_deposit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	b := req.appointmentBooking
+	b.Notes = strings.TrimSpace(b.Notes)
+
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	id, deposit, err := bookAppointment(tx, ownerID, sched, b, "front_desk", "booked", now)
+	var depositInvoiceID int64
+	if err == nil && deposit > 0 {
+		switch {
+		case req.WaiveDeposit:
+			deposit = 0
+			_, err = tx.Exec("UPDATE appointments SET deposit_amount = 0 WHERE id = ?", id)
+		case req.Deposit != nil:
+			depositInvoiceID, err = takeAppointmentDeposit(tx, ownerID, id, *req.Deposit, now)
+		default:
+			err = invoiceInputError(fmt.Sprintf("A deposit of %s is required for this booking", formatMoney(deposit)))
+		}
+	}
+	if err != nil {
+		if _, ok := err.(invoiceInputError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else if err == errSlotUnavailable {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			log.Printf("Failed to book appointment: %v", err)
+			http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		return
+	}
+	resp := map[string]interface{}{"id": id, "deposit_amount": deposit}
+	if depositInvoiceID != 0 {
+		resp["deposit_invoice_id"] = depositInvoiceID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// --- API: Update Appointment ---
+// Body: {"start", "staff_id", "service_id", "notes", "scope"}; fields left
+// out keep their current values. For an appointment in a series, scope
+// "future" applies the change to this and every later occurrence, moving
+// each by the same amount of time; the default, "this", changes only this
+// one. Any occurrence that no longer fits fails the update with 409 and a
+// list of conflicts.
+func APIUpdateAppointment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Start     string  `json:"start"`
+		StaffID   int64   `json:"staff_id"`
+		ServiceID int64   `json:"service_id"`
+		Notes     *string `json:"notes"`
+		Scope     string  `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = "this"
+	}
+	if req.Scope != "this" && req.Scope != "future" {
+		http.Error(w, "Scope must be this or future", http.StatusBadRequest)
+		return
+	}
+
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	var status, startsAt string
+	var seriesID sql.NullInt64
+	err = tx.QueryRow("SELECT status, starts_at, series_id FROM appointments WHERE id = ? AND owner_id = ?", id, ownerID).
+		Scan(&status, &startsAt, &seriesID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Appointment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch appointment", http.StatusInternalServerError)
+		return
+	}
+	if status != "booked" && status != "pending" {
+		http.Error(w, fmt.Sprintf("Can't edit an appointment that is %s", status), http.StatusBadRequest)
+		return
+	}
+	// The move is measured in wall-clock time so occurrences keep their time
+	// of day across daylight saving changes.
+	oldStart, _ := time.Parse(dateTimeLayout, startsAt)
+	shift := time.Duration(0)
+	if req.Start != "" {
+		newStart, err := parseSalonTime(req.Start, sched.loc)
+		if err != nil {
+			http.Error(w, "Invalid start time", http.StatusBadRequest)
+			return
+		}
+		wall, _ := time.Parse(dateTimeLayout, newStart.Format(dateTimeLayout))
+		shift = wall.Sub(oldStart)
+	}
+
+	query := `
+        SELECT id, customer_id, staff_id, service_id, starts_at, COALESCE(notes, '') FROM appointments
+        WHERE id = ?`
+	args := []interface{}{id}
+	if req.Scope == "future" && seriesID.Valid {
+		query += " OR (series_id = ? AND starts_at > ? AND status IN ('booked', 'pending'))"
+		args = append(args, seriesID.Int64, startsAt)
+	}
+	rows, err := tx.Query(query+" ORDER BY starts_at", args...)
+	if err != nil {
+		http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+		return
+	}
+	type target struct {
+		id       int64
+		booking  appointmentBooking
+		startsAt string
+	}
+	var targets []target
+	for rows.Next() {
+		var t target
+		if err := rows.Scan(&t.id, &t.booking.CustomerID, &t.booking.StaffID, &t.booking.ServiceID, &t.startsAt, &t.booking.Notes); err != nil {
+			rows.Close()
+			http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+			return
+		}
+		targets = append(targets, t)
+	}
+	rows.Close()
+
+	now := time.Now()
+	conflicts := []string{}
+	for _, t := range targets {
+		b := t.booking
+		if req.StaffID != 0 {
+			b.StaffID = req.StaffID
+		}
+		if req.ServiceID != 0 {
+			b.ServiceID = req.ServiceID
+		}
+		if req.Notes != nil {
+			b.Notes = strings.TrimSpace(*req.Notes)
+		}
+		wall, _ := time.Parse(dateTimeLayout, t.startsAt)
+		b.Start = wall.Add(shift).Format(dateTimeLayout)
+		slot, err := findBookingSlot(tx, ownerID, sched, b, now, t.id)
+		if err == errSlotUnavailable {
+			if start, err := time.ParseInLocation(dateTimeLayout, b.Start, sched.loc); err == nil {
+				conflicts = append(conflicts, start.Format(time.RFC3339))
+			}
+			continue
+		} else if _, ok := err.(invoiceInputError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("Failed to check appointment %d: %v", t.id, err)
+			http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+			return
+		}
+		_, err = tx.Exec(`
+            UPDATE appointments SET staff_id = ?, service_id = ?, starts_at = ?, ends_at = ?, buffer_minutes = ?, notes = ?,
+                updated_at = ? WHERE id = ?`,
+			slot.staffID, b.ServiceID, slot.start.Format(dateTimeLayout), slot.end.Format(dateTimeLayout), slot.buffer,
+			nullIfEmpty(b.Notes), now, t.id)
+		if err != nil {
+			http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+			return
+		}
+		if err := reserveResources(tx, t.id, slot.needs); err != nil {
+			http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+			return
+		}
+		// A moved appointment needs confirming again and its reminders
+		// sending for the new time.
+		if b.Start != t.startsAt {
+			if _, err := tx.Exec("UPDATE appointments SET confirmed_at = NULL WHERE id = ?", t.id); err != nil {
+				http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+				return
+			}
+			if _, err := tx.Exec("DELETE FROM appointment_notifications WHERE appointment_id = ? AND kind = 'reminder'", t.id); err != nil {
+				http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":     "Some occurrences clash with the schedule or other bookings",
+			"conflicts": conflicts,
+		})
+		return
+	}
+	if req.Scope == "future" && seriesID.Valid {
+		notes := ""
+		if req.Notes != nil {
+			notes = strings.TrimSpace(*req.Notes)
+		}
+		_, err := tx.Exec(`
+            UPDATE appointment_series SET staff_id = COALESCE(?, staff_id), service_id = COALESCE(?, service_id),
+                notes = CASE WHEN ? THEN ? ELSE notes END, updated_at = ?
+            WHERE id = ?`,
+			sql.NullInt64{Int64: req.StaffID, Valid: req.StaffID != 0}, sql.NullInt64{Int64: req.ServiceID, Valid: req.ServiceID != 0},
+			req.Notes != nil, nullIfEmpty(notes), now, seriesID.Int64)
+		if err != nil {
+			http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"updated": len(targets)})
+}
+
+// --- API: Change Appointment Status ---
+// Handles /api/appointments/{id}/{action} where action is confirm (for
+// pending online bookings), cancel, no-show or complete. Cancelling frees the
+// slot for other bookings and offers it to the waitlist. Late cancellations and no-shows are charged the
+// cancellation policy fee unless waive_fee=1 is given.
+func APIChangeAppointment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	var status, startsAt string
+	var deposit float64
+	var depositInvoice sql.NullInt64
+	err = tx.QueryRow(`
+        SELECT status, starts_at, COALESCE(deposit_amount, 0), deposit_invoice_id FROM appointments
+        WHERE id = ? AND owner_id = ?`, id, ownerID).Scan(&status, &startsAt, &deposit, &depositInvoice)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Appointment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch appointment", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	action := chi.URLParam(r, "action")
+	var newStatus string
+	var feeInvoiceID int64
+	switch {
+	case action == "confirm" && status == "pending":
+		if deposit > 0 && !depositInvoice.Valid {
+			http.Error(w, fmt.Sprintf("Take the deposit of %s before confirming", formatMoney(deposit)), http.StatusBadRequest)
+			return
+		}
+		newStatus = "booked"
+	case action == "complete" && status == "booked":
+		newStatus = "completed"
+	case action == "no-show" && status == "booked":
+		sched, err := loadSalonSchedule(tx, ownerID)
+		if err != nil {
+			http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+			return
+		}
+		if start, err := time.ParseInLocation(dateTimeLayout, startsAt, sched.loc); err != nil || now.Before(start) {
+			http.Error(w, "Can't mark a no-show before the appointment starts", http.StatusBadRequest)
+			return
+		}
+		fallthrough
+	case action == "cancel" && (status == "booked" || status == "pending"):
+		newStatus, feeInvoiceID, err = endAppointment(tx, ownerID, id, action, r.URL.Query().Get("waive_fee") == "1", now)
+		if err != nil {
+			log.Printf("Failed to %s appointment %d: %v", action, id, err)
+			http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+			return
+		}
+	case action == "confirm" || action == "cancel" || action == "no-show" || action == "complete":
+		http.Error(w, fmt.Sprintf("Can't %s an appointment that is %s", action, status), http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	if action == "confirm" || action == "complete" {
+		if _, err := tx.Exec("UPDATE appointments SET status = ?, updated_at = ? WHERE id = ?", newStatus, now, id); err != nil {
+			http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+			return
+		}
+	}
+	if action == "complete" {
+		_, err := tx.Exec("UPDATE walk_ins SET status = 'done', finished_at = ? WHERE appointment_id = ? AND status = 'in_service'", now, id)
+		if err != nil {
+			http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update appointment", http.StatusInternalServerError)
+		return
+	}
+	if action == "cancel" {
+		go offerFreedSlot(db, ownerID, id, now)
+	}
+	if action == "complete" {
+		notifyWalkIns(ownerID)
+	}
+	resp := map[string]interface{}{"status": newStatus}
+	if feeInvoiceID != 0 {
+		resp["fee_invoice_id"] = feeInvoiceID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,570 @@
+// internal/handlers/appointment_policy.go
+// The cancellation policy: late-cancellation and no-show fees, deposits for
+// flagged customers and high-value services, and the attendance record per
+// customer that drives them. Fees and deposits are raised as invoices.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// cancellationPolicy is an owner's rules for late cancellations, no-shows
+// and deposits. Fees are a percentage of the service price or a fixed amount,
+// by FeeType. Deposits are DepositPercent of the service price, taken for
+// services priced at DepositMinPrice or more (0 turns this off) and for
+// flagged customers. Customers are flagged by hand or once they reach
+// FlagAfter no-shows and late cancellations (0 turns this off).
+type cancellationPolicy struct {
+	WindowHours     int     `json:"cancel_window_hours"`
+	LateCancelFee   float64 `json:"late_cancel_fee"`
+	NoShowFee       float64 `json:"no_show_fee"`
+	FeeType         string  `json:"fee_type"`
+	DepositPercent  float64 `json:"deposit_percent"`
+	DepositMinPrice float64 `json:"deposit_min_price"`
+	FlagAfter       int     `json:"flag_after"`
+}
+
+func loadCancellationPolicy(q queryer, ownerID int) (cancellationPolicy, error) {
+	var p cancellationPolicy
+	err := q.QueryRow(`
+        SELECT COALESCE(cancel_window_hours, 24), COALESCE(late_cancel_fee, 0), COALESCE(no_show_fee, 0),
+            COALESCE(policy_fee_type, 'percent'), COALESCE(deposit_percent, 0), COALESCE(deposit_min_price, 0),
+            COALESCE(no_show_flag_after, 2)
+        FROM owners WHERE id = ?`, ownerID).Scan(
+		&p.WindowHours, &p.LateCancelFee, &p.NoShowFee, &p.FeeType, &p.DepositPercent, &p.DepositMinPrice, &p.FlagAfter)
+	return p, err
+}
+
+// fee is the charge for a "late_cancel" or "no_show" of a service at price.
+func (p cancellationPolicy) fee(kind string, price float64) float64 {
+	var amount float64
+	switch kind {
+	case "late_cancel":
+		amount = p.LateCancelFee
+	case "no_show":
+		amount = p.NoShowFee
+	}
+	if p.FeeType == "percent" {
+		amount = price * amount / 100
+	}
+	return round2(amount)
+}
+
+// customerAttendance counts how a customer's past appointments ended.
+type customerAttendance struct {
+	Completed       int  `json:"completed"`
+	Cancelled       int  `json:"cancelled"`
+	LateCancelled   int  `json:"late_cancelled"`
+	NoShows         int  `json:"no_shows"`
+	RequiresDeposit bool `json:"requires_deposit"`
+	Flagged         bool `json:"flagged"`
+}
+
+func loadCustomerAttendance(q queryer, customerID int64, policy cancellationPolicy) (customerAttendance, error) {
+	var a customerAttendance
+	if err := q.QueryRow("SELECT COALESCE(requires_deposit, 0) FROM customers WHERE id = ?", customerID).Scan(&a.RequiresDeposit); err != nil {
+		return a, err
+	}
+	rows, err := q.Query("SELECT status, COUNT(*) FROM appointments WHERE customer_id = ? GROUP BY status", customerID)
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return a, err
+		}
+		switch status {
+		case "completed":
+			a.Completed = n
+		case "cancelled":
+			a.Cancelled = n
+		case "late_cancelled":
+			a.LateCancelled = n
+		case "no_show":
+			a.NoShows = n
+		}
+	}
+	a.Flagged = a.RequiresDeposit || (policy.FlagAfter > 0 && a.NoShows+a.LateCancelled >= policy.FlagAfter)
+	return a, rows.Err()
+}
+
+// depositFor works out the deposit needed to book a service for a customer.
+func depositFor(q queryer, customerID, serviceID int64, policy cancellationPolicy) (float64, error) {
+	if policy.DepositPercent <= 0 {
+		return 0, nil
+	}
+	var price float64
+	if err := q.QueryRow("SELECT price FROM services WHERE id = ?", serviceID).Scan(&price); err != nil {
+		return 0, err
+	}
+	attendance, err := loadCustomerAttendance(q, customerID, policy)
+	if err != nil {
+		return 0, err
+	}
+	if attendance.Flagged || (policy.DepositMinPrice > 0 && price >= policy.DepositMinPrice) {
+		return round2(price * policy.DepositPercent / 100), nil
+	}
+	return 0, nil
+}
+
+// appointmentLabel describes an appointment on invoice lines, e.g. "Cut on
+// Mon 20 Oct 10:00".
+func appointmentLabel(service, startsAt string) string {
+	if t, err := time.Parse(dateTimeLayout, startsAt); err == nil {
+		return service + " on " + t.Format("Mon 2 Jan 15:04")
+	}
+	return service
+}
+
+// createChargeInvoice raises a one-line, untaxed invoice for a fee or a
+// deposit with any payments already made against it. It is Paid once the
+// payments cover the amount.
+func createChargeInvoice(tx *sql.Tx, ownerID int, customerID int64, description string, amount float64,
+	payments []invoicePaymentInput, now time.Time) (int64, error) {
+	var paid float64
+	for _, p := range payments {
+		paid += p.Amount
+	}
+	status := "Unpaid"
+	if round2(paid) >= round2(amount) {
+		status = "Paid"
+	}
+	res, err := tx.Exec(`
+        INSERT INTO invoices (owner_id, customer_id, invoice_date, total_amount, discount, tax, payment_status,
+            subtotal, discount_amount, tax_amount, tax_inclusive, created_at, updated_at)
+        VALUES (?, ?, ?, ?, 0, 0, ?, ?, 0, 0, 0, ?, ?)`,
+		ownerID, customerID, now.Format("2006-01-02"), amount, status, amount, now, now)
+	if err != nil {
+		return 0, err
+	}
+	invoiceID, _ := res.LastInsertId()
+	if _, err := tx.Exec("UPDATE invoices SET invoice_number = ? WHERE id = ?", fmt.Sprintf("INV-%04d", invoiceID), invoiceID); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO invoice_items (invoice_id, description, quantity, unit_price, line_total, net_amount)
+        VALUES (?, ?, 1, ?, ?, ?)`, invoiceID, description, amount, amount, amount)
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range payments {
+		_, err := tx.Exec("INSERT INTO invoice_payments (invoice_id, method, amount, reference, paid_at) VALUES (?, ?, ?, ?, ?)",
+			invoiceID, p.Method, round2(p.Amount), p.Reference, now)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return invoiceID, nil
+}
+
+// takeAppointmentDeposit records payment of an appointment's deposit as a
+// Paid invoice.
+func takeAppointmentDeposit(tx *sql.Tx, ownerID int, appointmentID int64, p invoicePaymentInput, now time.Time) (int64, error) {
+	var customerID int64
+	var service, startsAt string
+	var deposit float64
+	var depositInvoice sql.NullInt64
+	err := tx.QueryRow(`
+        SELECT a.customer_id, sv.name, a.starts_at, COALESCE(a.deposit_amount, 0), a.deposit_invoice_id
+        FROM appointments a JOIN services sv ON a.service_id = sv.id
+        WHERE a.id = ? AND a.owner_id = ?`, appointmentID, ownerID).Scan(&customerID, &service, &startsAt, &deposit, &depositInvoice)
+	if err != nil {
+		return 0, err
+	}
+	if deposit <= 0 {
+		return 0, invoiceInputError("No deposit is required for this appointment")
+	}
+	if depositInvoice.Valid {
+		return 0, invoiceInputError("The deposit has already been paid")
+	}
+	p.Method = strings.ToLower(strings.TrimSpace(p.Method))
+	if p.Method == "" {
+		p.Method = "cash"
+	}
+	if !validPaymentMethods[p.Method] || p.Method == "gift_card" || p.Method == "points" || p.Method == "deposit" {
+		return 0, invoiceInputError("Invalid payment method")
+	}
+	p.Amount = deposit
+	invoiceID, err := createChargeInvoice(tx, ownerID, customerID, "Deposit: "+appointmentLabel(service, startsAt), deposit,
+		[]invoicePaymentInput{p}, now)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("UPDATE appointments SET deposit_invoice_id = ?, updated_at = ? WHERE id = ?", invoiceID, now, appointmentID)
+	return invoiceID, err
+}
+
+// redeemAppointmentDeposit puts part of a paid deposit, named by its
+// appointment ID in the payment reference, towards an invoice for the same
+// customer.
+func redeemAppointmentDeposit(tx *sql.Tx, ownerID, customerID int, reference string, amount float64) error {
+	appointmentID, err := strconv.ParseInt(strings.TrimSpace(reference), 10, 64)
+	if err != nil {
+		return invoiceInputError("Deposit payments need the appointment ID as reference")
+	}
+	var deposit, used float64
+	var depositInvoice sql.NullInt64
+	err = tx.QueryRow(`
+        SELECT COALESCE(deposit_amount, 0), COALESCE(deposit_used, 0), deposit_invoice_id FROM appointments
+        WHERE id = ? AND owner_id = ? AND customer_id = ?`, appointmentID, ownerID, customerID).Scan(&deposit, &used, &depositInvoice)
+	if err == sql.ErrNoRows || (err == nil && !depositInvoice.Valid) {
+		return invoiceInputError(fmt.Sprintf("No deposit paid for appointment %d", appointmentID))
+	} else if err != nil {
+		return err
+	}
+	if round2(amount) > round2(deposit-used) {
+		return invoiceInputError(fmt.Sprintf("Only %s of the deposit for appointment %d is left", formatMoney(deposit-used), appointmentID))
+	}
+	_, err = tx.Exec("UPDATE appointments SET deposit_used = deposit_used + ? WHERE id = ?", round2(amount), appointmentID)
+	return err
+}
+
+// endAppointment cancels an appointment ("cancel") or marks it a no-show
+// ("no-show"). Cancelling a booked appointment inside the policy window makes
+// it a late cancellation. Late cancellations and no-shows are charged the
+// policy fee unless waived, with any unused deposit put towards it. It
+// returns the new status and the fee invoice, if one was raised.
+func endAppointment(tx *sql.Tx, ownerID int, appointmentID int64, action string, waive bool, now time.Time) (string, int64, error) {
+	var customerID int64
+	var status, startsAt, service string
+	var price, deposit, used float64
+	var depositInvoice sql.NullInt64
+	err := tx.QueryRow(`
+        SELECT a.customer_id, a.status, a.starts_at, sv.name, sv.price, COALESCE(a.deposit_amount, 0),
+            COALESCE(a.deposit_used, 0), a.deposit_invoice_id
+        FROM appointments a JOIN services sv ON a.service_id = sv.id
+        WHERE a.id = ? AND a.owner_id = ?`, appointmentID, ownerID).Scan(
+		&customerID, &status, &startsAt, &service, &price, &deposit, &used, &depositInvoice)
+	if err != nil {
+		return "", 0, err
+	}
+	policy, err := loadCancellationPolicy(tx, ownerID)
+	if err != nil {
+		return "", 0, err
+	}
+	sched, err := loadSalonSchedule(tx, ownerID)
+	if err != nil {
+		return "", 0, err
+	}
+	start, err := time.ParseInLocation(dateTimeLayout, startsAt, sched.loc)
+	if err != nil {
+		return "", 0, err
+	}
+
+	newStatus, feeKind, label := "cancelled", "", ""
+	if action == "no-show" {
+		newStatus, feeKind, label = "no_show", "no_show", "No-show fee"
+	} else if status == "booked" && start.Sub(now) < time.Duration(policy.WindowHours)*time.Hour {
+		newStatus, feeKind, label = "late_cancelled", "late_cancel", "Late cancellation fee"
+	}
+
+	var feeInvoiceID sql.NullInt64
+	if fee := policy.fee(feeKind, price); fee > 0 && !waive {
+		var payments []invoicePaymentInput
+		if left := round2(deposit - used); depositInvoice.Valid && left > 0 {
+			amount := math.Min(left, fee)
+			payments = append(payments, invoicePaymentInput{Method: "deposit", Amount: amount, Reference: strconv.FormatInt(appointmentID, 10)})
+			if _, err := tx.Exec("UPDATE appointments SET deposit_used = deposit_used + ? WHERE id = ?", amount, appointmentID); err != nil {
+				return "", 0, err
+			}
+		}
+		id, err := createChargeInvoice(tx, ownerID, customerID, label+": "+appointmentLabel(service, startsAt), fee, payments, now)
+		if err != nil {
+			return "", 0, err
+		}
+		feeInvoiceID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	_, err = tx.Exec("UPDATE appointments SET status = ?, fee_invoice_id = ?, updated_at = ? WHERE id = ?",
+		newStatus, feeInvoiceID, now, appointmentID)
+	return newStatus, feeInvoiceID.Int64, err
+}
+
+// --- API: Take Appointment Deposit ---
+// Body: {"method", "reference"}. Takes the deposit the appointment requires.
+func APITakeAppointmentDeposit(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+	var p invoicePaymentInput
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to take deposit", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	invoiceID, err := takeAppointmentDeposit(tx, ownerID, id, p, time.Now())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Appointment not found", http.StatusNotFound)
+		} else if _, ok := err.(invoiceInputError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to take deposit", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to take deposit", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"invoice_id": invoiceID})
+}
+
+// --- API: Get Cancellation Policy ---
+func APIGetCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	policy, err := loadCancellationPolicy(database.GetDB(), ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch cancellation policy", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// --- API: Update Cancellation Policy ---
+func APIUpdateCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	p := cancellationPolicy{WindowHours: 24, FeeType: "percent", FlagAfter: 2}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if p.FeeType != "percent" && p.FeeType != "fixed" {
+		http.Error(w, "Fee type must be percent or fixed", http.StatusBadRequest)
+		return
+	}
+	if p.WindowHours < 0 || p.WindowHours > 168 {
+		http.Error(w, "Cancellation window must be 0-168 hours", http.StatusBadRequest)
+		return
+	}
+	if p.LateCancelFee < 0 || p.NoShowFee < 0 || (p.FeeType == "percent" && (p.LateCancelFee > 100 || p.NoShowFee > 100)) {
+		http.Error(w, "Fees must be positive, and percentages at most 100", http.StatusBadRequest)
+		return
+	}
+	if p.DepositPercent < 0 || p.DepositPercent > 100 || p.DepositMinPrice < 0 {
+		http.Error(w, "Deposit must be 0-100 percent with a positive minimum price", http.StatusBadRequest)
+		return
+	}
+	if p.FlagAfter < 0 {
+		http.Error(w, "Flag threshold cannot be negative", http.StatusBadRequest)
+		return
+	}
+	_, err := database.GetDB().Exec(`
+        UPDATE owners SET cancel_window_hours = ?, late_cancel_fee = ?, no_show_fee = ?, policy_fee_type = ?,
+            deposit_percent = ?, deposit_min_price = ?, no_show_flag_after = ?, updated_at = ?
+        WHERE id = ?`,
+		p.WindowHours, p.LateCancelFee, p.NoShowFee, p.FeeType, p.DepositPercent, p.DepositMinPrice, p.FlagAfter, time.Now(), ownerID)
+	if err != nil {
+		http.Error(w, "Failed to save cancellation policy", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Customer Attendance ---
+// Counts a customer's completed, cancelled and late-cancelled appointments
+// and no-shows, and whether they must pay deposits.
+func APIGetCustomerAttendance(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	customerID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", customerID, ownerID).Scan(&count)
+	if count == 0 {
+		http.Error(w, "Customer not found", http.StatusNotFound)
+		return
+	}
+	policy, err := loadCancellationPolicy(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch attendance", http.StatusInternalServerError)
+		return
+	}
+	attendance, err := loadCustomerAttendance(db, customerID, policy)
+	if err != nil {
+		http.Error(w, "Failed to fetch attendance", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attendance)
+}
+
+// --- API: Flag Customer for Deposits ---
+// Body: {"requires_deposit": true}.
+func APIUpdateCustomerDepositFlag(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	customerID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		RequiresDeposit bool `json:"requires_deposit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	res, err := database.GetDB().Exec("UPDATE customers SET requires_deposit = ?, updated_at = ? WHERE id = ? AND owner_id = ?",
+		req.RequiresDeposit, time.Now(), customerID, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to update customer", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Customer not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// APINoShowReport lists customers with no-shows or late cancellations for
+// appointments between "start" and "end", with the fees raised for them. Add
+// format=csv to download it as a spreadsheet.
+func APINoShowReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	start, end, ok := reportDateRange(r)
+	if !ok {
+		http.Error(w, "Invalid date range (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query(`
+        SELECT c.id, c.name, SUM(a.status = 'no_show'), SUM(a.status = 'late_cancelled'),
+            COALESCE(SUM(i.total_amount), 0),
+            COALESCE(SUM(i.total_amount - (SELECT COALESCE(SUM(p.amount), 0) FROM invoice_payments p WHERE p.invoice_id = i.id)), 0)
+        FROM appointments a
+        JOIN customers c ON a.customer_id = c.id
+        LEFT JOIN invoices i ON a.fee_invoice_id = i.id
+        WHERE a.owner_id = ? AND a.status IN ('no_show', 'late_cancelled') AND substr(a.starts_at, 1, 10) BETWEEN ? AND ?
+        GROUP BY c.id, c.name
+        ORDER BY SUM(a.status = 'no_show') + SUM(a.status = 'late_cancelled') DESC, c.name`, ownerID, start, end)
+	if err != nil {
+		http.Error(w, "Failed to generate report", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type noShowRow struct {
+		CustomerID    int64   `json:"customer_id"`
+		Name          string  `json:"name"`
+		NoShows       int     `json:"no_shows"`
+		LateCancelled int     `json:"late_cancelled"`
+		FeesCharged   float64 `json:"fees_charged"`
+		FeesUnpaid    float64 `json:"fees_unpaid"`
+	}
+	results := []noShowRow{}
+	for rows.Next() {
+		var row noShowRow
+		if err := rows.Scan(&row.CustomerID, &row.Name, &row.NoShows, &row.LateCancelled, &row.FeesCharged, &row.FeesUnpaid); err != nil {
+			log.Printf("Failed to scan no-show row: %v", err)
+			continue
+		}
+		row.FeesCharged, row.FeesUnpaid = round2(row.FeesCharged), round2(row.FeesUnpaid)
+		results = append(results, row)
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=no_shows_"+start+"_"+end+".csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"Customer", "No-shows", "Late cancellations", "Fees charged", "Fees unpaid"})
+		for _, row := range results {
+			cw.Write([]string{row.Name, strconv.Itoa(row.NoShows), strconv.Itoa(row.LateCancelled),
+				formatMoney(row.FeesCharged), formatMoney(row.FeesUnpaid)})
+		}
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"start": start, "end": end, "customers": results})
+}
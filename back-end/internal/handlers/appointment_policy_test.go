@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCancellationPolicyFee(t *testing.T) {
+	percent := cancellationPolicy{LateCancelFee: 50, NoShowFee: 100, FeeType: "percent"}
+	fixed := cancellationPolicy{LateCancelFee: 15, NoShowFee: 25, FeeType: "fixed"}
+	tests := []struct {
+		policy cancellationPolicy
+		kind   string
+		price  float64
+		want   float64
+	}{
+		{percent, "late_cancel", 45, 22.5},
+		{percent, "no_show", 45, 45},
+		{percent, "", 45, 0},
+		{fixed, "late_cancel", 45, 15},
+		{fixed, "no_show", 45, 25},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.fee(tt.kind, tt.price); got != tt.want {
+			t.Errorf("fee(%q, %v) with %s fees = %v, want %v", tt.kind, tt.price, tt.policy.FeeType, got, tt.want)
+		}
+	}
+}
+
+func TestDepositFor(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db,
+		`INSERT INTO services (id, owner_id, name, price) VALUES (1, 1, 'Cut', 40), (2, 1, 'Colour', 150)`,
+		`INSERT INTO customers (id, owner_id, name, requires_deposit) VALUES (1, 1, 'Reliable', 0), (2, 1, 'Flagged', 1), (3, 1, 'No-shows', 0)`,
+		`INSERT INTO appointments (owner_id, customer_id, staff_id, service_id, starts_at, ends_at, status) VALUES
+			(1, 3, 1, 1, '2026-10-01 10:00', '2026-10-01 10:30', 'no_show'),
+			(1, 3, 1, 1, '2026-10-08 10:00', '2026-10-08 10:30', 'late_cancelled'),
+			(1, 1, 1, 1, '2026-10-08 11:00', '2026-10-08 11:30', 'cancelled')`,
+	)
+	policy := cancellationPolicy{DepositPercent: 20, DepositMinPrice: 100, FlagAfter: 2}
+	tests := []struct {
+		name       string
+		policy     cancellationPolicy
+		customerID int64
+		serviceID  int64
+		want       float64
+	}{
+		{"cheap service", policy, 1, 1, 0},
+		{"high-value service", policy, 1, 2, 30},
+		{"flagged by hand", policy, 2, 1, 8},
+		{"flagged by no-shows", policy, 3, 1, 8},
+		{"automatic flagging off", cancellationPolicy{DepositPercent: 20}, 3, 1, 0},
+		{"deposits off", cancellationPolicy{DepositMinPrice: 100, FlagAfter: 2}, 2, 2, 0},
+	}
+	for _, tt := range tests {
+		got, err := depositFor(db, tt.customerID, tt.serviceID, tt.policy)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: depositFor() = %v (%v), want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestEndAppointment(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 11, 2, 9, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO owners (id, email, password_hash, timezone, cancel_window_hours, late_cancel_fee, no_show_fee, policy_fee_type)
+			VALUES (1, 'a@example.com', 'x', 'UTC', 24, 50, 100, 'percent')`,
+		`INSERT INTO services (id, owner_id, name, price) VALUES (1, 1, 'Colour', 80)`,
+		`INSERT INTO customers (id, owner_id, name) VALUES (1, 1, 'Jane')`,
+	)
+	tests := []struct {
+		name       string
+		startsAt   string
+		action     string
+		waive      bool
+		deposit    float64
+		wantStatus string
+		wantFee    float64
+		wantPaid   float64
+	}{
+		{name: "outside the window", startsAt: "2026-11-04 10:00", action: "cancel", wantStatus: "cancelled"},
+		{name: "late cancellation", startsAt: "2026-11-02 15:00", action: "cancel", wantStatus: "late_cancelled", wantFee: 40},
+		{name: "late cancellation waived", startsAt: "2026-11-02 15:00", action: "cancel", waive: true, wantStatus: "late_cancelled"},
+		{name: "no-show", startsAt: "2026-11-02 08:00", action: "no-show", wantStatus: "no_show", wantFee: 80},
+		{name: "no-show paid from the deposit", startsAt: "2026-11-02 08:00", action: "no-show", deposit: 16,
+			wantStatus: "no_show", wantFee: 80, wantPaid: 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+			var depositInvoice interface{}
+			if tt.deposit > 0 {
+				depositInvoice = 99
+			}
+			res, err := tx.Exec(`INSERT INTO appointments (owner_id, customer_id, staff_id, service_id, starts_at, ends_at, deposit_amount, deposit_invoice_id)
+				VALUES (1, 1, 1, 1, ?, ?, ?, ?)`, tt.startsAt, tt.startsAt, tt.deposit, depositInvoice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			appointmentID, _ := res.LastInsertId()
+
+			status, invoiceID, err := endAppointment(tx, 1, appointmentID, tt.action, tt.waive, now)
+			if err != nil {
+				t.Fatalf("endAppointment() error = %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", status, tt.wantStatus)
+			}
+			if tt.wantFee == 0 {
+				if invoiceID != 0 {
+					t.Errorf("fee invoice %d raised, want none", invoiceID)
+				}
+				return
+			}
+			var total, paid float64
+			var paymentStatus string
+			tx.QueryRow("SELECT total_amount, payment_status FROM invoices WHERE id = ?", invoiceID).Scan(&total, &paymentStatus)
+			tx.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM invoice_payments WHERE invoice_id = ? AND method = 'deposit'", invoiceID).Scan(&paid)
+			if total != tt.wantFee || paid != tt.wantPaid || paymentStatus != "Unpaid" {
+				t.Errorf("fee invoice = %v (%s) with %v from the deposit, want %v Unpaid with %v", total, paymentStatus, paid, tt.wantFee, tt.wantPaid)
+			}
+			var used float64
+			tx.QueryRow("SELECT deposit_used FROM appointments WHERE id = ?", appointmentID).Scan(&used)
+			if used != tt.wantPaid {
+				t.Errorf("deposit_used = %v, want %v", used, tt.wantPaid)
+			}
+		})
+	}
+}
+
+func TestRedeemAppointmentDeposit(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO appointments (id, owner_id, customer_id, staff_id, service_id, starts_at, ends_at, deposit_amount, deposit_used, deposit_invoice_id) VALUES
+		(1, 1, 5, 1, 1, '2026-11-02 10:00', '2026-11-02 11:00', 30, 10, 7),
+		(2, 1, 5, 1, 1, '2026-11-03 10:00', '2026-11-03 11:00', 30, 0, NULL)`)
+	tests := []struct {
+		name              string
+		ownerID, customer int
+		reference         string
+		amount            float64
+		wantErr           string
+	}{
+		{name: "within what's left", ownerID: 1, customer: 5, reference: "1", amount: 20},
+		{name: "more than what's left", ownerID: 1, customer: 5, reference: "1", amount: 20.01,
+			wantErr: "Only 20.00 of the deposit for appointment 1 is left"},
+		{name: "deposit not paid", ownerID: 1, customer: 5, reference: "2", amount: 5, wantErr: "No deposit paid for appointment 2"},
+		{name: "another customer", ownerID: 1, customer: 6, reference: "1", amount: 5, wantErr: "No deposit paid for appointment 1"},
+		{name: "another salon", ownerID: 2, customer: 5, reference: "1", amount: 5, wantErr: "No deposit paid for appointment 1"},
+		{name: "no reference", ownerID: 1, customer: 5, reference: "", amount: 5,
+			wantErr: "Deposit payments need the appointment ID as reference"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+			err = redeemAppointmentDeposit(tx, tt.ownerID, tt.customer, tt.reference, tt.amount)
+			if tt.wantErr != "" {
+				if _, ok := err.(invoiceInputError); !ok || err.Error() != tt.wantErr {
+					t.Fatalf("redeemAppointmentDeposit() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("redeemAppointmentDeposit() error = %v", err)
+			}
+			var used float64
+			tx.QueryRow("SELECT deposit_used FROM appointments WHERE id = 1").Scan(&used)
+			if used != 30 {
+				t.Errorf("deposit_used = %v, want 30", used)
+			}
+		})
+	}
+}
@@ -0,0 +1,652 @@
+// internal/handlers/appointment_reminders.go
+// Background job that sends appointment confirmations and reminders, the
+// Twilio webhook that handles customers replying to confirm or cancel, and
+// the settings and message templates behind them.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	twilioclient "github.com/twilio/twilio-go/client"
+
+	"salon-management/internal/database"
+	"salon-management/internal/reminders"
+)
+
+// reminderEvents are the messages an owner can write templates for, in the
+// order they're listed, with the wording used until they do.
+var reminderEvents = []string{
+	"birthday",
+	"anniversary",
+	"appointment_confirmation",
+	"appointment_reminder",
+	"appointment_confirmed",
+	"appointment_cancelled",
+	"waitlist_offer",
+	"waitlist_booked",
+	"waitlist_taken",
+	"feedback_request",
+	"winback",
+}
+
+var defaultReminderTemplates = map[string]string{
+	"birthday":                 "Dear [CustomerName], greetings from [SalonName] on your [Event]!",
+	"anniversary":              "Dear [CustomerName], greetings from [SalonName] on your [Event]!",
+	"appointment_confirmation": "Hi [CustomerName], your [Service] with [Stylist] at [SalonName] is booked for [Date] at [Time]. Reply C to confirm or X to cancel.",
+	"appointment_reminder":     "Hi [CustomerName], a reminder of your [Service] with [Stylist] at [SalonName] on [Date] at [Time]. Reply C to confirm or X to cancel.",
+	"appointment_confirmed":    "Thanks [CustomerName], your [Service] on [Date] at [Time] is confirmed. See you at [SalonName]!",
+	"appointment_cancelled":    "Your [Service] at [SalonName] on [Date] at [Time] has been cancelled. We hope to see you soon, [CustomerName].",
+	"waitlist_offer":           "Hi [CustomerName], a [Service] slot with [Stylist] at [SalonName] has opened on [Date] at [Time]. Reply BOOK or visit [Link] within [Minutes] minutes to take it. First to reply gets it!",
+	"waitlist_booked":          "Great news [CustomerName], you're booked for [Service] with [Stylist] on [Date] at [Time]. See you at [SalonName]! Reply X to cancel.",
+	"waitlist_taken":           "Sorry [CustomerName], the [Service] slot on [Date] at [Time] has already gone. You're still on the [SalonName] waitlist.",
+	"feedback_request":         "Thanks for visiting [SalonName], [CustomerName]! How likely are you to recommend us to a friend? Rate your visit from 0 to 10: [Link]",
+	"winback":                  "Hi [CustomerName], we've missed you at [SalonName]! It's been a while since your last visit and we'd love to see you again.[Offer]",
+}
+
+// loadReminderTemplate returns the owner's template for an event, or the
+// default.
+func loadReminderTemplate(q queryer, ownerID int, event string) string {
+	var template string
+	err := q.QueryRow("SELECT template FROM reminder_templates WHERE owner_id = ? AND event_type = ?", ownerID, event).Scan(&template)
+	if err != nil || template == "" {
+		return defaultReminderTemplates[event]
+	}
+	return template
+}
+
+// parseReminderOffsets reads the stored comma-separated reminder offsets, in
+// minutes before the appointment.
+func parseReminderOffsets(s string) []int {
+	var offsets []int
+	for _, part := range strings.Split(s, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(part)); err == nil && n > 0 {
+			offsets = append(offsets, n)
+		}
+	}
+	return offsets
+}
+
+// appointmentNotice is what's needed to message a customer about an
+// appointment.
+type appointmentNotice struct {
+	id           int64
+	ownerID      int
+	salonName    string
+	customerName string
+	phone, email []byte
+	service      string
+	stylist      string
+	start        time.Time
+	createdAt    time.Time
+	updatedAt    time.Time
+
+	// smsOptOut and emailOptOut are the customer's opt-outs, see
+	// optOutColumns.
+	smsOptOut, emailOptOut bool
+}
+
+const appointmentNoticeColumns = `a.id, a.owner_id, COALESCE(o.salon_name, ''), c.name, c.phone, c.email, ` + optOutColumns + `,
+            sv.name, st.name, a.starts_at, a.created_at, a.updated_at
+        FROM appointments a
+        JOIN owners o ON a.owner_id = o.id
+        JOIN customers c ON a.customer_id = c.id
+        JOIN services sv ON a.service_id = sv.id
+        JOIN staff st ON a.staff_id = st.id`
+
+func scanAppointmentNotice(row rowScanner, loc *time.Location) (appointmentNotice, error) {
+	var a appointmentNotice
+	var start string
+	err := row.Scan(&a.id, &a.ownerID, &a.salonName, &a.customerName, &a.phone, &a.email, &a.smsOptOut, &a.emailOptOut,
+		&a.service, &a.stylist, &start, &a.createdAt, &a.updatedAt)
+	if err != nil {
+		return a, err
+	}
+	a.start, err = time.ParseInLocation(dateTimeLayout, start, loc)
+	return a, err
+}
+
+// fields are the template placeholders for the appointment.
+func (a appointmentNotice) fields() map[string]string {
+	return map[string]string{
+		"CustomerName": a.customerName,
+		"SalonName":    a.salonName,
+		"Date":         a.start.Format("Mon 2 Jan"),
+		"Time":         a.start.Format("15:04"),
+		"Service":      a.service,
+		"Stylist":      a.stylist,
+	}
+}
+
+// contact picks how to reach the customer: SMS if they have a phone number,
+// otherwise email, leaving out channels they opted out of.
+func (a appointmentNotice) contact() (string, string) {
+	if phone, err := decryptField(a.phone); err == nil && phone != "" && !a.smsOptOut {
+		if normalized, ok := normalizeContact("sms", phone); ok {
+			return "sms", normalized
+		}
+		return "sms", phone
+	}
+	if email, err := decryptField(a.email); err == nil && email != "" && !a.emailOptOut {
+		return "email", strings.ToLower(strings.TrimSpace(email))
+	}
+	return "", ""
+}
+
+// unreachable is why contact found no way to message the customer.
+func (a appointmentNotice) unreachable() string {
+	phone, _ := decryptField(a.phone)
+	email, _ := decryptField(a.email)
+	if (phone != "" && a.smsOptOut) || (email != "" && a.emailOptOut) {
+		return "Customer opted out of messages"
+	}
+	return "Customer has no phone number or email"
+}
+
+// StartAppointmentReminders sends appointment confirmations and reminders
+// that have come due, every minute.
+func StartAppointmentReminders(db *sql.DB) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		sendAppointmentNotifications(database.GetDB(), time.Now())
+		<-ticker.C
+	}
+}
+
+// sendAppointmentNotifications sends, for each upcoming booked appointment,
+// a confirmation once it's booked and a reminder at each of the owner's
+// offsets before it starts. Only the latest reminder that has come due is
+// sent, and none for offsets that had already passed when it was booked.
+func sendAppointmentNotifications(db *sql.DB, now time.Time) {
+	rows, err := db.Query(`
+        SELECT id, COALESCE(appointment_confirmations, 1), COALESCE(reminder_offsets, '1440,120') FROM owners
+        WHERE id IN (SELECT owner_id FROM appointments WHERE status = 'booked')`)
+	if err != nil {
+		log.Printf("Error querying appointment reminder settings: %v", err)
+		return
+	}
+	type ownerSettings struct {
+		id            int
+		confirmations bool
+		offsets       []int
+	}
+	var owners []ownerSettings
+	for rows.Next() {
+		var o ownerSettings
+		var offsets string
+		if err := rows.Scan(&o.id, &o.confirmations, &offsets); err == nil {
+			o.offsets = parseReminderOffsets(offsets)
+			owners = append(owners, o)
+		}
+	}
+	rows.Close()
+
+	for _, o := range owners {
+		sched, err := loadSalonSchedule(db, o.id)
+		if err != nil {
+			log.Printf("Failed to load schedule for owner %d: %v", o.id, err)
+			continue
+		}
+		maxOffset := 0
+		for _, off := range o.offsets {
+			maxOffset = max(maxOffset, off)
+		}
+		nowLocal := now.In(sched.loc)
+		// Appointments booked or confirmed in the last day may still need a
+		// confirmation; others only once their first reminder is near.
+		rows, err := db.Query(`
+            SELECT `+appointmentNoticeColumns+`
+            WHERE a.owner_id = ? AND a.status = 'booked' AND a.starts_at > ?
+                AND (a.starts_at <= ? OR a.updated_at > ?)
+            ORDER BY a.starts_at`,
+			o.id, nowLocal.Format(dateTimeLayout), nowLocal.Add(time.Duration(maxOffset)*time.Minute).Format(dateTimeLayout),
+			now.Add(-24*time.Hour))
+		if err != nil {
+			log.Printf("Error querying appointments to remind: %v", err)
+			continue
+		}
+		var due []appointmentNotice
+		for rows.Next() {
+			a, err := scanAppointmentNotice(rows, sched.loc)
+			if err != nil {
+				log.Printf("Error scanning appointment to remind: %v", err)
+				continue
+			}
+			due = append(due, a)
+		}
+		rows.Close()
+
+		for _, a := range due {
+			if o.confirmations && now.Sub(a.updatedAt) < 24*time.Hour {
+				if err := notifyAppointment(db, a, "confirmation", 0, now); err != nil {
+					log.Printf("Failed to send confirmation for appointment %d: %v", a.id, err)
+				}
+			}
+			offset := 0
+			for _, off := range o.offsets {
+				if !now.Before(a.start.Add(-time.Duration(off)*time.Minute)) && (offset == 0 || off < offset) {
+					offset = off
+				}
+			}
+			if offset > 0 && a.createdAt.Before(a.start.Add(-time.Duration(offset)*time.Minute)) {
+				if err := notifyAppointment(db, a, "reminder", offset, now); err != nil {
+					log.Printf("Failed to send reminder for appointment %d: %v", a.id, err)
+				}
+			}
+		}
+	}
+}
+
+// notifyAppointment sends one confirmation or reminder and records it. A
+// message already recorded for the appointment, kind and offset isn't sent
+// again, and a failed send isn't retried.
+func notifyAppointment(db *sql.DB, a appointmentNotice, kind string, offset int, now time.Time) error {
+	res, err := db.Exec(`
+        INSERT OR IGNORE INTO appointment_notifications (appointment_id, kind, offset_minutes, status, created_at)
+        VALUES (?, ?, ?, 'sending', ?)`, a.id, kind, offset, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	id, _ := res.LastInsertId()
+
+	channel, to := a.contact()
+	status, errText := "sent", ""
+	if to == "" {
+		status, errText = "skipped", a.unreachable()
+	} else {
+		message := reminders.FillTemplate(loadReminderTemplate(db, a.ownerID, "appointment_"+kind), a.fields())
+		if channel == "sms" {
+			err = reminders.SendSMS(to, message)
+		} else {
+			err = reminders.SendEmail(to, "Your appointment at "+a.salonName, message)
+		}
+		if err != nil {
+			status, errText = "failed", err.Error()
+		}
+	}
+	_, err = db.Exec("UPDATE appointment_notifications SET channel = ?, recipient = ?, recipient_hash = ?, status = ?, error = ? WHERE id = ?",
+		nullIfEmpty(channel), nullIfEmpty(maskContact(to)), nullIfEmpty(contactHashIfSet(to)), status, nullIfEmpty(errText), id)
+	return err
+}
+
+func contactHashIfSet(contact string) string {
+	if contact == "" {
+		return ""
+	}
+	return contactHash(contact)
+}
+
+// replyAction reads a customer's SMS reply: C or YES confirms, X or NO
+// cancels, and BOOK takes a waitlist offer. The reply has to be just the
+// keyword, so a message like "no idea, what time was it?" isn't taken as a
+// cancellation.
+func replyAction(body string) string {
+	fields := strings.Fields(strings.ToUpper(body))
+	if len(fields) != 1 {
+		return ""
+	}
+	switch strings.Trim(fields[0], ".!") {
+	case "C", "Y", "YES", "CONFIRM":
+		return "confirm"
+	case "X", "N", "NO", "CANCEL":
+		return "cancel"
+	case "BOOK":
+		return "book"
+	}
+	return ""
+}
+
+// handleAppointmentReply applies a confirm or cancel reply from a phone
+// number to the upcoming appointment it was last messaged about, returning
+// the acknowledgement to send back, if any.
+func handleAppointmentReply(db *sql.DB, phone, body string, now time.Time) (string, error) {
+	action := replyAction(body)
+	if action == "" {
+		return "", nil
+	}
+	if action == "book" {
+		return handleWaitlistReply(db, phone, now)
+	}
+	var appointmentID int64
+	var ownerID int
+	err := db.QueryRow(`
+        SELECT a.id, a.owner_id FROM appointment_notifications n
+        JOIN appointments a ON n.appointment_id = a.id
+        WHERE n.recipient_hash = ? AND n.channel = 'sms' AND n.status = 'sent' AND a.status = 'booked'
+        ORDER BY n.id DESC LIMIT 1`, contactHash(phone)).Scan(&appointmentID, &ownerID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		return "", err
+	}
+	a, err := scanAppointmentNotice(db.QueryRow("SELECT "+appointmentNoticeColumns+" WHERE a.id = ?", appointmentID), sched.loc)
+	if err != nil {
+		return "", err
+	}
+	if !a.start.After(now) {
+		return "", nil
+	}
+
+	event := "appointment_confirmed"
+	if action == "cancel" {
+		// Cancelling by text is held to the same policy as at the front desk.
+		event = "appointment_cancelled"
+		tx, err := db.Begin()
+		if err != nil {
+			return "", err
+		}
+		defer tx.Rollback()
+		if _, _, err := endAppointment(tx, a.ownerID, a.id, "cancel", false, now); err != nil {
+			return "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", err
+		}
+		go offerFreedSlot(db, a.ownerID, a.id, now)
+	} else if _, err := db.Exec("UPDATE appointments SET confirmed_at = ? WHERE id = ?", now, a.id); err != nil {
+		return "", err
+	}
+	return reminders.FillTemplate(loadReminderTemplate(db, a.ownerID, event), a.fields()), nil
+}
+
+// --- Webhook: Twilio Incoming SMS ---
+// Twilio posts customers' text messages here. The request signature is
+// checked with TWILIO_AUTH_TOKEN against PUBLIC_BASE_URL, and requests are
+// refused if no token is configured. Any reply is returned as TwiML.
+func TwilioIncomingSMS(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	token := os.Getenv("TWILIO_AUTH_TOKEN")
+	if token == "" {
+		log.Printf("Rejected Twilio webhook: TWILIO_AUTH_TOKEN is not set")
+		http.Error(w, "SMS replies are not configured", http.StatusServiceUnavailable)
+		return
+	}
+	params := map[string]string{}
+	for k, v := range r.PostForm {
+		params[k] = v[0]
+	}
+	validator := twilioclient.NewRequestValidator(token)
+	if !validator.Validate(publicBaseURL()+r.URL.RequestURI(), params, r.Header.Get("X-Twilio-Signature")) {
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return
+	}
+
+	var reply string
+	if from, ok := normalizeContact("sms", r.PostForm.Get("From")); ok {
+		db, body := database.GetDB(), r.PostForm.Get("Body")
+		handled, err := handleConsentReply(db, from, body, time.Now())
+		if err == nil && !handled {
+			reply, err = handleAppointmentReply(db, from, body, time.Now())
+		}
+		if err != nil {
+			log.Printf("Failed to handle SMS reply: %v", err)
+		}
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(xml.Header + "<Response>"))
+	if reply != "" {
+		w.Write([]byte("<Message>"))
+		xml.EscapeText(w, []byte(reply))
+		w.Write([]byte("</Message>"))
+	}
+	w.Write([]byte("</Response>"))
+}
+
+// --- API: Get Appointment Reminder Settings ---
+func APIGetAppointmentReminderSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var confirmations bool
+	var offsets string
+	err := database.GetDB().QueryRow(`
+        SELECT COALESCE(appointment_confirmations, 1), COALESCE(reminder_offsets, '1440,120') FROM owners WHERE id = ?`,
+		ownerID).Scan(&confirmations, &offsets)
+	if err != nil {
+		http.Error(w, "Failed to fetch reminder settings", http.StatusInternalServerError)
+		return
+	}
+	parsed := parseReminderOffsets(offsets)
+	if parsed == nil {
+		parsed = []int{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"confirmations":   confirmations,
+		"offsets_minutes": parsed,
+	})
+}
+
+// --- API: Update Appointment Reminder Settings ---
+// Body: {"confirmations": true, "offsets_minutes": [1440, 120]} sends a
+// confirmation on booking and reminders 24 hours and 2 hours before.
+func APIUpdateAppointmentReminderSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		Confirmations bool  `json:"confirmations"`
+		Offsets       []int `json:"offsets_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Offsets) > 5 {
+		http.Error(w, "At most 5 reminders can be sent", http.StatusBadRequest)
+		return
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(req.Offsets)))
+	parts := make([]string, len(req.Offsets))
+	for i, off := range req.Offsets {
+		if off <= 0 || off > 7*24*60 {
+			http.Error(w, "Reminder offsets must be 1 minute to 7 days (10080 minutes)", http.StatusBadRequest)
+			return
+		}
+		if i > 0 && off == req.Offsets[i-1] {
+			http.Error(w, "Reminder offsets must be different", http.StatusBadRequest)
+			return
+		}
+		parts[i] = strconv.Itoa(off)
+	}
+	_, err := database.GetDB().Exec("UPDATE owners SET appointment_confirmations = ?, reminder_offsets = ?, updated_at = ? WHERE id = ?",
+		req.Confirmations, strings.Join(parts, ","), time.Now(), ownerID)
+	if err != nil {
+		http.Error(w, "Failed to save reminder settings", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type reminderTemplate struct {
+	EventType string `json:"event_type"`
+	Template  string `json:"template"`
+	Default   bool   `json:"default"`
+}
+
+// --- API: List Reminder Templates ---
+// Lists every message template, with the default wording for those the
+// owner hasn't changed.
+func APIGetReminderTemplates(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	rows, err := database.GetDB().Query("SELECT event_type, template FROM reminder_templates WHERE owner_id = ?", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch templates", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	saved := map[string]string{}
+	for rows.Next() {
+		var event, template string
+		if err := rows.Scan(&event, &template); err == nil {
+			saved[event] = template
+		}
+	}
+	templates := []reminderTemplate{}
+	for _, event := range reminderEvents {
+		t := reminderTemplate{EventType: event, Template: saved[event]}
+		if t.Template == "" {
+			t.Template, t.Default = defaultReminderTemplates[event], true
+		}
+		templates = append(templates, t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// --- API: Update Reminder Template ---
+// Body: {"template"}. Placeholders are [CustomerName] and [SalonName], plus
+// [Event] for birthdays and anniversaries, [Date], [Time], [Service] and
+// [Stylist] for appointments, [Link] and [Minutes] for waitlist offers and
+// [Stylist] and [Link] for feedback requests and [LastVisit], [Code],
+// [Expires] and [Offer] for win-back messages. An empty template restores
+// the default.
+func APIUpdateReminderTemplate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	event := chi.URLParam(r, "event")
+	if _, ok := defaultReminderTemplates[event]; !ok {
+		http.Error(w, "Unknown template", http.StatusNotFound)
+		return
+	}
+	var req struct {
+		Template string `json:"template"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Template = strings.TrimSpace(req.Template)
+	if len(req.Template) > 480 {
+		http.Error(w, "Template is too long (max 480 characters)", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var err error
+	if req.Template == "" {
+		_, err = db.Exec("DELETE FROM reminder_templates WHERE owner_id = ? AND event_type = ?", ownerID, event)
+	} else {
+		_, err = db.Exec(`
+            INSERT INTO reminder_templates (owner_id, event_type, template) VALUES (?, ?, ?)
+            ON CONFLICT(owner_id, event_type) DO UPDATE SET template = excluded.template`, ownerID, event, req.Template)
+	}
+	if err != nil {
+		http.Error(w, "Failed to save template", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type appointmentNotification struct {
+	ID        int64  `json:"id"`
+	Kind      string `json:"kind"`
+	Offset    int    `json:"offset_minutes"`
+	Channel   string `json:"channel"`
+	Recipient string `json:"recipient"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// --- API: Appointment Notifications ---
+// Lists the confirmations and reminders sent for an appointment.
+func APIGetAppointmentNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM appointments WHERE id = ? AND owner_id = ?", id, ownerID).Scan(&count)
+	if count == 0 {
+		http.Error(w, "Appointment not found", http.StatusNotFound)
+		return
+	}
+	rows, err := db.Query(`
+        SELECT id, kind, offset_minutes, COALESCE(channel, ''), COALESCE(recipient, ''), status, COALESCE(error, ''), created_at
+        FROM appointment_notifications WHERE appointment_id = ? ORDER BY id`, id)
+	if err != nil {
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	notifications := []appointmentNotification{}
+	for rows.Next() {
+		var n appointmentNotification
+		if err := rows.Scan(&n.ID, &n.Kind, &n.Offset, &n.Channel, &n.Recipient, &n.Status, &n.Error, &n.CreatedAt); err != nil {
+			log.Printf("Failed to scan notification: %v", err)
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
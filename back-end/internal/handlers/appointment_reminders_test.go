@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReminderOffsets(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+	}{
+		{"1440,120", []int{1440, 120}},
+		{" 60 , 15 ", []int{60, 15}},
+		{"60,,abc,-5,0", []int{60}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := parseReminderOffsets(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseReminderOffsets(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReplyAction(t *testing.T) {
+	tests := map[string]string{
+		"C":                       "confirm",
+		"yes":                     "confirm",
+		" Confirm! ":              "confirm",
+		"X":                       "cancel",
+		"no.":                     "cancel",
+		"CANCEL":                  "cancel",
+		"hello":                   "",
+		"":                        "",
+		"yes please":              "",
+		"no idea what time it is": "",
+		"C U there":               "",
+	}
+	for body, want := range tests {
+		if got := replyAction(body); got != want {
+			t.Errorf("replyAction(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestHandleAppointmentReply(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 11, 2, 9, 0, 0, 0, time.UTC)
+	phone, _ := encryptField("+15550001111")
+	mustExec(t, db,
+		`INSERT INTO owners (id, email, password_hash, salon_name, timezone) VALUES (1, 'a@example.com', 'x', 'Shear Joy', 'UTC')`,
+		`INSERT INTO staff (id, owner_id, name) VALUES (1, 1, 'Ann')`,
+		`INSERT INTO services (id, owner_id, name, price) VALUES (1, 1, 'Cut', 30)`,
+	)
+	if _, err := db.Exec("INSERT INTO customers (id, owner_id, name, phone) VALUES (1, 1, 'Jane', ?)", phone); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []struct {
+		id     int
+		starts string
+	}{
+		{1, "2026-11-03 10:00"},
+		{2, "2026-11-02 08:00"},
+	} {
+		if _, err := db.Exec(`INSERT INTO appointments (id, owner_id, customer_id, staff_id, service_id, starts_at, ends_at, created_at, updated_at)
+            VALUES (?, 1, 1, 1, 1, ?, ?, ?, ?)`, a.id, a.starts, a.starts, now, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addNotification := func(appointmentID int) {
+		mustExec(t, db, "DELETE FROM appointment_notifications")
+		if _, err := db.Exec(`INSERT INTO appointment_notifications (appointment_id, kind, channel, recipient_hash, status)
+            VALUES (?, 'reminder', 'sms', ?, 'sent')`, appointmentID, contactHash("+15550001111")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addNotification(1)
+	if reply, err := handleAppointmentReply(db, "+15550002222", "YES", now); err != nil || reply != "" {
+		t.Errorf("reply from an unknown number = %q (%v), want none", reply, err)
+	}
+	if reply, err := handleAppointmentReply(db, "+15550001111", "what time?", now); err != nil || reply != "" {
+		t.Errorf("reply to an unrecognised message = %q (%v), want none", reply, err)
+	}
+	reply, err := handleAppointmentReply(db, "+15550001111", "YES", now)
+	if err != nil || reply == "" {
+		t.Fatalf("confirm reply = %q (%v), want a message", reply, err)
+	}
+	var confirmed bool
+	db.QueryRow("SELECT confirmed_at IS NOT NULL FROM appointments WHERE id = 1").Scan(&confirmed)
+	if !confirmed {
+		t.Error("appointment wasn't confirmed")
+	}
+	if _, err := handleAppointmentReply(db, "+15550001111", "CANCEL", now); err != nil {
+		t.Fatal(err)
+	}
+	var status string
+	db.QueryRow("SELECT status FROM appointments WHERE id = 1").Scan(&status)
+	if status != "cancelled" {
+		t.Errorf("status = %q, want cancelled", status)
+	}
+
+	addNotification(2)
+	if reply, err := handleAppointmentReply(db, "+15550001111", "CANCEL", now); err != nil || reply != "" {
+		t.Errorf("reply for a past appointment = %q (%v), want none", reply, err)
+	}
+	db.QueryRow("SELECT status FROM appointments WHERE id = 2").Scan(&status)
+	if status != "booked" {
+		t.Errorf("past appointment status = %q, want booked", status)
+	}
+}
+
+func TestTwilioIncomingSMSSignature(t *testing.T) {
+	openTestDB(t)
+	send := func() int {
+		r := httptest.NewRequest("POST", "/webhooks/twilio/sms", strings.NewReader("From=%2B15550001111&Body=YES"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		TwilioIncomingSMS(w, r)
+		return w.Code
+	}
+	t.Setenv("TWILIO_AUTH_TOKEN", "")
+	if code := send(); code != http.StatusServiceUnavailable {
+		t.Errorf("without TWILIO_AUTH_TOKEN: status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	t.Setenv("TWILIO_AUTH_TOKEN", "secret")
+	if code := send(); code != http.StatusForbidden {
+		t.Errorf("unsigned request: status = %d, want %d", code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,385 @@
+// internal/handlers/appointment_series.go
+// Recurring appointments: a series books the same service every N weeks or
+// every N months on the same weekday of the month, until an end date or for
+// a number of occurrences. Each occurrence is checked against the
+// availability engine and booked up front.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// maxSeriesOccurrences caps how many appointments one series books.
+const maxSeriesOccurrences = 52
+
+type appointmentSeries struct {
+	ID          int64     `json:"id"`
+	CustomerID  int64     `json:"customer_id"`
+	Customer    string    `json:"customer_name"`
+	ServiceID   int64     `json:"service_id"`
+	Service     string    `json:"service_name"`
+	StaffID     *int64    `json:"staff_id"`
+	Frequency   string    `json:"frequency"`
+	Interval    int       `json:"interval"`
+	Start       string    `json:"start"`
+	EndsOn      string    `json:"ends_on,omitempty"`
+	Occurrences int       `json:"occurrences,omitempty"`
+	Notes       string    `json:"notes"`
+	Status      string    `json:"status"`
+	Upcoming    int       `json:"upcoming"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// seriesOccurrences lists the start times of a series beginning at first,
+// stopping after count occurrences or the last one on or before the date
+// until, whichever is given. It returns one more than maxSeriesOccurrences
+// at most, so callers can tell the series is too long.
+func seriesOccurrences(first time.Time, frequency string, interval, count int, until string) []time.Time {
+	var times []time.Time
+	nth := (first.Day()-1)/7 + 1
+	for i := 0; len(times) <= maxSeriesOccurrences; i++ {
+		if count > 0 && len(times) == count {
+			break
+		}
+		var t time.Time
+		if frequency == "monthly_weekday" {
+			t = nthWeekday(first.Year(), first.Month()+time.Month(i*interval), first.Weekday(), nth, first, first.Location())
+		} else {
+			t = first.AddDate(0, 0, 7*interval*i)
+		}
+		if until != "" && t.Format("2006-01-02") > until {
+			break
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// nthWeekday is the nth weekday of a month at clock's time of day, the 5th
+// meaning the last.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int, clock time.Time, loc *time.Location) time.Time {
+	if n >= 5 {
+		t := time.Date(year, month+1, 0, clock.Hour(), clock.Minute(), 0, 0, loc)
+		for t.Weekday() != weekday {
+			t = t.AddDate(0, 0, -1)
+		}
+		return t
+	}
+	t := time.Date(year, month, 1, clock.Hour(), clock.Minute(), 0, 0, loc)
+	for t.Weekday() != weekday {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.AddDate(0, 0, 7*(n-1))
+}
+
+// --- API: Create Appointment Series ---
+// Body: {"customer_id", "service_id", "staff_id" (optional), "start" (the
+// first occurrence), "frequency" (weekly or monthly_weekday), "interval",
+// "ends_on" or "count", "notes", "skip_conflicts"}. Occurrences that don't
+// fit the schedule fail the whole series with 409 and a list of conflicts
+// unless skip_conflicts is set. With dry_run=1 nothing is saved and every
+// occurrence is listed with whether it's free.
+func APICreateAppointmentSeries(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		appointmentBooking
+		Frequency     string `json:"frequency"`
+		Interval      int    `json:"interval"`
+		EndsOn        string `json:"ends_on"`
+		Count         int    `json:"count"`
+		SkipConflicts bool   `json:"skip_conflicts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Notes = strings.TrimSpace(req.Notes)
+	switch req.Frequency {
+	case "weekly":
+		if req.Interval < 1 || req.Interval > 52 {
+			http.Error(w, "Weekly series repeat every 1-52 weeks", http.StatusBadRequest)
+			return
+		}
+	case "monthly_weekday":
+		if req.Interval < 1 || req.Interval > 12 {
+			http.Error(w, "Monthly series repeat every 1-12 months", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Frequency must be weekly or monthly_weekday", http.StatusBadRequest)
+		return
+	}
+	if (req.EndsOn == "") == (req.Count == 0) {
+		http.Error(w, "Give either an end date or a number of occurrences", http.StatusBadRequest)
+		return
+	}
+	if req.Count < 0 || req.Count > maxSeriesOccurrences {
+		http.Error(w, "A series can have at most 52 occurrences", http.StatusBadRequest)
+		return
+	}
+	if req.EndsOn != "" {
+		if _, err := time.Parse("2006-01-02", req.EndsOn); err != nil {
+			http.Error(w, "Invalid end date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to create series", http.StatusInternalServerError)
+		return
+	}
+	first, err := parseSalonTime(req.Start, sched.loc)
+	if err != nil {
+		http.Error(w, "Invalid start time", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if !first.After(now) {
+		http.Error(w, "The first occurrence must be in the future", http.StatusBadRequest)
+		return
+	}
+	times := seriesOccurrences(first, req.Frequency, req.Interval, req.Count, req.EndsOn)
+	if len(times) == 0 {
+		http.Error(w, "The end date is before the first occurrence", http.StatusBadRequest)
+		return
+	}
+	if len(times) > maxSeriesOccurrences {
+		http.Error(w, "A series can have at most 52 occurrences", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to create series", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	staffID := sql.NullInt64{Int64: req.StaffID, Valid: req.StaffID != 0}
+	res, err := tx.Exec(`
+        INSERT INTO appointment_series (owner_id, customer_id, service_id, staff_id, frequency, interval_count, starts_at,
+            ends_on, occurrences, notes, status, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'active', ?, ?)`,
+		ownerID, req.CustomerID, req.ServiceID, staffID, req.Frequency, req.Interval, first.Format(dateTimeLayout),
+		nullIfEmpty(req.EndsOn), sql.NullInt64{Int64: int64(req.Count), Valid: req.Count > 0}, nullIfEmpty(req.Notes), now, now)
+	if err != nil {
+		http.Error(w, "Failed to create series", http.StatusInternalServerError)
+		return
+	}
+	seriesID, _ := res.LastInsertId()
+
+	type occurrence struct {
+		Start         string `json:"start"`
+		AppointmentID int64  `json:"appointment_id,omitempty"`
+		Available     bool   `json:"available"`
+	}
+	occurrences := []occurrence{}
+	conflicts := []string{}
+	booked := 0
+	for _, t := range times {
+		b := req.appointmentBooking
+		b.Start = t.Format(dateTimeLayout)
+		b.SeriesID = seriesID
+		id, _, err := bookAppointment(tx, ownerID, sched, b, "front_desk", "booked", now)
+		if err == errSlotUnavailable {
+			conflicts = append(conflicts, t.Format(time.RFC3339))
+			occurrences = append(occurrences, occurrence{Start: t.Format(time.RFC3339)})
+			continue
+		} else if _, ok := err.(invoiceInputError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("Failed to book series occurrence: %v", err)
+			http.Error(w, "Failed to create series", http.StatusInternalServerError)
+			return
+		}
+		booked++
+		occurrences = append(occurrences, occurrence{Start: t.Format(time.RFC3339), AppointmentID: id, Available: true})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("dry_run") == "1" {
+		for i := range occurrences {
+			occurrences[i].AppointmentID = 0
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"occurrences": occurrences, "conflicts": conflicts})
+		return
+	}
+	if booked == 0 || (len(conflicts) > 0 && !req.SkipConflicts) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":     "Some occurrences clash with the schedule or other bookings",
+			"conflicts": conflicts,
+		})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create series", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": seriesID, "occurrences": occurrences, "conflicts": conflicts})
+}
+
+// --- API: List Appointment Series ---
+// Lists active series, or every series with status=all.
+func APIGetAppointmentSeries(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch series", http.StatusInternalServerError)
+		return
+	}
+	query := `
+        SELECT s.id, s.customer_id, c.name, s.service_id, sv.name, s.staff_id, s.frequency, s.interval_count, s.starts_at,
+            COALESCE(s.ends_on, ''), COALESCE(s.occurrences, 0), COALESCE(s.notes, ''), s.status, s.created_at,
+            (SELECT COUNT(*) FROM appointments a WHERE a.series_id = s.id AND a.status IN ('booked', 'pending') AND a.starts_at > ?)
+        FROM appointment_series s
+        JOIN customers c ON s.customer_id = c.id
+        JOIN services sv ON s.service_id = sv.id
+        WHERE s.owner_id = ?`
+	if r.URL.Query().Get("status") != "all" {
+		query += " AND s.status = 'active'"
+	}
+	rows, err := db.Query(query+" ORDER BY c.name, s.id", time.Now().In(sched.loc).Format(dateTimeLayout), ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch series", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	series := []appointmentSeries{}
+	for rows.Next() {
+		var s appointmentSeries
+		var staffID sql.NullInt64
+		if err := rows.Scan(&s.ID, &s.CustomerID, &s.Customer, &s.ServiceID, &s.Service, &staffID, &s.Frequency, &s.Interval,
+			&s.Start, &s.EndsOn, &s.Occurrences, &s.Notes, &s.Status, &s.CreatedAt, &s.Upcoming); err != nil {
+			log.Printf("Failed to scan appointment series: %v", err)
+			continue
+		}
+		if staffID.Valid {
+			s.StaffID = &staffID.Int64
+		}
+		if t, err := time.ParseInLocation(dateTimeLayout, s.Start, sched.loc); err == nil {
+			s.Start = t.Format(time.RFC3339)
+		}
+		series = append(series, s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
+// --- API: Cancel Appointment Series ---
+// Cancels every upcoming occurrence and ends the series. Occurrences inside
+// the cancellation window are charged as late cancellations unless
+// waive_fee=1 is given.
+func APICancelAppointmentSeries(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	seriesID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid series ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to cancel series", http.StatusInternalServerError)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to cancel series", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	res, err := tx.Exec("UPDATE appointment_series SET status = 'cancelled', updated_at = ? WHERE id = ? AND owner_id = ? AND status = 'active'",
+		now, seriesID, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to cancel series", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := tx.Query(`
+        SELECT id FROM appointments WHERE series_id = ? AND status IN ('booked', 'pending') AND starts_at > ?
+        ORDER BY starts_at`, seriesID, now.In(sched.loc).Format(dateTimeLayout))
+	if err != nil {
+		http.Error(w, "Failed to cancel series", http.StatusInternalServerError)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	waive := r.URL.Query().Get("waive_fee") == "1"
+	feeInvoiceIDs := []int64{}
+	for _, id := range ids {
+		_, feeInvoiceID, err := endAppointment(tx, ownerID, id, "cancel", waive, now)
+		if err != nil {
+			log.Printf("Failed to cancel appointment %d of series %d: %v", id, seriesID, err)
+			http.Error(w, "Failed to cancel series", http.StatusInternalServerError)
+			return
+		}
+		if feeInvoiceID != 0 {
+			feeInvoiceIDs = append(feeInvoiceIDs, feeInvoiceID)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to cancel series", http.StatusInternalServerError)
+		return
+	}
+	for _, id := range ids {
+		go offerFreedSlot(db, ownerID, id, now)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"cancelled": len(ids), "fee_invoice_ids": feeInvoiceIDs})
+}
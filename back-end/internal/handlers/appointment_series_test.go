@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNthWeekday(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		month   time.Month
+		weekday time.Weekday
+		n       int
+		want    string
+	}{
+		{time.November, time.Monday, 1, "2026-11-02 10:30"},
+		{time.November, time.Sunday, 1, "2026-11-01 10:30"},
+		{time.November, time.Tuesday, 3, "2026-11-17 10:30"},
+		{time.November, time.Monday, 5, "2026-11-30 10:30"},
+		{time.December, time.Monday, 5, "2026-12-28 10:30"},
+		{time.February, time.Saturday, 5, "2026-02-28 10:30"},
+	}
+	for _, tt := range tests {
+		got := nthWeekday(2026, tt.month, tt.weekday, tt.n, clock, time.UTC).Format(dateTimeLayout)
+		if got != tt.want {
+			t.Errorf("nthWeekday(%s, %s, %d) = %s, want %s", tt.month, tt.weekday, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSeriesOccurrences(t *testing.T) {
+	format := func(times []time.Time) []string {
+		var s []string
+		for _, t := range times {
+			s = append(s, t.Format(dateTimeLayout))
+		}
+		return s
+	}
+	// Monday 2 November 2026 is the first Monday; 30 November is the last.
+	first := time.Date(2026, 11, 2, 10, 0, 0, 0, time.UTC)
+	last := time.Date(2026, 11, 30, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		first     time.Time
+		frequency string
+		interval  int
+		count     int
+		until     string
+		want      []string
+	}{
+		{"weekly by count", first, "weekly", 1, 3, "",
+			[]string{"2026-11-02 10:00", "2026-11-09 10:00", "2026-11-16 10:00"}},
+		{"fortnightly until a date", first, "weekly", 2, 0, "2026-11-30",
+			[]string{"2026-11-02 10:00", "2026-11-16 10:00", "2026-11-30 10:00"}},
+		{"first Monday monthly", first, "monthly_weekday", 1, 3, "",
+			[]string{"2026-11-02 10:00", "2026-12-07 10:00", "2027-01-04 10:00"}},
+		{"last Monday every other month", last, "monthly_weekday", 2, 3, "",
+			[]string{"2026-11-30 10:00", "2027-01-25 10:00", "2027-03-29 10:00"}},
+	}
+	for _, tt := range tests {
+		got := format(seriesOccurrences(tt.first, tt.frequency, tt.interval, tt.count, tt.until))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: seriesOccurrences() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := seriesOccurrences(first, "weekly", 1, 0, "2099-01-01"); len(got) != maxSeriesOccurrences+1 {
+		t.Errorf("open-ended series has %d occurrences, want %d to flag it as too long", len(got), maxSeriesOccurrences+1)
+	}
+}
@@ -0,0 +1,415 @@
+// internal/handlers/availability.go
+// The availability engine: works out bookable appointment slots from salon
+// hours, closures, staff schedules, time off and existing appointments.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"salon-management/internal/database"
+)
+
+// Wall-clock layouts used for schedule data stored as TEXT.
+const (
+	clockLayout    = "15:04"
+	dateTimeLayout = "2006-01-02 15:04"
+)
+
+// timeRange is a span of minutes from midnight on one day, end exclusive.
+type timeRange struct{ start, end int }
+
+// parseClock reads an "HH:MM" time of day as minutes from midnight. "24:00"
+// is allowed as the end of the day.
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse(clockLayout, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// intersectRanges returns the spans covered by both a and b.
+func intersectRanges(a, b []timeRange) []timeRange {
+	var out []timeRange
+	for _, x := range a {
+		for _, y := range b {
+			s, e := max(x.start, y.start), min(x.end, y.end)
+			if s < e {
+				out = append(out, timeRange{s, e})
+			}
+		}
+	}
+	return out
+}
+
+// subtractRange removes busy from each of the free spans.
+func subtractRange(free []timeRange, busy timeRange) []timeRange {
+	var out []timeRange
+	for _, f := range free {
+		if busy.end <= f.start || busy.start >= f.end {
+			out = append(out, f)
+			continue
+		}
+		if busy.start > f.start {
+			out = append(out, timeRange{f.start, busy.start})
+		}
+		if busy.end < f.end {
+			out = append(out, timeRange{busy.end, f.end})
+		}
+	}
+	return out
+}
+
+// salonSchedule is an owner's time zone, slot interval and weekly opening
+// hours.
+type salonSchedule struct {
+	loc      *time.Location
+	interval int
+	hours    map[time.Weekday][]timeRange
+}
+
+// loadSalonSchedule reads the owner's time zone, slot interval and opening
+// hours. Without a time zone the server's local time is used.
+func loadSalonSchedule(q queryer, ownerID int) (*salonSchedule, error) {
+	s := &salonSchedule{loc: time.Local, interval: 15, hours: map[time.Weekday][]timeRange{}}
+	var tz sql.NullString
+	var interval sql.NullInt64
+	if err := q.QueryRow("SELECT timezone, slot_interval FROM owners WHERE id = ?", ownerID).Scan(&tz, &interval); err != nil {
+		return nil, err
+	}
+	if tz.String != "" {
+		if loc, err := time.LoadLocation(tz.String); err == nil {
+			s.loc = loc
+		}
+	}
+	if interval.Int64 > 0 {
+		s.interval = int(interval.Int64)
+	}
+	rows, err := q.Query("SELECT weekday, opens, closes FROM salon_hours WHERE owner_id = ? ORDER BY weekday, opens", ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day int
+		var opens, closes string
+		if err := rows.Scan(&day, &opens, &closes); err != nil {
+			return nil, err
+		}
+		o, err1 := parseClock(opens)
+		c, err2 := parseClock(closes)
+		if err1 == nil && err2 == nil {
+			s.hours[time.Weekday(day)] = append(s.hours[time.Weekday(day)], timeRange{o, c})
+		}
+	}
+	return s, rows.Err()
+}
+
+// parseSalonTime reads an appointment time sent by a client, either RFC 3339
+// or a wall-clock "YYYY-MM-DDTHH:MM" in the salon's time zone.
+func parseSalonTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.In(loc), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04", s, loc); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(dateTimeLayout, s, loc)
+}
+
+// busySpan is a stored "YYYY-MM-DD HH:MM" interval during which a staff
+// member can't be booked.
+type busySpan struct{ start, end string }
+
+// onDay clips the span to the day starting at the wall-clock midnight day.
+func (b busySpan) onDay(day time.Time) (timeRange, bool) {
+	s, err1 := time.Parse(dateTimeLayout, b.start)
+	e, err2 := time.Parse(dateTimeLayout, b.end)
+	if err1 != nil || err2 != nil {
+		return timeRange{}, false
+	}
+	r := timeRange{int(s.Sub(day).Minutes()), int(e.Sub(day).Minutes())}
+	r.start, r.end = max(r.start, 0), min(r.end, 24*60)
+	return r, r.start < r.end
+}
+
+// availableSlot is a time a service can be booked with a staff member.
+type availableSlot struct {
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	StaffID   int64  `json:"staff_id"`
+	StaffName string `json:"staff_name"`
+}
+
+// findAvailableSlots lists the start times between the dates from and to
+// (inclusive) at which a service of duration minutes, followed by buffer
+// minutes of cleanup, fits into a staff member's working hours without
+// overlapping time off or other appointments, and the resources it needs
+// have capacity to spare. A staffID of 0 searches every active staff member.
+// Slots start on the salon's slot interval and never in the past;
+// excludeAppointment ignores one appointment, for rescheduling.
+func findAvailableSlots(q queryer, ownerID int, sched *salonSchedule, staffID int64, duration, buffer int, needs []resourceNeed,
+	from, to time.Time, now time.Time, excludeAppointment int64) ([]availableSlot, error) {
+	query := "SELECT id, name FROM staff WHERE owner_id = ? AND active = 1"
+	args := []interface{}{ownerID}
+	if staffID != 0 {
+		query += " AND id = ?"
+		args = append(args, staffID)
+	}
+	rows, err := q.Query(query+" ORDER BY name", args...)
+	if err != nil {
+		return nil, err
+	}
+	type staffInfo struct {
+		id   int64
+		name string
+	}
+	var staff []staffInfo
+	for rows.Next() {
+		var s staffInfo
+		if err := rows.Scan(&s.id, &s.name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		staff = append(staff, s)
+	}
+	rows.Close()
+
+	fromDate, toDate := from.Format("2006-01-02"), to.Format("2006-01-02")
+	closed := map[string]bool{}
+	rows, err = q.Query(`
+        SELECT starts_on, ends_on FROM salon_closures
+        WHERE owner_id = ? AND starts_on <= ? AND ends_on >= ?`, ownerID, toDate, fromDate)
+	if err != nil {
+		return nil, err
+	}
+	var closures [][2]string
+	for rows.Next() {
+		var c [2]string
+		if err := rows.Scan(&c[0], &c[1]); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		closures = append(closures, c)
+	}
+	rows.Close()
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		for _, c := range closures {
+			if c[0] <= day && day <= c[1] {
+				closed[day] = true
+			}
+		}
+	}
+
+	// Busy spans are loaded from the day before so appointments running
+	// past midnight are seen.
+	rangeStart := from.AddDate(0, 0, -1).Format(dateTimeLayout)
+	rangeEnd := to.AddDate(0, 0, 1).Format(dateTimeLayout)
+	nowLocal := now.In(sched.loc)
+	resourceBookings, err := loadResourceBookings(q, needs, rangeStart, rangeEnd, excludeAppointment)
+	if err != nil {
+		return nil, err
+	}
+	var slots []availableSlot
+	for _, s := range staff {
+		weekly := map[time.Weekday][]timeRange{}
+		rows, err := q.Query("SELECT weekday, starts, ends FROM staff_schedules WHERE staff_id = ?", s.id)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var day int
+			var starts, ends string
+			if err := rows.Scan(&day, &starts, &ends); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			st, err1 := parseClock(starts)
+			en, err2 := parseClock(ends)
+			if err1 == nil && err2 == nil {
+				weekly[time.Weekday(day)] = append(weekly[time.Weekday(day)], timeRange{st, en})
+			}
+		}
+		rows.Close()
+
+		var busy []busySpan
+		rows, err = q.Query(`
+            SELECT starts_at, ends_at FROM staff_time_off WHERE staff_id = ? AND starts_at < ? AND ends_at > ?
+            UNION ALL
+            SELECT starts_at, strftime('%Y-%m-%d %H:%M', ends_at, '+' || buffer_minutes || ' minutes')
+            FROM appointments
+            WHERE staff_id = ? AND status IN ('booked', 'pending') AND id != ? AND starts_at < ? AND ends_at >= ?`,
+			s.id, rangeEnd, rangeStart, s.id, excludeAppointment, rangeEnd, rangeStart)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var b busySpan
+			if err := rows.Scan(&b.start, &b.end); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			busy = append(busy, b)
+		}
+		rows.Close()
+
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			if closed[d.Format("2006-01-02")] {
+				continue
+			}
+			free := intersectRanges(sched.hours[d.Weekday()], weekly[d.Weekday()])
+			// Cleanup after the last service of the day may run past
+			// closing time.
+			for i := range free {
+				free[i].end += buffer
+			}
+			midnight := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+			for _, b := range busy {
+				if r, ok := b.onDay(midnight); ok {
+					free = subtractRange(free, r)
+				}
+			}
+			for _, f := range free {
+				start := (f.start + sched.interval - 1) / sched.interval * sched.interval
+				for ; start+duration+buffer <= f.end; start += sched.interval {
+					t := time.Date(d.Year(), d.Month(), d.Day(), 0, start, 0, 0, sched.loc)
+					if !t.After(nowLocal) || !resourcesFree(needs, resourceBookings, midnight, start, start+duration+buffer) {
+						continue
+					}
+					slots = append(slots, availableSlot{
+						Start:     t.Format(time.RFC3339),
+						End:       t.Add(time.Duration(duration) * time.Minute).Format(time.RFC3339),
+						StaffID:   s.id,
+						StaffName: s.name,
+					})
+				}
+			}
+		}
+	}
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].Start < slots[j].Start })
+	return slots, nil
+}
+
+// availabilityRange reads the "start" and "end" dates of an availability
+// search in the salon's time zone, defaulting to the coming week. Searches
+// are limited to 31 days.
+func availabilityRange(r *http.Request, loc *time.Location, now time.Time) (time.Time, time.Time, error) {
+	today := now.In(loc)
+	from := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, loc)
+	if v := r.URL.Query().Get("start"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return from, from, errors.New("Invalid start date (YYYY-MM-DD)")
+		}
+		from = t
+	}
+	to := from.AddDate(0, 0, 6)
+	if v := r.URL.Query().Get("end"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return from, to, errors.New("Invalid end date (YYYY-MM-DD)")
+		}
+		to = t
+	}
+	if to.Before(from) || to.After(from.AddDate(0, 0, 31)) {
+		return from, to, errors.New("End date must be within 31 days after the start date")
+	}
+	return from, to, nil
+}
+
+// loadServiceTiming returns a service's duration and buffer in minutes.
+func loadServiceTiming(q queryer, ownerID int, serviceID int64) (string, int, int, error) {
+	var name string
+	var duration, buffer int
+	err := q.QueryRow(`
+        SELECT name, COALESCE(duration_minutes, 30), COALESCE(buffer_minutes, 0)
+        FROM services WHERE id = ? AND owner_id = ? AND active = 1`, serviceID, ownerID).Scan(&name, &duration, &buffer)
+	return name, duration, buffer, err
+}
+
+// --- API: Availability ---
+// Lists bookable slots for "service_id" (or a plain "duration" in minutes),
+// optionally for one "staff_id", between the "start" and "end" dates.
+func APIGetAvailability(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to load opening hours", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	from, to, err := availabilityRange(r, sched.loc, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var duration, buffer int
+	var needs []resourceNeed
+	if v := r.URL.Query().Get("service_id"); v != "" {
+		serviceID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid service ID", http.StatusBadRequest)
+			return
+		}
+		if _, duration, buffer, err = loadServiceTiming(db, ownerID, serviceID); err != nil {
+			http.Error(w, "Service not found", http.StatusBadRequest)
+			return
+		}
+		if needs, err = loadServiceResources(db, serviceID); err != nil {
+			http.Error(w, "Failed to work out availability", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		duration, err = strconv.Atoi(r.URL.Query().Get("duration"))
+		if err != nil || duration <= 0 || duration > 720 {
+			http.Error(w, "Give a service_id or a duration of 1-720 minutes", http.StatusBadRequest)
+			return
+		}
+	}
+	var staffID int64
+	if v := strings.TrimSpace(r.URL.Query().Get("staff_id")); v != "" {
+		if staffID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "Invalid staff ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	slots, err := findAvailableSlots(db, ownerID, sched, staffID, duration, buffer, needs, from, to, now, 0)
+	if err != nil {
+		http.Error(w, "Failed to work out availability", http.StatusInternalServerError)
+		return
+	}
+	if slots == nil {
+		slots = []availableSlot{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"timezone":         sched.loc.String(),
+		"duration_minutes": duration,
+		"buffer_minutes":   buffer,
+		"slots":            slots,
+	})
+}
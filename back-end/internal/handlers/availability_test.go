@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFindAvailableSlots(t *testing.T) {
+	db := openTestDB(t)
+	const ownerID = 1
+	// Monday 2 November 2026, with the salon open 09:00-12:00 on Mondays.
+	day := time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)
+	sched := &salonSchedule{loc: time.UTC, interval: 30, hours: map[time.Weekday][]timeRange{
+		time.Monday: {{9 * 60, 12 * 60}},
+	}}
+	dayBefore := day.AddDate(0, 0, -1)
+
+	tests := []struct {
+		name     string
+		setup    []string
+		staffID  int64
+		duration int
+		buffer   int
+		needs    []resourceNeed
+		now      time.Time
+		want     []string // "HH:MM staff_id"
+	}{
+		{
+			name:     "every interval within hours",
+			duration: 60,
+			now:      dayBefore,
+			want:     []string{"09:00 1", "09:30 1", "10:00 1", "10:30 1", "11:00 1"},
+		},
+		{
+			name:     "cleanup may run past closing",
+			duration: 60,
+			buffer:   30,
+			now:      dayBefore,
+			want:     []string{"09:00 1", "09:30 1", "10:00 1", "10:30 1", "11:00 1"},
+		},
+		{
+			name:     "cleanup before the next appointment",
+			setup:    []string{"INSERT INTO appointments (owner_id, customer_id, staff_id, service_id, starts_at, ends_at) VALUES (1, 1, 1, 1, '2026-11-02 11:00', '2026-11-02 12:00')"},
+			duration: 30,
+			buffer:   15,
+			now:      dayBefore,
+			want:     []string{"09:00 1", "09:30 1", "10:00 1"},
+		},
+		{
+			name:     "booked appointments and their buffer are busy",
+			setup:    []string{"INSERT INTO appointments (owner_id, customer_id, staff_id, service_id, starts_at, ends_at, buffer_minutes) VALUES (1, 1, 1, 1, '2026-11-02 09:30', '2026-11-02 10:00', 30)"},
+			duration: 60,
+			now:      dayBefore,
+			want:     []string{"10:30 1", "11:00 1"},
+		},
+		{
+			name:     "cancelled appointments are ignored",
+			setup:    []string{"INSERT INTO appointments (owner_id, customer_id, staff_id, service_id, starts_at, ends_at, status) VALUES (1, 1, 1, 1, '2026-11-02 09:00', '2026-11-02 11:00', 'cancelled')"},
+			duration: 90,
+			now:      dayBefore,
+			want:     []string{"09:00 1", "09:30 1", "10:00 1", "10:30 1"},
+		},
+		{
+			name:     "time off",
+			setup:    []string{"INSERT INTO staff_time_off (staff_id, kind, starts_at, ends_at) VALUES (1, 'leave', '2026-11-02 08:00', '2026-11-02 10:30')"},
+			duration: 60,
+			now:      dayBefore,
+			want:     []string{"10:30 1", "11:00 1"},
+		},
+		{
+			name:     "salon closure",
+			setup:    []string{"INSERT INTO salon_closures (owner_id, starts_on, ends_on) VALUES (1, '2026-11-01', '2026-11-03')"},
+			duration: 30,
+			now:      dayBefore,
+			want:     nil,
+		},
+		{
+			name:     "nothing in the past",
+			duration: 60,
+			now:      time.Date(2026, 11, 2, 10, 10, 0, 0, time.UTC),
+			want:     []string{"10:30 1", "11:00 1"},
+		},
+		{
+			name:     "staff hours within salon hours",
+			setup:    []string{"UPDATE staff_schedules SET starts = '10:00', ends = '17:00' WHERE staff_id = 1"},
+			duration: 60,
+			now:      dayBefore,
+			want:     []string{"10:00 1", "10:30 1", "11:00 1"},
+		},
+		{
+			name: "every active staff member",
+			setup: []string{
+				"INSERT INTO staff (id, owner_id, name) VALUES (2, 1, 'Bea')",
+				"INSERT INTO staff_schedules (staff_id, weekday, starts, ends) VALUES (2, 1, '11:00', '12:00')",
+				"INSERT INTO staff (id, owner_id, name, active) VALUES (3, 1, 'Cy', 0)",
+				"INSERT INTO staff_schedules (staff_id, weekday, starts, ends) VALUES (3, 1, '09:00', '12:00')",
+			},
+			duration: 60,
+			now:      dayBefore,
+			want:     []string{"09:00 1", "09:30 1", "10:00 1", "10:30 1", "11:00 1", "11:00 2"},
+		},
+		{
+			name: "one staff member",
+			setup: []string{
+				"INSERT INTO staff (id, owner_id, name) VALUES (2, 1, 'Bea')",
+				"INSERT INTO staff_schedules (staff_id, weekday, starts, ends) VALUES (2, 1, '11:00', '12:00')",
+			},
+			staffID:  2,
+			duration: 60,
+			now:      dayBefore,
+			want:     []string{"11:00 2"},
+		},
+		{
+			name: "room already in use",
+			setup: []string{
+				"INSERT INTO appointments (id, owner_id, customer_id, staff_id, service_id, starts_at, ends_at, buffer_minutes) VALUES (9, 1, 1, 2, 1, '2026-11-02 10:00', '2026-11-02 10:30', 30)",
+				"INSERT INTO appointment_resources (appointment_id, resource_id, quantity) VALUES (9, 1, 1)",
+			},
+			duration: 60,
+			needs:    []resourceNeed{{ResourceID: 1, Quantity: 1, Capacity: 1}},
+			now:      dayBefore,
+			want:     []string{"09:00 1", "11:00 1"},
+		},
+		{
+			name: "room with room to spare",
+			setup: []string{
+				"INSERT INTO appointments (id, owner_id, customer_id, staff_id, service_id, starts_at, ends_at) VALUES (9, 1, 1, 2, 1, '2026-11-02 10:00', '2026-11-02 11:00')",
+				"INSERT INTO appointment_resources (appointment_id, resource_id, quantity) VALUES (9, 1, 1)",
+			},
+			duration: 60,
+			needs:    []resourceNeed{{ResourceID: 1, Quantity: 1, Capacity: 2}},
+			now:      dayBefore,
+			want:     []string{"09:00 1", "09:30 1", "10:00 1", "10:30 1", "11:00 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+			setup := append([]string{
+				"INSERT INTO staff (id, owner_id, name) VALUES (1, 1, 'Ann')",
+				"INSERT INTO staff_schedules (staff_id, weekday, starts, ends) VALUES (1, 1, '08:00', '17:00')",
+			}, tt.setup...)
+			for _, stmt := range setup {
+				if _, err := tx.Exec(stmt); err != nil {
+					t.Fatalf("%s: %v", stmt, err)
+				}
+			}
+
+			slots, err := findAvailableSlots(tx, ownerID, sched, tt.staffID, tt.duration, tt.buffer, tt.needs, day, day, tt.now, 0)
+			if err != nil {
+				t.Fatalf("findAvailableSlots() error = %v", err)
+			}
+			var got []string
+			for _, s := range slots {
+				start, err := time.Parse(time.RFC3339, s.Start)
+				if err != nil {
+					t.Fatalf("slot start %q: %v", s.Start, err)
+				}
+				end, _ := time.Parse(time.RFC3339, s.End)
+				if end.Sub(start) != time.Duration(tt.duration)*time.Minute {
+					t.Errorf("slot %s-%s doesn't last %d minutes", s.Start, s.End, tt.duration)
+				}
+				got = append(got, start.Format("15:04")+" "+strconv.FormatInt(s.StaffID, 10))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findAvailableSlots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourcesFree(t *testing.T) {
+	midnight := time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)
+	booked := map[int64][]resourceBooking{
+		1: {
+			{busySpan{"2026-11-02 10:00", "2026-11-02 11:00"}, 1},
+			{busySpan{"2026-11-02 10:30", "2026-11-02 12:00"}, 1},
+		},
+		2: {{busySpan{"2026-11-01 23:00", "2026-11-02 09:30"}, 2}},
+	}
+	tests := []struct {
+		name       string
+		needs      []resourceNeed
+		start, end int // minutes after midnight
+		want       bool
+	}{
+		{"no needs", nil, 600, 660, true},
+		{"before any reservation", []resourceNeed{{ResourceID: 1, Quantity: 1, Capacity: 2}}, 540, 600, true},
+		{"one reservation at a time", []resourceNeed{{ResourceID: 1, Quantity: 1, Capacity: 2}}, 600, 630, true},
+		{"overlapping reservations fill it", []resourceNeed{{ResourceID: 1, Quantity: 1, Capacity: 2}}, 600, 660, false},
+		{"reservation starting within the slot", []resourceNeed{{ResourceID: 1, Quantity: 1, Capacity: 2}}, 540, 645, false},
+		{"more capacity", []resourceNeed{{ResourceID: 1, Quantity: 1, Capacity: 3}}, 600, 720, true},
+		{"needs more than one unit", []resourceNeed{{ResourceID: 1, Quantity: 2, Capacity: 3}}, 600, 630, true},
+		{"needs too many units", []resourceNeed{{ResourceID: 1, Quantity: 2, Capacity: 3}}, 630, 660, false},
+		{"reservation from the night before", []resourceNeed{{ResourceID: 2, Quantity: 1, Capacity: 2}}, 540, 600, false},
+		{"after the overnight reservation", []resourceNeed{{ResourceID: 2, Quantity: 1, Capacity: 2}}, 570, 600, true},
+	}
+	for _, tt := range tests {
+		if got := resourcesFree(tt.needs, booked, midnight, tt.start, tt.end); got != tt.want {
+			t.Errorf("%s: resourcesFree() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// internal/handlers/backroom_handlers.go
+// Backroom consumables: the products a service uses up (its recipe), the
+// usage recorded against each invoiced service and corrections to it.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// productUsage is an amount of a backroom product, in the product's unit
+// (e.g. grams of colour), used for one service or one invoice line.
+type productUsage struct {
+	ProductID int64  `json:"product_id"`
+	Name      string `json:"name,omitempty"`
+	Unit      string `json:"unit,omitempty"`
+	Amount    int    `json:"amount"`
+
+	unitCost float64
+}
+
+// loadServiceRecipe lists the products one of a service uses.
+func loadServiceRecipe(q queryer, serviceID int64) ([]productUsage, error) {
+	rows, err := q.Query(`
+        SELECT r.product_id, p.name, COALESCE(p.unit, ''), r.amount, p.cost_price
+        FROM service_recipes r
+        JOIN products p ON r.product_id = p.id
+        WHERE r.service_id = ? AND p.active = 1
+        ORDER BY p.name`, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	recipe := []productUsage{}
+	for rows.Next() {
+		var u productUsage
+		if err := rows.Scan(&u.ProductID, &u.Name, &u.Unit, &u.Amount, &u.unitCost); err != nil {
+			return nil, err
+		}
+		recipe = append(recipe, u)
+	}
+	return recipe, rows.Err()
+}
+
+// resolveUsage validates a list of product amounts, dropping zero amounts
+// and filling in each product's current cost.
+func resolveUsage(q queryer, ownerID int, usage []productUsage) ([]productUsage, error) {
+	resolved := []productUsage{}
+	seen := map[int64]bool{}
+	for _, u := range usage {
+		if u.Amount < 0 {
+			return nil, invoiceInputError("Usage amounts cannot be negative")
+		}
+		if seen[u.ProductID] {
+			return nil, invoiceInputError("Each product can only be listed once in usage")
+		}
+		seen[u.ProductID] = true
+		err := q.QueryRow("SELECT name, COALESCE(unit, ''), cost_price FROM products WHERE id = ? AND owner_id = ? AND active = 1",
+			u.ProductID, ownerID).Scan(&u.Name, &u.Unit, &u.unitCost)
+		if err == sql.ErrNoRows {
+			return nil, invoiceInputError(fmt.Sprintf("Product %d not found", u.ProductID))
+		} else if err != nil {
+			return nil, err
+		}
+		if u.Amount > 0 {
+			resolved = append(resolved, u)
+		}
+	}
+	return resolved, nil
+}
+
+// resolveItemUsage works out the backroom products an invoice line uses. The
+// staff member's actual usage, when sent, replaces the service's recipe,
+// which is otherwise scaled by the line quantity.
+func resolveItemUsage(q queryer, ownerID int, it *invoiceItemInput) error {
+	if it.Usage != nil {
+		usage, err := resolveUsage(q, ownerID, it.Usage)
+		it.usage = usage
+		return err
+	}
+	if it.ServiceID == 0 {
+		return nil
+	}
+	recipe, err := loadServiceRecipe(q, it.ServiceID)
+	if err != nil {
+		return err
+	}
+	for _, u := range recipe {
+		u.Amount = int(math.Round(float64(u.Amount) * it.Quantity))
+		if u.Amount > 0 {
+			it.usage = append(it.usage, u)
+		}
+	}
+	return nil
+}
+
+// recordItemUsage takes an invoice line's usage off stock and records it with
+// the cost at the time. Usage never fails for lack of stock: backroom counts
+// are approximate and the service has already been done.
+func recordItemUsage(tx *sql.Tx, invoiceID, itemID int64, usage []productUsage, now time.Time) error {
+	for _, u := range usage {
+		if _, err := moveStock(tx, u.ProductID, -u.Amount, "usage", "", sql.NullInt64{Int64: invoiceID, Valid: true}, "", now); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+            INSERT INTO invoice_item_usage (invoice_item_id, invoice_id, product_id, amount, unit_cost, created_at)
+            VALUES (?, ?, ?, ?, ?, ?)`,
+			itemID, invoiceID, u.ProductID, u.Amount, u.unitCost, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- API: Get Service Recipe ---
+func APIGetServiceRecipe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid service ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var exists int
+	if err := db.QueryRow("SELECT 1 FROM services WHERE id = ? AND owner_id = ?", id, ownerID).Scan(&exists); err != nil {
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	recipe, err := loadServiceRecipe(db, id)
+	if err != nil {
+		http.Error(w, "Failed to fetch recipe", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recipe)
+}
+
+// --- API: Update Service Recipe ---
+// Replaces the list of products, with amounts per service, that a service
+// uses.
+func APIUpdateServiceRecipe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid service ID", http.StatusBadRequest)
+		return
+	}
+	var recipe []productUsage
+	if err := json.NewDecoder(r.Body).Decode(&recipe); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var exists int
+	if err := db.QueryRow("SELECT 1 FROM services WHERE id = ? AND owner_id = ?", id, ownerID).Scan(&exists); err != nil {
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	recipe, err = resolveUsage(db, ownerID, recipe)
+	if err != nil {
+		if _, ok := err.(invoiceInputError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to update recipe", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update recipe", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM service_recipes WHERE service_id = ?", id); err != nil {
+		http.Error(w, "Failed to update recipe", http.StatusInternalServerError)
+		return
+	}
+	for _, u := range recipe {
+		if _, err := tx.Exec("INSERT INTO service_recipes (service_id, product_id, amount) VALUES (?, ?, ?)", id, u.ProductID, u.Amount); err != nil {
+			http.Error(w, "Failed to update recipe", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update recipe", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Correct Invoice Item Usage ---
+// Replaces the products recorded as used on an invoice line, e.g. when the
+// stylist mixed more colour than the recipe. The old usage is put back into
+// stock before the new usage is taken off.
+func APIUpdateInvoiceItemUsage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	invoiceID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+	itemID, err := strconv.ParseInt(chi.URLParam(r, "itemID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+	var usage []productUsage
+	if err := json.NewDecoder(r.Body).Decode(&usage); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update usage", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	var exists int
+	err = tx.QueryRow(`
+        SELECT 1 FROM invoice_items ii JOIN invoices i ON ii.invoice_id = i.id
+        WHERE ii.id = ? AND ii.invoice_id = ? AND i.owner_id = ?`, itemID, invoiceID, ownerID).Scan(&exists)
+	if err != nil {
+		http.Error(w, "Invoice item not found", http.StatusNotFound)
+		return
+	}
+	usage, err = resolveUsage(tx, ownerID, usage)
+	if err != nil {
+		if _, ok := err.(invoiceInputError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to update usage", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	now := time.Now()
+	rows, err := tx.Query("SELECT product_id, amount FROM invoice_item_usage WHERE invoice_item_id = ?", itemID)
+	if err != nil {
+		http.Error(w, "Failed to update usage", http.StatusInternalServerError)
+		return
+	}
+	var previous []productUsage
+	for rows.Next() {
+		var u productUsage
+		if err := rows.Scan(&u.ProductID, &u.Amount); err != nil {
+			rows.Close()
+			http.Error(w, "Failed to update usage", http.StatusInternalServerError)
+			return
+		}
+		previous = append(previous, u)
+	}
+	rows.Close()
+	for _, u := range previous {
+		_, err := moveStock(tx, u.ProductID, u.Amount, "usage", "", sql.NullInt64{Int64: invoiceID, Valid: true}, "Usage corrected", now)
+		if err != nil {
+			http.Error(w, "Failed to update stock", http.StatusInternalServerError)
+			return
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM invoice_item_usage WHERE invoice_item_id = ?", itemID); err != nil {
+		http.Error(w, "Failed to update usage", http.StatusInternalServerError)
+		return
+	}
+	if err := recordItemUsage(tx, invoiceID, itemID, usage, now); err != nil {
+		http.Error(w, "Failed to update usage", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update usage", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolveItemUsage(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db,
+		`INSERT INTO products (id, owner_id, name, unit, backroom, cost_price, retail_price, active) VALUES
+			(1, 1, 'Colour', 'g', 1, 0.2, 0, 1),
+			(2, 1, 'Developer', 'ml', 1, 0.05, 0, 1),
+			(3, 1, 'Old toner', 'ml', 1, 0.1, 0, 0),
+			(4, 2, 'Bleach', 'g', 1, 0.3, 0, 1)`,
+		`INSERT INTO service_recipes (service_id, product_id, amount) VALUES (10, 1, 60), (10, 2, 90), (10, 3, 20)`,
+	)
+	colour := productUsage{ProductID: 1, Name: "Colour", Unit: "g", Amount: 60, unitCost: 0.2}
+	developer := productUsage{ProductID: 2, Name: "Developer", Unit: "ml", Amount: 90, unitCost: 0.05}
+
+	tests := []struct {
+		name    string
+		item    invoiceItemInput
+		want    []productUsage
+		wantErr string
+	}{
+		{name: "service recipe", item: invoiceItemInput{ServiceID: 10, Quantity: 1},
+			want: []productUsage{colour, developer}},
+		{name: "recipe scaled by quantity", item: invoiceItemInput{ServiceID: 10, Quantity: 1.5},
+			want: []productUsage{{1, "Colour", "g", 90, 0.2}, {2, "Developer", "ml", 135, 0.05}}},
+		{name: "service without a recipe", item: invoiceItemInput{ServiceID: 11, Quantity: 1}},
+		{name: "not a service", item: invoiceItemInput{Description: "Misc", Quantity: 1}},
+		{name: "actual usage replaces the recipe",
+			item: invoiceItemInput{ServiceID: 10, Quantity: 1, Usage: []productUsage{{ProductID: 1, Amount: 75}, {ProductID: 2, Amount: 0}}},
+			want: []productUsage{{1, "Colour", "g", 75, 0.2}}},
+		{name: "no usage at all", item: invoiceItemInput{ServiceID: 10, Quantity: 1, Usage: []productUsage{}},
+			want: []productUsage{}},
+		{name: "negative amount", item: invoiceItemInput{ServiceID: 10, Usage: []productUsage{{ProductID: 1, Amount: -5}}},
+			wantErr: "Usage amounts cannot be negative"},
+		{name: "product listed twice", item: invoiceItemInput{ServiceID: 10, Usage: []productUsage{{ProductID: 1, Amount: 5}, {ProductID: 1, Amount: 5}}},
+			wantErr: "Each product can only be listed once in usage"},
+		{name: "inactive product", item: invoiceItemInput{ServiceID: 10, Usage: []productUsage{{ProductID: 3, Amount: 5}}},
+			wantErr: "Product 3 not found"},
+		{name: "another salon's product", item: invoiceItemInput{ServiceID: 10, Usage: []productUsage{{ProductID: 4, Amount: 5}}},
+			wantErr: "Product 4 not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := resolveItemUsage(db, 1, &tt.item)
+			if tt.wantErr != "" {
+				if _, ok := err.(invoiceInputError); !ok || err.Error() != tt.wantErr {
+					t.Fatalf("resolveItemUsage() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveItemUsage() error = %v", err)
+			}
+			if !reflect.DeepEqual(tt.item.usage, tt.want) {
+				t.Errorf("usage = %+v, want %+v", tt.item.usage, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordItemUsage(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO products (id, owner_id, name, backroom, cost_price, retail_price, stock_on_hand) VALUES
+		(1, 1, 'Colour', 1, 0.2, 0, 50)`)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	usage := []productUsage{{ProductID: 1, Amount: 60, unitCost: 0.2}}
+	if err := recordItemUsage(tx, 9, 3, usage, time.Now()); err != nil {
+		t.Fatalf("recordItemUsage() error = %v", err)
+	}
+	var stock int
+	tx.QueryRow("SELECT stock_on_hand FROM products WHERE id = 1").Scan(&stock)
+	if stock != -10 {
+		t.Errorf("stock_on_hand = %d, want -10: usage is recorded even without stock", stock)
+	}
+	var amount int
+	var cost float64
+	err = tx.QueryRow("SELECT amount, unit_cost FROM invoice_item_usage WHERE invoice_item_id = 3 AND invoice_id = 9 AND product_id = 1").
+		Scan(&amount, &cost)
+	if err != nil || amount != 60 || cost != 0.2 {
+		t.Errorf("usage row = %d at %v (%v), want 60 at 0.2", amount, cost, err)
+	}
+}
@@ -0,0 +1,713 @@
+// internal/handlers/calendar_feeds.go
+// iCalendar (RFC 5545) subscription feeds of appointments per salon and per
+// staff member, and import of staff members' personal calendars as time off.
+package handlers
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// Appointments from this far back and ahead are listed in calendar feeds.
+const (
+	calendarFeedPast   = 30 * 24 * time.Hour
+	calendarFeedFuture = 180 * 24 * time.Hour
+)
+
+// Limits on imported calendar files.
+const (
+	maxCalendarImportBytes  = 2 << 20
+	maxCalendarImportEvents = 500
+)
+
+// importedTimeOffReason is stored for imported events instead of their
+// titles, which are the staff member's own and may be private.
+const importedTimeOffReason = "Personal time (imported)"
+
+// calendarFeedURL is the subscription address for a feed token.
+func calendarFeedURL(token string) string {
+	return publicBaseURL() + "/calendar/" + token + ".ics"
+}
+
+// webcalURL is a feed address with the webcal scheme, which phones open
+// straight in their calendar app.
+func webcalURL(feedURL string) string {
+	if i := strings.Index(feedURL, "://"); i >= 0 {
+		return "webcal" + feedURL[i:]
+	}
+	return feedURL
+}
+
+type calendarFeedLink struct {
+	StaffID   int64  `json:"staff_id,omitempty"`
+	URL       string `json:"url"`
+	WebcalURL string `json:"webcal_url"`
+}
+
+func newCalendarFeedLink(token string) *calendarFeedLink {
+	u := calendarFeedURL(token)
+	return &calendarFeedLink{URL: u, WebcalURL: webcalURL(u)}
+}
+
+// --- ICS writing ---
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "")
+
+// icsText escapes a TEXT property value.
+func icsText(s string) string {
+	return icsEscaper.Replace(s)
+}
+
+// writeICSLine writes a content line, folded so that no line is longer than
+// 75 octets without splitting a UTF-8 sequence, ending in CRLF.
+func writeICSLine(b *strings.Builder, name, value string) {
+	line := name + ":" + value
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74 // the leading space counts towards the next line
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// icsUTC formats t as an RFC 5545 UTC date-time.
+func icsUTC(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// firstName is the part of a customer's name shown in calendar feeds.
+func firstName(name string) string {
+	if f := strings.Fields(name); len(f) > 0 {
+		return f[0]
+	}
+	return ""
+}
+
+// icsStatus maps an appointment status to a VEVENT STATUS.
+func icsStatus(status string) string {
+	switch status {
+	case "pending":
+		return "TENTATIVE"
+	case "cancelled", "late_cancelled":
+		return "CANCELLED"
+	}
+	return "CONFIRMED"
+}
+
+// writeCalendarFeed renders the owner's appointments, or one staff member's
+// when staffID is set, as a VCALENDAR. Only the customer's first name and the
+// service are included; contact details and notes never leave the salon.
+func writeCalendarFeed(q queryer, ownerID int, staffID int64, now time.Time) (string, error) {
+	sched, err := loadSalonSchedule(q, ownerID)
+	if err != nil {
+		return "", err
+	}
+	var salonName, address sql.NullString
+	if err := q.QueryRow("SELECT salon_name, address FROM owners WHERE id = ?", ownerID).Scan(&salonName, &address); err != nil {
+		return "", err
+	}
+	calName := salonName.String
+	if calName == "" {
+		calName = "Salon"
+	}
+	query := `
+        SELECT a.id, c.name, st.name, sv.name, a.starts_at, a.ends_at, a.status, a.updated_at
+        FROM appointments a
+        JOIN customers c ON a.customer_id = c.id
+        JOIN staff st ON a.staff_id = st.id
+        JOIN services sv ON a.service_id = sv.id
+        WHERE a.owner_id = ? AND a.starts_at >= ? AND a.starts_at < ?`
+	args := []interface{}{ownerID,
+		now.Add(-calendarFeedPast).In(sched.loc).Format(dateTimeLayout),
+		now.Add(calendarFeedFuture).In(sched.loc).Format(dateTimeLayout)}
+	if staffID != 0 {
+		query += " AND a.staff_id = ?"
+		args = append(args, staffID)
+		var staffName string
+		if err := q.QueryRow("SELECT name FROM staff WHERE id = ?", staffID).Scan(&staffName); err != nil {
+			return "", err
+		}
+		calName += " – " + staffName
+	}
+	rows, err := q.Query(query+" ORDER BY a.starts_at", args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	host := "salon"
+	if u, err := url.Parse(publicBaseURL()); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN", "VCALENDAR")
+	writeICSLine(&b, "VERSION", "2.0")
+	writeICSLine(&b, "PRODID", "-//Salon Management//Appointments//EN")
+	writeICSLine(&b, "CALSCALE", "GREGORIAN")
+	writeICSLine(&b, "METHOD", "PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME", icsText(calName))
+	writeICSLine(&b, "X-WR-TIMEZONE", sched.loc.String())
+	stamp := icsUTC(now)
+	for rows.Next() {
+		var id int64
+		var customer, stylist, service, startsAt, endsAt, status string
+		var updated sql.NullTime
+		if err := rows.Scan(&id, &customer, &stylist, &service, &startsAt, &endsAt, &status, &updated); err != nil {
+			return "", err
+		}
+		start, err1 := time.ParseInLocation(dateTimeLayout, startsAt, sched.loc)
+		end, err2 := time.ParseInLocation(dateTimeLayout, endsAt, sched.loc)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		summary := service
+		if name := firstName(customer); name != "" {
+			summary = name + " – " + service
+		}
+		if staffID == 0 {
+			summary += " (" + stylist + ")"
+		}
+		writeICSLine(&b, "BEGIN", "VEVENT")
+		writeICSLine(&b, "UID", fmt.Sprintf("appointment-%d@%s", id, host))
+		writeICSLine(&b, "DTSTAMP", stamp)
+		if updated.Valid {
+			writeICSLine(&b, "LAST-MODIFIED", icsUTC(updated.Time))
+		}
+		writeICSLine(&b, "DTSTART", icsUTC(start))
+		writeICSLine(&b, "DTEND", icsUTC(end))
+		writeICSLine(&b, "SUMMARY", icsText(summary))
+		writeICSLine(&b, "DESCRIPTION", icsText("Service: "+service+"\nStylist: "+stylist))
+		if address.String != "" {
+			writeICSLine(&b, "LOCATION", icsText(address.String))
+		}
+		writeICSLine(&b, "STATUS", icsStatus(status))
+		writeICSLine(&b, "END", "VEVENT")
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	writeICSLine(&b, "END", "VCALENDAR")
+	return b.String(), nil
+}
+
+// --- Public: Calendar Feed ---
+// Serves the feed for a salon or staff token. Deactivated staff lose their
+// feed.
+func ViewCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	db := database.GetDB()
+	var ownerID int
+	var staffID int64
+	err := db.QueryRow("SELECT owner_id, id FROM staff WHERE calendar_token = ? AND active = 1", token).Scan(&ownerID, &staffID)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow("SELECT id FROM owners WHERE calendar_token = ?", token).Scan(&ownerID)
+	}
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to load calendar", http.StatusInternalServerError)
+		return
+	}
+	feed, err := writeCalendarFeed(db, ownerID, staffID, time.Now())
+	if err != nil {
+		log.Printf("Failed to build calendar feed for owner %d: %v", ownerID, err)
+		http.Error(w, "Failed to load calendar", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="appointments.ics"`)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	io.WriteString(w, feed)
+}
+
+// --- API: Calendar Feeds ---
+// Lists the salon feed and each active staff member's feed; a feed that
+// hasn't been turned on is null.
+func APIGetCalendarFeeds(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	var salonToken sql.NullString
+	if err := db.QueryRow("SELECT calendar_token FROM owners WHERE id = ?", ownerID).Scan(&salonToken); err != nil {
+		http.Error(w, "Failed to fetch calendar feeds", http.StatusInternalServerError)
+		return
+	}
+	rows, err := db.Query("SELECT id, name, calendar_token FROM staff WHERE owner_id = ? AND active = 1 ORDER BY name", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch calendar feeds", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	type staffFeed struct {
+		StaffID int64             `json:"staff_id"`
+		Name    string            `json:"name"`
+		Feed    *calendarFeedLink `json:"feed"`
+	}
+	staff := []staffFeed{}
+	for rows.Next() {
+		var f staffFeed
+		var token sql.NullString
+		if err := rows.Scan(&f.StaffID, &f.Name, &token); err != nil {
+			log.Printf("Failed to scan staff calendar feed: %v", err)
+			continue
+		}
+		if token.String != "" {
+			f.Feed = newCalendarFeedLink(token.String)
+		}
+		staff = append(staff, f)
+	}
+	var salon *calendarFeedLink
+	if salonToken.String != "" {
+		salon = newCalendarFeedLink(salonToken.String)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"salon": salon, "staff": staff})
+}
+
+// --- API: Reset Salon Calendar Feed ---
+// Turns on the salon-wide feed with a new secret URL. Any previous URL stops
+// working.
+func APIResetSalonCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	token, err := newToken()
+	if err != nil {
+		http.Error(w, "Failed to create calendar feed", http.StatusInternalServerError)
+		return
+	}
+	if _, err := database.GetDB().Exec("UPDATE owners SET calendar_token = ?, updated_at = ? WHERE id = ?", token, time.Now(), ownerID); err != nil {
+		http.Error(w, "Failed to create calendar feed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newCalendarFeedLink(token))
+}
+
+// --- API: Delete Salon Calendar Feed ---
+func APIDeleteSalonCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	if _, err := database.GetDB().Exec("UPDATE owners SET calendar_token = NULL, updated_at = ? WHERE id = ?", time.Now(), ownerID); err != nil {
+		http.Error(w, "Failed to turn off calendar feed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Reset Staff Calendar Feed ---
+// Turns on a staff member's feed with a new secret URL. Any previous URL
+// stops working.
+func APIResetStaffCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	staffID, ok := staffIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	token, err := newToken()
+	if err != nil {
+		http.Error(w, "Failed to create calendar feed", http.StatusInternalServerError)
+		return
+	}
+	if _, err := database.GetDB().Exec("UPDATE staff SET calendar_token = ?, updated_at = ? WHERE id = ?", token, time.Now(), staffID); err != nil {
+		http.Error(w, "Failed to create calendar feed", http.StatusInternalServerError)
+		return
+	}
+	link := newCalendarFeedLink(token)
+	link.StaffID = staffID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(link)
+}
+
+// --- API: Delete Staff Calendar Feed ---
+func APIDeleteStaffCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	staffID, ok := staffIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	if _, err := database.GetDB().Exec("UPDATE staff SET calendar_token = NULL, updated_at = ? WHERE id = ?", time.Now(), staffID); err != nil {
+		http.Error(w, "Failed to turn off calendar feed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- ICS reading ---
+
+// icsProperty is one unfolded content line.
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// parseICSLine splits "NAME;PARAM=x:value", ignoring colons inside quoted
+// parameter values.
+func parseICSLine(line string) (icsProperty, bool) {
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icsProperty{}, false
+	}
+	parts := strings.Split(line[:colon], ";")
+	p := icsProperty{name: strings.ToUpper(parts[0]), params: map[string]string{}, value: line[colon+1:]}
+	for _, param := range parts[1:] {
+		if k, v, ok := strings.Cut(param, "="); ok {
+			p.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return p, true
+}
+
+// readICSEvents returns the properties of each top-level VEVENT, unfolding
+// continuation lines. Nested components such as VALARM are skipped.
+func readICSEvents(r io.Reader) ([]map[string]icsProperty, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxCalendarImportBytes)
+	var lines []string
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if len(lines) == 0 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	var events []map[string]icsProperty
+	var stack []string
+	var current map[string]icsProperty
+	for _, line := range lines {
+		p, ok := parseICSLine(line)
+		if !ok {
+			continue
+		}
+		switch p.name {
+		case "BEGIN":
+			stack = append(stack, strings.ToUpper(p.value))
+			if len(stack) == 2 && stack[0] == "VCALENDAR" && stack[1] == "VEVENT" {
+				current = map[string]icsProperty{}
+			}
+			continue
+		case "END":
+			if len(stack) == 2 && current != nil {
+				events = append(events, current)
+				current = nil
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		if current != nil && len(stack) == 2 {
+			if _, seen := current[p.name]; !seen {
+				current[p.name] = p
+			}
+		}
+	}
+	if len(events) == 0 && (len(lines) == 0 || !strings.EqualFold(strings.TrimSpace(lines[0]), "BEGIN:VCALENDAR")) {
+		return nil, fmt.Errorf("not an iCalendar file")
+	}
+	return events, nil
+}
+
+// parseICSTime reads a DATE or DATE-TIME property. UTC and TZID times are
+// converted to loc; floating times and dates are taken as loc wall-clock
+// times. Unknown TZIDs, such as Windows zone names, fall back to loc.
+func parseICSTime(p icsProperty, loc *time.Location) (t time.Time, allDay bool, err error) {
+	v := strings.TrimSpace(p.value)
+	if strings.EqualFold(p.params["VALUE"], "DATE") || len(v) == 8 {
+		t, err = time.ParseInLocation("20060102", v, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(v, "Z") {
+		t, err = time.Parse("20060102T150405Z", v)
+		return t.In(loc), false, err
+	}
+	zone := loc
+	if tzid := p.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			zone = l
+		}
+	}
+	t, err = time.ParseInLocation("20060102T150405", v, zone)
+	return t.In(loc), false, err
+}
+
+var icsDurationRe = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseICSDuration reads an RFC 5545 DURATION such as PT1H30M or P1D.
+func parseICSDuration(s string) (time.Duration, bool) {
+	m := icsDurationRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || m[1] == "-" {
+		return 0, false
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+2] != "" {
+			n, _ := strconv.Atoi(m[i+2])
+			d += time.Duration(n) * unit
+		}
+	}
+	return d, d > 0
+}
+
+type calendarImportSkip struct {
+	UID    string `json:"uid"`
+	Reason string `json:"reason"`
+}
+
+// --- API: Import Staff Time Off ---
+// Reads an .ics file, as a multipart "file" field or the raw request body,
+// and blocks out each busy event as time off for the staff member.
+// Re-importing updates events by UID; with replace=1, previously imported
+// time off that is no longer in the file and hasn't ended is removed.
+// Recurring, cancelled, free and past events are skipped.
+func APIImportStaffTimeOff(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	staffID, ok := staffIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxCalendarImportBytes+64*1024)
+	var src io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Upload the calendar as a \"file\" field", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		src = file
+	}
+	events, err := readICSEvents(io.LimitReader(src, maxCalendarImportBytes))
+	if err != nil {
+		http.Error(w, "Invalid calendar file", http.StatusBadRequest)
+		return
+	}
+	if len(events) > maxCalendarImportEvents {
+		http.Error(w, fmt.Sprintf("Too many events (max %d)", maxCalendarImportEvents), http.StatusBadRequest)
+		return
+	}
+
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to load salon schedule", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().In(sched.loc)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to import calendar", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	imported, updated, removed := 0, 0, 0
+	skipped := []calendarImportSkip{}
+	seen := map[string]bool{}
+	for _, ev := range events {
+		uid := strings.TrimSpace(ev["UID"].value)
+		skip := func(reason string) { skipped = append(skipped, calendarImportSkip{uid, reason}) }
+		start, hasStart := ev["DTSTART"]
+		switch {
+		case uid == "":
+			skip("no UID")
+			continue
+		case !hasStart:
+			skip("no start time")
+			continue
+		case ev["RRULE"].value != "" || ev["RECURRENCE-ID"].value != "":
+			skip("recurring events are not supported")
+			continue
+		case strings.EqualFold(ev["STATUS"].value, "CANCELLED"):
+			skip("cancelled")
+			continue
+		case strings.EqualFold(ev["TRANSP"].value, "TRANSPARENT"):
+			skip("marked as free")
+			continue
+		case seen[uid]:
+			skip("duplicate UID")
+			continue
+		}
+		from, allDay, err := parseICSTime(start, sched.loc)
+		if err != nil {
+			skip("unreadable start time")
+			continue
+		}
+		var to time.Time
+		if end, ok := ev["DTEND"]; ok {
+			if to, _, err = parseICSTime(end, sched.loc); err != nil {
+				skip("unreadable end time")
+				continue
+			}
+		} else if d, ok := parseICSDuration(ev["DURATION"].value); ok {
+			to = from.Add(d)
+		} else if allDay {
+			to = from.AddDate(0, 0, 1)
+		}
+		startsAt, endsAt := from.Format(dateTimeLayout), to.Format(dateTimeLayout)
+		if endsAt <= startsAt {
+			skip("no duration")
+			continue
+		}
+		if !to.After(now) {
+			skip("already over")
+			continue
+		}
+		seen[uid] = true
+
+		var id int64
+		err = tx.QueryRow("SELECT id FROM staff_time_off WHERE staff_id = ? AND source_uid = ?", staffID, uid).Scan(&id)
+		if err == sql.ErrNoRows {
+			_, err = tx.Exec(`
+                INSERT INTO staff_time_off (staff_id, kind, starts_at, ends_at, reason, source_uid, created_at)
+                VALUES (?, 'other', ?, ?, ?, ?, ?)`,
+				staffID, startsAt, endsAt, importedTimeOffReason, uid, time.Now())
+			imported++
+		} else if err == nil {
+			_, err = tx.Exec("UPDATE staff_time_off SET starts_at = ?, ends_at = ? WHERE id = ?", startsAt, endsAt, id)
+			updated++
+		}
+		if err != nil {
+			log.Printf("Failed to import time off for staff %d: %v", staffID, err)
+			http.Error(w, "Failed to import calendar", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if r.URL.Query().Get("replace") == "1" {
+		rows, err := tx.Query(`
+            SELECT id, source_uid FROM staff_time_off
+            WHERE staff_id = ? AND source_uid IS NOT NULL AND ends_at > ?`, staffID, now.Format(dateTimeLayout))
+		if err != nil {
+			http.Error(w, "Failed to import calendar", http.StatusInternalServerError)
+			return
+		}
+		var stale []int64
+		for rows.Next() {
+			var id int64
+			var uid string
+			if err := rows.Scan(&id, &uid); err == nil && !seen[uid] {
+				stale = append(stale, id)
+			}
+		}
+		rows.Close()
+		for _, id := range stale {
+			if _, err := tx.Exec("DELETE FROM staff_time_off WHERE id = ?", id); err != nil {
+				http.Error(w, "Failed to import calendar", http.StatusInternalServerError)
+				return
+			}
+			removed++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to import calendar", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": imported,
+		"updated":  updated,
+		"removed":  removed,
+		"skipped":  skipped,
+	})
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestWriteICSLine(t *testing.T) {
+	var b strings.Builder
+	writeICSLine(&b, "SUMMARY", icsText("Cut, colour; and blow-dry"))
+	if got, want := b.String(), `SUMMARY:Cut\, colour\; and blow-dry`+"\r\n"; got != want {
+		t.Errorf("short line = %q, want %q", got, want)
+	}
+
+	b.Reset()
+	value := strings.Repeat("é", 100)
+	writeICSLine(&b, "DESCRIPTION", value)
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+	if len(lines) < 2 {
+		t.Fatalf("long line wasn't folded: %q", b.String())
+	}
+	var unfolded string
+	for i, line := range lines {
+		if len(line) > 75 {
+			t.Errorf("line %d is %d octets, want at most 75", i, len(line))
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line %d splits a UTF-8 sequence: %q", i, line)
+		}
+		if i > 0 {
+			if !strings.HasPrefix(line, " ") {
+				t.Errorf("continuation line %d doesn't start with a space", i)
+			}
+			line = line[1:]
+		}
+		unfolded += line
+	}
+	if unfolded != "DESCRIPTION:"+value {
+		t.Errorf("unfolded = %q, want the original line", unfolded)
+	}
+}
+
+func TestReadICSEvents(t *testing.T) {
+	ics := "\ufeffBEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Dentist\r\n" +
+		"DTSTART;TZID=\"Europe/London\":20261102T100000\r\n" +
+		"DESCRIPTION:Bring the \r\n referral letter\r\n" +
+		"BEGIN:VALARM\r\n" +
+		"SUMMARY:Reminder\r\n" +
+		"END:VALARM\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Holiday\r\n" +
+		"DTSTART;VALUE=DATE:20261224\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	events, err := readICSEvents(strings.NewReader(ics))
+	if err != nil {
+		t.Fatalf("readICSEvents() error = %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if got := events[0]["SUMMARY"].value; got != "Dentist" {
+		t.Errorf("SUMMARY = %q, want Dentist (not the alarm's)", got)
+	}
+	if got := events[0]["DTSTART"].params["TZID"]; got != "Europe/London" {
+		t.Errorf("TZID = %q, want Europe/London", got)
+	}
+	if got := events[0]["DESCRIPTION"].value; got != "Bring the referral letter" {
+		t.Errorf("DESCRIPTION = %q, want the unfolded line", got)
+	}
+	if got := events[1]["DTSTART"].params["VALUE"]; got != "DATE" {
+		t.Errorf("VALUE = %q, want DATE", got)
+	}
+
+	if _, err := readICSEvents(strings.NewReader("Subject,Start Date\r\nDentist,11/02/2026\r\n")); err == nil {
+		t.Error("readICSEvents() accepted a CSV file")
+	}
+}
+
+func TestParseICSTime(t *testing.T) {
+	loc := time.FixedZone("Salon", 2*60*60)
+	tests := []struct {
+		name       string
+		prop       icsProperty
+		want       string
+		wantAllDay bool
+	}{
+		{"UTC", icsProperty{value: "20261102T080000Z"}, "2026-11-02 10:00", false},
+		{"floating", icsProperty{value: "20261102T080000"}, "2026-11-02 08:00", false},
+		{"TZID", icsProperty{params: map[string]string{"TZID": "UTC"}, value: "20261102T080000"}, "2026-11-02 10:00", false},
+		{"unknown TZID", icsProperty{params: map[string]string{"TZID": "W. Europe Standard Time"}, value: "20261102T080000"},
+			"2026-11-02 08:00", false},
+		{"date", icsProperty{params: map[string]string{"VALUE": "DATE"}, value: "20261224"}, "2026-12-24 00:00", true},
+	}
+	for _, tt := range tests {
+		got, allDay, err := parseICSTime(tt.prop, loc)
+		if err != nil || got.Format(dateTimeLayout) != tt.want || allDay != tt.wantAllDay || got.Location() != loc {
+			t.Errorf("%s: parseICSTime() = %s in %s, all day %v (%v), want %s, all day %v",
+				tt.name, got.Format(dateTimeLayout), got.Location(), allDay, err, tt.want, tt.wantAllDay)
+		}
+	}
+	if _, _, err := parseICSTime(icsProperty{value: "tomorrow"}, loc); err == nil {
+		t.Error("parseICSTime() accepted an invalid time")
+	}
+}
+
+func TestParseICSDuration(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"PT1H30M", 90 * time.Minute, true},
+		{"P1D", 24 * time.Hour, true},
+		{"P1W", 7 * 24 * time.Hour, true},
+		{"P1DT2H", 26 * time.Hour, true},
+		{"-PT1H", 0, false},
+		{"PT0S", 0, false},
+		{"1 hour", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseICSDuration(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseICSDuration(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,1093 @@
+// internal/handlers/campaign_handlers.go
+// Broadcast campaigns: a personalized SMS or email to a segment of customers
+// picked by tag, birthday month, spend and last visit, with a preview, test
+// sends, scheduling and a rate-limited send queue that records each
+// recipient's delivery status. Also customer tags.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+	"salon-management/internal/reminders"
+)
+
+const (
+	// campaignBatchSize is how many messages a campaign sends per minute.
+	campaignBatchSize = 30
+	// campaignSampleSize is how many personalized messages a preview shows.
+	campaignSampleSize = 5
+	maxCampaignMessage = 1000
+	maxCustomerTags    = 20
+)
+
+// campaignSegment picks a campaign's audience. Every filter set must match;
+// a customer matches Tags if they have any of them. MinSpend and MaxSpend
+// bound what the customer paid in the last 12 months, and the last visit
+// dates are YYYY-MM-DD.
+type campaignSegment struct {
+	Tags            []string `json:"tags,omitempty"`
+	BirthdayMonth   int      `json:"birthday_month,omitempty"`
+	MinSpend        *float64 `json:"min_spend,omitempty"`
+	MaxSpend        *float64 `json:"max_spend,omitempty"`
+	LastVisitBefore string   `json:"last_visit_before,omitempty"`
+	LastVisitAfter  string   `json:"last_visit_after,omitempty"`
+}
+
+// campaign is a broadcast and, once queued, its recipients counted by status.
+type campaign struct {
+	ID          int64           `json:"id"`
+	Name        string          `json:"name"`
+	Subject     string          `json:"subject,omitempty"`
+	Message     string          `json:"message"`
+	Segment     campaignSegment `json:"segment"`
+	Status      string          `json:"status"`
+	ScheduledAt string          `json:"scheduled_at,omitempty"`
+	StartedAt   string          `json:"started_at,omitempty"`
+	FinishedAt  string          `json:"finished_at,omitempty"`
+	Counts      map[string]int  `json:"counts"`
+}
+
+// campaignCustomer is a customer in a campaign's audience.
+type campaignCustomer struct {
+	id        int64
+	lastVisit sql.NullString
+	notice    appointmentNotice
+}
+
+// normalizeTags lower-cases, trims and de-duplicates tags.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || len(t) > 50 {
+			return nil, errors.New("Tags must be 1-50 characters")
+		}
+		if !seen[t] {
+			seen[t] = true
+			normalized = append(normalized, t)
+		}
+	}
+	if len(normalized) > maxCustomerTags {
+		return nil, fmt.Errorf("At most %d tags are allowed", maxCustomerTags)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// normalize validates the segment and tidies its tags.
+func (s *campaignSegment) normalize() error {
+	tags, err := normalizeTags(s.Tags)
+	if err != nil {
+		return err
+	}
+	s.Tags = tags
+	if s.BirthdayMonth < 0 || s.BirthdayMonth > 12 {
+		return errors.New("Birthday month must be 1-12")
+	}
+	if (s.MinSpend != nil && *s.MinSpend < 0) || (s.MaxSpend != nil && *s.MaxSpend < 0) {
+		return errors.New("Spend must be 0 or more")
+	}
+	if s.MinSpend != nil && s.MaxSpend != nil && *s.MinSpend > *s.MaxSpend {
+		return errors.New("Minimum spend must not be above maximum spend")
+	}
+	for _, d := range []string{s.LastVisitBefore, s.LastVisitAfter} {
+		if _, err := time.Parse("2006-01-02", d); d != "" && err != nil {
+			return errors.New("Last visit dates must be YYYY-MM-DD")
+		}
+	}
+	return nil
+}
+
+// filter is the SQL condition on customers c that the segment adds, with
+// its arguments.
+func (s campaignSegment) filter(now time.Time) (string, []interface{}) {
+	var sb strings.Builder
+	var args []interface{}
+	if len(s.Tags) > 0 {
+		sb.WriteString(" AND EXISTS (SELECT 1 FROM customer_tags t WHERE t.customer_id = c.id AND t.tag IN (?" +
+			strings.Repeat(", ?", len(s.Tags)-1) + "))")
+		for _, t := range s.Tags {
+			args = append(args, t)
+		}
+	}
+	if s.BirthdayMonth > 0 {
+		sb.WriteString(" AND substr(c.birthday, 6, 2) = ?")
+		args = append(args, fmt.Sprintf("%02d", s.BirthdayMonth))
+	}
+	const spend = `(SELECT COALESCE(SUM(i.total_amount), 0) FROM invoices i
+        WHERE i.customer_id = c.id AND i.payment_status = 'Paid' AND substr(i.invoice_date, 1, 10) > ?)`
+	yearAgo := now.AddDate(-1, 0, 0).Format("2006-01-02")
+	if s.MinSpend != nil {
+		sb.WriteString(" AND " + spend + " >= ?")
+		args = append(args, yearAgo, *s.MinSpend)
+	}
+	if s.MaxSpend != nil {
+		sb.WriteString(" AND " + spend + " <= ?")
+		args = append(args, yearAgo, *s.MaxSpend)
+	}
+	const lastVisit = "(SELECT MAX(substr(i.invoice_date, 1, 10)) FROM invoices i WHERE i.customer_id = c.id)"
+	if s.LastVisitBefore != "" {
+		sb.WriteString(" AND " + lastVisit + " < ?")
+		args = append(args, s.LastVisitBefore)
+	}
+	if s.LastVisitAfter != "" {
+		sb.WriteString(" AND " + lastVisit + " >= ?")
+		args = append(args, s.LastVisitAfter)
+	}
+	return sb.String(), args
+}
+
+// loadCampaignAudience lists the customers in a segment, by name.
+func loadCampaignAudience(q queryer, ownerID int, seg campaignSegment, now time.Time) ([]campaignCustomer, error) {
+	filter, args := seg.filter(now)
+	rows, err := q.Query(`
+        SELECT c.id, c.name, c.phone, c.email, `+optOutColumns+`, COALESCE(o.salon_name, ''),
+            (SELECT MAX(substr(i.invoice_date, 1, 10)) FROM invoices i WHERE i.customer_id = c.id)
+        FROM customers c JOIN owners o ON c.owner_id = o.id
+        WHERE c.owner_id = ?`+filter+`
+        ORDER BY c.name, c.id`, append([]interface{}{ownerID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var audience []campaignCustomer
+	for rows.Next() {
+		var c campaignCustomer
+		if err := rows.Scan(&c.id, &c.notice.customerName, &c.notice.phone, &c.notice.email, &c.notice.smsOptOut,
+			&c.notice.emailOptOut, &c.notice.salonName, &c.lastVisit); err != nil {
+			return nil, err
+		}
+		audience = append(audience, c)
+	}
+	return audience, rows.Err()
+}
+
+// campaignText personalizes a campaign message for one customer.
+// Placeholders are [CustomerName], [FirstName], [SalonName] and [LastVisit].
+func campaignText(template string, c campaignCustomer) string {
+	var visited string
+	if d, err := time.Parse("2006-01-02", c.lastVisit.String); err == nil {
+		visited = d.Format("2 January 2006")
+	}
+	return reminders.FillTemplate(template, map[string]string{
+		"CustomerName": c.notice.customerName,
+		"FirstName":    firstName(c.notice.customerName),
+		"SalonName":    c.notice.salonName,
+		"LastVisit":    visited,
+	})
+}
+
+// campaignSubject is the email subject of a campaign, defaulting to one
+// naming the salon.
+func campaignSubject(subject, salonName string) string {
+	if subject != "" {
+		return subject
+	}
+	return "News from " + salonName
+}
+
+// sendCampaignMessage sends a campaign message over the channel picked by
+// appointmentNotice.contact.
+func sendCampaignMessage(channel, to, subject, message string) error {
+	if channel == "sms" {
+		return reminders.SendSMS(to, message)
+	}
+	return reminders.SendEmail(to, subject, message)
+}
+
+const campaignColumns = "id, name, subject, message, segment, status, scheduled_at, started_at, finished_at"
+
+func scanCampaign(row rowScanner) (*campaign, error) {
+	var c campaign
+	var subject sql.NullString
+	var segment string
+	var scheduledAt, startedAt, finishedAt sql.NullTime
+	if err := row.Scan(&c.ID, &c.Name, &subject, &c.Message, &segment, &c.Status, &scheduledAt, &startedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	c.Subject = subject.String
+	if err := json.Unmarshal([]byte(segment), &c.Segment); err != nil {
+		return nil, err
+	}
+	for _, t := range []struct {
+		dest *string
+		at   sql.NullTime
+	}{{&c.ScheduledAt, scheduledAt}, {&c.StartedAt, startedAt}, {&c.FinishedAt, finishedAt}} {
+		if t.at.Valid {
+			*t.dest = t.at.Time.Format(time.RFC3339)
+		}
+	}
+	c.Counts = map[string]int{}
+	return &c, nil
+}
+
+// loadCampaignCounts fills in how many recipients have each status.
+func loadCampaignCounts(q queryer, c *campaign) error {
+	rows, err := q.Query("SELECT status, COUNT(*) FROM campaign_recipients WHERE campaign_id = ? GROUP BY status", c.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return err
+		}
+		c.Counts[status] = n
+	}
+	return rows.Err()
+}
+
+// StartCampaigns queues campaigns that are due and sends the next batch of
+// each campaign's queue, every minute.
+func StartCampaigns(db *sql.DB) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		sendCampaigns(database.GetDB(), time.Now())
+		<-ticker.C
+	}
+}
+
+// sendCampaigns queues the audience of each campaign whose time has come,
+// then sends up to campaignBatchSize queued messages per campaign. A
+// campaign is sent once its queue is empty.
+func sendCampaigns(db *sql.DB, now time.Time) {
+	rows, err := db.Query("SELECT id, owner_id, segment FROM campaigns WHERE status = 'scheduled' AND scheduled_at <= ?", now)
+	if err != nil {
+		log.Printf("Error querying scheduled campaigns: %v", err)
+		return
+	}
+	type due struct {
+		id      int64
+		ownerID int
+		segment string
+	}
+	var dueCampaigns []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.ownerID, &d.segment); err == nil {
+			dueCampaigns = append(dueCampaigns, d)
+		}
+	}
+	rows.Close()
+	for _, d := range dueCampaigns {
+		if err := queueCampaign(db, d.id, d.ownerID, d.segment, now); err != nil {
+			log.Printf("Failed to queue campaign %d: %v", d.id, err)
+		}
+	}
+
+	rows, err = db.Query(`
+        SELECT c.id, c.owner_id, COALESCE(c.subject, ''), c.message, COALESCE(o.salon_name, '')
+        FROM campaigns c JOIN owners o ON c.owner_id = o.id
+        WHERE c.status = 'sending' ORDER BY c.started_at`)
+	if err != nil {
+		log.Printf("Error querying sending campaigns: %v", err)
+		return
+	}
+	type sending struct {
+		id                          int64
+		ownerID                     int
+		subject, message, salonName string
+	}
+	var campaigns []sending
+	for rows.Next() {
+		var s sending
+		if err := rows.Scan(&s.id, &s.ownerID, &s.subject, &s.message, &s.salonName); err == nil {
+			campaigns = append(campaigns, s)
+		}
+	}
+	rows.Close()
+	for _, s := range campaigns {
+		if err := sendCampaignBatch(db, s.id, campaignSubject(s.subject, s.salonName), s.message, now); err != nil {
+			log.Printf("Failed to send campaign %d: %v", s.id, err)
+		}
+	}
+}
+
+// queueCampaign starts a scheduled campaign, queueing a message for each
+// customer in its segment as it stands now. Customers with no phone number
+// or email, or who opted out, are recorded as skipped.
+func queueCampaign(db *sql.DB, id int64, ownerID int, segment string, now time.Time) error {
+	var seg campaignSegment
+	if err := json.Unmarshal([]byte(segment), &seg); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE campaigns SET status = 'sending', started_at = ?, updated_at = ? WHERE id = ? AND status = 'scheduled'",
+		now, now, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil // cancelled meanwhile
+	}
+	audience, err := loadCampaignAudience(tx, ownerID, seg, now)
+	if err != nil {
+		return err
+	}
+	for _, c := range audience {
+		status, errText := "queued", ""
+		if _, to := c.notice.contact(); to == "" {
+			status, errText = "skipped", c.notice.unreachable()
+		}
+		_, err := tx.Exec("INSERT OR IGNORE INTO campaign_recipients (campaign_id, customer_id, status, error) VALUES (?, ?, ?, ?)",
+			id, c.id, status, nullIfEmpty(errText))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sendCampaignBatch sends the next queued messages of a campaign. Each
+// recipient is claimed before sending so a cancellation stops the rest of
+// the queue, and failed sends are recorded and not retried.
+func sendCampaignBatch(db *sql.DB, id int64, subject, message string, now time.Time) error {
+	rows, err := db.Query(`
+        SELECT r.id, c.id, c.name, c.phone, c.email, `+optOutColumns+`, COALESCE(o.salon_name, ''),
+            (SELECT MAX(substr(i.invoice_date, 1, 10)) FROM invoices i WHERE i.customer_id = c.id)
+        FROM campaign_recipients r
+        JOIN customers c ON r.customer_id = c.id
+        JOIN owners o ON c.owner_id = o.id
+        WHERE r.campaign_id = ? AND r.status = 'queued'
+        ORDER BY r.id LIMIT ?`, id, campaignBatchSize)
+	if err != nil {
+		return err
+	}
+	type queued struct {
+		recipientID int64
+		customer    campaignCustomer
+	}
+	var batch []queued
+	for rows.Next() {
+		var q queued
+		c := &q.customer
+		if err := rows.Scan(&q.recipientID, &c.id, &c.notice.customerName, &c.notice.phone, &c.notice.email,
+			&c.notice.smsOptOut, &c.notice.emailOptOut, &c.notice.salonName, &c.lastVisit); err != nil {
+			rows.Close()
+			return err
+		}
+		batch = append(batch, q)
+	}
+	rows.Close()
+
+	for _, q := range batch {
+		res, err := db.Exec("UPDATE campaign_recipients SET status = 'sending' WHERE id = ? AND status = 'queued'", q.recipientID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		channel, to := q.customer.notice.contact()
+		status, errText := "sent", ""
+		if to == "" {
+			status, errText = "skipped", q.customer.notice.unreachable()
+		} else if err := sendCampaignMessage(channel, to, subject, campaignText(message, q.customer)); err != nil {
+			status, errText = "failed", err.Error()
+		}
+		_, err = db.Exec(`
+            UPDATE campaign_recipients SET channel = ?, recipient = ?, recipient_hash = ?, status = ?, error = ?, sent_at = ?
+            WHERE id = ?`, nullIfEmpty(channel), nullIfEmpty(maskContact(to)), nullIfEmpty(contactHashIfSet(to)),
+			status, nullIfEmpty(errText), time.Now(), q.recipientID)
+		if err != nil {
+			return err
+		}
+	}
+	if len(batch) == campaignBatchSize {
+		return nil
+	}
+
+	// The queue is empty. Anything still marked sending was interrupted.
+	if _, err := db.Exec(`
+        UPDATE campaign_recipients SET status = 'failed', error = 'Sending was interrupted'
+        WHERE campaign_id = ? AND status = 'sending'`, id); err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE campaigns SET status = 'sent', finished_at = ?, updated_at = ? WHERE id = ? AND status = 'sending'",
+		now, now, id)
+	return err
+}
+
+// campaignIDParam reads the campaign ID from the URL and checks it belongs
+// to the owner, returning its status. It writes the error response itself.
+func campaignIDParam(w http.ResponseWriter, r *http.Request, ownerID int) (int64, string, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid campaign ID", http.StatusBadRequest)
+		return 0, "", false
+	}
+	var status string
+	err = database.GetDB().QueryRow("SELECT status FROM campaigns WHERE id = ? AND owner_id = ?", id, ownerID).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return 0, "", false
+	} else if err != nil {
+		http.Error(w, "Failed to fetch campaign", http.StatusInternalServerError)
+		return 0, "", false
+	}
+	return id, status, true
+}
+
+// --- API: List Campaigns ---
+func APIGetCampaigns(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query("SELECT "+campaignColumns+" FROM campaigns WHERE owner_id = ? ORDER BY created_at DESC, id DESC", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch campaigns", http.StatusInternalServerError)
+		return
+	}
+	campaigns := []*campaign{}
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			log.Printf("Failed to scan campaign: %v", err)
+			continue
+		}
+		campaigns = append(campaigns, c)
+	}
+	rows.Close()
+	for _, c := range campaigns {
+		if err := loadCampaignCounts(db, c); err != nil {
+			http.Error(w, "Failed to fetch campaigns", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(campaigns)
+}
+
+// --- API: Get Campaign ---
+func APIGetCampaign(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, _, ok := campaignIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	c, err := scanCampaign(db.QueryRow("SELECT "+campaignColumns+" FROM campaigns WHERE id = ?", id))
+	if err == nil {
+		err = loadCampaignCounts(db, c)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch campaign", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// decodeCampaign reads and validates a campaign request body.
+func decodeCampaign(w http.ResponseWriter, r *http.Request) (*campaign, bool) {
+	var c campaign
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return nil, false
+	}
+	c.Name = strings.TrimSpace(c.Name)
+	c.Subject = strings.TrimSpace(c.Subject)
+	c.Message = strings.TrimSpace(c.Message)
+	if c.Name == "" || len(c.Name) > 100 {
+		http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(c.Subject) > 150 {
+		http.Error(w, "Subject must be at most 150 characters", http.StatusBadRequest)
+		return nil, false
+	}
+	if c.Message == "" || len(c.Message) > maxCampaignMessage {
+		http.Error(w, fmt.Sprintf("Message is required (max %d characters)", maxCampaignMessage), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := c.Segment.normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &c, true
+}
+
+// --- API: Create Campaign ---
+// Body: {"name", "subject" for emails, "message" and "segment"}. The message
+// can use [CustomerName], [FirstName], [SalonName] and [LastVisit]. New
+// campaigns are drafts until sent or scheduled.
+func APICreateCampaign(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	c, ok := decodeCampaign(w, r)
+	if !ok {
+		return
+	}
+	segment, _ := json.Marshal(c.Segment)
+	now := time.Now()
+	res, err := database.GetDB().Exec(`
+        INSERT INTO campaigns (owner_id, name, subject, message, segment, status, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, 'draft', ?, ?)`, ownerID, c.Name, nullIfEmpty(c.Subject), c.Message, string(segment), now, now)
+	if err != nil {
+		http.Error(w, "Failed to create campaign", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int64{"id": id})
+}
+
+// --- API: Update Campaign ---
+// Only drafts can be changed.
+func APIUpdateCampaign(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, status, ok := campaignIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	if status != "draft" {
+		http.Error(w, "Only draft campaigns can be changed", http.StatusConflict)
+		return
+	}
+	c, ok := decodeCampaign(w, r)
+	if !ok {
+		return
+	}
+	segment, _ := json.Marshal(c.Segment)
+	res, err := database.GetDB().Exec(`
+        UPDATE campaigns SET name = ?, subject = ?, message = ?, segment = ?, updated_at = ?
+        WHERE id = ? AND status = 'draft'`, c.Name, nullIfEmpty(c.Subject), c.Message, string(segment), time.Now(), id)
+	if err != nil {
+		http.Error(w, "Failed to update campaign", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Only draft campaigns can be changed", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Campaign ---
+// Only drafts can be deleted; campaigns that went out are kept with their
+// delivery records.
+func APIDeleteCampaign(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, _, ok := campaignIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	res, err := database.GetDB().Exec("DELETE FROM campaigns WHERE id = ? AND status = 'draft'", id)
+	if err != nil {
+		http.Error(w, "Failed to delete campaign", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Only draft campaigns can be deleted", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- API: Preview Campaign ---
+// Body: {"segment", "message"}. Returns how many customers the segment
+// matches, how many can be reached by SMS and email, and the message as the
+// first few of them would get it.
+func APIPreviewCampaign(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		Segment campaignSegment `json:"segment"`
+		Message string          `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := req.Segment.normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	audience, err := loadCampaignAudience(database.GetDB(), ownerID, req.Segment, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to preview campaign", http.StatusInternalServerError)
+		return
+	}
+	type sample struct {
+		CustomerID int64  `json:"customer_id"`
+		Name       string `json:"name"`
+		Channel    string `json:"channel"`
+		Message    string `json:"message"`
+	}
+	preview := struct {
+		Customers   int      `json:"customers"`
+		SMS         int      `json:"sms"`
+		Email       int      `json:"email"`
+		Unreachable int      `json:"unreachable"`
+		Samples     []sample `json:"samples"`
+	}{Customers: len(audience), Samples: []sample{}}
+	for _, c := range audience {
+		channel, _ := c.notice.contact()
+		switch channel {
+		case "sms":
+			preview.SMS++
+		case "email":
+			preview.Email++
+		default:
+			preview.Unreachable++
+			continue
+		}
+		if len(preview.Samples) < campaignSampleSize {
+			preview.Samples = append(preview.Samples, sample{c.id, c.notice.customerName, channel, campaignText(req.Message, c)})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// --- API: Test Campaign ---
+// Body: {"to": phone number or email}. Sends the campaign, personalized for
+// the first customer in its segment, to the given address only.
+func APITestCampaign(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, _, ok := campaignIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	var req struct {
+		To string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	channel := "sms"
+	if strings.Contains(req.To, "@") {
+		channel = "email"
+	}
+	to, valid := normalizeContact(channel, req.To)
+	if !valid {
+		http.Error(w, "Enter a phone number in international format or an email address", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	c, err := scanCampaign(db.QueryRow("SELECT "+campaignColumns+" FROM campaigns WHERE id = ?", id))
+	if err != nil {
+		http.Error(w, "Failed to fetch campaign", http.StatusInternalServerError)
+		return
+	}
+	audience, err := loadCampaignAudience(db, ownerID, c.Segment, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to fetch campaign", http.StatusInternalServerError)
+		return
+	}
+	example := campaignCustomer{notice: appointmentNotice{customerName: "Test Customer"}}
+	db.QueryRow("SELECT COALESCE(salon_name, '') FROM owners WHERE id = ?", ownerID).Scan(&example.notice.salonName)
+	if len(audience) > 0 {
+		example = audience[0]
+	}
+	err = sendCampaignMessage(channel, to, "[Test] "+campaignSubject(c.Subject, example.notice.salonName),
+		campaignText(c.Message, example))
+	if err != nil {
+		log.Printf("Failed to send test of campaign %d: %v", id, err)
+		http.Error(w, "Failed to send test message: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Send Campaign ---
+// Body: {"send_at": "YYYY-MM-DD HH:MM"} in salon time to schedule a draft,
+// or empty to send it within the minute. The audience is taken when sending
+// starts.
+func APISendCampaign(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, status, ok := campaignIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	if status != "draft" {
+		http.Error(w, "Only draft campaigns can be sent", http.StatusConflict)
+		return
+	}
+	var req struct {
+		SendAt string `json:"send_at"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+	db := database.GetDB()
+	now := time.Now()
+	sendAt := now
+	if req.SendAt != "" {
+		schedule, err := loadSalonSchedule(db, ownerID)
+		if err != nil {
+			http.Error(w, "Failed to schedule campaign", http.StatusInternalServerError)
+			return
+		}
+		sendAt, err = time.ParseInLocation(dateTimeLayout, req.SendAt, schedule.loc)
+		if err != nil {
+			http.Error(w, "send_at must be YYYY-MM-DD HH:MM", http.StatusBadRequest)
+			return
+		}
+		if !sendAt.After(now) || sendAt.After(now.AddDate(1, 0, 0)) {
+			http.Error(w, "send_at must be in the next year", http.StatusBadRequest)
+			return
+		}
+	}
+	res, err := db.Exec("UPDATE campaigns SET status = 'scheduled', scheduled_at = ?, updated_at = ? WHERE id = ? AND status = 'draft'",
+		sendAt.In(time.Local), now, id)
+	if err != nil {
+		http.Error(w, "Failed to schedule campaign", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Only draft campaigns can be sent", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// --- API: Cancel Campaign ---
+// Stops a scheduled campaign, or the rest of the queue of one being sent.
+func APICancelCampaign(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, _, ok := campaignIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		http.Error(w, "Failed to cancel campaign", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	res, err := tx.Exec(`
+        UPDATE campaigns SET status = 'cancelled', finished_at = ?, updated_at = ?
+        WHERE id = ? AND status IN ('scheduled', 'sending')`, now, now, id)
+	if err != nil {
+		http.Error(w, "Failed to cancel campaign", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Only scheduled or sending campaigns can be cancelled", http.StatusConflict)
+		return
+	}
+	if _, err := tx.Exec("UPDATE campaign_recipients SET status = 'cancelled' WHERE campaign_id = ? AND status = 'queued'", id); err != nil {
+		http.Error(w, "Failed to cancel campaign", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to cancel campaign", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: List Campaign Recipients ---
+// Each recipient's delivery status, optionally only those with "status".
+func APIGetCampaignRecipients(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, _, ok := campaignIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	query := `
+        SELECT r.id, r.customer_id, c.name, COALESCE(r.channel, ''), COALESCE(r.recipient, ''), r.status,
+            COALESCE(r.error, ''), r.sent_at
+        FROM campaign_recipients r JOIN customers c ON r.customer_id = c.id
+        WHERE r.campaign_id = ?`
+	args := []interface{}{id}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query += " AND r.status = ?"
+		args = append(args, status)
+	}
+	rows, err := database.GetDB().Query(query+" ORDER BY r.id", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch recipients", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	type recipient struct {
+		ID           int64  `json:"id"`
+		CustomerID   int64  `json:"customer_id"`
+		CustomerName string `json:"customer_name"`
+		Channel      string `json:"channel,omitempty"`
+		Recipient    string `json:"recipient,omitempty"`
+		Status       string `json:"status"`
+		Error        string `json:"error,omitempty"`
+		SentAt       string `json:"sent_at,omitempty"`
+	}
+	recipients := []recipient{}
+	for rows.Next() {
+		var rc recipient
+		var sentAt sql.NullTime
+		if err := rows.Scan(&rc.ID, &rc.CustomerID, &rc.CustomerName, &rc.Channel, &rc.Recipient, &rc.Status,
+			&rc.Error, &sentAt); err != nil {
+			log.Printf("Failed to scan campaign recipient: %v", err)
+			continue
+		}
+		if sentAt.Valid {
+			rc.SentAt = sentAt.Time.Format(time.RFC3339)
+		}
+		recipients = append(recipients, rc)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recipients)
+}
+
+// --- API: List Tags ---
+// Every tag in use, with how many customers have it.
+func APIGetTags(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	rows, err := database.GetDB().Query("SELECT tag, COUNT(*) FROM customer_tags WHERE owner_id = ? GROUP BY tag ORDER BY tag", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	type tagCount struct {
+		Tag       string `json:"tag"`
+		Customers int    `json:"customers"`
+	}
+	tags := []tagCount{}
+	for rows.Next() {
+		var t tagCount
+		if err := rows.Scan(&t.Tag, &t.Customers); err != nil {
+			log.Printf("Failed to scan tag: %v", err)
+			continue
+		}
+		tags = append(tags, t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// --- API: Get Customer Tags ---
+func APIGetCustomerTags(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	customerID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+	rows, err := database.GetDB().Query("SELECT tag FROM customer_tags WHERE customer_id = ? AND owner_id = ? ORDER BY tag",
+		customerID, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	tags := []string{}
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err == nil {
+			tags = append(tags, t)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// --- API: Update Customer Tags ---
+// Body: {"tags": [...]}, replacing the customer's tags.
+func APIUpdateCustomerTags(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	customerID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", customerID, ownerID).Scan(&exists); err != nil {
+		http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+		return
+	}
+	if exists == 0 {
+		http.Error(w, "Customer not found", http.StatusNotFound)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM customer_tags WHERE customer_id = ?", customerID); err != nil {
+		http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+		return
+	}
+	for _, t := range tags {
+		if _, err := tx.Exec("INSERT INTO customer_tags (owner_id, customer_id, tag) VALUES (?, ?, ?)", ownerID, customerID, t); err != nil {
+			http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
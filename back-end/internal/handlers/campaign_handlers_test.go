@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := normalizeTags([]string{" VIP", "colour ", "vip", "Balayage"})
+	if want := []string{"balayage", "colour", "vip"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeTags() = %v (%v), want %v", got, err, want)
+	}
+	if _, err := normalizeTags([]string{"vip", " "}); err == nil {
+		t.Error("normalizeTags() accepted a blank tag")
+	}
+	if _, err := normalizeTags([]string{strings.Repeat("x", 51)}); err == nil {
+		t.Error("normalizeTags() accepted a 51-character tag")
+	}
+}
+
+func TestCampaignSegmentNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		seg     campaignSegment
+		wantErr string
+	}{
+		{name: "everyone"},
+		{name: "all filters", seg: campaignSegment{Tags: []string{"VIP"}, BirthdayMonth: 12, MinSpend: price(10), MaxSpend: price(10),
+			LastVisitBefore: "2026-10-01", LastVisitAfter: "2026-01-01"}},
+		{name: "birthday month", seg: campaignSegment{BirthdayMonth: 13}, wantErr: "Birthday month must be 1-12"},
+		{name: "negative spend", seg: campaignSegment{MinSpend: price(-1)}, wantErr: "Spend must be 0 or more"},
+		{name: "spend range", seg: campaignSegment{MinSpend: price(100), MaxSpend: price(50)},
+			wantErr: "Minimum spend must not be above maximum spend"},
+		{name: "visit date", seg: campaignSegment{LastVisitAfter: "01/01/2026"}, wantErr: "Last visit dates must be YYYY-MM-DD"},
+	}
+	for _, tt := range tests {
+		err := tt.seg.normalize()
+		if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+			t.Errorf("%s: normalize() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadCampaignAudience(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 11, 2, 10, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO owners (id, email, password_hash) VALUES (1, 'a@example.com', 'x'), (2, 'b@example.com', 'x')`,
+		`INSERT INTO customers (id, owner_id, name, birthday) VALUES
+			(1, 1, 'Ann', '1990-12-05'),
+			(2, 1, 'Bea', '1985-06-20'),
+			(3, 1, 'Cy', '1992-12-25'),
+			(4, 1, 'Di', NULL),
+			(5, 2, 'Ed', '1990-12-01')`,
+		`INSERT INTO customer_consents (owner_id, customer_id, channel, status, source) VALUES (1, 3, 'sms', 'opted_out', 'sms_reply')`,
+		`INSERT INTO customer_tags (owner_id, customer_id, tag) VALUES (1, 1, 'vip'), (1, 2, 'colour'), (1, 3, 'vip'), (2, 5, 'vip')`,
+		`INSERT INTO invoices (owner_id, customer_id, invoice_date, total_amount, payment_status) VALUES
+			(1, 1, '2026-10-01', 300, 'Paid'),
+			(1, 1, '2025-06-01', 900, 'Paid'),
+			(1, 2, '2026-03-01', 80, 'Paid'),
+			(1, 2, '2026-04-01', 500, 'Unpaid')`,
+	)
+	tests := []struct {
+		name string
+		seg  campaignSegment
+		want []int64
+	}{
+		{"everyone", campaignSegment{}, []int64{1, 2, 3, 4}},
+		{"tagged", campaignSegment{Tags: []string{"vip", "colour"}}, []int64{1, 2, 3}},
+		{"birthday month", campaignSegment{BirthdayMonth: 12}, []int64{1, 3}},
+		{"paid spend in the last year", campaignSegment{MinSpend: price(100)}, []int64{1}},
+		{"low spenders", campaignSegment{MaxSpend: price(100)}, []int64{2, 3, 4}},
+		{"lapsed", campaignSegment{LastVisitBefore: "2026-06-01"}, []int64{2}},
+		{"recent", campaignSegment{LastVisitAfter: "2026-06-01"}, []int64{1}},
+	}
+	for _, tt := range tests {
+		audience, err := loadCampaignAudience(db, 1, tt.seg, now)
+		if err != nil {
+			t.Fatalf("%s: loadCampaignAudience() error = %v", tt.name, err)
+		}
+		var got []int64
+		for _, c := range audience {
+			got = append(got, c.id)
+			if optedOut := c.id == 3; c.notice.smsOptOut != optedOut || c.notice.emailOptOut {
+				t.Errorf("%s: customer %d opted out of SMS %v, email %v", tt.name, c.id, c.notice.smsOptOut, c.notice.emailOptOut)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: audience = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCampaignText(t *testing.T) {
+	c := campaignCustomer{
+		lastVisit: sql.NullString{String: "2026-07-14", Valid: true},
+		notice:    appointmentNotice{customerName: "Jane Smith", salonName: "Shear Joy"},
+	}
+	got := campaignText("Hi [FirstName] ([CustomerName]), [SalonName] last saw you on [LastVisit].", c)
+	if want := "Hi Jane (Jane Smith), Shear Joy last saw you on 14 July 2026."; got != want {
+		t.Errorf("campaignText() = %q, want %q", got, want)
+	}
+	if got := campaignSubject("", "Shear Joy"); got != "News from Shear Joy" {
+		t.Errorf("campaignSubject() = %q, want the default", got)
+	}
+}
@@ -0,0 +1,416 @@
+// internal/handlers/commission_handlers.go
+// Handlers for staff commission rules and the commission calculation used by
+// the payroll report.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// commissionTier is one band of a tiered commission rule: sales in the pay
+// period from MinSales up to the next tier earn Rate percent.
+type commissionTier struct {
+	MinSales float64 `json:"min_sales"`
+	Rate     float64 `json:"rate"`
+}
+
+// commissionRule pays staff a percentage of their sales. StaffID and Category
+// narrow the rule down; when several rules match a line, the one naming both
+// the staff member and category wins, then staff only, then category only.
+type commissionRule struct {
+	ID       int64            `json:"id"`
+	Name     string           `json:"name"`
+	StaffID  *int64           `json:"staff_id"`
+	Category string           `json:"category"`
+	Tiers    []commissionTier `json:"tiers"`
+	Active   bool             `json:"active"`
+}
+
+// matchScore ranks how specifically the rule matches a line, or returns -1
+// if it doesn't apply.
+func (c *commissionRule) matchScore(staffID int64, category string) int {
+	score := 0
+	if c.StaffID != nil {
+		if *c.StaffID != staffID {
+			return -1
+		}
+		score += 2
+	}
+	if c.Category != "" {
+		if !strings.EqualFold(c.Category, category) {
+			return -1
+		}
+		score++
+	}
+	return score
+}
+
+// commissionOn works out the commission on a pay period's sales, paying each
+// band of sales at its tier's rate.
+func (c *commissionRule) commissionOn(sales float64) float64 {
+	var total float64
+	for i, t := range c.Tiers {
+		if sales <= t.MinSales {
+			break
+		}
+		upper := sales
+		if i+1 < len(c.Tiers) && c.Tiers[i+1].MinSales < sales {
+			upper = c.Tiers[i+1].MinSales
+		}
+		total += (upper - t.MinSales) * t.Rate / 100
+	}
+	return round2(total)
+}
+
+func loadCommissionRules(q queryer, ownerID int, activeOnly bool) ([]*commissionRule, error) {
+	query := "SELECT id, name, staff_id, COALESCE(category, ''), active FROM commission_rules WHERE owner_id = ?"
+	if activeOnly {
+		query += " AND active = 1"
+	}
+	rows, err := q.Query(query+" ORDER BY name", ownerID)
+	if err != nil {
+		return nil, err
+	}
+	rules := []*commissionRule{}
+	for rows.Next() {
+		c := &commissionRule{Tiers: []commissionTier{}}
+		var staffID sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.Name, &staffID, &c.Category, &c.Active); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if staffID.Valid {
+			c.StaffID = &staffID.Int64
+		}
+		rules = append(rules, c)
+	}
+	rows.Close()
+	for _, c := range rules {
+		rows, err := q.Query("SELECT min_sales, rate FROM commission_rule_tiers WHERE rule_id = ? ORDER BY min_sales", c.ID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var t commissionTier
+			if err := rows.Scan(&t.MinSales, &t.Rate); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			c.Tiers = append(c.Tiers, t)
+		}
+		rows.Close()
+	}
+	return rules, nil
+}
+
+type commissionRuleTotal struct {
+	RuleID     int64   `json:"rule_id"`
+	Rule       string  `json:"rule"`
+	Sales      float64 `json:"sales"`
+	Commission float64 `json:"commission"`
+}
+
+// staffCommission is one staff member's pay for a period.
+type staffCommission struct {
+	StaffID    int64                 `json:"staff_id"`
+	Name       string                `json:"name"`
+	Sales      float64               `json:"sales"`
+	Commission float64               `json:"commission"`
+	Tips       float64               `json:"tips"`
+	Total      float64               `json:"total"`
+	Rules      []commissionRuleTotal `json:"rules"`
+}
+
+// calculateCommissions totals each staff member's attributed sales, tips and
+// commission on paid invoices dated between start and end. Sales are line
+// amounts after discounts and excluding tax.
+func calculateCommissions(db *sql.DB, ownerID int, start, end string) ([]*staffCommission, error) {
+	rules, err := loadCommissionRules(db, ownerID, true)
+	if err != nil {
+		return nil, err
+	}
+	names, err := staffNames(db, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	byStaff := map[int64]*staffCommission{}
+	get := func(staffID int64) *staffCommission {
+		s, ok := byStaff[staffID]
+		if !ok {
+			s = &staffCommission{StaffID: staffID, Name: names[staffID], Rules: []commissionRuleTotal{}}
+			byStaff[staffID] = s
+		}
+		return s
+	}
+
+	rows, err := db.Query(`
+        SELECT ii.staff_id, COALESCE(s.category, p.category, ''), COALESCE(ii.net_amount, ii.line_total)
+        FROM invoice_items ii
+        JOIN invoices i ON ii.invoice_id = i.id
+        LEFT JOIN services s ON ii.service_id = s.id
+        LEFT JOIN products p ON ii.product_id = p.id
+        WHERE i.owner_id = ? AND i.payment_status = 'Paid' AND i.invoice_date BETWEEN ? AND ?
+            AND ii.staff_id IS NOT NULL`, ownerID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	type key struct{ staffID, ruleID int64 }
+	sales := map[key]float64{}
+	for rows.Next() {
+		var staffID int64
+		var category string
+		var amount float64
+		if err := rows.Scan(&staffID, &category, &amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		get(staffID).Sales += amount
+		var best *commissionRule
+		bestScore := -1
+		for _, c := range rules {
+			if score := c.matchScore(staffID, category); score > bestScore {
+				best, bestScore = c, score
+			}
+		}
+		if best != nil {
+			sales[key{staffID, best.ID}] += amount
+		}
+	}
+	rows.Close()
+	for _, c := range rules {
+		for k, amount := range sales {
+			if k.ruleID != c.ID {
+				continue
+			}
+			s := get(k.staffID)
+			commission := c.commissionOn(amount)
+			s.Commission += commission
+			s.Rules = append(s.Rules, commissionRuleTotal{RuleID: c.ID, Rule: c.Name, Sales: round2(amount), Commission: commission})
+		}
+	}
+
+	rows, err = db.Query(`
+        SELECT t.staff_id, SUM(t.amount)
+        FROM invoice_tips t
+        JOIN invoices i ON t.invoice_id = i.id
+        WHERE i.owner_id = ? AND i.payment_status = 'Paid' AND i.invoice_date BETWEEN ? AND ?
+        GROUP BY t.staff_id`, ownerID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var staffID int64
+		var tips float64
+		if err := rows.Scan(&staffID, &tips); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		get(staffID).Tips = tips
+	}
+	rows.Close()
+
+	result := []*staffCommission{}
+	for _, s := range byStaff {
+		s.Sales, s.Commission, s.Tips = round2(s.Sales), round2(s.Commission), round2(s.Tips)
+		s.Total = round2(s.Commission + s.Tips)
+		sort.Slice(s.Rules, func(i, j int) bool { return s.Rules[i].Rule < s.Rules[j].Rule })
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// --- API: List Commission Rules ---
+func APIGetCommissionRules(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	rules, err := loadCommissionRules(database.GetDB(), ownerID, false)
+	if err != nil {
+		http.Error(w, "Failed to fetch commission rules", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// decodeCommissionRule reads and validates a commission rule request body.
+func decodeCommissionRule(w http.ResponseWriter, r *http.Request, ownerID int) (*commissionRule, bool) {
+	c := &commissionRule{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return nil, false
+	}
+	c.Name = strings.TrimSpace(c.Name)
+	c.Category = strings.TrimSpace(c.Category)
+	if c.Name == "" || len(c.Name) > 100 {
+		http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(c.Tiers) == 0 {
+		http.Error(w, "A commission rule needs at least one tier", http.StatusBadRequest)
+		return nil, false
+	}
+	seen := map[float64]bool{}
+	for _, t := range c.Tiers {
+		if t.MinSales < 0 || t.Rate < 0 || t.Rate > 100 || seen[t.MinSales] {
+			http.Error(w, "Tiers need distinct minimum sales of 0 or more and a rate between 0 and 100", http.StatusBadRequest)
+			return nil, false
+		}
+		seen[t.MinSales] = true
+	}
+	sort.Slice(c.Tiers, func(i, j int) bool { return c.Tiers[i].MinSales < c.Tiers[j].MinSales })
+	if c.StaffID != nil {
+		if err := lookupStaff(database.GetDB(), ownerID, *c.StaffID); err != nil {
+			http.Error(w, "Staff member not found", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	return c, true
+}
+
+// saveCommissionTiers replaces the tiers of a commission rule.
+func saveCommissionTiers(tx *sql.Tx, c *commissionRule) error {
+	if _, err := tx.Exec("DELETE FROM commission_rule_tiers WHERE rule_id = ?", c.ID); err != nil {
+		return err
+	}
+	for _, t := range c.Tiers {
+		if _, err := tx.Exec("INSERT INTO commission_rule_tiers (rule_id, min_sales, rate) VALUES (?, ?, ?)", c.ID, t.MinSales, t.Rate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- API: Add Commission Rule ---
+func APIAddCommissionRule(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	c, ok := decodeCommissionRule(w, r, ownerID)
+	if !ok {
+		return
+	}
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		http.Error(w, "Failed to add commission rule", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+        INSERT INTO commission_rules (owner_id, name, staff_id, category, active, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, c.Name, c.StaffID, nullIfEmpty(c.Category), c.Active, time.Now(), time.Now())
+	if err != nil {
+		http.Error(w, "Failed to add commission rule", http.StatusInternalServerError)
+		return
+	}
+	c.ID, _ = res.LastInsertId()
+	if err := saveCommissionTiers(tx, c); err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to add commission rule", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": c.ID})
+}
+
+// --- API: Update Commission Rule ---
+func APIUpdateCommissionRule(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid commission rule ID", http.StatusBadRequest)
+		return
+	}
+	c, ok := decodeCommissionRule(w, r, ownerID)
+	if !ok {
+		return
+	}
+	c.ID = int64(id)
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		http.Error(w, "Failed to update commission rule", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+        UPDATE commission_rules SET name = ?, staff_id = ?, category = ?, active = ?, updated_at = ?
+        WHERE id = ? AND owner_id = ?`,
+		c.Name, c.StaffID, nullIfEmpty(c.Category), c.Active, time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to update commission rule", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Commission rule not found", http.StatusNotFound)
+		return
+	}
+	if err := saveCommissionTiers(tx, c); err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to update commission rule", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Commission Rule ---
+func APIDeleteCommissionRule(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid commission rule ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	_, err = db.Exec("UPDATE commission_rules SET active = 0, updated_at = ? WHERE id = ? AND owner_id = ?", time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete commission rule", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
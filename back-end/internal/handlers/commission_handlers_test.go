@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommissionOn(t *testing.T) {
+	tiered := &commissionRule{Tiers: []commissionTier{{0, 10}, {1000, 15}, {2000, 20}}}
+	threshold := &commissionRule{Tiers: []commissionTier{{500, 10}}}
+	tests := []struct {
+		name  string
+		rule  *commissionRule
+		sales float64
+		want  float64
+	}{
+		{"nothing sold", tiered, 0, 0},
+		{"first tier", tiered, 500, 50},
+		{"top of the first tier", tiered, 1000, 100},
+		{"into the second tier", tiered, 1500, 175},
+		{"every tier", tiered, 2500, 350},
+		{"below the threshold", threshold, 400, 0},
+		{"only sales above the threshold", threshold, 700, 20},
+		{"rounded to cents", tiered, 333.33, 33.33},
+		{"no tiers", &commissionRule{}, 1000, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.commissionOn(tt.sales); got != tt.want {
+			t.Errorf("%s: commissionOn(%v) = %v, want %v", tt.name, tt.sales, got, tt.want)
+		}
+	}
+}
+
+func TestCommissionMatchScore(t *testing.T) {
+	alice := int64(1)
+	tests := []struct {
+		name     string
+		rule     commissionRule
+		staffID  int64
+		category string
+		want     int
+	}{
+		{"staff and category", commissionRule{StaffID: &alice, Category: "Colour"}, 1, "colour", 3},
+		{"staff and category, other category", commissionRule{StaffID: &alice, Category: "Colour"}, 1, "Cut", -1},
+		{"staff and category, other staff", commissionRule{StaffID: &alice, Category: "Colour"}, 2, "Colour", -1},
+		{"staff only", commissionRule{StaffID: &alice}, 1, "Cut", 2},
+		{"category only", commissionRule{Category: "Colour"}, 2, "Colour", 1},
+		{"everyone", commissionRule{}, 2, "", 0},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matchScore(tt.staffID, tt.category); got != tt.want {
+			t.Errorf("%s: matchScore() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateCommissions(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db,
+		`INSERT INTO staff (id, owner_id, name) VALUES (1, 1, 'Alice'), (2, 1, 'Bob')`,
+		`INSERT INTO services (id, owner_id, name, price, category) VALUES (10, 1, 'Balayage', 150, 'Colour'), (11, 1, 'Cut', 50, 'Cut')`,
+		`INSERT INTO commission_rules (id, owner_id, name, staff_id, category) VALUES
+			(1, 1, 'House', NULL, NULL),
+			(2, 1, 'Alice colour', 1, 'Colour')`,
+		`INSERT INTO commission_rule_tiers (rule_id, min_sales, rate) VALUES (1, 0, 10), (2, 0, 20), (2, 100, 30)`,
+		`INSERT INTO invoices (id, owner_id, customer_id, invoice_date, total_amount, payment_status) VALUES
+			(1, 1, 5, '2026-03-05', 280, 'Paid'),
+			(2, 1, 5, '2026-03-06', 100, 'Unpaid'),
+			(3, 1, 5, '2026-04-01', 100, 'Paid'),
+			(4, 2, 5, '2026-03-05', 100, 'Paid')`,
+		`INSERT INTO invoice_items (invoice_id, service_id, description, quantity, unit_price, line_total, net_amount, staff_id) VALUES
+			(1, 10, 'Balayage', 1, 160, 160, 150, 1),
+			(1, 11, 'Cut', 1, 50, 50, NULL, 1),
+			(1, 10, 'Balayage', 1, 80, 80, 80, 2),
+			(1, NULL, 'Shampoo', 1, 12, 12, 12, NULL),
+			(2, 11, 'Cut', 1, 100, 100, 100, 1),
+			(3, 11, 'Cut', 1, 100, 100, 100, 1),
+			(4, 11, 'Cut', 1, 100, 100, 100, 1)`,
+		`INSERT INTO invoice_tips (invoice_id, staff_id, amount) VALUES (1, 1, 5), (2, 1, 7)`,
+	)
+
+	got, err := calculateCommissions(db, 1, "2026-03-01", "2026-03-31")
+	if err != nil {
+		t.Fatalf("calculateCommissions() error = %v", err)
+	}
+	want := []*staffCommission{
+		{StaffID: 1, Name: "Alice", Sales: 200, Commission: 40, Tips: 5, Total: 45, Rules: []commissionRuleTotal{
+			{RuleID: 2, Rule: "Alice colour", Sales: 150, Commission: 35},
+			{RuleID: 1, Rule: "House", Sales: 50, Commission: 5},
+		}},
+		{StaffID: 2, Name: "Bob", Sales: 80, Commission: 8, Total: 8, Rules: []commissionRuleTotal{
+			{RuleID: 1, Rule: "House", Sales: 80, Commission: 8},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for _, s := range got {
+			t.Logf("%+v", *s)
+		}
+		t.Errorf("calculateCommissions() didn't match")
+	}
+}
@@ -0,0 +1,373 @@
+// internal/handlers/consent_handlers.go
+// Marketing consent: each customer's opt-in or opt-out per channel with when
+// and where it came from, STOP and START text replies, and a log of every
+// change for compliance requests. Automated messages leave out channels a
+// customer opted out of.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+const (
+	consentOptedIn  = "opted_in"
+	consentOptedOut = "opted_out"
+)
+
+// optOutColumns selects whether customer c opted out of SMS and of email,
+// for scanning into appointmentNotice.smsOptOut and emailOptOut.
+const optOutColumns = `EXISTS (SELECT 1 FROM customer_consents cc
+                WHERE cc.customer_id = c.id AND cc.channel = 'sms' AND cc.status = 'opted_out') AS sms_opt_out,
+            EXISTS (SELECT 1 FROM customer_consents cc
+                WHERE cc.customer_id = c.id AND cc.channel = 'email' AND cc.status = 'opted_out') AS email_opt_out`
+
+var consentChannels = []string{"sms", "email"}
+
+// staffConsentSources are the sources staff can record a change with. The
+// system also records "sms_reply" for texted keywords and "migrated" for
+// the old marketing opt-out flag.
+var staffConsentSources = map[string]bool{"front_desk": true, "customer_request": true, "paper_form": true}
+
+// smsOptOutKeywords and smsOptInKeywords are the replies carriers treat as
+// unsubscribing and resubscribing. CANCEL is left out because it cancels an
+// appointment here.
+var (
+	smsOptOutKeywords = map[string]bool{"STOP": true, "STOPALL": true, "UNSUBSCRIBE": true, "END": true, "QUIT": true,
+		"REVOKE": true, "OPTOUT": true}
+	smsOptInKeywords = map[string]bool{"START": true, "UNSTOP": true}
+)
+
+// consentEvent is one change to a customer's consent.
+type consentEvent struct {
+	ID           int64  `json:"id"`
+	CustomerID   int64  `json:"customer_id"`
+	CustomerName string `json:"customer_name,omitempty"`
+	Channel      string `json:"channel"`
+	Status       string `json:"status"`
+	Source       string `json:"source"`
+	Note         string `json:"note,omitempty"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// setConsent records a customer's consent on a channel and logs the change.
+// Setting the status the customer already has does nothing.
+func setConsent(tx *sql.Tx, ownerID int, customerID int64, channel, status, source, note string, now time.Time) error {
+	var current string
+	err := tx.QueryRow("SELECT status FROM customer_consents WHERE customer_id = ? AND channel = ?", customerID, channel).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if current == status {
+		return nil
+	}
+	_, err = tx.Exec(`
+        INSERT OR REPLACE INTO customer_consents (owner_id, customer_id, channel, status, source, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?)`, ownerID, customerID, channel, status, source, now)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO consent_log (owner_id, customer_id, channel, status, source, note, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`, ownerID, customerID, channel, status, source, nullIfEmpty(note), now)
+	return err
+}
+
+// consentKeyword reads an SMS reply as an opt-out or opt-in keyword,
+// returning the consent status it asks for, or "".
+func consentKeyword(body string) string {
+	fields := strings.Fields(strings.ToUpper(body))
+	if len(fields) != 1 {
+		return ""
+	}
+	word := strings.Trim(fields[0], ".!")
+	switch {
+	case smsOptOutKeywords[word]:
+		return consentOptedOut
+	case smsOptInKeywords[word]:
+		return consentOptedIn
+	}
+	return ""
+}
+
+// handleConsentReply applies a STOP or START text to every customer with
+// that phone number, across salons since they share the sending number.
+// It reports whether the text was such a keyword. No reply is sent: the
+// carrier confirms opt-outs itself.
+func handleConsentReply(db *sql.DB, phone, body string, now time.Time) (bool, error) {
+	status := consentKeyword(body)
+	if status == "" {
+		return false, nil
+	}
+	rows, err := db.Query("SELECT id, owner_id FROM customers WHERE phone_hash = ? ORDER BY id", contactHash(phone))
+	if err != nil {
+		return true, err
+	}
+	type match struct {
+		customerID int64
+		ownerID    int
+	}
+	var matches []match
+	for rows.Next() {
+		var m match
+		if err := rows.Scan(&m.customerID, &m.ownerID); err != nil {
+			rows.Close()
+			return true, err
+		}
+		matches = append(matches, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(matches) == 0 {
+		return true, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return true, err
+	}
+	defer tx.Rollback()
+	note := "Replied " + strings.ToUpper(strings.Trim(strings.TrimSpace(body), ".!"))
+	for _, m := range matches {
+		if err := setConsent(tx, m.ownerID, m.customerID, "sms", status, "sms_reply", note, now); err != nil {
+			return true, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// --- API: Get Customer Consent ---
+// Returns the customer's consent per channel ("unknown" if never recorded)
+// and the history of changes, newest first.
+func APIGetCustomerConsent(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	customerID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", customerID, ownerID).Scan(&exists); err != nil {
+		http.Error(w, "Failed to fetch consent", http.StatusInternalServerError)
+		return
+	}
+	if exists == 0 {
+		http.Error(w, "Customer not found", http.StatusNotFound)
+		return
+	}
+
+	type channelConsent struct {
+		Status    string `json:"status"`
+		Source    string `json:"source,omitempty"`
+		UpdatedAt string `json:"updated_at,omitempty"`
+	}
+	channels := map[string]channelConsent{}
+	for _, ch := range consentChannels {
+		channels[ch] = channelConsent{Status: "unknown"}
+	}
+	rows, err := db.Query("SELECT channel, status, source, updated_at FROM customer_consents WHERE customer_id = ?", customerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch consent", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var channel string
+		var c channelConsent
+		var updatedAt time.Time
+		if err := rows.Scan(&channel, &c.Status, &c.Source, &updatedAt); err != nil {
+			log.Printf("Failed to scan consent: %v", err)
+			continue
+		}
+		c.UpdatedAt = updatedAt.Format(time.RFC3339)
+		channels[channel] = c
+	}
+	rows.Close()
+
+	history, err := loadConsentLog(db, "l.owner_id = ? AND l.customer_id = ?", ownerID, customerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch consent", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"channels": channels, "history": history})
+}
+
+// --- API: Update Customer Consent ---
+// Body: {"channel": "sms" or "email", "status": "opted_in" or "opted_out",
+// "source": "front_desk" (the default), "customer_request" or "paper_form",
+// and an optional "note"}.
+func APIUpdateCustomerConsent(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	customerID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Channel string `json:"channel"`
+		Status  string `json:"status"`
+		Source  string `json:"source"`
+		Note    string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Channel != "sms" && req.Channel != "email" {
+		http.Error(w, "Channel must be sms or email", http.StatusBadRequest)
+		return
+	}
+	if req.Status != consentOptedIn && req.Status != consentOptedOut {
+		http.Error(w, "Status must be opted_in or opted_out", http.StatusBadRequest)
+		return
+	}
+	if req.Source == "" {
+		req.Source = "front_desk"
+	}
+	if !staffConsentSources[req.Source] {
+		http.Error(w, "Source must be front_desk, customer_request or paper_form", http.StatusBadRequest)
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > 500 {
+		http.Error(w, "Note must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", customerID, ownerID).Scan(&exists); err != nil {
+		http.Error(w, "Failed to update consent", http.StatusInternalServerError)
+		return
+	}
+	if exists == 0 {
+		http.Error(w, "Customer not found", http.StatusNotFound)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update consent", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if err := setConsent(tx, ownerID, customerID, req.Channel, req.Status, req.Source, req.Note, time.Now()); err != nil {
+		http.Error(w, "Failed to update consent", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update consent", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// loadConsentLog lists consent changes matching a condition on consent_log
+// l, newest first.
+func loadConsentLog(q queryer, where string, args ...interface{}) ([]consentEvent, error) {
+	rows, err := q.Query(`
+        SELECT l.id, l.customer_id, c.name, l.channel, l.status, l.source, COALESCE(l.note, ''), l.created_at
+        FROM consent_log l JOIN customers c ON l.customer_id = c.id
+        WHERE `+where+`
+        ORDER BY l.created_at DESC, l.id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []consentEvent{}
+	for rows.Next() {
+		var e consentEvent
+		var createdAt time.Time
+		if err := rows.Scan(&e.ID, &e.CustomerID, &e.CustomerName, &e.Channel, &e.Status, &e.Source, &e.Note, &createdAt); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = createdAt.Format(time.RFC3339)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// --- API: Consent Log ---
+// Every consent change between "start" and "end", or the full history of
+// one "customer_id". Add format=csv to download it as a spreadsheet.
+func APIGetConsentLog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var events []consentEvent
+	var err error
+	filename := "consent_log"
+	if idStr := r.URL.Query().Get("customer_id"); idStr != "" {
+		customerID, perr := strconv.ParseInt(idStr, 10, 64)
+		if perr != nil {
+			http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+			return
+		}
+		filename += "_customer_" + idStr
+		events, err = loadConsentLog(database.GetDB(), "l.owner_id = ? AND l.customer_id = ?", ownerID, customerID)
+	} else {
+		start, end, ok := reportDateRange(r)
+		if !ok {
+			http.Error(w, "Dates must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		filename += "_" + start + "_" + end
+		events, err = loadConsentLog(database.GetDB(), "l.owner_id = ? AND substr(l.created_at, 1, 10) BETWEEN ? AND ?",
+			ownerID, start, end)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch consent log", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename+".csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"Date", "Customer ID", "Customer", "Channel", "Status", "Source", "Note"})
+		for _, e := range events {
+			cw.Write([]string{e.CreatedAt, strconv.FormatInt(e.CustomerID, 10), e.CustomerName, strings.ToUpper(e.Channel),
+				e.Status, e.Source, e.Note})
+		}
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"salon-management/internal/database"
+)
+
+func TestConsentKeyword(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"STOP", consentOptedOut},
+		{" stop. ", consentOptedOut},
+		{"Start", consentOptedIn},
+		{"STOP please", ""},
+		{"CANCEL", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := consentKeyword(tt.in); got != tt.want {
+			t.Errorf("consentKeyword(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHandleConsentReply(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 11, 2, 9, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO owners (id, email, password_hash) VALUES (1, 'a@example.com', 'x'), (2, 'b@example.com', 'x')`,
+	)
+	for _, c := range []struct {
+		id      int
+		ownerID int
+		phone   string
+	}{
+		{1, 1, "+15550001111"},
+		{2, 2, "+15550001111"},
+		{3, 1, "+15550002222"},
+	} {
+		phone, _ := encryptField(c.phone)
+		if _, err := db.Exec("INSERT INTO customers (id, owner_id, name, phone, phone_hash) VALUES (?, ?, 'Jane', ?, ?)",
+			c.id, c.ownerID, phone, contactHash(c.phone)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	statuses := func() map[int]string {
+		rows, err := db.Query("SELECT customer_id, status FROM customer_consents WHERE channel = 'sms'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		got := map[int]string{}
+		for rows.Next() {
+			var id int
+			var status string
+			rows.Scan(&id, &status)
+			got[id] = status
+		}
+		return got
+	}
+	logged := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM consent_log WHERE source = 'sms_reply'").Scan(&n)
+		return n
+	}
+
+	if handled, err := handleConsentReply(db, "+15550001111", "see you then", now); err != nil || handled {
+		t.Errorf("ordinary text handled = %v (%v), want false", handled, err)
+	}
+	if handled, err := handleConsentReply(db, "+15550009999", "STOP", now); err != nil || !handled {
+		t.Errorf("STOP from an unknown number handled = %v (%v), want true", handled, err)
+	}
+	if handled, err := handleConsentReply(db, "+15550001111", "Stop", now); err != nil || !handled {
+		t.Fatalf("STOP handled = %v (%v), want true", handled, err)
+	}
+	if got := statuses(); len(got) != 2 || got[1] != consentOptedOut || got[2] != consentOptedOut {
+		t.Errorf("after STOP consents = %v, want customers 1 and 2 opted out", got)
+	}
+	// Repeating the keyword changes nothing and logs nothing.
+	handleConsentReply(db, "+15550001111", "STOP", now)
+	if n := logged(); n != 2 {
+		t.Errorf("after repeated STOP %d log entries, want 2", n)
+	}
+	handleConsentReply(db, "+15550001111", "START", now)
+	if got := statuses(); got[1] != consentOptedIn || got[2] != consentOptedIn {
+		t.Errorf("after START consents = %v, want customers 1 and 2 opted in", got)
+	}
+	if n := logged(); n != 4 {
+		t.Errorf("after START %d log entries, want 4", n)
+	}
+}
+
+func TestMigrateMarketingOptOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "salon.db") + "?_synchronous=OFF"
+	db, err := database.InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	mustExec(t, db,
+		"ALTER TABLE customers ADD COLUMN marketing_opt_out INTEGER DEFAULT 0",
+		`INSERT INTO owners (id, email, password_hash) VALUES (1, 'a@example.com', 'x')`,
+		`INSERT INTO customers (id, owner_id, name, marketing_opt_out) VALUES (1, 1, 'Ann', 1), (2, 1, 'Bea', 0)`,
+	)
+	db.Close()
+
+	db, err = database.InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB after adding the column: %v", err)
+	}
+	defer db.Close()
+	var consents, logged, columns int
+	db.QueryRow("SELECT COUNT(*) FROM customer_consents WHERE customer_id = 1 AND status = 'opted_out'").Scan(&consents)
+	db.QueryRow("SELECT COUNT(*) FROM consent_log WHERE customer_id = 1 AND source = 'migrated'").Scan(&logged)
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('customers') WHERE name = 'marketing_opt_out'").Scan(&columns)
+	if consents != 2 || logged != 2 {
+		t.Errorf("customer 1 has %d opt-outs and %d log entries, want 2 of each", consents, logged)
+	}
+	if columns != 0 {
+		t.Error("marketing_opt_out column was not dropped")
+	}
+	var others int
+	db.QueryRow("SELECT COUNT(*) FROM customer_consents WHERE customer_id = 2").Scan(&others)
+	if others != 0 {
+		t.Errorf("customer 2 has %d consent rows, want none", others)
+	}
+}
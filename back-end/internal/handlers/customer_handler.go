@@ -17,18 +17,15 @@ import (
 	"salon-management/internal/database"
 
 	"net/mail"
-	"regexp"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/joho/godotenv"
 )
 
 var encryptionKey []byte
 
-func init() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found or failed to load .env file. Relying on system environment variables.")
-	}
+// LoadEncryptionKey reads the key customer contact details are encrypted with
+// from ENCRYPTION_KEY, exiting if it isn't valid.
+func LoadEncryptionKey() {
 	keyHex := os.Getenv("ENCRYPTION_KEY")
 	var err error
 	encryptionKey, err = hex.DecodeString(keyHex)
@@ -36,6 +33,8 @@ func init() {
 		log.Fatalf("ENCRYPTION_KEY must be a 64-character hex string (32 bytes), got %d bytes", len(encryptionKey))
 	}
 	log.Println("ENCRYPTION_KEY loaded successfully.")
+	database.DecryptField = decryptField
+	database.ContactHash = customerContactHash
 }
 
 func encryptField(plain string) ([]byte, error) {
@@ -76,22 +75,21 @@ func decryptField(ciphertext []byte) (string, error) {
 	return string(plain), nil
 }
 
-
 // --- API: List Customers ---
 func APIGetCustomers(w http.ResponseWriter, r *http.Request) {
-		userID := r.Context().Value(UserIDKey)
-	// var id int
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
 	switch v := userID.(type) {
 	case int:
-		id = v
+		ownerID = v
 	case int64:
-		id = int(v)
+		ownerID = int(v)
 	default:
 		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
 		return
 	}
 	db := database.GetDB()
-	rows, err := db.Query("SELECT id, name, phone, email, birthday, anniversary FROM customers WHERE owner_id = ?", userID)
+	rows, err := db.Query("SELECT id, name, phone, email, birthday, anniversary FROM customers WHERE owner_id = ?", ownerID)
 	if err != nil {
 		http.Error(w, "Failed to fetch customers", http.StatusInternalServerError)
 		return
@@ -135,12 +133,12 @@ func APIGetCustomers(w http.ResponseWriter, r *http.Request) {
 // --- API: Add Customer ---
 func APIAddCustomer(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(UserIDKey)
-	// var id int
+	var ownerID int
 	switch v := userID.(type) {
 	case int:
-		id = v
+		ownerID = v
 	case int64:
-		id = int(v)
+		ownerID = int(v)
 	default:
 		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
 		return
@@ -160,12 +158,19 @@ func APIAddCustomer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Name is required", http.StatusBadRequest)
 		return
 	}
+	db := database.GetDB()
 	if c.Phone != "" {
-		phoneRegex := regexp.MustCompile(`^[0-9 +()-]*$`)
-		if !phoneRegex.MatchString(c.Phone) {
-			http.Error(w, "Invalid phone number format", http.StatusBadRequest)
+		countryCode, err := loadPhoneCountryCode(db, ownerID)
+		if err != nil {
+			http.Error(w, "Failed to load salon settings", http.StatusInternalServerError)
+			return
+		}
+		phone, ok := normalizePhone(c.Phone, countryCode)
+		if !ok {
+			http.Error(w, "Invalid phone number: use international format (e.g. +447700900123) or set the salon's phone country code", http.StatusBadRequest)
 			return
 		}
+		c.Phone = phone
 	}
 	if c.Email != "" {
 		if _, err := mail.ParseAddress(c.Email); err != nil {
@@ -195,10 +200,10 @@ func APIAddCustomer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to encrypt email", http.StatusInternalServerError)
 		return
 	}
-	db := database.GetDB()
 	res, err := db.Exec(
-		"INSERT INTO customers (name, phone, email, birthday, anniversary, owner_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
-		c.Name, encryptedPhone, encryptedEmail, c.Birthday, c.Anniversary, userID, time.Now(),
+		"INSERT INTO customers (name, phone, email, phone_hash, email_hash, birthday, anniversary, owner_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		c.Name, encryptedPhone, encryptedEmail, customerContactHash("sms", c.Phone), customerContactHash("email", c.Email),
+		c.Birthday, c.Anniversary, ownerID, time.Now(),
 	)
 	if err != nil {
 		http.Error(w, "Failed to add customer", http.StatusInternalServerError)
@@ -212,12 +217,12 @@ func APIAddCustomer(w http.ResponseWriter, r *http.Request) {
 // --- API: Update Customer ---
 func APIUpdateCustomer(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(UserIDKey)
-	// var id int
+	var ownerID int
 	switch v := userID.(type) {
 	case int:
-		id = v
+		ownerID = v
 	case int64:
-		id = int(v)
+		ownerID = int(v)
 	default:
 		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
 		return
@@ -243,12 +248,19 @@ func APIUpdateCustomer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Name is required", http.StatusBadRequest)
 		return
 	}
+	db := database.GetDB()
 	if c.Phone != "" {
-		phoneRegex := regexp.MustCompile(`^[0-9 +()-]*$`)
-		if !phoneRegex.MatchString(c.Phone) {
-			http.Error(w, "Invalid phone number format", http.StatusBadRequest)
+		countryCode, err := loadPhoneCountryCode(db, ownerID)
+		if err != nil {
+			http.Error(w, "Failed to load salon settings", http.StatusInternalServerError)
 			return
 		}
+		phone, ok := normalizePhone(c.Phone, countryCode)
+		if !ok {
+			http.Error(w, "Invalid phone number: use international format (e.g. +447700900123) or set the salon's phone country code", http.StatusBadRequest)
+			return
+		}
+		c.Phone = phone
 	}
 	if c.Email != "" {
 		if _, err := mail.ParseAddress(c.Email); err != nil {
@@ -278,10 +290,10 @@ func APIUpdateCustomer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to encrypt email", http.StatusInternalServerError)
 		return
 	}
-	db := database.GetDB()
 	_, err = db.Exec(
-		"UPDATE customers SET name=?, phone=?, email=?, birthday=?, anniversary=?, updated_at=? WHERE id=? AND owner_id=?",
-		c.Name, encryptedPhone, encryptedEmail, c.Birthday, c.Anniversary, time.Now(), id, userID,
+		"UPDATE customers SET name=?, phone=?, email=?, phone_hash=?, email_hash=?, birthday=?, anniversary=?, updated_at=? WHERE id=? AND owner_id=?",
+		c.Name, encryptedPhone, encryptedEmail, customerContactHash("sms", c.Phone), customerContactHash("email", c.Email),
+		c.Birthday, c.Anniversary, time.Now(), id, ownerID,
 	)
 	if err != nil {
 		http.Error(w, "Failed to update customer", http.StatusInternalServerError)
@@ -293,12 +305,12 @@ func APIUpdateCustomer(w http.ResponseWriter, r *http.Request) {
 // --- API: Delete Customer ---
 func APIDeleteCustomer(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(UserIDKey)
-	// var id int
+	var ownerID int
 	switch v := userID.(type) {
 	case int:
-		id = v
+		ownerID = v
 	case int64:
-		id = int(v)
+		ownerID = int(v)
 	default:
 		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
 		return
@@ -310,7 +322,7 @@ func APIDeleteCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	db := database.GetDB()
-	_, err = db.Exec("DELETE FROM customers WHERE id = ? AND owner_id = ?", id, userID)
+	_, err = db.Exec("DELETE FROM customers WHERE id = ? AND owner_id = ?", id, ownerID)
 	if err != nil {
 		http.Error(w, "Failed to delete customer", http.StatusInternalServerError)
 		return
@@ -321,12 +333,12 @@ func APIDeleteCustomer(w http.ResponseWriter, r *http.Request) {
 // --- API: Get Single Customer ---
 func APIGetCustomer(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(UserIDKey)
-	// var id int
+	var ownerID int
 	switch v := userID.(type) {
 	case int:
-		id = v
+		ownerID = v
 	case int64:
-		id = int(v)
+		ownerID = int(v)
 	default:
 		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
 		return
@@ -339,18 +351,20 @@ func APIGetCustomer(w http.ResponseWriter, r *http.Request) {
 	}
 	db := database.GetDB()
 	var c struct {
-		ID          int    `json:"id"`
-		Name        string `json:"name"`
-		Phone       string `json:"phone"`
-		Email       string `json:"email"`
-		Birthday    string `json:"birthday,omitempty"`
-		Anniversary string `json:"anniversary,omitempty"`
+		ID          int               `json:"id"`
+		Name        string            `json:"name"`
+		Phone       string            `json:"phone"`
+		Email       string            `json:"email"`
+		Birthday    string            `json:"birthday,omitempty"`
+		Anniversary string            `json:"anniversary,omitempty"`
+		Packages    []customerPackage `json:"packages"`
+		Loyalty     *loyaltySummary   `json:"loyalty"`
 	}
 	var birthday, anniversary sql.NullString
 	var encryptedPhone, encryptedEmail []byte
 	err = db.QueryRow(
 		"SELECT id, name, phone, email, birthday, anniversary FROM customers WHERE id = ? AND owner_id = ?",
-		id, userID,
+		id, ownerID,
 	).Scan(&c.ID, &c.Name, &encryptedPhone, &encryptedEmail, &birthday, &anniversary)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -372,6 +386,14 @@ func APIGetCustomer(w http.ResponseWriter, r *http.Request) {
 	if anniversary.Valid {
 		c.Anniversary = anniversary.String
 	}
+	if c.Packages, err = loadCustomerPackages(db, ownerID, c.ID); err != nil {
+		http.Error(w, "Failed to fetch customer packages", http.StatusInternalServerError)
+		return
+	}
+	if c.Loyalty, err = loadLoyaltySummary(db, ownerID, c.ID, false); err != nil {
+		http.Error(w, "Failed to fetch loyalty points", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(c)
 }
@@ -4,6 +4,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"salon-management/internal/database"
 	// "salon-management/views"
@@ -38,12 +39,12 @@ import (
 // APIDashboardStats returns the dashboard statistics as JSON for API requests.
 func APIDashboardStats(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(UserIDKey)
-	var id int
+	var ownerID int
 	switch v := userID.(type) {
 	case int:
-		id = v
+		ownerID = v
 	case int64:
-		id = int(v)
+		ownerID = int(v)
 	default:
 		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
 		return
@@ -53,9 +54,15 @@ func APIDashboardStats(w http.ResponseWriter, r *http.Request) {
 	var totalCustomers, totalInvoices int
 	var totalRevenue float64
 
-	db.QueryRow("SELECT COUNT(*) FROM customers WHERE owner_id = ?", id).Scan(&totalCustomers)
-	db.QueryRow("SELECT COUNT(*) FROM invoices WHERE owner_id = ?", id).Scan(&totalInvoices)
-	db.QueryRow("SELECT SUM(total_amount) FROM invoices WHERE owner_id = ?", id).Scan(&totalRevenue)
+	db.QueryRow("SELECT COUNT(*) FROM customers WHERE owner_id = ?", ownerID).Scan(&totalCustomers)
+	db.QueryRow("SELECT COUNT(*) FROM invoices WHERE owner_id = ?", ownerID).Scan(&totalInvoices)
+	db.QueryRow("SELECT SUM(total_amount) FROM invoices WHERE owner_id = ?", ownerID).Scan(&totalRevenue)
+
+	lowStock, err := loadLowStockProducts(db, ownerID)
+	if err != nil {
+		log.Printf("Failed to load low stock products: %v", err)
+		lowStock = []product{}
+	}
 
 	// Dummy growth rate for now
 	resp := map[string]interface{}{
@@ -63,6 +70,7 @@ func APIDashboardStats(w http.ResponseWriter, r *http.Request) {
 		"totalInvoices":  totalInvoices,
 		"monthlyRevenue": totalRevenue,
 		"growthRate":     "23.5%",
+		"lowStockAlerts": lowStock,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
@@ -0,0 +1,641 @@
+// internal/handlers/feedback_handlers.go
+// Post-visit feedback: a signed survey link sent some time after a paid
+// invoice, 0-10 scores with comments, NPS and per-stylist reports, and
+// alerts to the owner about low scores.
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+	"salon-management/internal/reminders"
+)
+
+const (
+	// feedbackLinkTTL is how long a survey link can be answered.
+	feedbackLinkTTL = 30 * 24 * time.Hour
+	// feedbackQuietPeriod is the least time between two surveys sent to the
+	// same customer, so regulars aren't asked after every visit.
+	feedbackQuietPeriod = 30 * 24 * time.Hour
+	// feedbackCatchUp is how long after falling due a survey is still sent,
+	// e.g. after downtime. Older invoices are never surveyed.
+	feedbackCatchUp = 24 * time.Hour
+)
+
+// feedbackSignature is the HMAC of a feedback request ID that makes its link
+// unguessable without storing a token.
+func feedbackSignature(id int64) string {
+	mac := hmac.New(sha256.New, encryptionKey)
+	fmt.Fprintf(mac, "feedback:%d", id)
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// feedbackToken is the signed "{id}.{signature}" in a survey link.
+func feedbackToken(id int64) string {
+	return strconv.FormatInt(id, 10) + "." + feedbackSignature(id)
+}
+
+// parseFeedbackToken checks a survey link's signature and returns the
+// feedback request ID.
+func parseFeedbackToken(token string) (int64, bool) {
+	idPart, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, hmac.Equal([]byte(sig), []byte(feedbackSignature(id)))
+}
+
+// npsGroup buckets a 0-10 score the way Net Promoter Score does.
+func npsGroup(score int) string {
+	switch {
+	case score >= 9:
+		return "promoter"
+	case score >= 7:
+		return "passive"
+	}
+	return "detractor"
+}
+
+// npsScore is the percentage of promoters minus the percentage of
+// detractors, rounded to a whole number.
+func npsScore(promoters, detractors, responses int) int {
+	if responses == 0 {
+		return 0
+	}
+	return int(math.Round(float64(promoters-detractors) * 100 / float64(responses)))
+}
+
+// StartFeedbackRequests sends feedback surveys that have come due, every five
+// minutes.
+func StartFeedbackRequests(db *sql.DB) {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		sendFeedbackRequests(database.GetDB(), time.Now())
+		<-ticker.C
+	}
+}
+
+// sendFeedbackRequests surveys customers whose paid invoices for services
+// are older than the owner's delay. The survey is linked to the invoice and
+// to the stylist who did the largest service on it. Customers surveyed in
+// the last 30 days are skipped.
+func sendFeedbackRequests(db *sql.DB, now time.Time) {
+	rows, err := db.Query("SELECT id, COALESCE(feedback_delay_minutes, 120) FROM owners WHERE feedback_requests = 1")
+	if err != nil {
+		log.Printf("Error querying feedback settings: %v", err)
+		return
+	}
+	type ownerSettings struct{ id, delay int }
+	var owners []ownerSettings
+	for rows.Next() {
+		var o ownerSettings
+		if err := rows.Scan(&o.id, &o.delay); err == nil {
+			owners = append(owners, o)
+		}
+	}
+	rows.Close()
+
+	for _, o := range owners {
+		due := now.Add(-time.Duration(o.delay) * time.Minute)
+		rows, err := db.Query(`
+            SELECT i.id, i.customer_id, COALESCE(o.salon_name, ''), c.name, c.phone, c.email, `+optOutColumns+`,
+                (SELECT ii.staff_id FROM invoice_items ii
+                 WHERE ii.invoice_id = i.id AND ii.service_id IS NOT NULL AND ii.staff_id IS NOT NULL
+                 ORDER BY ii.line_total DESC LIMIT 1)
+            FROM invoices i
+            JOIN owners o ON i.owner_id = o.id
+            JOIN customers c ON i.customer_id = c.id
+            WHERE i.owner_id = ? AND i.payment_status = 'Paid' AND i.created_at <= ? AND i.created_at > ?
+                AND EXISTS (SELECT 1 FROM invoice_items ii WHERE ii.invoice_id = i.id AND ii.service_id IS NOT NULL)
+                AND NOT EXISTS (SELECT 1 FROM feedback_requests f WHERE f.invoice_id = i.id)
+            ORDER BY i.created_at`, o.id, due, due.Add(-feedbackCatchUp))
+		if err != nil {
+			log.Printf("Error querying invoices to survey: %v", err)
+			continue
+		}
+		type survey struct {
+			invoiceID, customerID int64
+			staffID               sql.NullInt64
+			notice                appointmentNotice
+		}
+		var surveys []survey
+		for rows.Next() {
+			var s survey
+			if err := rows.Scan(&s.invoiceID, &s.customerID, &s.notice.salonName, &s.notice.customerName,
+				&s.notice.phone, &s.notice.email, &s.notice.smsOptOut, &s.notice.emailOptOut, &s.staffID); err != nil {
+				log.Printf("Failed to scan invoice to survey: %v", err)
+				continue
+			}
+			surveys = append(surveys, s)
+		}
+		rows.Close()
+		for _, s := range surveys {
+			if err := sendFeedbackRequest(db, o.id, s.invoiceID, s.customerID, s.staffID, s.notice, now); err != nil {
+				log.Printf("Failed to send feedback request for invoice %d: %v", s.invoiceID, err)
+			}
+		}
+	}
+}
+
+// sendFeedbackRequest records and sends one survey. Failed sends are
+// recorded and not retried.
+func sendFeedbackRequest(db *sql.DB, ownerID int, invoiceID, customerID int64, staffID sql.NullInt64,
+	a appointmentNotice, now time.Time) error {
+	var recent int
+	if err := db.QueryRow(`
+        SELECT COUNT(*) FROM feedback_requests
+        WHERE customer_id = ? AND status IN ('sent', 'answered') AND created_at > ?`,
+		customerID, now.Add(-feedbackQuietPeriod)).Scan(&recent); err != nil {
+		return err
+	}
+	res, err := db.Exec(`
+        INSERT OR IGNORE INTO feedback_requests (owner_id, invoice_id, customer_id, staff_id, status, expires_at, created_at)
+        VALUES (?, ?, ?, ?, 'sending', ?, ?)`, ownerID, invoiceID, customerID, staffID, now.Add(feedbackLinkTTL), now)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil // already surveyed
+	}
+	id, _ := res.LastInsertId()
+
+	channel, to := a.contact()
+	status, errText := "sent", ""
+	switch {
+	case recent > 0:
+		channel, to = "", ""
+		status, errText = "skipped", "Customer was asked for feedback recently"
+	case to == "":
+		status, errText = "skipped", a.unreachable()
+	default:
+		var stylist string
+		if staffID.Valid {
+			db.QueryRow("SELECT name FROM staff WHERE id = ?", staffID.Int64).Scan(&stylist)
+		}
+		message := reminders.FillTemplate(loadReminderTemplate(db, ownerID, "feedback_request"), map[string]string{
+			"CustomerName": a.customerName,
+			"SalonName":    a.salonName,
+			"Stylist":      stylist,
+			"Link":         publicBaseURL() + "/feedback/" + feedbackToken(id),
+		})
+		if channel == "sms" {
+			err = reminders.SendSMS(to, message)
+		} else {
+			err = reminders.SendEmail(to, "How was your visit to "+a.salonName+"?", message)
+		}
+		if err != nil {
+			status, errText = "failed", err.Error()
+		}
+	}
+	_, err = db.Exec("UPDATE feedback_requests SET channel = ?, recipient = ?, recipient_hash = ?, status = ?, error = ? WHERE id = ?",
+		nullIfEmpty(channel), nullIfEmpty(maskContact(to)), nullIfEmpty(contactHashIfSet(to)), status, nullIfEmpty(errText), id)
+	return err
+}
+
+// alertLowFeedback emails the owner about a low score so they can follow up
+// with the customer, and records that the alert went out.
+func alertLowFeedback(db *sql.DB, id int64) {
+	var ownerEmail, customerName string
+	var salonName, stylist, invoiceNumber, comment sql.NullString
+	var score int
+	err := db.QueryRow(`
+        SELECT o.email, o.salon_name, c.name, st.name, i.invoice_number, f.score, f.comment
+        FROM feedback_requests f
+        JOIN owners o ON f.owner_id = o.id
+        JOIN customers c ON f.customer_id = c.id
+        JOIN invoices i ON f.invoice_id = i.id
+        LEFT JOIN staff st ON f.staff_id = st.id
+        WHERE f.id = ?`, id).Scan(&ownerEmail, &salonName, &customerName, &stylist, &invoiceNumber, &score, &comment)
+	if err != nil {
+		log.Printf("Failed to load feedback %d for alert: %v", id, err)
+		return
+	}
+	body := fmt.Sprintf("%s rated their visit %d out of 10.\n\nInvoice: %s\nStylist: %s\nComment: %s\n",
+		customerName, score, invoiceNumber.String, stylist.String, comment.String)
+	if comment.String == "" {
+		body = strings.Replace(body, "Comment: \n", "No comment was left.\n", 1)
+	}
+	subject := fmt.Sprintf("Low feedback score (%d/10) from %s", score, customerName)
+	if err := reminders.SendEmail(ownerEmail, subject, body); err != nil {
+		log.Printf("Failed to send low feedback alert for %d: %v", id, err)
+		return
+	}
+	db.Exec("UPDATE feedback_requests SET alerted_at = ? WHERE id = ?", time.Now(), id)
+}
+
+// --- Public: View Feedback Survey ---
+// The link in a feedback request. Shows what the visit was and whether it
+// has been rated.
+func ViewFeedbackSurvey(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseFeedbackToken(chi.URLParam(r, "token"))
+	if !ok {
+		http.Error(w, "This feedback link is invalid", http.StatusNotFound)
+		return
+	}
+	var salonName, stylist sql.NullString
+	var visitDate, status string
+	var score sql.NullInt64
+	var comment sql.NullString
+	var expiresAt time.Time
+	err := database.GetDB().QueryRow(`
+        SELECT o.salon_name, st.name, substr(i.invoice_date, 1, 10), f.status, f.score, f.comment, f.expires_at
+        FROM feedback_requests f
+        JOIN owners o ON f.owner_id = o.id
+        JOIN invoices i ON f.invoice_id = i.id
+        LEFT JOIN staff st ON f.staff_id = st.id
+        WHERE f.id = ?`, id).Scan(&salonName, &stylist, &visitDate, &status, &score, &comment, &expiresAt)
+	if err != nil {
+		http.Error(w, "This feedback link is invalid", http.StatusNotFound)
+		return
+	}
+	if status == "sent" && !time.Now().Before(expiresAt) {
+		status = "expired"
+	}
+	resp := map[string]interface{}{
+		"salon":      salonName.String,
+		"stylist":    firstName(stylist.String),
+		"visit_date": visitDate,
+		"status":     status,
+	}
+	if score.Valid {
+		resp["score"] = score.Int64
+		resp["comment"] = comment.String
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// --- Public: Submit Feedback ---
+// Body: {"score" 0-10, "comment"}. Each survey can be answered once.
+func SubmitFeedback(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseFeedbackToken(chi.URLParam(r, "token"))
+	if !ok {
+		http.Error(w, "This feedback link is invalid", http.StatusNotFound)
+		return
+	}
+	var req struct {
+		Score   *int   `json:"score"`
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if req.Score == nil || *req.Score < 0 || *req.Score > 10 {
+		http.Error(w, "Score must be from 0 to 10", http.StatusBadRequest)
+		return
+	}
+	if len(req.Comment) > 1000 {
+		http.Error(w, "Comment is too long (max 1000 characters)", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var status string
+	var expiresAt time.Time
+	var alertScore int
+	err := db.QueryRow(`
+        SELECT f.status, f.expires_at, COALESCE(o.feedback_alert_score, 6)
+        FROM feedback_requests f JOIN owners o ON f.owner_id = o.id WHERE f.id = ?`, id).Scan(&status, &expiresAt, &alertScore)
+	if err != nil {
+		http.Error(w, "This feedback link is invalid", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	switch {
+	case status == "answered":
+		http.Error(w, "Thanks, you've already rated this visit", http.StatusConflict)
+		return
+	case status != "sent" || !now.Before(expiresAt):
+		http.Error(w, "This feedback link has expired", http.StatusGone)
+		return
+	}
+	res, err := db.Exec(`
+        UPDATE feedback_requests SET status = 'answered', score = ?, comment = ?, responded_at = ?
+        WHERE id = ? AND status = 'sent'`, *req.Score, nullIfEmpty(req.Comment), now, id)
+	if err != nil {
+		http.Error(w, "Failed to save feedback", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Thanks, you've already rated this visit", http.StatusConflict)
+		return
+	}
+	if *req.Score <= alertScore {
+		go alertLowFeedback(db, id)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "answered"})
+}
+
+// --- API: Feedback Settings ---
+func APIGetFeedbackSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var settings struct {
+		Enabled      bool `json:"enabled"`
+		DelayMinutes int  `json:"delay_minutes"`
+		AlertScore   int  `json:"alert_score"`
+	}
+	err := database.GetDB().QueryRow(`
+        SELECT COALESCE(feedback_requests, 0), COALESCE(feedback_delay_minutes, 120), COALESCE(feedback_alert_score, 6)
+        FROM owners WHERE id = ?`, ownerID).Scan(&settings.Enabled, &settings.DelayMinutes, &settings.AlertScore)
+	if err != nil {
+		http.Error(w, "Failed to fetch feedback settings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// --- API: Update Feedback Settings ---
+// Body: {"enabled", "delay_minutes" after payment before asking, and
+// "alert_score", the score at or below which the owner is emailed; -1
+// turns alerts off}.
+func APIUpdateFeedbackSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		Enabled      bool `json:"enabled"`
+		DelayMinutes int  `json:"delay_minutes"`
+		AlertScore   int  `json:"alert_score"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.DelayMinutes < 15 || req.DelayMinutes > 7*24*60 {
+		http.Error(w, "Delay must be from 15 minutes to 7 days", http.StatusBadRequest)
+		return
+	}
+	if req.AlertScore < -1 || req.AlertScore > 10 {
+		http.Error(w, "Alert score must be from 0 to 10, or -1 for no alerts", http.StatusBadRequest)
+		return
+	}
+	_, err := database.GetDB().Exec(`
+        UPDATE owners SET feedback_requests = ?, feedback_delay_minutes = ?, feedback_alert_score = ?, updated_at = ?
+        WHERE id = ?`, req.Enabled, req.DelayMinutes, req.AlertScore, time.Now(), ownerID)
+	if err != nil {
+		http.Error(w, "Failed to save feedback settings", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: List Feedback ---
+// Lists answered surveys for visits between "start" and "end", newest
+// first, optionally for one "staff_id" or at or below "max_score".
+func APIGetFeedback(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	start, end, ok := reportDateRange(r)
+	if !ok {
+		http.Error(w, "Dates must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	query := `
+        SELECT f.id, f.invoice_id, COALESCE(i.invoice_number, ''), substr(i.invoice_date, 1, 10), f.customer_id, c.name,
+            f.staff_id, COALESCE(st.name, ''), f.score, COALESCE(f.comment, ''), f.responded_at
+        FROM feedback_requests f
+        JOIN invoices i ON f.invoice_id = i.id
+        JOIN customers c ON f.customer_id = c.id
+        LEFT JOIN staff st ON f.staff_id = st.id
+        WHERE f.owner_id = ? AND f.status = 'answered' AND i.invoice_date BETWEEN ? AND ?`
+	args := []interface{}{ownerID, start, end}
+	if s := r.URL.Query().Get("staff_id"); s != "" {
+		staffID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid staff ID", http.StatusBadRequest)
+			return
+		}
+		query += " AND f.staff_id = ?"
+		args = append(args, staffID)
+	}
+	if s := r.URL.Query().Get("max_score"); s != "" {
+		maxScore, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "Invalid max_score", http.StatusBadRequest)
+			return
+		}
+		query += " AND f.score <= ?"
+		args = append(args, maxScore)
+	}
+	rows, err := database.GetDB().Query(query+" ORDER BY f.responded_at DESC", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch feedback", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	type feedback struct {
+		ID            int64     `json:"id"`
+		InvoiceID     int64     `json:"invoice_id"`
+		InvoiceNumber string    `json:"invoice_number"`
+		VisitDate     string    `json:"visit_date"`
+		CustomerID    int64     `json:"customer_id"`
+		CustomerName  string    `json:"customer_name"`
+		StaffID       *int64    `json:"staff_id"`
+		StaffName     string    `json:"staff_name"`
+		Score         int       `json:"score"`
+		Group         string    `json:"group"`
+		Comment       string    `json:"comment"`
+		RespondedAt   time.Time `json:"responded_at"`
+	}
+	results := []feedback{}
+	for rows.Next() {
+		var f feedback
+		var staffID sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.InvoiceID, &f.InvoiceNumber, &f.VisitDate, &f.CustomerID, &f.CustomerName,
+			&staffID, &f.StaffName, &f.Score, &f.Comment, &f.RespondedAt); err != nil {
+			log.Printf("Failed to scan feedback: %v", err)
+			continue
+		}
+		if staffID.Valid {
+			f.StaffID = &staffID.Int64
+		}
+		f.Group = npsGroup(f.Score)
+		results = append(results, f)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// feedbackSummary counts survey responses for a report.
+type feedbackSummary struct {
+	Sent         int     `json:"sent"`
+	Responses    int     `json:"responses"`
+	ResponseRate float64 `json:"response_rate"`
+	Promoters    int     `json:"promoters"`
+	Passives     int     `json:"passives"`
+	Detractors   int     `json:"detractors"`
+	NPS          int     `json:"nps"`
+	AverageScore float64 `json:"average_score"`
+}
+
+// feedbackSummaryColumns aggregates feedback_requests f into the columns
+// read by scanFeedbackSummary.
+const feedbackSummaryColumns = `COALESCE(SUM(f.status IN ('sent', 'answered')), 0), COALESCE(SUM(f.status = 'answered'), 0),
+            COALESCE(SUM(f.score >= 9), 0), COALESCE(SUM(f.score BETWEEN 7 AND 8), 0),
+            COALESCE(SUM(f.score <= 6), 0), COALESCE(AVG(f.score), 0)`
+
+func scanFeedbackSummary(dest []interface{}, row rowScanner, s *feedbackSummary) error {
+	dest = append(dest, &s.Sent, &s.Responses, &s.Promoters, &s.Passives, &s.Detractors, &s.AverageScore)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	if s.Sent > 0 {
+		s.ResponseRate = round2(float64(s.Responses) * 100 / float64(s.Sent))
+	}
+	s.NPS = npsScore(s.Promoters, s.Detractors, s.Responses)
+	s.AverageScore = round2(s.AverageScore)
+	return nil
+}
+
+// --- API: NPS Report ---
+// Net Promoter Score for visits between "start" and "end": promoters score
+// 9-10, passives 7-8 and detractors 0-6.
+func APINPSReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	start, end, ok := reportDateRange(r)
+	if !ok {
+		http.Error(w, "Dates must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	var summary feedbackSummary
+	err := scanFeedbackSummary(nil, database.GetDB().QueryRow(`
+        SELECT `+feedbackSummaryColumns+`
+        FROM feedback_requests f JOIN invoices i ON f.invoice_id = i.id
+        WHERE f.owner_id = ? AND i.invoice_date BETWEEN ? AND ?`, ownerID, start, end), &summary)
+	if err != nil {
+		http.Error(w, "Failed to build NPS report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"start": start, "end": end, "summary": summary})
+}
+
+// --- API: Satisfaction by Stylist Report ---
+// Responses, average score and NPS per stylist for visits between "start"
+// and "end", lowest NPS first. Add format=csv to download it as a
+// spreadsheet.
+func APIStylistSatisfactionReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	start, end, ok := reportDateRange(r)
+	if !ok {
+		http.Error(w, "Dates must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	rows, err := database.GetDB().Query(`
+        SELECT st.id, st.name, `+feedbackSummaryColumns+`
+        FROM feedback_requests f
+        JOIN invoices i ON f.invoice_id = i.id
+        JOIN staff st ON f.staff_id = st.id
+        WHERE f.owner_id = ? AND i.invoice_date BETWEEN ? AND ?
+        GROUP BY st.id ORDER BY st.name`, ownerID, start, end)
+	if err != nil {
+		http.Error(w, "Failed to build satisfaction report", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	type stylistRow struct {
+		StaffID int64  `json:"staff_id"`
+		Name    string `json:"name"`
+		feedbackSummary
+	}
+	results := []stylistRow{}
+	for rows.Next() {
+		var row stylistRow
+		if err := scanFeedbackSummary([]interface{}{&row.StaffID, &row.Name}, rows, &row.feedbackSummary); err != nil {
+			log.Printf("Failed to scan satisfaction row: %v", err)
+			continue
+		}
+		results = append(results, row)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Responses > 0 && (results[j].Responses == 0 || results[i].NPS < results[j].NPS)
+	})
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=stylist_satisfaction_"+start+"_"+end+".csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"Stylist", "Surveys sent", "Responses", "Promoters", "Passives", "Detractors", "NPS", "Average score"})
+		for _, row := range results {
+			cw.Write([]string{row.Name, strconv.Itoa(row.Sent), strconv.Itoa(row.Responses), strconv.Itoa(row.Promoters),
+				strconv.Itoa(row.Passives), strconv.Itoa(row.Detractors), strconv.Itoa(row.NPS),
+				strconv.FormatFloat(row.AverageScore, 'f', 2, 64)})
+		}
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"start": start, "end": end, "stylists": results})
+}
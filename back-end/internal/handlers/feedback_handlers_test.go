@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeedbackToken(t *testing.T) {
+	token := feedbackToken(42)
+	if id, ok := parseFeedbackToken(token); !ok || id != 42 {
+		t.Errorf("parseFeedbackToken(%q) = %d, %v, want 42, true", token, id, ok)
+	}
+	_, sig, _ := strings.Cut(token, ".")
+	for _, bad := range []string{"43." + sig, "42." + strings.Repeat("0", len(sig)), "42", "-1." + sig, "x." + sig, ""} {
+		if _, ok := parseFeedbackToken(bad); ok {
+			t.Errorf("parseFeedbackToken(%q) accepted a forged token", bad)
+		}
+	}
+}
+
+func TestNPS(t *testing.T) {
+	groups := map[int]string{0: "detractor", 6: "detractor", 7: "passive", 8: "passive", 9: "promoter", 10: "promoter"}
+	for score, want := range groups {
+		if got := npsGroup(score); got != want {
+			t.Errorf("npsGroup(%d) = %q, want %q", score, got, want)
+		}
+	}
+	tests := []struct{ promoters, detractors, responses, want int }{
+		{0, 0, 0, 0},
+		{5, 2, 10, 30},
+		{1, 2, 3, -33},
+		{2, 1, 3, 33},
+		{4, 0, 4, 100},
+	}
+	for _, tt := range tests {
+		if got := npsScore(tt.promoters, tt.detractors, tt.responses); got != tt.want {
+			t.Errorf("npsScore(%d, %d, %d) = %d, want %d", tt.promoters, tt.detractors, tt.responses, got, tt.want)
+		}
+	}
+}
+
+func TestSendFeedbackRequests(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 11, 2, 15, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO owners (id, email, password_hash, feedback_requests, feedback_delay_minutes) VALUES (1, 'a@example.com', 'x', 1, 120)`,
+		`INSERT INTO customers (id, owner_id, name) VALUES (1, 1, 'No Contact'), (2, 1, 'Asked Recently')`,
+		`INSERT INTO staff (id, owner_id, name) VALUES (1, 1, 'Ann'), (2, 1, 'Bea')`,
+	)
+	for _, inv := range []struct {
+		id, customerID int
+		status         string
+		created        time.Time
+		service        bool
+	}{
+		{1, 1, "Paid", now.Add(-3 * time.Hour), true},
+		{2, 1, "Paid", now.Add(-time.Hour), true},
+		{3, 1, "Paid", now.Add(-3 * time.Hour), false},
+		{4, 1, "Unpaid", now.Add(-3 * time.Hour), true},
+		{5, 1, "Paid", now.Add(-30 * time.Hour), true},
+		{6, 2, "Paid", now.Add(-3 * time.Hour), true},
+	} {
+		if _, err := db.Exec(`INSERT INTO invoices (id, owner_id, customer_id, invoice_date, total_amount, payment_status, created_at)
+            VALUES (?, 1, ?, ?, 50, ?, ?)`, inv.id, inv.customerID, inv.created.Format("2006-01-02"), inv.status, inv.created); err != nil {
+			t.Fatal(err)
+		}
+		var serviceID interface{}
+		if inv.service {
+			serviceID = 1
+		}
+		// The survey names the stylist of the largest service.
+		if _, err := db.Exec(`INSERT INTO invoice_items (invoice_id, description, quantity, unit_price, line_total, service_id, staff_id)
+            VALUES (?, 'Retail', 1, 40, 40, NULL, 1), (?, 'Colour', 1, 30, 30, ?, 2)`, inv.id, inv.id, serviceID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO feedback_requests (owner_id, invoice_id, customer_id, status, expires_at, created_at)
+        VALUES (1, 99, 2, 'answered', ?, ?)`, now.Add(feedbackLinkTTL), now.Add(-10*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	sendFeedbackRequests(db, now)
+	rows, err := db.Query("SELECT invoice_id, staff_id, status, COALESCE(error, '') FROM feedback_requests WHERE invoice_id != 99 ORDER BY invoice_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var invoiceID, staffID int
+		var status, errText string
+		rows.Scan(&invoiceID, &staffID, &status, &errText)
+		got = append(got, strconv.Itoa(invoiceID)+" "+strconv.Itoa(staffID)+" "+status+": "+errText)
+	}
+	want := []string{
+		"1 2 skipped: Customer has no phone number or email",
+		"6 2 skipped: Customer was asked for feedback recently",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("feedback requests =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	sendFeedbackRequests(db, now.Add(5*time.Minute))
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM feedback_requests WHERE invoice_id = 1").Scan(&count)
+	if count != 1 {
+		t.Errorf("invoice 1 surveyed %d times, want once", count)
+	}
+}
+
+func TestSubmitFeedback(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	mustExec(t, db, `INSERT INTO owners (id, email, password_hash) VALUES (1, 'a@example.com', 'x')`)
+	for _, f := range []struct {
+		id      int
+		status  string
+		expires time.Time
+	}{
+		{1, "sent", now.Add(time.Hour)},
+		{2, "sent", now.Add(-time.Hour)},
+		{3, "skipped", now.Add(time.Hour)},
+	} {
+		if _, err := db.Exec(`INSERT INTO feedback_requests (id, owner_id, invoice_id, customer_id, status, expires_at, created_at)
+            VALUES (?, 1, ?, 1, ?, ?, ?)`, f.id, f.id, f.status, f.expires, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	submit := func(token, body string) int {
+		r := withURLParams(httptest.NewRequest("POST", "/feedback/"+token, strings.NewReader(body)), "token", token)
+		w := httptest.NewRecorder()
+		SubmitFeedback(w, r)
+		return w.Code
+	}
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{"forged link", "1.0000", `{"score": 9}`, http.StatusNotFound},
+		{"no score", feedbackToken(1), `{"comment": "Lovely"}`, http.StatusBadRequest},
+		{"score out of range", feedbackToken(1), `{"score": 11}`, http.StatusBadRequest},
+		{"answered", feedbackToken(1), `{"score": 9, "comment": " Lovely "}`, http.StatusOK},
+		{"answered twice", feedbackToken(1), `{"score": 10}`, http.StatusConflict},
+		{"expired", feedbackToken(2), `{"score": 9}`, http.StatusGone},
+		{"never sent", feedbackToken(3), `{"score": 9}`, http.StatusGone},
+	}
+	for _, tt := range tests {
+		if got := submit(tt.token, tt.body); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	var score int
+	var comment string
+	db.QueryRow("SELECT score, comment FROM feedback_requests WHERE id = 1").Scan(&score, &comment)
+	if score != 9 || comment != "Lovely" {
+		t.Errorf("saved %d %q, want 9 \"Lovely\"", score, comment)
+	}
+}
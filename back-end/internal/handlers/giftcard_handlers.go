@@ -0,0 +1,385 @@
+// internal/handlers/giftcard_handlers.go
+// Handlers for gift cards: selling them on invoices, redeeming them as a
+// payment method, balance lookups, transfers and the balance ledger.
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// Cards are valid for a year unless the sale says otherwise.
+const defaultGiftCardValidity = 1
+
+// Letters and digits that can't be confused when read aloud or typed.
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// giftCardSale is set on an invoice line that sells a gift card. The line's
+// unit price is the value loaded onto the card.
+type giftCardSale struct {
+	ExpiresOn  string `json:"expires_on,omitempty"`
+	CustomerID int64  `json:"customer_id,omitempty"` // holder, defaults to the buyer
+}
+
+type giftCardTransaction struct {
+	Kind         string  `json:"kind"`
+	Amount       float64 `json:"amount"`
+	BalanceAfter float64 `json:"balance_after"`
+	InvoiceID    *int64  `json:"invoice_id,omitempty"`
+	Note         string  `json:"note,omitempty"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+type giftCard struct {
+	ID            int64                 `json:"id"`
+	Code          string                `json:"code"`
+	InitialAmount float64               `json:"initial_amount"`
+	Balance       float64               `json:"balance"`
+	CustomerID    *int64                `json:"customer_id"`
+	ExpiresOn     string                `json:"expires_on,omitempty"`
+	Status        string                `json:"status"`
+	CreatedAt     string                `json:"created_at"`
+	Transactions  []giftCardTransaction `json:"transactions,omitempty"`
+}
+
+// newGiftCardCode returns a random code formatted as XXXX-XXXX-XXXX-XXXX.
+func newGiftCardCode() (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(giftCardAlphabet)))
+	for i := 0; i < 16; i++ {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(giftCardAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// normalizeGiftCardCode accepts codes typed with or without dashes, spaces
+// or lowercase letters.
+func normalizeGiftCardCode(code string) string {
+	var raw strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			raw.WriteRune(r)
+		}
+	}
+	s := raw.String()
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// maskGiftCardCode hides all but the last 4 characters of a gift card code,
+// so a receipt or payment record can't be used to spend the card.
+func maskGiftCardCode(code string) string {
+	raw := strings.ReplaceAll(normalizeGiftCardCode(code), "-", "")
+	if len(raw) > 4 {
+		raw = raw[len(raw)-4:]
+	}
+	return "****" + raw
+}
+
+// resolveGiftCardSale validates a gift card line. Gift cards are sold one per
+// line, are never taxed and default to a year's validity.
+func resolveGiftCardSale(q queryer, ownerID int, it *invoiceItemInput) error {
+	if it.Description == "" {
+		it.Description = "Gift card"
+	}
+	if len(it.Description) > 200 {
+		return invoiceInputError("Each item needs a description (max 200 characters)")
+	}
+	if it.Quantity != 1 {
+		return invoiceInputError("Gift cards are sold one per line")
+	}
+	if it.UnitPrice == nil || *it.UnitPrice <= 0 {
+		return invoiceInputError("Gift cards need a positive value")
+	}
+	it.ServiceID, it.TaxRateID = 0, 0
+	if it.GiftCard.ExpiresOn == "" {
+		it.GiftCard.ExpiresOn = time.Now().AddDate(defaultGiftCardValidity, 0, 0).Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", it.GiftCard.ExpiresOn); err != nil {
+		return invoiceInputError("Gift card expiry must be YYYY-MM-DD")
+	}
+	if it.GiftCard.CustomerID != 0 {
+		var count int
+		if err := q.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", it.GiftCard.CustomerID, ownerID).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return invoiceInputError("Gift card holder not found for this salon")
+		}
+	}
+	return nil
+}
+
+// issueGiftCard creates a card sold on invoiceID and records the issue in
+// the ledger. It returns the new card's code.
+func issueGiftCard(tx *sql.Tx, ownerID int, invoiceID int64, holderID int64, amount float64, expiresOn string, now time.Time) (string, error) {
+	code, err := newGiftCardCode()
+	if err != nil {
+		return "", err
+	}
+	res, err := tx.Exec(`
+        INSERT INTO gift_cards (owner_id, code, initial_amount, balance, customer_id, invoice_id, expires_on, status, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, 'active', ?, ?)`,
+		ownerID, code, amount, amount, holderID, invoiceID, expiresOn, now, now)
+	if err != nil {
+		return "", err
+	}
+	cardID, _ := res.LastInsertId()
+	_, err = tx.Exec(`
+        INSERT INTO gift_card_transactions (gift_card_id, kind, amount, balance_after, invoice_id, customer_id, created_at)
+        VALUES (?, 'issue', ?, ?, ?, ?, ?)`,
+		cardID, amount, amount, invoiceID, holderID, now)
+	return code, err
+}
+
+// redeemGiftCard takes amount off a card's balance as payment for invoiceID.
+func redeemGiftCard(tx *sql.Tx, ownerID int, code string, amount float64, invoiceID int64, customerID int, now time.Time) error {
+	var cardID int64
+	var balance float64
+	var expiresOn sql.NullString
+	var status string
+	err := tx.QueryRow(
+		"SELECT id, balance, date(expires_on), status FROM gift_cards WHERE code = ? AND owner_id = ?",
+		normalizeGiftCardCode(code), ownerID,
+	).Scan(&cardID, &balance, &expiresOn, &status)
+	if err == sql.ErrNoRows {
+		return invoiceInputError("Gift card " + code + " not found")
+	} else if err != nil {
+		return err
+	}
+	if status != "active" {
+		return invoiceInputError("Gift card " + code + " is " + status)
+	}
+	if expiresOn.String != "" && now.Format("2006-01-02") > expiresOn.String {
+		return invoiceInputError("Gift card " + code + " has expired")
+	}
+	if round2(balance) < round2(amount) {
+		return invoiceInputError("Gift card " + code + " only has " + formatMoney(balance) + " left")
+	}
+	newBalance := round2(balance - amount)
+	if _, err := tx.Exec("UPDATE gift_cards SET balance = ?, updated_at = ? WHERE id = ?", newBalance, now, cardID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO gift_card_transactions (gift_card_id, kind, amount, balance_after, invoice_id, customer_id, created_at)
+        VALUES (?, 'redeem', ?, ?, ?, ?, ?)`,
+		cardID, -amount, newBalance, invoiceID, customerID, now)
+	return err
+}
+
+// loadGiftCard reads a card and its ledger by code.
+func loadGiftCard(db *sql.DB, ownerID int, code string) (*giftCard, error) {
+	g := &giftCard{}
+	var customerID sql.NullInt64
+	var expiresOn sql.NullString
+	err := db.QueryRow(`
+        SELECT id, code, initial_amount, balance, customer_id, date(expires_on), status, created_at
+        FROM gift_cards WHERE code = ? AND owner_id = ?`, normalizeGiftCardCode(code), ownerID).Scan(
+		&g.ID, &g.Code, &g.InitialAmount, &g.Balance, &customerID, &expiresOn, &g.Status, &g.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if customerID.Valid {
+		g.CustomerID = &customerID.Int64
+	}
+	g.ExpiresOn = expiresOn.String
+	if g.Status == "active" && g.ExpiresOn != "" && time.Now().Format("2006-01-02") > g.ExpiresOn {
+		g.Status = "expired"
+	}
+
+	rows, err := db.Query(`
+        SELECT kind, amount, balance_after, invoice_id, note, created_at
+        FROM gift_card_transactions WHERE gift_card_id = ? ORDER BY id`, g.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t giftCardTransaction
+		var invoiceID sql.NullInt64
+		var note sql.NullString
+		if err := rows.Scan(&t.Kind, &t.Amount, &t.BalanceAfter, &invoiceID, &note, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		if invoiceID.Valid {
+			t.InvoiceID = &invoiceID.Int64
+		}
+		t.Note = note.String
+		g.Transactions = append(g.Transactions, t)
+	}
+	return g, rows.Err()
+}
+
+// --- API: List Gift Cards ---
+// Optional ?customer_id= limits the list to cards held by one customer.
+func APIGetGiftCards(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	query := `SELECT id, code, initial_amount, balance, customer_id, date(expires_on), status, created_at
+        FROM gift_cards WHERE owner_id = ?`
+	args := []interface{}{ownerID}
+	if c := r.URL.Query().Get("customer_id"); c != "" {
+		customerID, err := strconv.Atoi(c)
+		if err != nil {
+			http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+			return
+		}
+		query += " AND customer_id = ?"
+		args = append(args, customerID)
+	}
+	db := database.GetDB()
+	rows, err := db.Query(query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch gift cards", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	today := time.Now().Format("2006-01-02")
+	cards := []giftCard{}
+	for rows.Next() {
+		var g giftCard
+		var customerID sql.NullInt64
+		var expiresOn sql.NullString
+		if err := rows.Scan(&g.ID, &g.Code, &g.InitialAmount, &g.Balance, &customerID, &expiresOn, &g.Status, &g.CreatedAt); err != nil {
+			log.Printf("Failed to scan gift card: %v", err)
+			continue
+		}
+		if customerID.Valid {
+			g.CustomerID = &customerID.Int64
+		}
+		g.ExpiresOn = expiresOn.String
+		if g.Status == "active" && g.ExpiresOn != "" && today > g.ExpiresOn {
+			g.Status = "expired"
+		}
+		cards = append(cards, g)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cards)
+}
+
+// --- API: Gift Card Balance ---
+func APIGetGiftCard(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	g, err := loadGiftCard(database.GetDB(), ownerID, chi.URLParam(r, "code"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Gift card not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch gift card", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g)
+}
+
+// --- API: Transfer Gift Card ---
+// Body: {"customer_id": 12}. Moves the card (and its balance) to a customer
+// profile, e.g. when a gift recipient becomes a client.
+func APITransferGiftCard(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		CustomerID int `json:"customer_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CustomerID <= 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", req.CustomerID, ownerID).Scan(&count)
+	if count == 0 {
+		http.Error(w, "Customer not found for this salon", http.StatusBadRequest)
+		return
+	}
+	g, err := loadGiftCard(db, ownerID, chi.URLParam(r, "code"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Gift card not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch gift card", http.StatusInternalServerError)
+		}
+		return
+	}
+	if g.Status != "active" {
+		http.Error(w, "Only active gift cards can be transferred", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to transfer gift card", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	note := "Transferred to customer " + strconv.Itoa(req.CustomerID)
+	if g.CustomerID != nil {
+		note = "Transferred from customer " + strconv.FormatInt(*g.CustomerID, 10) + " to customer " + strconv.Itoa(req.CustomerID)
+	}
+	if _, err := tx.Exec("UPDATE gift_cards SET customer_id = ?, updated_at = ? WHERE id = ?", req.CustomerID, now, g.ID); err != nil {
+		http.Error(w, "Failed to transfer gift card", http.StatusInternalServerError)
+		return
+	}
+	_, err = tx.Exec(`
+        INSERT INTO gift_card_transactions (gift_card_id, kind, amount, balance_after, customer_id, note, created_at)
+        VALUES (?, 'transfer', 0, ?, ?, ?, ?)`,
+		g.ID, g.Balance, req.CustomerID, note, now)
+	if err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to transfer gift card", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
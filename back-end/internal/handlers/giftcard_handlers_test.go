@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestNewGiftCardCode(t *testing.T) {
+	format := regexp.MustCompile(`^[` + giftCardAlphabet + `]{4}(-[` + giftCardAlphabet + `]{4}){3}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		code, err := newGiftCardCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("newGiftCardCode() = %q", code)
+		}
+		if seen[code] {
+			t.Fatalf("newGiftCardCode() repeated %q", code)
+		}
+		seen[code] = true
+		if normalizeGiftCardCode(code) != code {
+			t.Errorf("normalizeGiftCardCode(%q) changed a valid code", code)
+		}
+	}
+}
+
+func TestNormalizeGiftCardCode(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"abcd-efgh-jkmn-pq23", "ABCD-EFGH-JKMN-PQ23"},
+		{"ABCDEFGHJKMNPQ23", "ABCD-EFGH-JKMN-PQ23"},
+		{" abcd efgh\tjkmn pq23 ", "ABCD-EFGH-JKMN-PQ23"},
+		{"ab-c", "ABC"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeGiftCardCode(tt.in); got != tt.want {
+			t.Errorf("normalizeGiftCardCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedeemGiftCard(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO gift_cards (id, owner_id, code, initial_amount, balance, expires_on, status, created_at, updated_at) VALUES
+			(1, 1, 'AAAA-BBBB-CCCC-DDDD', 50, 50, '2026-12-31', 'active', '2026-01-01', '2026-01-01'),
+			(2, 1, 'EEEE-FFFF-GGGG-HHHH', 50, 50, '2026-03-09', 'active', '2026-01-01', '2026-01-01'),
+			(3, 1, 'JJJJ-KKKK-LLLL-MMMM', 50, 50, NULL, 'void', '2026-01-01', '2026-01-01')`,
+	)
+
+	tests := []struct {
+		name        string
+		ownerID     int
+		code        string
+		amount      float64
+		wantErr     string
+		wantBalance float64
+	}{
+		{name: "partial redemption", ownerID: 1, code: "aaaa bbbb cccc dddd", amount: 20, wantBalance: 30},
+		{name: "whole balance", ownerID: 1, code: "AAAA-BBBB-CCCC-DDDD", amount: 50, wantBalance: 0},
+		{name: "more than the balance", ownerID: 1, code: "AAAA-BBBB-CCCC-DDDD", amount: 50.01,
+			wantErr: "Gift card AAAA-BBBB-CCCC-DDDD only has 50.00 left", wantBalance: 50},
+		{name: "another salon's card", ownerID: 2, code: "AAAA-BBBB-CCCC-DDDD", amount: 1,
+			wantErr: "Gift card AAAA-BBBB-CCCC-DDDD not found", wantBalance: 50},
+		{name: "expired", ownerID: 1, code: "EEEE-FFFF-GGGG-HHHH", amount: 1,
+			wantErr: "Gift card EEEE-FFFF-GGGG-HHHH has expired", wantBalance: 50},
+		{name: "voided", ownerID: 1, code: "JJJJ-KKKK-LLLL-MMMM", amount: 1,
+			wantErr: "Gift card JJJJ-KKKK-LLLL-MMMM is void", wantBalance: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			err = redeemGiftCard(tx, tt.ownerID, tt.code, tt.amount, 7, 3, now)
+			if tt.wantErr != "" {
+				if _, ok := err.(invoiceInputError); !ok || err.Error() != tt.wantErr {
+					t.Fatalf("redeemGiftCard() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("redeemGiftCard() error = %v", err)
+			}
+
+			var balance float64
+			code := normalizeGiftCardCode(tt.code)
+			if err := tx.QueryRow("SELECT balance FROM gift_cards WHERE code = ?", code).Scan(&balance); err != nil {
+				t.Fatal(err)
+			}
+			if balance != tt.wantBalance {
+				t.Errorf("balance = %v, want %v", balance, tt.wantBalance)
+			}
+			var ledger int
+			tx.QueryRow(`SELECT COUNT(*) FROM gift_card_transactions t JOIN gift_cards g ON g.id = t.gift_card_id
+				WHERE g.code = ? AND t.kind = 'redeem' AND t.amount = ? AND t.balance_after = ? AND t.invoice_id = 7`,
+				code, -tt.amount, tt.wantBalance).Scan(&ledger)
+			if want := map[bool]int{true: 0, false: 1}[tt.wantErr != ""]; ledger != want {
+				t.Errorf("%d redeem transactions recorded, want %d", ledger, want)
+			}
+		})
+	}
+}
+
+func TestIssueGiftCard(t *testing.T) {
+	db := openTestDB(t)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	code, err := issueGiftCard(tx, 1, 7, 3, 75, "2027-03-10", now)
+	if err != nil {
+		t.Fatalf("issueGiftCard() error = %v", err)
+	}
+	var balance float64
+	var owner, customer int
+	var status string
+	err = tx.QueryRow("SELECT owner_id, customer_id, balance, status FROM gift_cards WHERE code = ?", code).
+		Scan(&owner, &customer, &balance, &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != 1 || customer != 3 || balance != 75 || status != "active" {
+		t.Errorf("card = owner %d, customer %d, balance %v, %s", owner, customer, balance, status)
+	}
+	var kind string
+	var amount float64
+	tx.QueryRow("SELECT kind, amount FROM gift_card_transactions WHERE invoice_id = 7").Scan(&kind, &amount)
+	if kind != "issue" || amount != 75 {
+		t.Errorf("ledger = %s %v, want issue 75", kind, amount)
+	}
+}
+
+func TestMaskGiftCardCode(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"ABCD-EFGH-JKMN-PQ23", "****PQ23"},
+		{"abcd efgh jkmn pq23", "****PQ23"},
+		{"****PQ23", "****PQ23"},
+		{"AB", "****AB"},
+	}
+	for _, tt := range tests {
+		if got := maskGiftCardCode(tt.in); got != tt.want {
+			t.Errorf("maskGiftCardCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	pdf := renderInvoicePDF(&invoiceDetail{
+		Number: "INV-0001", Date: "2026-11-02", Status: "Paid", Total: 50,
+		Payments: []invoiceDetailPayment{{Method: "gift_card", Amount: 50, Reference: "ABCD-EFGH-JKMN-PQ23", PaidAt: "2026-11-02"}},
+	})
+	if bytes.Contains(pdf, []byte("JKMN")) || !bytes.Contains(pdf, []byte("****PQ23")) {
+		t.Error("receipt shows more of the gift card code than its last 4 characters")
+	}
+}
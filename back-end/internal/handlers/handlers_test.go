@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+func TestMain(m *testing.M) {
+	encryptionKey = bytes.Repeat([]byte{7}, 32)
+	database.DecryptField = decryptField
+	database.ContactHash = customerContactHash
+	os.Exit(m.Run())
+}
+
+// openTestDB creates a migrated database in a temporary directory and makes
+// it the one handlers use.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "salon.db") + "?_synchronous=OFF")
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// mustExec runs setup statements, failing the test on the first error.
+func mustExec(t *testing.T, db *sql.DB, stmts ...string) {
+	t.Helper()
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+}
+
+func price(v float64) *float64 { return &v }
+
+// asOwner returns r as sent by the signed-in owner ownerID.
+func asOwner(r *http.Request, ownerID int) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), UserIDKey, ownerID))
+}
+
+// withURLParams returns r with chi route parameters set, as the router would
+// for a matched route. params alternates keys and values.
+func withURLParams(r *http.Request, params ...string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for i := 0; i+1 < len(params); i += 2 {
+		rctx.URLParams.Add(params[i], params[i+1])
+	}
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
@@ -0,0 +1,272 @@
+// internal/handlers/invoice_delivery.go
+// Handlers for sending receipts to customers by email or SMS.
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+	"salon-management/internal/reminders"
+)
+
+// How long a secure receipt link stays valid.
+const receiptLinkTTL = 30 * 24 * time.Hour
+
+// publicBaseURL is the externally reachable address used in links sent to
+// customers.
+func publicBaseURL() string {
+	if u := os.Getenv("PUBLIC_BASE_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:8080"
+}
+
+// newToken returns a random hex token suitable for unguessable URLs.
+func newToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// maskContact hides most of a phone number or email address so delivery
+// history can be shown to staff without exposing the full contact.
+func maskContact(s string) string {
+	if at := strings.LastIndex(s, "@"); at > 0 {
+		return s[:1] + strings.Repeat("*", at-1) + s[at:]
+	}
+	if len(s) <= 4 {
+		return s
+	}
+	return strings.Repeat("*", len(s)-4) + s[len(s)-4:]
+}
+
+type invoiceDelivery struct {
+	ID        int64  `json:"id"`
+	Channel   string `json:"channel"`
+	Delivery  string `json:"delivery"`
+	Recipient string `json:"recipient"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	ExpiresAt string `json:"link_expires_at,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// --- API: Send Invoice ---
+// Body: {"channel": "email"|"sms", "delivery": "attachment"|"link"}.
+// SMS always sends a link; email defaults to attaching the PDF.
+func APISendInvoice(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	invoiceID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Channel  string `json:"channel"`
+		Delivery string `json:"delivery"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	switch req.Channel {
+	case "email":
+		if req.Delivery == "" {
+			req.Delivery = "attachment"
+		}
+	case "sms":
+		if req.Delivery == "" {
+			req.Delivery = "link"
+		}
+		if req.Delivery != "link" {
+			http.Error(w, "SMS receipts can only be sent as a link", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Channel must be email or sms", http.StatusBadRequest)
+		return
+	}
+	if req.Delivery != "attachment" && req.Delivery != "link" {
+		http.Error(w, "Delivery must be attachment or link", http.StatusBadRequest)
+		return
+	}
+
+	db := database.GetDB()
+	inv, err := loadInvoiceDetail(db, ownerID, invoiceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invoice not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch invoice", http.StatusInternalServerError)
+		}
+		return
+	}
+	recipient, missing := inv.CustomerEmail, "Customer has no email address on file"
+	if req.Channel == "sms" {
+		recipient, missing = inv.CustomerPhone, "Customer has no phone number on file"
+	}
+	if recipient == "" {
+		http.Error(w, missing, http.StatusBadRequest)
+		return
+	}
+
+	var token, link string
+	var expiresAt sql.NullTime
+	if req.Delivery == "link" {
+		token, err = newToken()
+		if err != nil {
+			http.Error(w, "Failed to create receipt link", http.StatusInternalServerError)
+			return
+		}
+		link = publicBaseURL() + "/receipts/" + token
+		expiresAt = sql.NullTime{Time: time.Now().Add(receiptLinkTTL), Valid: true}
+	}
+
+	summary := fmt.Sprintf("Hi %s, thank you for visiting %s. Your receipt %s for %s",
+		inv.CustomerName, inv.SalonName, inv.Number, formatMoney(inv.Total))
+	switch {
+	case req.Channel == "sms":
+		err = reminders.SendSMS(recipient, summary+": "+link)
+	case req.Delivery == "link":
+		err = reminders.SendEmail(recipient, "Your receipt from "+inv.SalonName,
+			summary+" is available here:\n\n"+link+"\n\nThe link expires in 30 days.\n")
+	default:
+		err = reminders.SendEmail(recipient, "Your receipt from "+inv.SalonName,
+			summary+" is attached.\n",
+			reminders.Attachment{Filename: inv.Number + ".pdf", ContentType: "application/pdf", Data: renderInvoicePDF(inv)})
+	}
+
+	d := invoiceDelivery{
+		Channel:   req.Channel,
+		Delivery:  req.Delivery,
+		Recipient: maskContact(recipient),
+		Status:    "sent",
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	if err != nil {
+		log.Printf("Failed to send invoice %d by %s: %v", invoiceID, req.Channel, err)
+		d.Status = "failed"
+		d.Error = err.Error()
+		// Don't leave a live link behind for a message that never arrived.
+		token, expiresAt = "", sql.NullTime{}
+	}
+	if expiresAt.Valid {
+		d.ExpiresAt = expiresAt.Time.Format(time.RFC3339)
+	}
+	var viewToken sql.NullString
+	if token != "" {
+		viewToken = sql.NullString{String: token, Valid: true}
+	}
+	res, dbErr := db.Exec(`
+        INSERT INTO invoice_deliveries (invoice_id, channel, delivery, recipient, view_token, expires_at, status, error, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		invoiceID, d.Channel, d.Delivery, d.Recipient, viewToken, expiresAt, d.Status, d.Error, time.Now())
+	if dbErr != nil {
+		log.Printf("Failed to record delivery of invoice %d: %v", invoiceID, dbErr)
+	} else {
+		d.ID, _ = res.LastInsertId()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(d)
+}
+
+// --- API: List Invoice Deliveries ---
+func APIGetInvoiceDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	invoiceID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query(`
+        SELECT d.id, d.channel, d.delivery, d.recipient, d.status, d.error, d.expires_at, d.created_at
+        FROM invoice_deliveries d
+        JOIN invoices i ON d.invoice_id = i.id
+        WHERE d.invoice_id = ? AND i.owner_id = ?
+        ORDER BY d.created_at DESC`, invoiceID, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch deliveries", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	deliveries := []invoiceDelivery{}
+	for rows.Next() {
+		var d invoiceDelivery
+		var recipient, errMsg, expiresAt sql.NullString
+		if err := rows.Scan(&d.ID, &d.Channel, &d.Delivery, &recipient, &d.Status, &errMsg, &expiresAt, &d.CreatedAt); err != nil {
+			log.Printf("Failed to scan delivery: %v", err)
+			continue
+		}
+		d.Recipient = recipient.String
+		d.Error = errMsg.String
+		d.ExpiresAt = expiresAt.String
+		deliveries = append(deliveries, d)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// ViewReceipt serves the PDF behind a secure receipt link. It is public; the
+// unguessable, expiring token is the only credential.
+func ViewReceipt(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	db := database.GetDB()
+	var invoiceID, ownerID int
+	err := db.QueryRow(`
+        SELECT i.id, i.owner_id
+        FROM invoice_deliveries d
+        JOIN invoices i ON d.invoice_id = i.id
+        WHERE d.view_token = ? AND d.expires_at > ?`, token, time.Now()).Scan(&invoiceID, &ownerID)
+	if err != nil {
+		http.Error(w, "This receipt link is invalid or has expired", http.StatusNotFound)
+		return
+	}
+	inv, err := loadInvoiceDetail(db, ownerID, invoiceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch invoice", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", inv.Number+".pdf"))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(renderInvoicePDF(inv))
+}
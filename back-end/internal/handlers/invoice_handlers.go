@@ -3,8 +3,13 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"salon-management/internal/database"
@@ -84,30 +89,89 @@ import (
 
 // 	views.NewInvoicePage(customers, services).Render(r.Context(), w)
 // }
-var id int
+
+// invoicePaymentInput is one entry of the JSON-encoded "payments" form field.
+type invoicePaymentInput struct {
+	Method    string  `json:"method"`
+	Amount    float64 `json:"amount"`
+	Reference string  `json:"reference"`
+}
+
+// invoiceTipInput is one entry of the JSON-encoded "tips" form field.
+type invoiceTipInput struct {
+	StaffID int64   `json:"staff_id"`
+	Amount  float64 `json:"amount"`
+}
+
+var validPaymentMethods = map[string]bool{
+	"cash":          true,
+	"card":          true,
+	"upi":           true,
+	"bank_transfer": true,
+	"gift_card":     true,
+	"points":        true,
+	"deposit":       true,
+	"other":         true,
+}
+
+// round2 rounds a currency amount to two decimal places.
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
 // CreateInvoice handles the submission of a new invoice.
+//
+// Line items may be sent as a JSON array in the "items" form field; the
+// subtotal, discount, tax and total are then calculated here. Each line is
+// taxed at its own (or its service's) tax rate, falling back to the
+// invoice-wide "tax" percentage, and "tax_inclusive" overrides the salon's
+// tax-inclusive pricing setting. A "promo_code" applies that promotion;
+// otherwise the best automatic promotion the customer qualifies for is used.
+//
+// Without items the older form is accepted: "total_amount" is the final
+// amount and the breakdown is derived from it. Payments may be sent in
+// "payments"; a Paid invoice without them is recorded as a single payment
+// using "payment_method" (cash by default). A "gift_card" payment carries the
+// card code as its reference and is taken off that card's balance; only the
+// code's last 4 characters are kept with the payment. Lines with
+// "gift_card" set sell a new card and lines with "package_id" sell a prepaid
+// package; both are only issued on Paid invoices. Members get their plan's
+// included services at zero price and its discount if that is higher than
+// "discount". Any other service invoiced at zero price uses a session from
+// the customer's package covering it, if there is one. A "points" payment is
+// paid for with the customer's loyalty points, and Paid invoices earn points
+// under the salon's loyalty rules.
+//
+// Each item may name the "staff_id" who performed it; "staff_id" on the form
+// is used for items that don't. Tips are sent in "tips" as a JSON array of
+// {staff_id, amount} and are paid on top of the invoice total.
 func CreateInvoice(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(UserIDKey)
-	// var id int
+	var ownerID int
 	switch v := userID.(type) {
 	case int:
-		id = v
+		ownerID = v
 	case int64:
-		id = int(v)
+		ownerID = int(v)
 	default:
 		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
 		return
 	}
 	db := database.GetDB()
 
-	// Simplified for this example. A real implementation would parse services,
-	// calculate totals dynamically, and handle discounts/taxes.
 	r.ParseForm()
 	customerIDStr := r.FormValue("customer_id")
 	totalAmountStr := r.FormValue("total_amount")
 	paymentStatus := r.FormValue("payment_status")
 	discountStr := r.FormValue("discount")
 	taxStr := r.FormValue("tax")
+	itemsJSON := r.FormValue("items")
+	paymentsJSON := r.FormValue("payments")
+	paymentMethod := strings.TrimSpace(r.FormValue("payment_method"))
+	taxInclusiveStr := r.FormValue("tax_inclusive")
+	promoCode := strings.TrimSpace(r.FormValue("promo_code"))
+	staffIDStr := r.FormValue("staff_id")
+	tipsJSON := r.FormValue("tips")
 
 	// --- Validation ---
 	customerID, err := strconv.Atoi(customerIDStr)
@@ -128,12 +192,6 @@ func CreateInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	totalAmount, err := strconv.ParseFloat(totalAmountStr, 64)
-	if err != nil || totalAmount <= 0 {
-		http.Error(w, "Invalid total amount", http.StatusBadRequest)
-		return
-	}
-
 	if paymentStatus != "Paid" && paymentStatus != "Unpaid" {
 		http.Error(w, "Invalid payment status", http.StatusBadRequest)
 		return
@@ -145,26 +203,380 @@ func CreateInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tax, err := strconv.ParseFloat(taxStr, 64)
-	if err != nil || tax < 0 || tax > 100 {
-		http.Error(w, "Invalid tax", http.StatusBadRequest)
+	var tax float64
+	if taxStr != "" {
+		tax, err = strconv.ParseFloat(taxStr, 64)
+		if err != nil || tax < 0 || tax > 100 {
+			http.Error(w, "Invalid tax", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var inclusive bool
+	if taxInclusiveStr == "" {
+		db.QueryRow("SELECT COALESCE(prices_include_tax, 0) FROM owners WHERE id = ?", userID).Scan(&inclusive)
+	} else {
+		inclusive = taxInclusiveStr == "true"
+	}
+
+	var defaultStaffID int64
+	if staffIDStr != "" {
+		defaultStaffID, err = strconv.ParseInt(staffIDStr, 10, 64)
+		if err != nil || defaultStaffID <= 0 {
+			http.Error(w, "Invalid staff ID", http.StatusBadRequest)
+			return
+		}
+		if err := lookupStaff(db, ownerID, defaultStaffID); err != nil {
+			http.Error(w, "Staff member not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var items []invoiceItemInput
+	if itemsJSON != "" {
+		if err := json.Unmarshal([]byte(itemsJSON), &items); err != nil {
+			http.Error(w, "Invalid items", http.StatusBadRequest)
+			return
+		}
+	}
+	for i := range items {
+		if items[i].StaffID == 0 {
+			items[i].StaffID = defaultStaffID
+		}
+	}
+	if err := resolveInvoiceItems(db, ownerID, items, tax); err != nil {
+		if _, ok := err.(invoiceInputError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to validate items", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var totals invoiceTotals
+	var promo *promotion
+	var promoAmount float64
+	if len(items) > 0 {
+		discount, err = applyMembershipBenefits(db, ownerID, customerID, items, discount, time.Now().Format("2006-01-02"))
+		if err != nil {
+			http.Error(w, "Failed to apply membership", http.StatusInternalServerError)
+			return
+		}
+		promo, promoAmount, err = selectPromotion(db, ownerID, customerID, promoCode, items, discount, time.Now())
+		if err != nil {
+			if _, ok := err.(invoiceInputError); ok {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, "Failed to apply promotion", http.StatusInternalServerError)
+			}
+			return
+		}
+		totals = priceInvoice(items, discount, inclusive)
+	} else if promoCode != "" {
+		http.Error(w, "Promotion codes need itemised invoices", http.StatusBadRequest)
+		return
+	} else {
+		totalAmount, err := strconv.ParseFloat(totalAmountStr, 64)
+		if err != nil || totalAmount <= 0 {
+			http.Error(w, "Invalid total amount", http.StatusBadRequest)
+			return
+		}
+		// The total already includes discount and tax; work backwards so the
+		// receipt can still show the breakdown.
+		inclusive = false
+		taxable := totalAmount / (1 + tax/100)
+		subtotal := taxable
+		if discount < 100 {
+			subtotal = taxable / (1 - discount/100)
+		}
+		subtotal = round2(subtotal)
+		totals = invoiceTotals{
+			Subtotal:       subtotal,
+			DiscountAmount: round2(subtotal - taxable),
+			TaxAmount:      round2(totalAmount - taxable),
+			Total:          totalAmount,
+		}
+		if totals.TaxAmount > 0 {
+			totals.Taxes = []invoiceTax{{Name: "Tax", Rate: tax, Taxable: round2(taxable), Amount: totals.TaxAmount}}
+		}
+		items = []invoiceItemInput{{Description: "Salon services", Quantity: 1, UnitPrice: &subtotal,
+			StaffID: defaultStaffID, lineTotal: subtotal, netAmount: round2(taxable)}}
+	}
+	totalAmount := totals.Total
+	for _, it := range items {
+		if it.GiftCard != nil && paymentStatus != "Paid" {
+			http.Error(w, "Gift cards can only be sold on paid invoices", http.StatusBadRequest)
+			return
+		}
+		if it.pkg != nil && paymentStatus != "Paid" {
+			http.Error(w, "Packages can only be sold on paid invoices", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var tips []invoiceTipInput
+	if tipsJSON != "" {
+		if err := json.Unmarshal([]byte(tipsJSON), &tips); err != nil {
+			http.Error(w, "Invalid tips", http.StatusBadRequest)
+			return
+		}
+	}
+	var tipAmount float64
+	for _, t := range tips {
+		if t.Amount <= 0 {
+			http.Error(w, "Tip amount must be positive", http.StatusBadRequest)
+			return
+		}
+		if err := lookupStaff(db, ownerID, t.StaffID); err != nil {
+			http.Error(w, "Staff member not found for tip", http.StatusBadRequest)
+			return
+		}
+		tipAmount += t.Amount
+	}
+	tipAmount = round2(tipAmount)
+
+	var payments []invoicePaymentInput
+	if paymentsJSON != "" {
+		if err := json.Unmarshal([]byte(paymentsJSON), &payments); err != nil {
+			http.Error(w, "Invalid payments", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(payments) == 0 && paymentStatus == "Paid" && totalAmount+tipAmount > 0 {
+		if paymentMethod == "" {
+			paymentMethod = "cash"
+		}
+		payments = []invoicePaymentInput{{Method: paymentMethod, Amount: round2(totalAmount + tipAmount)}}
+	}
+	var paid float64
+	for i := range payments {
+		payments[i].Method = strings.ToLower(strings.TrimSpace(payments[i].Method))
+		if !validPaymentMethods[payments[i].Method] {
+			http.Error(w, "Invalid payment method", http.StatusBadRequest)
+			return
+		}
+		if payments[i].Amount <= 0 {
+			http.Error(w, "Payment amount must be positive", http.StatusBadRequest)
+			return
+		}
+		if payments[i].Method == "gift_card" && strings.TrimSpace(payments[i].Reference) == "" {
+			http.Error(w, "Gift card payments need the card code as reference", http.StatusBadRequest)
+			return
+		}
+		if payments[i].Method == "deposit" && strings.TrimSpace(payments[i].Reference) == "" {
+			http.Error(w, "Deposit payments need the appointment ID as reference", http.StatusBadRequest)
+			return
+		}
+		paid += payments[i].Amount
+	}
+	if round2(paid) > round2(totalAmount+tipAmount) {
+		http.Error(w, "Payments exceed the invoice total", http.StatusBadRequest)
 		return
 	}
 
 	// --- Database Insertion ---
-	_, err = db.Exec(`
-        INSERT INTO invoices (owner_id, customer_id, invoice_date, total_amount, discount, tax, payment_status, created_at, updated_at)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		userID, customerID, time.Now().Format("2006-01-02"), totalAmount, discount, tax, paymentStatus, time.Now(), time.Now())
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to create invoice", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
 
+	now := time.Now()
+	res, err := tx.Exec(`
+        INSERT INTO invoices (owner_id, customer_id, invoice_date, total_amount, discount, tax, payment_status,
+            subtotal, discount_amount, tax_amount, tax_inclusive, tip_amount, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, customerID, now.Format("2006-01-02"), totalAmount, discount, tax, paymentStatus,
+		totals.Subtotal, totals.DiscountAmount, totals.TaxAmount, inclusive, tipAmount, now, now)
 	if err != nil {
 		http.Error(w, "Failed to create invoice", http.StatusInternalServerError)
 		return
 	}
+	invoiceID, _ := res.LastInsertId()
+	invoiceNumber := fmt.Sprintf("INV-%04d", invoiceID)
+	if _, err := tx.Exec("UPDATE invoices SET invoice_number = ? WHERE id = ?", invoiceNumber, invoiceID); err != nil {
+		http.Error(w, "Failed to create invoice", http.StatusInternalServerError)
+		return
+	}
+
+	// Credit packages sold on this invoice first so a session can be used
+	// straight away.
+	for _, it := range items {
+		if it.pkg != nil {
+			if err := issueCustomerPackage(tx, ownerID, customerID, invoiceID, it.pkg, now); err != nil {
+				http.Error(w, "Failed to issue package", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	for _, it := range items {
+		var serviceID, productID, customerPackageID, staffID sql.NullInt64
+		if it.StaffID != 0 {
+			staffID = sql.NullInt64{Int64: it.StaffID, Valid: true}
+		}
+		if it.ServiceID != 0 {
+			serviceID = sql.NullInt64{Int64: it.ServiceID, Valid: true}
+			if *it.UnitPrice == 0 && !it.membershipID.Valid && it.Quantity >= 1 && it.Quantity == math.Trunc(it.Quantity) {
+				customerPackageID, err = redeemCustomerPackage(tx, ownerID, customerID, it.ServiceID, int(it.Quantity), invoiceID, now)
+				if err != nil {
+					http.Error(w, "Failed to redeem package", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		if it.product != nil {
+			productID = sql.NullInt64{Int64: it.product.ID, Valid: true}
+			_, err := moveStock(tx, it.product.ID, -int(it.Quantity), "sale", "", sql.NullInt64{Int64: invoiceID, Valid: true}, "", now)
+			if err != nil {
+				if _, ok := err.(invoiceInputError); ok {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				} else {
+					http.Error(w, "Failed to update stock", http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+		res, err := tx.Exec(`
+            INSERT INTO invoice_items (invoice_id, service_id, product_id, description, quantity, unit_price, line_total,
+                tax_rate_id, tax_name, tax_rate, tax_amount, customer_package_id, membership_id, staff_id, net_amount)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			invoiceID, serviceID, productID, it.Description, it.Quantity, *it.UnitPrice, it.lineTotal,
+			it.taxRateID, it.taxName, it.taxRate, it.taxAmount, customerPackageID, it.membershipID, staffID, it.netAmount)
+		if err != nil {
+			http.Error(w, "Failed to save invoice items", http.StatusInternalServerError)
+			return
+		}
+		itemID, _ := res.LastInsertId()
+		if err := recordItemUsage(tx, invoiceID, itemID, it.usage, now); err != nil {
+			http.Error(w, "Failed to record product usage", http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, t := range totals.Taxes {
+		_, err := tx.Exec(`
+            INSERT INTO invoice_taxes (invoice_id, tax_rate_id, name, rate, taxable_amount, tax_amount)
+            VALUES (?, ?, ?, ?, ?, ?)`,
+			invoiceID, t.TaxRateID, t.Name, t.Rate, t.Taxable, t.Amount)
+		if err != nil {
+			http.Error(w, "Failed to save invoice taxes", http.StatusInternalServerError)
+			return
+		}
+	}
+	if promo != nil {
+		if err := recordInvoicePromotion(tx, invoiceID, customerID, promo, promoAmount, now); err != nil {
+			if _, ok := err.(invoiceInputError); ok {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, "Failed to record promotion", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	for _, t := range tips {
+		_, err := tx.Exec("INSERT INTO invoice_tips (invoice_id, staff_id, amount, created_at) VALUES (?, ?, ?, ?)",
+			invoiceID, t.StaffID, round2(t.Amount), now)
+		if err != nil {
+			http.Error(w, "Failed to save tips", http.StatusInternalServerError)
+			return
+		}
+	}
+	var giftCards []string
+	for _, it := range items {
+		if it.GiftCard == nil {
+			continue
+		}
+		holderID := it.GiftCard.CustomerID
+		if holderID == 0 {
+			holderID = int64(customerID)
+		}
+		code, err := issueGiftCard(tx, ownerID, invoiceID, holderID, *it.UnitPrice, it.GiftCard.ExpiresOn, now)
+		if err != nil {
+			http.Error(w, "Failed to issue gift card", http.StatusInternalServerError)
+			return
+		}
+		giftCards = append(giftCards, code)
+	}
+	var pointsPaid float64
+	for _, p := range payments {
+		if p.Method == "gift_card" {
+			p.Reference = normalizeGiftCardCode(p.Reference)
+			if err := redeemGiftCard(tx, ownerID, p.Reference, round2(p.Amount), invoiceID, customerID, now); err != nil {
+				if _, ok := err.(invoiceInputError); ok {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				} else {
+					http.Error(w, "Failed to redeem gift card", http.StatusInternalServerError)
+				}
+				return
+			}
+			p.Reference = maskGiftCardCode(p.Reference)
+		}
+		if p.Method == "points" {
+			points, err := redeemLoyaltyPoints(tx, ownerID, customerID, round2(p.Amount), invoiceID, now)
+			if err != nil {
+				if _, ok := err.(invoiceInputError); ok {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				} else {
+					http.Error(w, "Failed to redeem loyalty points", http.StatusInternalServerError)
+				}
+				return
+			}
+			p.Reference = fmt.Sprintf("%d points", points)
+			pointsPaid += p.Amount
+		}
+		if p.Method == "deposit" {
+			if err := redeemAppointmentDeposit(tx, ownerID, customerID, p.Reference, round2(p.Amount)); err != nil {
+				if _, ok := err.(invoiceInputError); ok {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				} else {
+					http.Error(w, "Failed to apply deposit", http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+		_, err := tx.Exec(`
+            INSERT INTO invoice_payments (invoice_id, method, amount, reference, paid_at)
+            VALUES (?, ?, ?, ?, ?)`,
+			invoiceID, p.Method, round2(p.Amount), p.Reference, now)
+		if err != nil {
+			http.Error(w, "Failed to save invoice payments", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Points are earned on what the customer spent, not on gift cards they
+	// bought or on the part paid with points.
+	if paymentStatus == "Paid" {
+		spend := totalAmount - pointsPaid
+		for _, it := range items {
+			if it.GiftCard != nil {
+				spend -= it.lineTotal
+			}
+		}
+		if err := earnLoyaltyPoints(tx, ownerID, customerID, invoiceID, items, spend, now); err != nil {
+			http.Error(w, "Failed to record loyalty points", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create invoice", http.StatusInternalServerError)
+		return
+	}
 
 	// Redirect to the invoices list
 	w.Header().Set("HX-Redirect", "/invoices")
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	resp := map[string]interface{}{
+		"id":             invoiceID,
+		"invoice_number": invoiceNumber,
+		"total_amount":   totalAmount,
+	}
+	if len(giftCards) > 0 {
+		resp["gift_cards"] = giftCards
+	}
+	json.NewEncoder(w).Encode(resp)
 }
 
 // GetInvoiceDetails displays details for a single invoice.
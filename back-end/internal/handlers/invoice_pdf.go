@@ -0,0 +1,448 @@
+// internal/handlers/invoice_pdf.go
+// Printable PDF receipts for invoices.
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+	"salon-management/internal/pdf"
+)
+
+type invoiceDetailItem struct {
+	Description string
+	Quantity    float64
+	UnitPrice   float64
+	LineTotal   float64
+}
+
+type invoiceDetailTax struct {
+	Name    string
+	Rate    float64
+	Taxable float64
+	Amount  float64
+}
+
+type invoiceDetailPromotion struct {
+	Name   string
+	Code   string
+	Amount float64
+}
+
+type invoiceDetailPayment struct {
+	Method    string
+	Amount    float64
+	Reference string
+	PaidAt    string
+}
+
+// invoiceDetail is everything printed on a receipt.
+type invoiceDetail struct {
+	ID              int64
+	Number          string
+	Date            string
+	Status          string
+	Subtotal        float64
+	DiscountPercent float64
+	DiscountAmount  float64
+	TaxPercent      float64
+	TaxAmount       float64
+	TaxInclusive    bool
+	Total           float64
+	TipAmount       float64
+
+	SalonName    string
+	SalonAddress string
+	SalonPhone   string
+	SalonEmail   string
+	Logo         []byte
+	Footer       string
+
+	CustomerID    int64
+	CustomerName  string
+	CustomerPhone string
+	CustomerEmail string
+
+	Items      []invoiceDetailItem
+	Taxes      []invoiceDetailTax
+	Promotions []invoiceDetailPromotion
+	Payments   []invoiceDetailPayment
+}
+
+// AmountPaid sums the recorded payments.
+func (d *invoiceDetail) AmountPaid() float64 {
+	var paid float64
+	for _, p := range d.Payments {
+		paid += p.Amount
+	}
+	return round2(paid)
+}
+
+// loadInvoiceDetail reads an invoice with its salon, customer, items and
+// payments. It returns sql.ErrNoRows if the invoice doesn't belong to ownerID.
+func loadInvoiceDetail(db *sql.DB, ownerID, invoiceID int) (*invoiceDetail, error) {
+	d := &invoiceDetail{}
+	var number, salonName, address, phone, footer sql.NullString
+	var subtotal, discountAmount, taxAmount sql.NullFloat64
+	var encryptedPhone, encryptedEmail []byte
+	err := db.QueryRow(`
+        SELECT i.id, i.invoice_number, i.invoice_date, i.payment_status, i.subtotal, i.discount, i.discount_amount,
+            i.tax, i.tax_amount, COALESCE(i.tax_inclusive, 0), i.total_amount, COALESCE(i.tip_amount, 0),
+            o.salon_name, o.address, o.phone, o.email, o.logo, o.invoice_footer,
+            c.id, c.name, c.phone, c.email
+        FROM invoices i
+        JOIN owners o ON i.owner_id = o.id
+        JOIN customers c ON i.customer_id = c.id
+        WHERE i.id = ? AND i.owner_id = ?`, invoiceID, ownerID).Scan(
+		&d.ID, &number, &d.Date, &d.Status, &subtotal, &d.DiscountPercent, &discountAmount,
+		&d.TaxPercent, &taxAmount, &d.TaxInclusive, &d.Total, &d.TipAmount,
+		&salonName, &address, &phone, &d.SalonEmail, &d.Logo, &footer,
+		&d.CustomerID, &d.CustomerName, &encryptedPhone, &encryptedEmail)
+	if err != nil {
+		return nil, err
+	}
+	d.Number = number.String
+	if d.Number == "" {
+		d.Number = fmt.Sprintf("INV-%04d", d.ID)
+	}
+	if len(d.Date) > 10 {
+		d.Date = d.Date[:10]
+	}
+	d.Subtotal = subtotal.Float64
+	d.DiscountAmount = discountAmount.Float64
+	d.TaxAmount = taxAmount.Float64
+	d.SalonName = salonName.String
+	d.SalonAddress = address.String
+	d.SalonPhone = phone.String
+	d.Footer = footer.String
+	if v, err := decryptField(encryptedPhone); err == nil {
+		d.CustomerPhone = v
+	}
+	if v, err := decryptField(encryptedEmail); err == nil {
+		d.CustomerEmail = v
+	}
+
+	// Sessions paid for by a prepaid package say so on the receipt.
+	rows, err := db.Query(`
+        SELECT CASE WHEN cp.id IS NULL THEN ii.description ELSE ii.description || ' (' || cp.name || ')' END,
+            ii.quantity, ii.unit_price, ii.line_total
+        FROM invoice_items ii
+        LEFT JOIN customer_packages cp ON ii.customer_package_id = cp.id
+        WHERE ii.invoice_id = ? ORDER BY ii.id`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var it invoiceDetailItem
+		if err := rows.Scan(&it.Description, &it.Quantity, &it.UnitPrice, &it.LineTotal); err != nil {
+			return nil, err
+		}
+		d.Items = append(d.Items, it)
+	}
+
+	trows, err := db.Query(`
+        SELECT name, rate, taxable_amount, tax_amount
+        FROM invoice_taxes WHERE invoice_id = ? ORDER BY rate, name`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer trows.Close()
+	for trows.Next() {
+		var t invoiceDetailTax
+		if err := trows.Scan(&t.Name, &t.Rate, &t.Taxable, &t.Amount); err != nil {
+			return nil, err
+		}
+		d.Taxes = append(d.Taxes, t)
+	}
+
+	mrows, err := db.Query(`
+        SELECT name, code, discount_amount
+        FROM invoice_promotions WHERE invoice_id = ? ORDER BY id`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer mrows.Close()
+	for mrows.Next() {
+		var m invoiceDetailPromotion
+		var code sql.NullString
+		if err := mrows.Scan(&m.Name, &code, &m.Amount); err != nil {
+			return nil, err
+		}
+		m.Code = code.String
+		d.Promotions = append(d.Promotions, m)
+	}
+
+	prows, err := db.Query(`
+        SELECT method, amount, reference, paid_at
+        FROM invoice_payments WHERE invoice_id = ? ORDER BY id`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer prows.Close()
+	for prows.Next() {
+		var p invoiceDetailPayment
+		var reference, paidAt sql.NullString
+		if err := prows.Scan(&p.Method, &p.Amount, &reference, &paidAt); err != nil {
+			return nil, err
+		}
+		p.Reference = reference.String
+		p.PaidAt = paidAt.String
+		if len(p.PaidAt) > 10 {
+			p.PaidAt = p.PaidAt[:10]
+		}
+		d.Payments = append(d.Payments, p)
+	}
+
+	// Invoices created before line items were stored only have a total.
+	if len(d.Items) == 0 {
+		d.Items = []invoiceDetailItem{{Description: "Salon services", Quantity: 1, UnitPrice: d.Total, LineTotal: d.Total}}
+		if d.Subtotal == 0 {
+			d.Subtotal = d.Total
+		}
+	}
+	return d, nil
+}
+
+// --- API: Invoice PDF ---
+func APIInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	invoiceID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+	inv, err := loadInvoiceDetail(database.GetDB(), ownerID, invoiceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invoice not found", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to load invoice %d: %v", invoiceID, err)
+			http.Error(w, "Failed to fetch invoice", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", inv.Number+".pdf"))
+	w.Write(renderInvoicePDF(inv))
+}
+
+func formatMoney(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func formatQuantity(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// fitText shortens s with an ellipsis so it fits in width at the current font.
+func fitText(doc *pdf.Document, s string, width float64) string {
+	if doc.StringWidth(s) <= width {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && doc.StringWidth(string(r)+"...") > width {
+		r = r[:len(r)-1]
+	}
+	return string(r) + "..."
+}
+
+// renderInvoicePDF lays out a one-column A4 receipt.
+func renderInvoicePDF(inv *invoiceDetail) []byte {
+	const (
+		left   = 50.0
+		right  = pdf.PageWidth - 50
+		bottom = pdf.PageHeight - 90
+	)
+	doc := pdf.New()
+
+	// Header: logo and salon details on the left, document title on the right.
+	y := 50.0
+	textX := left
+	if len(inv.Logo) > 0 {
+		if w, _, err := doc.Image(inv.Logo, left, y, 120, 60); err == nil {
+			textX = left + w + 12
+		} else {
+			log.Printf("Skipping unreadable logo on invoice %d: %v", inv.ID, err)
+		}
+	}
+	doc.SetFont(true, 16)
+	doc.Text(textX, y+16, fitText(doc, inv.SalonName, 300))
+	doc.SetFont(false, 9)
+	line := y + 30
+	for _, s := range []string{inv.SalonAddress, inv.SalonPhone, inv.SalonEmail} {
+		if s != "" {
+			doc.Text(textX, line, fitText(doc, s, 300))
+			line += 12
+		}
+	}
+
+	title := "INVOICE"
+	if inv.Status == "Paid" {
+		title = "RECEIPT"
+	}
+	doc.SetFont(true, 20)
+	doc.TextRight(right, y+16, title)
+	doc.SetFont(false, 9)
+	doc.TextRight(right, y+32, "Invoice no: "+inv.Number)
+	doc.TextRight(right, y+44, "Date: "+inv.Date)
+	doc.TextRight(right, y+56, "Status: "+inv.Status)
+
+	// Customer block.
+	y = 140
+	if line > y {
+		y = line + 10
+	}
+	doc.SetFont(true, 10)
+	doc.Text(left, y, "Bill to")
+	doc.SetFont(false, 10)
+	y += 14
+	doc.Text(left, y, inv.CustomerName)
+	for _, s := range []string{inv.CustomerPhone, inv.CustomerEmail} {
+		if s != "" {
+			y += 12
+			doc.Text(left, y, s)
+		}
+	}
+
+	// Line items.
+	const (
+		colQty   = 360.0
+		colPrice = 450.0
+	)
+	tableHeader := func() {
+		doc.FillRect(left, y, right-left, 18, 0.9)
+		doc.SetFont(true, 9)
+		doc.Text(left+6, y+12, "Description")
+		doc.TextRight(colQty, y+12, "Qty")
+		doc.TextRight(colPrice, y+12, "Unit price")
+		doc.TextRight(right-6, y+12, "Amount")
+		doc.SetFont(false, 9)
+		y += 32
+	}
+	y += 24
+	tableHeader()
+	for _, it := range inv.Items {
+		if y > bottom {
+			doc.AddPage()
+			y = 50
+			tableHeader()
+		}
+		doc.Text(left+6, y, fitText(doc, it.Description, colQty-left-50))
+		doc.TextRight(colQty, y, formatQuantity(it.Quantity))
+		doc.TextRight(colPrice, y, formatMoney(it.UnitPrice))
+		doc.TextRight(right-6, y, formatMoney(it.LineTotal))
+		y += 16
+	}
+	doc.Line(left, y-8, right, y-8)
+
+	// Totals.
+	totals := [][2]string{{"Subtotal", formatMoney(inv.Subtotal)}}
+	manualDiscount := inv.DiscountAmount
+	for _, m := range inv.Promotions {
+		manualDiscount -= m.Amount
+	}
+	if manualDiscount = round2(manualDiscount); manualDiscount > 0 {
+		totals = append(totals, [2]string{fmt.Sprintf("Discount (%s%%)", formatQuantity(inv.DiscountPercent)), "-" + formatMoney(manualDiscount)})
+	}
+	for _, m := range inv.Promotions {
+		label := m.Name
+		if m.Code != "" {
+			label += " (" + m.Code + ")"
+		}
+		totals = append(totals, [2]string{label, "-" + formatMoney(m.Amount)})
+	}
+	taxes := inv.Taxes
+	if len(taxes) == 0 && inv.TaxAmount != 0 {
+		taxes = []invoiceDetailTax{{Name: "Tax", Rate: inv.TaxPercent, Amount: inv.TaxAmount}}
+	}
+	for _, t := range taxes {
+		label := fmt.Sprintf("%s (%s%%)", t.Name, formatQuantity(t.Rate))
+		if inv.TaxInclusive {
+			label = "Incl. " + label
+		}
+		totals = append(totals, [2]string{label, formatMoney(t.Amount)})
+	}
+	if y+float64(len(totals)+1)*16 > bottom {
+		doc.AddPage()
+		y = 50
+	}
+	y += 8
+	for _, t := range totals {
+		doc.Text(colQty-40, y, fitText(doc, t[0], right-colQty-20))
+		doc.TextRight(right-6, y, t[1])
+		y += 16
+	}
+	doc.SetFont(true, 11)
+	doc.Text(colQty-40, y+2, "Total")
+	doc.TextRight(right-6, y+2, formatMoney(inv.Total))
+	if inv.TipAmount > 0 {
+		y += 18
+		doc.SetFont(false, 9)
+		doc.Text(colQty-40, y+2, "Tips")
+		doc.TextRight(right-6, y+2, formatMoney(inv.TipAmount))
+	}
+	y += 30
+
+	// Payments.
+	if len(inv.Payments) > 0 {
+		if y+float64(len(inv.Payments)+3)*16 > bottom {
+			doc.AddPage()
+			y = 50
+		}
+		doc.SetFont(true, 10)
+		doc.Text(left, y, "Payments")
+		doc.SetFont(false, 9)
+		y += 16
+		for _, p := range inv.Payments {
+			label := strings.ReplaceAll(p.Method, "_", " ")
+			switch {
+			case p.Method == "gift_card":
+				label += " (" + maskGiftCardCode(p.Reference) + ")"
+			case p.Reference != "":
+				label += " (" + p.Reference + ")"
+			}
+			doc.Text(left+6, y, p.PaidAt)
+			doc.Text(left+80, y, fitText(doc, label, colPrice-left-80))
+			doc.TextRight(right-6, y, formatMoney(p.Amount))
+			y += 14
+		}
+	}
+	if due := round2(inv.Total + inv.TipAmount - inv.AmountPaid()); due > 0 {
+		doc.SetFont(true, 10)
+		doc.Text(colQty-40, y+4, "Balance due")
+		doc.TextRight(right-6, y+4, formatMoney(due))
+	}
+
+	// Footer at the bottom of the last page.
+	footer := strings.Split(strings.TrimSpace(inv.Footer), "\n")
+	if inv.Footer == "" {
+		footer = []string{"Thank you for visiting " + inv.SalonName + "!"}
+	}
+	doc.SetFont(false, 8)
+	fy := pdf.PageHeight - 50 - float64(len(footer)-1)*11
+	for _, s := range footer {
+		s = fitText(doc, strings.TrimSpace(s), right-left)
+		doc.Text((pdf.PageWidth-doc.StringWidth(s))/2, fy, s)
+		fy += 11
+	}
+
+	return doc.Bytes()
+}
@@ -0,0 +1,218 @@
+// internal/handlers/invoice_pricing.go
+// Line item resolution and total/tax calculation for new invoices.
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// invoiceInputError is a problem with the submitted invoice that should be
+// reported to the client as 400 Bad Request.
+type invoiceInputError string
+
+func (e invoiceInputError) Error() string { return string(e) }
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// invoiceItemInput is one entry of the JSON-encoded "items" form field. A
+// service_id fills in the description, price and tax rate from the catalogue;
+// anything sent explicitly overrides it.
+type invoiceItemInput struct {
+	ServiceID   int64    `json:"service_id,omitempty"`
+	TaxRateID   int64    `json:"tax_rate_id,omitempty"`
+	Description string   `json:"description"`
+	Quantity    float64  `json:"quantity"`
+	UnitPrice   *float64 `json:"unit_price"`
+
+	// GiftCard marks the line as the sale of a gift card worth UnitPrice.
+	GiftCard *giftCardSale `json:"gift_card,omitempty"`
+	// PackageID sells a prepaid package from the catalogue.
+	PackageID int64 `json:"package_id,omitempty"`
+	// ProductID sells a retail product, taking it off stock.
+	ProductID int64 `json:"product_id,omitempty"`
+	// Usage is the backroom products actually used on a service, replacing
+	// the service's recipe.
+	Usage []productUsage `json:"usage,omitempty"`
+	// StaffID is the staff member credited with the line for commission.
+	StaffID int64 `json:"staff_id,omitempty"`
+
+	// Filled in by resolveInvoiceItems, selectPromotion and priceInvoice.
+	pkg           *servicePackage
+	product       *product
+	usage         []productUsage
+	membershipID  sql.NullInt64
+	taxRateID     sql.NullInt64
+	taxName       string
+	taxRate       float64
+	promoDiscount float64
+	lineTotal     float64
+	netAmount     float64
+	taxAmount     float64
+}
+
+// invoiceTax is one line of an invoice's per-rate tax breakdown.
+type invoiceTax struct {
+	TaxRateID sql.NullInt64
+	Name      string
+	Rate      float64
+	Taxable   float64
+	Amount    float64
+}
+
+type invoiceTotals struct {
+	Subtotal       float64
+	DiscountAmount float64
+	TaxAmount      float64
+	Total          float64
+	Taxes          []invoiceTax
+}
+
+// resolveInvoiceItems validates items and fills in catalogue defaults. Lines
+// without their own tax rate are taxed at defaultTax, the invoice-wide "tax"
+// percentage.
+func resolveInvoiceItems(q queryer, ownerID int, items []invoiceItemInput, defaultTax float64) error {
+	for i := range items {
+		it := &items[i]
+		it.Description = strings.TrimSpace(it.Description)
+		if it.Quantity == 0 {
+			it.Quantity = 1
+		}
+		if it.StaffID != 0 {
+			if err := lookupStaff(q, ownerID, it.StaffID); err == sql.ErrNoRows {
+				return invoiceInputError(fmt.Sprintf("Staff member %d not found", it.StaffID))
+			} else if err != nil {
+				return err
+			}
+		}
+		if it.GiftCard != nil {
+			if err := resolveGiftCardSale(q, ownerID, it); err != nil {
+				return err
+			}
+			continue
+		}
+		if it.PackageID != 0 {
+			if err := resolvePackageSale(q, ownerID, it); err != nil {
+				return err
+			}
+		}
+		if it.ProductID != 0 {
+			if err := resolveProductSale(q, ownerID, it); err != nil {
+				return err
+			}
+		}
+		if it.ServiceID != 0 {
+			var name string
+			var price float64
+			var taxRateID sql.NullInt64
+			err := q.QueryRow(
+				"SELECT name, price, tax_rate_id FROM services WHERE id = ? AND owner_id = ? AND active = 1",
+				it.ServiceID, ownerID,
+			).Scan(&name, &price, &taxRateID)
+			if err == sql.ErrNoRows {
+				return invoiceInputError(fmt.Sprintf("Service %d not found", it.ServiceID))
+			} else if err != nil {
+				return err
+			}
+			if it.Description == "" {
+				it.Description = name
+			}
+			if it.UnitPrice == nil {
+				it.UnitPrice = &price
+			}
+			if it.TaxRateID == 0 && taxRateID.Valid {
+				it.TaxRateID = taxRateID.Int64
+			}
+		}
+		if err := resolveItemUsage(q, ownerID, it); err != nil {
+			return err
+		}
+		if it.Description == "" || len(it.Description) > 200 {
+			return invoiceInputError("Each item needs a description (max 200 characters)")
+		}
+		if it.UnitPrice == nil {
+			return invoiceInputError("Each item needs a unit price")
+		}
+		if it.Quantity < 0 || *it.UnitPrice < 0 {
+			return invoiceInputError("Item quantity and price cannot be negative")
+		}
+
+		if it.TaxRateID != 0 {
+			t, err := lookupTaxRate(q, ownerID, it.TaxRateID)
+			if err == sql.ErrNoRows {
+				return invoiceInputError(fmt.Sprintf("Tax rate %d not found", it.TaxRateID))
+			} else if err != nil {
+				return err
+			}
+			it.taxRateID = sql.NullInt64{Int64: t.ID, Valid: true}
+			it.taxName, it.taxRate = t.Name, t.Rate
+		} else if defaultTax > 0 {
+			it.taxName, it.taxRate = "Tax", defaultTax
+		}
+	}
+	return nil
+}
+
+// priceInvoice works out line totals, the discount and a per-rate tax
+// breakdown. The discount percentage is spread across every line except gift
+// card sales before tax, followed by any promotion discount already allocated
+// to the lines; what is left, excluding tax, is the line's net amount. With
+// inclusive pricing the tax is contained in the line prices and is not added
+// to the total.
+func priceInvoice(items []invoiceItemInput, discount float64, inclusive bool) invoiceTotals {
+	var t invoiceTotals
+	var discountable, promoDiscount float64
+	byRate := map[string]int{}
+	for i := range items {
+		it := &items[i]
+		it.lineTotal = round2(it.Quantity * *it.UnitPrice)
+		t.Subtotal += it.lineTotal
+		if it.GiftCard == nil {
+			discountable += it.lineTotal
+		}
+		promoDiscount += it.promoDiscount
+		net := it.lineTotal - it.promoDiscount
+		if it.GiftCard == nil {
+			net -= it.lineTotal * discount / 100
+		}
+		it.netAmount = round2(net)
+		if it.taxRate <= 0 {
+			continue
+		}
+		if inclusive {
+			it.taxAmount = round2(net - net/(1+it.taxRate/100))
+			net -= it.taxAmount
+			it.netAmount = round2(net)
+		} else {
+			it.taxAmount = round2(net * it.taxRate / 100)
+		}
+		key := fmt.Sprintf("%d|%s|%g", it.taxRateID.Int64, it.taxName, it.taxRate)
+		idx, ok := byRate[key]
+		if !ok {
+			idx = len(t.Taxes)
+			byRate[key] = idx
+			t.Taxes = append(t.Taxes, invoiceTax{TaxRateID: it.taxRateID, Name: it.taxName, Rate: it.taxRate})
+		}
+		t.Taxes[idx].Taxable += net
+		t.Taxes[idx].Amount += it.taxAmount
+	}
+	t.Subtotal = round2(t.Subtotal)
+	t.DiscountAmount = round2(discountable*discount/100 + promoDiscount)
+	for i := range t.Taxes {
+		t.Taxes[i].Taxable = round2(t.Taxes[i].Taxable)
+		t.Taxes[i].Amount = round2(t.Taxes[i].Amount)
+		t.TaxAmount += t.Taxes[i].Amount
+	}
+	t.TaxAmount = round2(t.TaxAmount)
+	t.Total = t.Subtotal - t.DiscountAmount
+	if !inclusive {
+		t.Total += t.TaxAmount
+	}
+	t.Total = round2(t.Total)
+	return t
+}
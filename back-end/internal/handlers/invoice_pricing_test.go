@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestPriceInvoice(t *testing.T) {
+	tests := []struct {
+		name      string
+		items     []invoiceItemInput
+		discount  float64
+		inclusive bool
+		want      invoiceTotals
+	}{
+		{
+			name:  "no tax or discount",
+			items: []invoiceItemInput{{Quantity: 2, UnitPrice: price(12.5)}},
+			want:  invoiceTotals{Subtotal: 25, Total: 25},
+		},
+		{
+			name:     "discount before exclusive tax",
+			items:    []invoiceItemInput{{Quantity: 1, UnitPrice: price(100), taxName: "VAT", taxRate: 10}},
+			discount: 10,
+			want: invoiceTotals{Subtotal: 100, DiscountAmount: 10, TaxAmount: 9, Total: 99,
+				Taxes: []invoiceTax{{Name: "VAT", Rate: 10, Taxable: 90, Amount: 9}}},
+		},
+		{
+			name:      "inclusive tax is not added to the total",
+			items:     []invoiceItemInput{{Quantity: 1, UnitPrice: price(120), taxName: "VAT", taxRate: 20}},
+			inclusive: true,
+			want: invoiceTotals{Subtotal: 120, TaxAmount: 20, Total: 120,
+				Taxes: []invoiceTax{{Name: "VAT", Rate: 20, Taxable: 100, Amount: 20}}},
+		},
+		{
+			name: "gift cards are not discounted",
+			items: []invoiceItemInput{
+				{Quantity: 1, UnitPrice: price(100)},
+				{Quantity: 1, UnitPrice: price(50), GiftCard: &giftCardSale{}},
+			},
+			discount: 10,
+			want:     invoiceTotals{Subtotal: 150, DiscountAmount: 10, Total: 140},
+		},
+		{
+			name:  "promotion discount comes off the line before tax",
+			items: []invoiceItemInput{{Quantity: 1, UnitPrice: price(50), promoDiscount: 5, taxName: "Tax", taxRate: 10}},
+			want: invoiceTotals{Subtotal: 50, DiscountAmount: 5, TaxAmount: 4.5, Total: 49.5,
+				Taxes: []invoiceTax{{Name: "Tax", Rate: 10, Taxable: 45, Amount: 4.5}}},
+		},
+		{
+			name: "tax is broken down per rate",
+			items: []invoiceItemInput{
+				{Quantity: 1, UnitPrice: price(40), taxRateID: sql.NullInt64{Int64: 1, Valid: true}, taxName: "Standard", taxRate: 10},
+				{Quantity: 1, UnitPrice: price(20), taxRateID: sql.NullInt64{Int64: 2, Valid: true}, taxName: "Reduced", taxRate: 5},
+				{Quantity: 3, UnitPrice: price(10), taxRateID: sql.NullInt64{Int64: 1, Valid: true}, taxName: "Standard", taxRate: 10},
+			},
+			want: invoiceTotals{Subtotal: 90, TaxAmount: 8, Total: 98, Taxes: []invoiceTax{
+				{TaxRateID: sql.NullInt64{Int64: 1, Valid: true}, Name: "Standard", Rate: 10, Taxable: 70, Amount: 7},
+				{TaxRateID: sql.NullInt64{Int64: 2, Valid: true}, Name: "Reduced", Rate: 5, Taxable: 20, Amount: 1},
+			}},
+		},
+		{
+			name:     "amounts are rounded to cents",
+			items:    []invoiceItemInput{{Quantity: 3, UnitPrice: price(3.33), taxName: "Tax", taxRate: 7.5}},
+			discount: 5,
+			want: invoiceTotals{Subtotal: 9.99, DiscountAmount: 0.5, TaxAmount: 0.71, Total: 10.2,
+				Taxes: []invoiceTax{{Name: "Tax", Rate: 7.5, Taxable: 9.49, Amount: 0.71}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := priceInvoice(tt.items, tt.discount, tt.inclusive)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("priceInvoice() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveInvoiceItems(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db,
+		"INSERT INTO tax_rates (id, owner_id, name, rate) VALUES (1, 1, 'Standard', 20), (2, 1, 'Reduced', 5), (3, 2, 'Other salon', 10)",
+		"INSERT INTO tax_rates (id, owner_id, name, rate, active) VALUES (4, 1, 'Retired', 15, 0)",
+		"INSERT INTO services (id, owner_id, name, price, tax_rate_id) VALUES (1, 1, 'Cut', 30, 1), (2, 1, 'Wash', 10, NULL), (3, 2, 'Elsewhere', 5, NULL)",
+	)
+
+	tests := []struct {
+		name       string
+		item       invoiceItemInput
+		defaultTax float64
+		wantErr    string
+		wantDesc   string
+		wantPrice  float64
+		wantTax    string
+		wantRate   float64
+	}{
+		{name: "service defaults", item: invoiceItemInput{ServiceID: 1},
+			wantDesc: "Cut", wantPrice: 30, wantTax: "Standard", wantRate: 20},
+		{name: "explicit values override the service", item: invoiceItemInput{ServiceID: 1, Description: "Long cut", UnitPrice: price(45), TaxRateID: 2},
+			wantDesc: "Long cut", wantPrice: 45, wantTax: "Reduced", wantRate: 5},
+		{name: "invoice-wide tax for lines without a rate", item: invoiceItemInput{ServiceID: 2}, defaultTax: 8,
+			wantDesc: "Wash", wantPrice: 10, wantTax: "Tax", wantRate: 8},
+		{name: "untaxed line", item: invoiceItemInput{Description: "Misc", UnitPrice: price(3)},
+			wantDesc: "Misc", wantPrice: 3},
+		{name: "another salon's service", item: invoiceItemInput{ServiceID: 3}, wantErr: "Service 3 not found"},
+		{name: "another salon's tax rate", item: invoiceItemInput{Description: "Misc", UnitPrice: price(3), TaxRateID: 3}, wantErr: "Tax rate 3 not found"},
+		{name: "inactive tax rate", item: invoiceItemInput{Description: "Misc", UnitPrice: price(3), TaxRateID: 4}, wantErr: "Tax rate 4 not found"},
+		{name: "missing price", item: invoiceItemInput{Description: "Misc"}, wantErr: "Each item needs a unit price"},
+		{name: "missing description", item: invoiceItemInput{UnitPrice: price(3)}, wantErr: "Each item needs a description (max 200 characters)"},
+		{name: "negative price", item: invoiceItemInput{Description: "Refund", UnitPrice: price(-3)}, wantErr: "Item quantity and price cannot be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := []invoiceItemInput{tt.item}
+			err := resolveInvoiceItems(db, 1, items, tt.defaultTax)
+			if tt.wantErr != "" {
+				if _, ok := err.(invoiceInputError); !ok || err.Error() != tt.wantErr {
+					t.Fatalf("resolveInvoiceItems() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveInvoiceItems() error = %v", err)
+			}
+			it := items[0]
+			if it.Quantity != 1 || it.Description != tt.wantDesc || *it.UnitPrice != tt.wantPrice || it.taxName != tt.wantTax || it.taxRate != tt.wantRate {
+				t.Errorf("got %q x%v at %v taxed %q %v%%, want %q x1 at %v taxed %q %v%%",
+					it.Description, it.Quantity, *it.UnitPrice, it.taxName, it.taxRate, tt.wantDesc, tt.wantPrice, tt.wantTax, tt.wantRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,544 @@
+// internal/handlers/loyalty_handlers.go
+// Handlers for the loyalty programme: earn rules, the points ledger with
+// expiry, redeeming points as payment and customer tiers.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// Tiers are based on the points a customer earned over this many months.
+const loyaltyTierMonths = 12
+
+type loyaltyServiceBonus struct {
+	ServiceID int64 `json:"service_id"`
+	Points    int   `json:"points"`
+}
+
+type loyaltyTier struct {
+	Name      string `json:"name"`
+	MinPoints int    `json:"min_points"`
+}
+
+type loyaltySettings struct {
+	Enabled        bool                  `json:"enabled"`
+	PointsPerUnit  float64               `json:"points_per_unit"`
+	PointValue     float64               `json:"point_value"`
+	BirthdayBonus  int                   `json:"birthday_bonus"`
+	ExpiryDays     int                   `json:"expiry_days"`
+	ServiceBonuses []loyaltyServiceBonus `json:"service_bonuses"`
+	Tiers          []loyaltyTier         `json:"tiers"`
+}
+
+type loyaltyTransaction struct {
+	Kind      string `json:"kind"`
+	Points    int    `json:"points"`
+	ExpiresOn string `json:"expires_on,omitempty"`
+	InvoiceID *int64 `json:"invoice_id,omitempty"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// loyaltySummary is a customer's standing in the programme.
+type loyaltySummary struct {
+	Points       int                  `json:"points"`
+	Value        float64              `json:"value"`
+	Tier         string               `json:"tier,omitempty"`
+	Transactions []loyaltyTransaction `json:"transactions,omitempty"`
+}
+
+// loadLoyaltySettings returns the salon's loyalty settings; the programme is
+// disabled until the owner saves them.
+func loadLoyaltySettings(q queryer, ownerID int) (loyaltySettings, error) {
+	s := loyaltySettings{ServiceBonuses: []loyaltyServiceBonus{}, Tiers: []loyaltyTier{}}
+	err := q.QueryRow(`
+        SELECT enabled, points_per_unit, point_value, birthday_bonus, expiry_days
+        FROM loyalty_settings WHERE owner_id = ?`, ownerID).Scan(
+		&s.Enabled, &s.PointsPerUnit, &s.PointValue, &s.BirthdayBonus, &s.ExpiryDays)
+	if err == sql.ErrNoRows {
+		return s, nil
+	} else if err != nil {
+		return s, err
+	}
+
+	rows, err := q.Query("SELECT service_id, points FROM loyalty_service_bonuses WHERE owner_id = ? ORDER BY service_id", ownerID)
+	if err != nil {
+		return s, err
+	}
+	for rows.Next() {
+		var b loyaltyServiceBonus
+		if err := rows.Scan(&b.ServiceID, &b.Points); err != nil {
+			rows.Close()
+			return s, err
+		}
+		s.ServiceBonuses = append(s.ServiceBonuses, b)
+	}
+	rows.Close()
+
+	rows, err = q.Query("SELECT name, min_points FROM loyalty_tiers WHERE owner_id = ? ORDER BY min_points", ownerID)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t loyaltyTier
+		if err := rows.Scan(&t.Name, &t.MinPoints); err != nil {
+			return s, err
+		}
+		s.Tiers = append(s.Tiers, t)
+	}
+	return s, rows.Err()
+}
+
+// tierFor returns the highest tier whose threshold earned reaches. Tiers are
+// sorted by min_points.
+func (s loyaltySettings) tierFor(earned int) string {
+	tier := ""
+	for _, t := range s.Tiers {
+		if earned >= t.MinPoints {
+			tier = t.Name
+		}
+	}
+	return tier
+}
+
+// expireLoyaltyPoints writes off points whose expiry date has passed.
+func expireLoyaltyPoints(tx *sql.Tx, ownerID, customerID int, now time.Time) error {
+	rows, err := tx.Query(`
+        SELECT id, remaining FROM loyalty_transactions
+        WHERE owner_id = ? AND customer_id = ? AND remaining > 0 AND date(expires_on) < ?`,
+		ownerID, customerID, now.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	type lot struct{ id, remaining int64 }
+	var expired []lot
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		expired = append(expired, l)
+	}
+	rows.Close()
+	for _, l := range expired {
+		if _, err := tx.Exec("UPDATE loyalty_transactions SET remaining = 0 WHERE id = ?", l.id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+            INSERT INTO loyalty_transactions (owner_id, customer_id, kind, points, note, created_at)
+            VALUES (?, ?, 'expire', ?, 'Points expired', ?)`,
+			ownerID, customerID, -l.remaining, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spendLoyaltyPoints records points leaving the customer's balance, using up
+// the points closest to expiry first.
+func spendLoyaltyPoints(tx *sql.Tx, ownerID, customerID, points int, kind string, invoiceID sql.NullInt64, note string, now time.Time) error {
+	rows, err := tx.Query(`
+        SELECT id, remaining FROM loyalty_transactions
+        WHERE owner_id = ? AND customer_id = ? AND remaining > 0
+        ORDER BY expires_on IS NULL, date(expires_on), id`, ownerID, customerID)
+	if err != nil {
+		return err
+	}
+	type lot struct{ id, remaining int }
+	var lots []lot
+	available := 0
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		lots = append(lots, l)
+		available += l.remaining
+	}
+	rows.Close()
+	if available < points {
+		return invoiceInputError(fmt.Sprintf("The customer only has %d loyalty points", available))
+	}
+	left := points
+	for _, l := range lots {
+		if left == 0 {
+			break
+		}
+		use := l.remaining
+		if use > left {
+			use = left
+		}
+		if _, err := tx.Exec("UPDATE loyalty_transactions SET remaining = remaining - ? WHERE id = ?", use, l.id); err != nil {
+			return err
+		}
+		left -= use
+	}
+	_, err = tx.Exec(`
+        INSERT INTO loyalty_transactions (owner_id, customer_id, kind, points, invoice_id, note, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, customerID, kind, -points, invoiceID, nullIfEmpty(note), now)
+	return err
+}
+
+// addLoyaltyPoints credits points that expire according to the salon's
+// settings.
+func addLoyaltyPoints(tx *sql.Tx, ownerID, customerID, points int, kind string, invoiceID sql.NullInt64, note string, settings loyaltySettings, now time.Time) error {
+	var expiresOn sql.NullString
+	if settings.ExpiryDays > 0 {
+		expiresOn = sql.NullString{String: now.AddDate(0, 0, settings.ExpiryDays).Format("2006-01-02"), Valid: true}
+	}
+	_, err := tx.Exec(`
+        INSERT INTO loyalty_transactions (owner_id, customer_id, kind, points, remaining, expires_on, invoice_id, note, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, customerID, kind, points, points, expiresOn, invoiceID, nullIfEmpty(note), now)
+	return err
+}
+
+// redeemLoyaltyPoints pays amount of invoiceID with the customer's points.
+// It returns the number of points used.
+func redeemLoyaltyPoints(tx *sql.Tx, ownerID, customerID int, amount float64, invoiceID int64, now time.Time) (int, error) {
+	settings, err := loadLoyaltySettings(tx, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	if !settings.Enabled || settings.PointValue <= 0 {
+		return 0, invoiceInputError("Loyalty points can't be redeemed at this salon")
+	}
+	if err := expireLoyaltyPoints(tx, ownerID, customerID, now); err != nil {
+		return 0, err
+	}
+	// Round up so the points cover the amount, allowing for float error.
+	points := int(math.Ceil(amount/settings.PointValue - 1e-9))
+	err = spendLoyaltyPoints(tx, ownerID, customerID, points, "redeem",
+		sql.NullInt64{Int64: invoiceID, Valid: true}, "", now)
+	return points, err
+}
+
+// earnLoyaltyPoints credits the customer for a paid invoice: points for the
+// amount spent plus any service and birthday-month bonuses.
+func earnLoyaltyPoints(tx *sql.Tx, ownerID, customerID int, invoiceID int64, items []invoiceItemInput, spend float64, now time.Time) error {
+	settings, err := loadLoyaltySettings(tx, ownerID)
+	if err != nil || !settings.Enabled {
+		return err
+	}
+	inv := sql.NullInt64{Int64: invoiceID, Valid: true}
+	if points := int(math.Floor(spend * settings.PointsPerUnit)); points > 0 {
+		if err := addLoyaltyPoints(tx, ownerID, customerID, points, "earn", inv, "", settings, now); err != nil {
+			return err
+		}
+	}
+	for _, b := range settings.ServiceBonuses {
+		var quantity float64
+		for _, it := range items {
+			if it.ServiceID == b.ServiceID {
+				quantity += it.Quantity
+			}
+		}
+		if points := int(quantity) * b.Points; points > 0 {
+			if err := addLoyaltyPoints(tx, ownerID, customerID, points, "bonus", inv, "Service bonus", settings, now); err != nil {
+				return err
+			}
+		}
+	}
+	if settings.BirthdayBonus > 0 {
+		ok, err := inBirthdayMonth(tx, customerID, now)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := addLoyaltyPoints(tx, ownerID, customerID, settings.BirthdayBonus, "bonus", inv, "Birthday bonus", settings, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadLoyaltySummary expires old points and returns the customer's balance
+// and tier, with the full ledger if withLedger is set.
+func loadLoyaltySummary(db *sql.DB, ownerID, customerID int, withLedger bool) (*loyaltySummary, error) {
+	settings, err := loadLoyaltySettings(db, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err := expireLoyaltyPoints(tx, ownerID, customerID, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s := &loyaltySummary{}
+	var earned int
+	err = db.QueryRow(`
+        SELECT COALESCE(SUM(points), 0),
+            COALESCE(SUM(CASE WHEN kind IN ('earn', 'bonus') AND created_at >= ? THEN points ELSE 0 END), 0)
+        FROM loyalty_transactions WHERE owner_id = ? AND customer_id = ?`,
+		now.AddDate(0, -loyaltyTierMonths, 0), ownerID, customerID).Scan(&s.Points, &earned)
+	if err != nil {
+		return nil, err
+	}
+	s.Value = round2(float64(s.Points) * settings.PointValue)
+	s.Tier = settings.tierFor(earned)
+	if !withLedger {
+		return s, nil
+	}
+
+	s.Transactions = []loyaltyTransaction{}
+	rows, err := db.Query(`
+        SELECT kind, points, date(expires_on), invoice_id, note, created_at
+        FROM loyalty_transactions WHERE owner_id = ? AND customer_id = ? ORDER BY id DESC`, ownerID, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t loyaltyTransaction
+		var expiresOn, note sql.NullString
+		var invoiceID sql.NullInt64
+		if err := rows.Scan(&t.Kind, &t.Points, &expiresOn, &invoiceID, &note, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.ExpiresOn, t.Note = expiresOn.String, note.String
+		if invoiceID.Valid {
+			t.InvoiceID = &invoiceID.Int64
+		}
+		s.Transactions = append(s.Transactions, t)
+	}
+	return s, rows.Err()
+}
+
+// --- API: Loyalty Settings ---
+func APIGetLoyaltySettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	s, err := loadLoyaltySettings(database.GetDB(), ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch loyalty settings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// --- API: Update Loyalty Settings ---
+// Service bonuses and tiers in the body replace the existing ones.
+func APIUpdateLoyaltySettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var s loyaltySettings
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if s.PointsPerUnit < 0 || s.PointValue < 0 || s.BirthdayBonus < 0 || s.ExpiryDays < 0 {
+		http.Error(w, "Loyalty settings cannot be negative", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	for _, b := range s.ServiceBonuses {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM services WHERE id = ? AND owner_id = ?", b.ServiceID, ownerID).Scan(&count)
+		if count == 0 {
+			http.Error(w, fmt.Sprintf("Service %d not found", b.ServiceID), http.StatusBadRequest)
+			return
+		}
+		if b.Points < 1 {
+			http.Error(w, "Service bonus points must be positive", http.StatusBadRequest)
+			return
+		}
+	}
+	for i := range s.Tiers {
+		s.Tiers[i].Name = strings.TrimSpace(s.Tiers[i].Name)
+		if s.Tiers[i].Name == "" || len(s.Tiers[i].Name) > 50 || s.Tiers[i].MinPoints < 0 {
+			http.Error(w, "Each tier needs a name (max 50 characters) and a minimum of 0 or more points", http.StatusBadRequest)
+			return
+		}
+	}
+	sort.Slice(s.Tiers, func(i, j int) bool { return s.Tiers[i].MinPoints < s.Tiers[j].MinPoints })
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to save loyalty settings", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+        INSERT OR REPLACE INTO loyalty_settings (owner_id, enabled, points_per_unit, point_value, birthday_bonus, expiry_days, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, s.Enabled, s.PointsPerUnit, s.PointValue, s.BirthdayBonus, s.ExpiryDays, time.Now())
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM loyalty_service_bonuses WHERE owner_id = ?", ownerID)
+	}
+	for _, b := range s.ServiceBonuses {
+		if err == nil {
+			_, err = tx.Exec("INSERT OR REPLACE INTO loyalty_service_bonuses (owner_id, service_id, points) VALUES (?, ?, ?)",
+				ownerID, b.ServiceID, b.Points)
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM loyalty_tiers WHERE owner_id = ?", ownerID)
+	}
+	for _, t := range s.Tiers {
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO loyalty_tiers (owner_id, name, min_points) VALUES (?, ?, ?)", ownerID, t.Name, t.MinPoints)
+		}
+	}
+	if err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to save loyalty settings", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Customer Loyalty ---
+// Returns the customer's points balance, tier and points ledger.
+func APIGetCustomerLoyalty(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	customerID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", customerID, ownerID).Scan(&count)
+	if count == 0 {
+		http.Error(w, "Customer not found", http.StatusNotFound)
+		return
+	}
+	s, err := loadLoyaltySummary(db, ownerID, customerID, true)
+	if err != nil {
+		http.Error(w, "Failed to fetch loyalty points", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// --- API: Adjust Loyalty Points ---
+// Body: {"points": -50, "note": "Goodwill"}. Manual corrections to a
+// customer's balance.
+func APIAdjustCustomerLoyalty(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	customerID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Points int    `json:"points"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Points == 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if req.Note == "" || len(req.Note) > 200 {
+		http.Error(w, "A note is required (max 200 characters)", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", customerID, ownerID).Scan(&count)
+	if count == 0 {
+		http.Error(w, "Customer not found", http.StatusNotFound)
+		return
+	}
+	settings, err := loadLoyaltySettings(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to adjust loyalty points", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to adjust loyalty points", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if err := expireLoyaltyPoints(tx, ownerID, customerID, now); err != nil {
+		http.Error(w, "Failed to adjust loyalty points", http.StatusInternalServerError)
+		return
+	}
+	if req.Points > 0 {
+		err = addLoyaltyPoints(tx, ownerID, customerID, req.Points, "adjust", sql.NullInt64{}, req.Note, settings, now)
+	} else {
+		err = spendLoyaltyPoints(tx, ownerID, customerID, -req.Points, "adjust", sql.NullInt64{}, req.Note, now)
+	}
+	if err != nil {
+		if _, ok := err.(invoiceInputError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to adjust loyalty points", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to adjust loyalty points", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestLoyaltyTierFor(t *testing.T) {
+	s := loyaltySettings{Tiers: []loyaltyTier{{"Silver", 100}, {"Gold", 500}}}
+	tests := []struct {
+		earned int
+		want   string
+	}{
+		{0, ""},
+		{99, ""},
+		{100, "Silver"},
+		{499, "Silver"},
+		{500, "Gold"},
+		{9000, "Gold"},
+	}
+	for _, tt := range tests {
+		if got := s.tierFor(tt.earned); got != tt.want {
+			t.Errorf("tierFor(%d) = %q, want %q", tt.earned, got, tt.want)
+		}
+	}
+}
+
+// loyaltyLots returns the unspent points left on each of a customer's
+// earned rows, by id.
+func loyaltyLots(t *testing.T, tx *sql.Tx, customerID int) map[int]int {
+	t.Helper()
+	rows, err := tx.Query("SELECT id, remaining FROM loyalty_transactions WHERE customer_id = ? AND points > 0", customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	lots := map[int]int{}
+	for rows.Next() {
+		var id, remaining int
+		rows.Scan(&id, &remaining)
+		lots[id] = remaining
+	}
+	return lots
+}
+
+func TestRedeemLoyaltyPoints(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO loyalty_settings (owner_id, enabled, points_per_unit, point_value) VALUES (1, 1, 1, 0.1), (2, 0, 1, 0.1)`,
+		// Customer 5 earned, in id order: 50 points that never expire, 30
+		// expiring in April, 20 that expired yesterday and 40 expiring in
+		// March.
+		`INSERT INTO loyalty_transactions (id, owner_id, customer_id, kind, points, remaining, expires_on) VALUES
+			(1, 1, 5, 'earn', 50, 50, NULL),
+			(2, 1, 5, 'earn', 30, 30, '2026-04-30'),
+			(3, 1, 5, 'earn', 20, 20, '2026-03-09'),
+			(4, 1, 5, 'earn', 40, 40, '2026-03-31')`,
+	)
+
+	tests := []struct {
+		name       string
+		ownerID    int
+		amount     float64
+		wantPoints int
+		wantErr    string
+		wantLots   map[int]int
+	}{
+		{name: "soonest expiry is spent first", ownerID: 1, amount: 3, wantPoints: 30,
+			wantLots: map[int]int{1: 50, 2: 30, 3: 0, 4: 10}},
+		{name: "spills over into the next lot", ownerID: 1, amount: 5.5, wantPoints: 55,
+			wantLots: map[int]int{1: 50, 2: 15, 3: 0, 4: 0}},
+		{name: "points that never expire are spent last", ownerID: 1, amount: 12, wantPoints: 120,
+			wantLots: map[int]int{1: 0, 2: 0, 3: 0, 4: 0}},
+		{name: "part points round up", ownerID: 1, amount: 0.25, wantPoints: 3,
+			wantLots: map[int]int{1: 50, 2: 30, 3: 0, 4: 37}},
+		{name: "expired points can't be spent", ownerID: 1, amount: 12.1,
+			wantErr: "The customer only has 120 loyalty points"},
+		{name: "programme disabled", ownerID: 2, amount: 1,
+			wantErr: "Loyalty points can't be redeemed at this salon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			points, err := redeemLoyaltyPoints(tx, tt.ownerID, 5, tt.amount, 9, now)
+			if tt.wantErr != "" {
+				if _, ok := err.(invoiceInputError); !ok || err.Error() != tt.wantErr {
+					t.Fatalf("redeemLoyaltyPoints() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("redeemLoyaltyPoints() error = %v", err)
+			}
+			if points != tt.wantPoints {
+				t.Errorf("redeemLoyaltyPoints() = %d points, want %d", points, tt.wantPoints)
+			}
+			lots := loyaltyLots(t, tx, 5)
+			for id, want := range tt.wantLots {
+				if lots[id] != want {
+					t.Errorf("lot %d has %d points left, want %d", id, lots[id], want)
+				}
+			}
+			var expired, redeemed int
+			tx.QueryRow("SELECT COALESCE(SUM(points), 0) FROM loyalty_transactions WHERE kind = 'expire'").Scan(&expired)
+			tx.QueryRow("SELECT COALESCE(SUM(points), 0) FROM loyalty_transactions WHERE kind = 'redeem' AND invoice_id = 9").Scan(&redeemed)
+			if expired != -20 || redeemed != -tt.wantPoints {
+				t.Errorf("ledger has %d expired and %d redeemed, want -20 and %d", expired, redeemed, -tt.wantPoints)
+			}
+		})
+	}
+}
+
+func TestEarnLoyaltyPoints(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO loyalty_settings (owner_id, enabled, points_per_unit, point_value, birthday_bonus, expiry_days) VALUES
+			(1, 1, 2, 0.1, 25, 30)`,
+		`INSERT INTO loyalty_service_bonuses (owner_id, service_id, points) VALUES (1, 10, 5)`,
+		`INSERT INTO customers (id, owner_id, name, birthday) VALUES (5, 1, 'Jane', '1990-03-22'), (6, 1, 'John', '1990-07-01')`,
+	)
+	items := []invoiceItemInput{
+		{ServiceID: 10, Quantity: 2},
+		{ServiceID: 11, Quantity: 1},
+	}
+
+	tests := []struct {
+		name       string
+		ownerID    int
+		customerID int
+		spend      float64
+		want       map[string]int
+	}{
+		{name: "spend, service and birthday bonuses", ownerID: 1, customerID: 5, spend: 40.75,
+			want: map[string]int{"earn": 81, "Service bonus": 10, "Birthday bonus": 25}},
+		{name: "outside the birthday month", ownerID: 1, customerID: 6, spend: 10,
+			want: map[string]int{"earn": 20, "Service bonus": 10}},
+		{name: "programme disabled", ownerID: 2, customerID: 5, spend: 40, want: map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			if err := earnLoyaltyPoints(tx, tt.ownerID, tt.customerID, 9, items, tt.spend, now); err != nil {
+				t.Fatalf("earnLoyaltyPoints() error = %v", err)
+			}
+			rows, err := tx.Query(`SELECT COALESCE(note, kind), points, remaining, date(expires_on) FROM loyalty_transactions
+				WHERE customer_id = ? AND invoice_id = 9`, tt.customerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			got := map[string]int{}
+			for rows.Next() {
+				var label, expiresOn string
+				var points, remaining int
+				rows.Scan(&label, &points, &remaining, &expiresOn)
+				got[label] = points
+				if remaining != points || expiresOn != "2026-04-09" {
+					t.Errorf("%s: %d of %d remaining, expires %s", label, remaining, points, expiresOn)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("earned %v, want %v", got, tt.want)
+			}
+			for label, want := range tt.want {
+				if got[label] != want {
+					t.Errorf("%s = %d points, want %d", label, got[label], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+// internal/handlers/membership_billing.go
+// Background job that raises recurring membership invoices, and the member
+// benefits applied when an invoice is created.
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"salon-management/internal/database"
+)
+
+// At most this many overdue periods are billed for one membership in a
+// single run, so a long outage doesn't flood a customer with invoices.
+const maxCatchUpPeriods = 12
+
+// StartMembershipBilling raises invoices for memberships that are due, once
+// at start-up and then every hour.
+func StartMembershipBilling(db *sql.DB) {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		billDueMemberships(database.GetDB(), time.Now())
+		<-ticker.C
+	}
+}
+
+func billDueMemberships(db *sql.DB, now time.Time) {
+	rows, err := db.Query(
+		"SELECT id FROM memberships WHERE status = 'active' AND date(next_billing_on) <= ?",
+		now.Format("2006-01-02"))
+	if err != nil {
+		log.Printf("Error querying due memberships: %v", err)
+		return
+	}
+	var due []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			due = append(due, id)
+		}
+	}
+	rows.Close()
+	for _, id := range due {
+		if err := billMembership(db, id, now); err != nil {
+			log.Printf("Failed to bill membership %d: %v", id, err)
+		}
+	}
+}
+
+// billMembership raises an Unpaid invoice for each period of an active
+// membership that has started by now, and moves the membership on to its
+// next period.
+func billMembership(db *sql.DB, membershipID int64, now time.Time) error {
+	today := now.Format("2006-01-02")
+	for i := 0; i < maxCatchUpPeriods; i++ {
+		billed, err := billMembershipPeriod(db, membershipID, today, now)
+		if err != nil || !billed {
+			return err
+		}
+	}
+	return nil
+}
+
+func billMembershipPeriod(db *sql.DB, membershipID int64, today string, now time.Time) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var ownerID, customerID int
+	var status, planName, unit string
+	var next sql.NullString
+	var price float64
+	var count int
+	var taxRateID sql.NullInt64
+	var inclusive bool
+	err = tx.QueryRow(`
+        SELECT m.owner_id, m.customer_id, m.status, date(m.next_billing_on), p.name, p.price, p.interval_unit,
+            p.interval_count, p.tax_rate_id, COALESCE(o.prices_include_tax, 0)
+        FROM memberships m
+        JOIN membership_plans p ON m.plan_id = p.id
+        JOIN owners o ON m.owner_id = o.id
+        WHERE m.id = ?`, membershipID).Scan(
+		&ownerID, &customerID, &status, &next, &planName, &price, &unit, &count, &taxRateID, &inclusive)
+	if err != nil {
+		return false, err
+	}
+	if status != "active" || !next.Valid || next.String > today {
+		return false, nil
+	}
+
+	start, err := time.Parse("2006-01-02", next.String)
+	if err != nil {
+		return false, err
+	}
+	nextStart := addInterval(start, unit, count)
+	periodEnd := nextStart.AddDate(0, 0, -1).Format("2006-01-02")
+
+	items := []invoiceItemInput{{
+		Description: fmt.Sprintf("%s membership (%s to %s)", planName, next.String, periodEnd),
+		Quantity:    1,
+		UnitPrice:   &price,
+		TaxRateID:   taxRateID.Int64,
+	}}
+	if err := resolveInvoiceItems(tx, ownerID, items, 0); err != nil {
+		// The plan's tax rate has been archived; bill without it rather
+		// than stop billing altogether.
+		items[0].TaxRateID = 0
+		if err := resolveInvoiceItems(tx, ownerID, items, 0); err != nil {
+			return false, err
+		}
+	}
+	totals := priceInvoice(items, 0, inclusive)
+
+	res, err := tx.Exec(`
+        INSERT INTO invoices (owner_id, customer_id, invoice_date, total_amount, discount, tax, payment_status,
+            subtotal, discount_amount, tax_amount, tax_inclusive, membership_id, created_at, updated_at)
+        VALUES (?, ?, ?, ?, 0, 0, 'Unpaid', ?, 0, ?, ?, ?, ?, ?)`,
+		ownerID, customerID, next.String, totals.Total, totals.Subtotal, totals.TaxAmount, inclusive,
+		membershipID, now, now)
+	if err != nil {
+		return false, err
+	}
+	invoiceID, _ := res.LastInsertId()
+	if _, err := tx.Exec("UPDATE invoices SET invoice_number = ? WHERE id = ?", fmt.Sprintf("INV-%04d", invoiceID), invoiceID); err != nil {
+		return false, err
+	}
+	it := items[0]
+	_, err = tx.Exec(`
+        INSERT INTO invoice_items (invoice_id, description, quantity, unit_price, line_total,
+            tax_rate_id, tax_name, tax_rate, tax_amount)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		invoiceID, it.Description, it.Quantity, *it.UnitPrice, it.lineTotal,
+		it.taxRateID, it.taxName, it.taxRate, it.taxAmount)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range totals.Taxes {
+		_, err := tx.Exec(`
+            INSERT INTO invoice_taxes (invoice_id, tax_rate_id, name, rate, taxable_amount, tax_amount)
+            VALUES (?, ?, ?, ?, ?, ?)`,
+			invoiceID, t.TaxRateID, t.Name, t.Rate, t.Taxable, t.Amount)
+		if err != nil {
+			return false, err
+		}
+	}
+	_, err = tx.Exec(`
+        UPDATE memberships SET period_start = ?, period_end = ?, next_billing_on = ?, updated_at = ?
+        WHERE id = ?`,
+		next.String, periodEnd, nextStart.Format("2006-01-02"), now, membershipID)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// applyMembershipBenefits applies the customer's current membership to a new
+// invoice. Included services still within the period's allowance are priced
+// at zero and the plan discount replaces the manual discount when it is
+// higher. It returns the discount percentage to use.
+func applyMembershipBenefits(q queryer, ownerID, customerID int, items []invoiceItemInput, discount float64, today string) (float64, error) {
+	var membershipID int64
+	var planID int64
+	var planDiscount float64
+	var periodStart, periodEnd sql.NullString
+	err := q.QueryRow(`
+        SELECT m.id, m.plan_id, p.discount_percent, date(m.period_start), date(m.period_end)
+        FROM memberships m
+        JOIN membership_plans p ON m.plan_id = p.id
+        WHERE m.owner_id = ? AND m.customer_id = ? AND date(m.period_start) <= ?
+            AND (m.status = 'active' OR (m.status = 'cancelled' AND date(m.ends_on) >= ?))
+        ORDER BY p.discount_percent DESC, m.id
+        LIMIT 1`, ownerID, customerID, today, today).Scan(&membershipID, &planID, &planDiscount, &periodStart, &periodEnd)
+	if err == sql.ErrNoRows {
+		return discount, nil
+	} else if err != nil {
+		return discount, err
+	}
+
+	plan := &membershipPlan{ID: planID}
+	if err := loadPlanServices(q, plan); err != nil {
+		return discount, err
+	}
+	for _, s := range plan.IncludedServices {
+		remaining := -1
+		if s.Quantity != nil {
+			var used float64
+			err := q.QueryRow(`
+                SELECT COALESCE(SUM(ii.quantity), 0)
+                FROM invoice_items ii
+                JOIN invoices i ON ii.invoice_id = i.id
+                WHERE ii.membership_id = ? AND ii.service_id = ? AND i.invoice_date BETWEEN ? AND ?`,
+				membershipID, s.ServiceID, periodStart.String, periodEnd.String).Scan(&used)
+			if err != nil {
+				return discount, err
+			}
+			remaining = *s.Quantity - int(used)
+		}
+		for i := range items {
+			it := &items[i]
+			if it.ServiceID != s.ServiceID || it.GiftCard != nil || it.pkg != nil {
+				continue
+			}
+			if remaining >= 0 && it.Quantity > float64(remaining) {
+				continue
+			}
+			zero := 0.0
+			it.UnitPrice = &zero
+			it.membershipID = sql.NullInt64{Int64: membershipID, Valid: true}
+			if remaining >= 0 {
+				remaining -= int(it.Quantity)
+			}
+		}
+	}
+	if planDiscount > discount {
+		discount = planDiscount
+	}
+	return discount, nil
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddInterval(t *testing.T) {
+	d := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		unit  string
+		count int
+		want  string
+	}{
+		{"week", 2, "2026-02-14"},
+		{"month", 1, "2026-03-03"},
+		{"month", 3, "2026-05-01"},
+		{"year", 1, "2027-01-31"},
+	}
+	for _, tt := range tests {
+		if got := addInterval(d, tt.unit, tt.count).Format("2006-01-02"); got != tt.want {
+			t.Errorf("addInterval(%s, %d) = %s, want %s", tt.unit, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestBillMembership(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db,
+		`INSERT INTO owners (id, email, password_hash) VALUES (1, 'a@b.com', 'x')`,
+		`INSERT INTO membership_plans (id, owner_id, name, price, interval_unit, interval_count) VALUES (1, 1, 'Gold', 30, 'month', 1)`,
+		`INSERT INTO memberships (id, owner_id, customer_id, plan_id, status, next_billing_on) VALUES
+			(1, 1, 5, 1, 'active', '2026-01-15'),
+			(2, 1, 6, 1, 'paused', '2026-01-15'),
+			(3, 1, 7, 1, 'active', '2020-01-01')`,
+	)
+	now := time.Date(2026, 3, 20, 9, 0, 0, 0, time.UTC)
+	billDueMemberships(db, now)
+	billDueMemberships(db, now)
+
+	invoices := func(membershipID int) []string {
+		rows, err := db.Query("SELECT invoice_date || ' ' || total_amount || ' ' || payment_status FROM invoices WHERE membership_id = ? ORDER BY id", membershipID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var got []string
+		for rows.Next() {
+			var s string
+			rows.Scan(&s)
+			got = append(got, s)
+		}
+		return got
+	}
+
+	got := invoices(1)
+	want := []string{"2026-01-15 30.0 Unpaid", "2026-02-15 30.0 Unpaid", "2026-03-15 30.0 Unpaid"}
+	if len(got) != len(want) {
+		t.Fatalf("membership 1 invoices = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("invoice %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	var start, end, next string
+	db.QueryRow("SELECT date(period_start), date(period_end), date(next_billing_on) FROM memberships WHERE id = 1").Scan(&start, &end, &next)
+	if start != "2026-03-15" || end != "2026-04-14" || next != "2026-04-15" {
+		t.Errorf("period = %s to %s, next %s", start, end, next)
+	}
+
+	if got := invoices(2); len(got) != 0 {
+		t.Errorf("paused membership was billed: %v", got)
+	}
+	if got := invoices(3); len(got) != 2*maxCatchUpPeriods {
+		t.Errorf("overdue membership billed %d periods in two runs, want %d", len(got), 2*maxCatchUpPeriods)
+	}
+}
+
+func TestApplyMembershipBenefits(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db,
+		`INSERT INTO membership_plans (id, owner_id, name, price, interval_unit, discount_percent) VALUES (1, 1, 'Gold', 30, 'month', 15)`,
+		`INSERT INTO membership_plan_services (plan_id, service_id, quantity) VALUES (1, 10, 2), (1, 11, NULL)`,
+		`INSERT INTO memberships (id, owner_id, customer_id, plan_id, status, period_start, period_end, next_billing_on) VALUES
+			(1, 1, 5, 1, 'active', '2026-03-01', '2026-03-31', '2026-04-01')`,
+		// One of the two included service 10 visits has been used this period.
+		`INSERT INTO invoices (id, owner_id, customer_id, invoice_date, total_amount, payment_status) VALUES (1, 1, 5, '2026-03-05', 0, 'Paid')`,
+		`INSERT INTO invoice_items (invoice_id, service_id, description, quantity, unit_price, line_total, membership_id) VALUES (1, 10, 'Cut', 1, 0, 0, 1)`,
+	)
+	const today = "2026-03-20"
+
+	items := []invoiceItemInput{
+		{ServiceID: 10, Quantity: 1, UnitPrice: price(40)},
+		{ServiceID: 10, Quantity: 1, UnitPrice: price(40)},
+		{ServiceID: 11, Quantity: 3, UnitPrice: price(15)},
+		{ServiceID: 12, Quantity: 1, UnitPrice: price(25)},
+	}
+	discount, err := applyMembershipBenefits(db, 1, 5, items, 10, today)
+	if err != nil {
+		t.Fatalf("applyMembershipBenefits() error = %v", err)
+	}
+	if discount != 15 {
+		t.Errorf("discount = %v, want the plan's 15", discount)
+	}
+	wantPrices := []float64{0, 40, 0, 25}
+	for i, it := range items {
+		if *it.UnitPrice != wantPrices[i] {
+			t.Errorf("item %d price = %v, want %v", i, *it.UnitPrice, wantPrices[i])
+		}
+		if it.membershipID.Valid != (wantPrices[i] == 0) {
+			t.Errorf("item %d membershipID = %v", i, it.membershipID)
+		}
+	}
+
+	if discount, _ := applyMembershipBenefits(db, 1, 5, nil, 20, today); discount != 20 {
+		t.Errorf("a higher manual discount was replaced by %v", discount)
+	}
+	for _, tt := range []struct {
+		ownerID, customerID int
+		today               string
+	}{
+		{2, 5, today},
+		{1, 6, today},
+		{1, 5, "2026-02-28"},
+	} {
+		items := []invoiceItemInput{{ServiceID: 10, Quantity: 1, UnitPrice: price(40)}}
+		discount, err := applyMembershipBenefits(db, tt.ownerID, tt.customerID, items, 5, tt.today)
+		if err != nil || discount != 5 || *items[0].UnitPrice != 40 {
+			t.Errorf("non-member %+v got discount %v, price %v (%v)", tt, discount, *items[0].UnitPrice, err)
+		}
+	}
+}
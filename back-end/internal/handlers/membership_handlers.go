@@ -0,0 +1,527 @@
+// internal/handlers/membership_handlers.go
+// Handlers for membership plans and customer subscriptions: signing up,
+// pausing, resuming, cancelling and renewing.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+var validIntervalUnits = map[string]bool{
+	"week":  true,
+	"month": true,
+	"year":  true,
+}
+
+// planService is a service included in a membership plan. A nil Quantity
+// means unlimited use each billing period.
+type planService struct {
+	ServiceID int64 `json:"service_id"`
+	Quantity  *int  `json:"quantity"`
+}
+
+type membershipPlan struct {
+	ID               int64         `json:"id"`
+	Name             string        `json:"name"`
+	Price            float64       `json:"price"`
+	IntervalUnit     string        `json:"interval_unit"`
+	IntervalCount    int           `json:"interval_count"`
+	DiscountPercent  float64       `json:"discount_percent"`
+	TaxRateID        *int64        `json:"tax_rate_id"`
+	IncludedServices []planService `json:"included_services"`
+	Active           bool          `json:"active"`
+}
+
+type membership struct {
+	ID            int64  `json:"id"`
+	CustomerID    int64  `json:"customer_id"`
+	PlanID        int64  `json:"plan_id"`
+	PlanName      string `json:"plan_name"`
+	Status        string `json:"status"`
+	PeriodStart   string `json:"period_start,omitempty"`
+	PeriodEnd     string `json:"period_end,omitempty"`
+	NextBillingOn string `json:"next_billing_on,omitempty"`
+	EndsOn        string `json:"ends_on,omitempty"`
+}
+
+// addInterval moves d forward by count billing intervals.
+func addInterval(d time.Time, unit string, count int) time.Time {
+	switch unit {
+	case "week":
+		return d.AddDate(0, 0, 7*count)
+	case "year":
+		return d.AddDate(count, 0, 0)
+	default:
+		return d.AddDate(0, count, 0)
+	}
+}
+
+func loadPlanServices(q queryer, p *membershipPlan) error {
+	rows, err := q.Query("SELECT service_id, quantity FROM membership_plan_services WHERE plan_id = ? ORDER BY service_id", p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s planService
+		var quantity sql.NullInt64
+		if err := rows.Scan(&s.ServiceID, &quantity); err != nil {
+			return err
+		}
+		if quantity.Valid {
+			n := int(quantity.Int64)
+			s.Quantity = &n
+		}
+		p.IncludedServices = append(p.IncludedServices, s)
+	}
+	return rows.Err()
+}
+
+// --- API: List Membership Plans ---
+func APIGetMembershipPlans(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query(`
+        SELECT id, name, price, interval_unit, interval_count, discount_percent, tax_rate_id, active
+        FROM membership_plans WHERE owner_id = ? ORDER BY name`, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch membership plans", http.StatusInternalServerError)
+		return
+	}
+	plans := []*membershipPlan{}
+	for rows.Next() {
+		p := &membershipPlan{IncludedServices: []planService{}}
+		var taxRateID sql.NullInt64
+		if err := rows.Scan(&p.ID, &p.Name, &p.Price, &p.IntervalUnit, &p.IntervalCount, &p.DiscountPercent, &taxRateID, &p.Active); err != nil {
+			log.Printf("Failed to scan membership plan: %v", err)
+			continue
+		}
+		if taxRateID.Valid {
+			p.TaxRateID = &taxRateID.Int64
+		}
+		plans = append(plans, p)
+	}
+	rows.Close()
+	for _, p := range plans {
+		if err := loadPlanServices(db, p); err != nil {
+			log.Printf("Failed to load services for membership plan %d: %v", p.ID, err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plans)
+}
+
+// decodeMembershipPlan reads and validates a membership plan request body.
+func decodeMembershipPlan(w http.ResponseWriter, r *http.Request, ownerID int) (*membershipPlan, bool) {
+	p := &membershipPlan{Active: true, IntervalUnit: "month", IntervalCount: 1}
+	if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return nil, false
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" || len(p.Name) > 100 {
+		http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+		return nil, false
+	}
+	if p.Price < 0 {
+		http.Error(w, "Price cannot be negative", http.StatusBadRequest)
+		return nil, false
+	}
+	if !validIntervalUnits[p.IntervalUnit] || p.IntervalCount < 1 || p.IntervalCount > 12 {
+		http.Error(w, "Billing interval must be 1-12 weeks, months or years", http.StatusBadRequest)
+		return nil, false
+	}
+	if p.DiscountPercent < 0 || p.DiscountPercent > 100 {
+		http.Error(w, "Discount must be between 0 and 100", http.StatusBadRequest)
+		return nil, false
+	}
+	db := database.GetDB()
+	if p.TaxRateID != nil {
+		if _, err := lookupTaxRate(db, ownerID, *p.TaxRateID); err != nil {
+			http.Error(w, "Tax rate not found", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	for _, s := range p.IncludedServices {
+		if s.Quantity != nil && *s.Quantity < 1 {
+			http.Error(w, "Included service quantities must be positive", http.StatusBadRequest)
+			return nil, false
+		}
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM services WHERE id = ? AND owner_id = ?", s.ServiceID, ownerID).Scan(&count)
+		if count == 0 {
+			http.Error(w, fmt.Sprintf("Service %d not found", s.ServiceID), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+// savePlanServices replaces the services included in a plan.
+func savePlanServices(tx *sql.Tx, p *membershipPlan) error {
+	if _, err := tx.Exec("DELETE FROM membership_plan_services WHERE plan_id = ?", p.ID); err != nil {
+		return err
+	}
+	for _, s := range p.IncludedServices {
+		_, err := tx.Exec("INSERT OR REPLACE INTO membership_plan_services (plan_id, service_id, quantity) VALUES (?, ?, ?)",
+			p.ID, s.ServiceID, s.Quantity)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- API: Add Membership Plan ---
+func APIAddMembershipPlan(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	p, ok := decodeMembershipPlan(w, r, ownerID)
+	if !ok {
+		return
+	}
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		http.Error(w, "Failed to add membership plan", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+        INSERT INTO membership_plans (owner_id, name, price, interval_unit, interval_count, discount_percent,
+            tax_rate_id, active, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, p.Name, p.Price, p.IntervalUnit, p.IntervalCount, p.DiscountPercent, p.TaxRateID, p.Active,
+		time.Now(), time.Now())
+	if err != nil {
+		http.Error(w, "Failed to add membership plan", http.StatusInternalServerError)
+		return
+	}
+	p.ID, _ = res.LastInsertId()
+	if err := savePlanServices(tx, p); err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to add membership plan", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": p.ID})
+}
+
+// --- API: Update Membership Plan ---
+// Changes apply to existing members from their next invoice.
+func APIUpdateMembershipPlan(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid membership plan ID", http.StatusBadRequest)
+		return
+	}
+	p, ok := decodeMembershipPlan(w, r, ownerID)
+	if !ok {
+		return
+	}
+	p.ID = int64(id)
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		http.Error(w, "Failed to update membership plan", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+        UPDATE membership_plans SET name = ?, price = ?, interval_unit = ?, interval_count = ?, discount_percent = ?,
+            tax_rate_id = ?, active = ?, updated_at = ?
+        WHERE id = ? AND owner_id = ?`,
+		p.Name, p.Price, p.IntervalUnit, p.IntervalCount, p.DiscountPercent, p.TaxRateID, p.Active, time.Now(),
+		id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to update membership plan", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Membership plan not found", http.StatusNotFound)
+		return
+	}
+	if err := savePlanServices(tx, p); err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to update membership plan", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Membership Plan ---
+// Plans are withdrawn from sale; existing members keep being billed.
+func APIDeleteMembershipPlan(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid membership plan ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	_, err = db.Exec("UPDATE membership_plans SET active = 0, updated_at = ? WHERE id = ? AND owner_id = ?", time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete membership plan", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+const membershipColumns = `m.id, m.customer_id, m.plan_id, p.name, m.status, date(m.period_start), date(m.period_end),
+    date(m.next_billing_on), date(m.ends_on)`
+
+func scanMembership(row rowScanner) (membership, error) {
+	var m membership
+	var periodStart, periodEnd, nextBilling, endsOn sql.NullString
+	err := row.Scan(&m.ID, &m.CustomerID, &m.PlanID, &m.PlanName, &m.Status, &periodStart, &periodEnd, &nextBilling, &endsOn)
+	m.PeriodStart, m.PeriodEnd = periodStart.String, periodEnd.String
+	m.NextBillingOn, m.EndsOn = nextBilling.String, endsOn.String
+	return m, err
+}
+
+// --- API: List Memberships ---
+// Optional ?customer_id= limits the list to one customer.
+func APIGetMemberships(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	query := "SELECT " + membershipColumns + ` FROM memberships m
+        JOIN membership_plans p ON m.plan_id = p.id WHERE m.owner_id = ?`
+	args := []interface{}{ownerID}
+	if c := r.URL.Query().Get("customer_id"); c != "" {
+		customerID, err := strconv.Atoi(c)
+		if err != nil {
+			http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+			return
+		}
+		query += " AND m.customer_id = ?"
+		args = append(args, customerID)
+	}
+	rows, err := database.GetDB().Query(query+" ORDER BY m.created_at DESC", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch memberships", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	memberships := []membership{}
+	for rows.Next() {
+		m, err := scanMembership(rows)
+		if err != nil {
+			log.Printf("Failed to scan membership: %v", err)
+			continue
+		}
+		memberships = append(memberships, m)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(memberships)
+}
+
+// --- API: Add Membership ---
+// Body: {"customer_id": 1, "plan_id": 2, "start_on": "2024-06-01"}. The first
+// invoice is raised on the start date, which defaults to today and can be at
+// most one billing period in the past.
+func APIAddMembership(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		CustomerID int    `json:"customer_id"`
+		PlanID     int    `json:"plan_id"`
+		StartOn    string `json:"start_on"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	today := now.Format("2006-01-02")
+	if req.StartOn == "" {
+		req.StartOn = today
+	}
+	start, err := time.Parse("2006-01-02", req.StartOn)
+	if err != nil {
+		http.Error(w, "Invalid date format (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", req.CustomerID, ownerID).Scan(&count)
+	if count == 0 {
+		http.Error(w, "Customer not found for this salon", http.StatusBadRequest)
+		return
+	}
+	var unit string
+	var intervalCount int
+	err = db.QueryRow("SELECT interval_unit, interval_count FROM membership_plans WHERE id = ? AND owner_id = ? AND active = 1",
+		req.PlanID, ownerID).Scan(&unit, &intervalCount)
+	if err != nil {
+		http.Error(w, "Membership plan not found", http.StatusBadRequest)
+		return
+	}
+	// A membership may be backdated into its current period, but not so far
+	// that it would be billed for periods that have already ended.
+	if addInterval(start, unit, intervalCount).Format("2006-01-02") <= today {
+		http.Error(w, "Start date can't be more than one billing period ago", http.StatusBadRequest)
+		return
+	}
+	res, err := db.Exec(`
+        INSERT INTO memberships (owner_id, customer_id, plan_id, status, next_billing_on, created_at, updated_at)
+        VALUES (?, ?, ?, 'active', ?, ?, ?)`,
+		ownerID, req.CustomerID, req.PlanID, req.StartOn, now, now)
+	if err != nil {
+		http.Error(w, "Failed to add membership", http.StatusInternalServerError)
+		return
+	}
+	membershipID, _ := res.LastInsertId()
+	if err := billMembership(db, membershipID, now); err != nil {
+		log.Printf("Failed to bill membership %d: %v", membershipID, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": membershipID})
+}
+
+// --- API: Change Membership Status ---
+// POST /api/memberships/{id}/{action} where action is pause, resume, cancel
+// or renew. Cancelled members keep their benefits until the end of the period
+// they paid for; renewing within that period simply undoes the cancellation.
+func APIChangeMembership(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid membership ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	m, err := scanMembership(db.QueryRow("SELECT "+membershipColumns+` FROM memberships m
+        JOIN membership_plans p ON m.plan_id = p.id WHERE m.id = ? AND m.owner_id = ?`, id, ownerID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Membership not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch membership", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	today := now.Format("2006-01-02")
+	action := chi.URLParam(r, "action")
+	var query string
+	var args []interface{}
+	switch {
+	case action == "pause" && m.Status == "active":
+		query = "UPDATE memberships SET status = 'paused', paused_at = ?, updated_at = ? WHERE id = ?"
+		args = []interface{}{now, now, id}
+	case action == "resume" && m.Status == "paused":
+		// Periods missed while paused aren't billed; billing restarts today.
+		next := m.NextBillingOn
+		if next == "" || next < today {
+			next = today
+		}
+		query = "UPDATE memberships SET status = 'active', paused_at = NULL, next_billing_on = ?, updated_at = ? WHERE id = ?"
+		args = []interface{}{next, now, id}
+	case action == "cancel" && (m.Status == "active" || m.Status == "paused"):
+		endsOn := m.PeriodEnd
+		if m.Status == "paused" || endsOn == "" || endsOn < today {
+			endsOn = today
+		}
+		query = "UPDATE memberships SET status = 'cancelled', cancelled_at = ?, ends_on = ?, updated_at = ? WHERE id = ?"
+		args = []interface{}{now, endsOn, now, id}
+	case action == "renew" && m.Status == "cancelled":
+		next := m.NextBillingOn
+		if m.EndsOn < today || next == "" || next < today {
+			next = today
+		}
+		query = `UPDATE memberships SET status = 'active', cancelled_at = NULL, ends_on = NULL, next_billing_on = ?,
+            updated_at = ? WHERE id = ?`
+		args = []interface{}{next, now, id}
+	case action == "pause" || action == "resume" || action == "cancel" || action == "renew":
+		http.Error(w, fmt.Sprintf("Can't %s a membership that is %s", action, m.Status), http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	if _, err := db.Exec(query, args...); err != nil {
+		http.Error(w, "Failed to update membership", http.StatusInternalServerError)
+		return
+	}
+	if action == "resume" || action == "renew" {
+		if err := billMembership(db, int64(id), now); err != nil {
+			log.Printf("Failed to bill membership %d: %v", id, err)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
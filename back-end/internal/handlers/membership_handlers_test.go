@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIAddMembershipStartOn(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db,
+		`INSERT INTO owners (id, email, password_hash) VALUES (1, 'a@b.com', 'x')`,
+		`INSERT INTO customers (id, owner_id, name) VALUES (5, 1, 'Jane')`,
+		`INSERT INTO membership_plans (id, owner_id, name, price, interval_unit, interval_count) VALUES
+			(1, 1, 'Monthly', 30, 'month', 1),
+			(2, 1, 'Weekly', 10, 'week', 1)`,
+	)
+	today := time.Now()
+	tests := []struct {
+		name         string
+		planID       int
+		startOn      string
+		wantStatus   int
+		wantInvoices int
+	}{
+		{name: "defaults to today", planID: 1, wantStatus: http.StatusOK, wantInvoices: 1},
+		{name: "within the current period", planID: 1, startOn: today.AddDate(0, 0, -20).Format("2006-01-02"), wantStatus: http.StatusOK, wantInvoices: 1},
+		{name: "starts in the future", planID: 1, startOn: today.AddDate(0, 0, 3).Format("2006-01-02"), wantStatus: http.StatusOK},
+		{name: "more than a period ago", planID: 1, startOn: today.AddDate(0, 0, -40).Format("2006-01-02"), wantStatus: http.StatusBadRequest},
+		{name: "more than a week ago on a weekly plan", planID: 2, startOn: today.AddDate(0, 0, -7).Format("2006-01-02"), wantStatus: http.StatusBadRequest},
+		{name: "years ago", planID: 1, startOn: "2020-01-01", wantStatus: http.StatusBadRequest},
+		{name: "invalid date", planID: 1, startOn: "01/02/2026", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mustExec(t, db, "DELETE FROM memberships", "DELETE FROM invoices")
+			body := fmt.Sprintf(`{"customer_id": 5, "plan_id": %d, "start_on": %q}`, tt.planID, tt.startOn)
+			r := asOwner(httptest.NewRequest(http.MethodPost, "/api/memberships", strings.NewReader(body)), 1)
+			w := httptest.NewRecorder()
+			APIAddMembership(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tt.wantStatus)
+			}
+			var invoices int
+			db.QueryRow("SELECT COUNT(*) FROM invoices").Scan(&invoices)
+			if invoices != tt.wantInvoices {
+				t.Errorf("%d invoices raised, want %d", invoices, tt.wantInvoices)
+			}
+		})
+	}
+}
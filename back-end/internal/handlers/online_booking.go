@@ -0,0 +1,880 @@
+// internal/handlers/online_booking.go
+// The public booking portal: unauthenticated endpoints, scoped by the salon's
+// booking slug, for listing services and free slots and requesting an
+// appointment after confirming a one-time code sent to the customer.
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+	"salon-management/internal/reminders"
+)
+
+// Limits on the public booking portal, to stop it being used to spam
+// customers with codes or fill the diary with fake bookings.
+const (
+	bookingCodeTTL         = 10 * time.Minute
+	bookingSessionTTL      = 30 * time.Minute
+	bookingCodeAttempts    = 5
+	bookingCodesPerContact = 3  // per hour
+	bookingCodesPerIP      = 10 // per hour
+	bookingCodesPerSalon   = 60 // per hour, across all callers
+	bookingPendingLimit    = 3  // unconfirmed online bookings per customer
+)
+
+var (
+	bookingSlugRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	e164Regex        = regexp.MustCompile(`^\+[1-9]\d{9,14}$`)
+	countryCodeRegex = regexp.MustCompile(`^[1-9]\d{0,2}$`)
+)
+
+// bookingSalon is a salon that takes bookings online.
+type bookingSalon struct {
+	ownerID     int
+	name        string
+	address     string
+	phone       string
+	noticeHours int
+	windowDays  int
+	sched       *salonSchedule
+}
+
+// loadBookingSalon finds the salon with online booking turned on for a slug.
+func loadBookingSalon(db *sql.DB, slug string) (*bookingSalon, error) {
+	s := &bookingSalon{}
+	var name, address, phone sql.NullString
+	err := db.QueryRow(`
+        SELECT id, salon_name, address, phone, COALESCE(booking_notice_hours, 2), COALESCE(booking_window_days, 60)
+        FROM owners WHERE booking_slug = ? AND online_booking = 1`, slug).Scan(
+		&s.ownerID, &name, &address, &phone, &s.noticeHours, &s.windowDays)
+	if err != nil {
+		return nil, err
+	}
+	s.name, s.address, s.phone = name.String, address.String, phone.String
+	s.sched, err = loadSalonSchedule(db, s.ownerID)
+	return s, err
+}
+
+// bookingSalonParam loads the salon named by the {slug} URL parameter,
+// writing the error response if there isn't one.
+func bookingSalonParam(w http.ResponseWriter, r *http.Request) (*bookingSalon, bool) {
+	salon, err := loadBookingSalon(database.GetDB(), chi.URLParam(r, "slug"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Salon not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Failed to load salon", http.StatusInternalServerError)
+		return nil, false
+	}
+	return salon, true
+}
+
+// normalizeContact tidies a phone number (to +digits) or email address
+// (lower case) so it can be compared with customer records.
+func normalizeContact(channel, s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	switch channel {
+	case "sms":
+		return normalizePhone(s, "")
+	case "email":
+		addr, err := mail.ParseAddress(s)
+		if err != nil {
+			return "", false
+		}
+		return strings.ToLower(addr.Address), true
+	}
+	return "", false
+}
+
+// normalizePhone puts a phone number into E.164 form (+ and digits). A 00
+// international prefix becomes +, and a national number gets countryCode
+// (digits only, e.g. "44") in place of its leading trunk 0. With no
+// countryCode only international numbers are accepted.
+func normalizePhone(phone, countryCode string) (string, bool) {
+	s := strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "").Replace(strings.TrimSpace(phone))
+	switch {
+	case strings.HasPrefix(s, "+"):
+	case strings.HasPrefix(s, "00"):
+		s = "+" + s[2:]
+	case countryCode != "":
+		s = "+" + countryCode + strings.TrimPrefix(s, "0")
+	}
+	return s, e164Regex.MatchString(s)
+}
+
+// loadPhoneCountryCode returns the owner's phone_country_code, or "" if
+// they haven't set one.
+func loadPhoneCountryCode(q queryer, ownerID int) (string, error) {
+	var code sql.NullString
+	err := q.QueryRow("SELECT phone_country_code FROM owners WHERE id = ?", ownerID).Scan(&code)
+	return code.String, err
+}
+
+// normalizeCustomerPhones rewrites the owner's customer phone numbers that
+// aren't in E.164 form yet using countryCode, so they get a phone_hash and
+// can be matched to online bookings. Numbers that still can't be
+// normalized are left alone. It returns how many were updated.
+func normalizeCustomerPhones(tx *sql.Tx, ownerID int, countryCode string) (int, error) {
+	type customerPhone struct {
+		id    int64
+		phone []byte
+	}
+	rows, err := tx.Query(`SELECT id, phone FROM customers
+        WHERE owner_id = ? AND (phone_hash IS NULL OR phone_hash = '') AND phone IS NOT NULL`, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	var customers []customerPhone
+	for rows.Next() {
+		var c customerPhone
+		if err := rows.Scan(&c.id, &c.phone); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		customers = append(customers, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, c := range customers {
+		if len(c.phone) == 0 {
+			continue
+		}
+		plain, err := decryptField(c.phone)
+		if err != nil || plain == "" {
+			continue
+		}
+		phone, ok := normalizePhone(plain, countryCode)
+		if !ok {
+			continue
+		}
+		encrypted, err := encryptField(phone)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("UPDATE customers SET phone = ?, phone_hash = ? WHERE id = ?",
+			encrypted, contactHash(phone), c.id); err != nil {
+			return 0, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// contactHash is a keyed hash of a normalized contact, so codes can be
+// counted per phone number or email without storing them in the clear.
+func contactHash(contact string) string {
+	mac := hmac.New(sha256.New, encryptionKey)
+	mac.Write([]byte(contact))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hashBookingCode(token, code string) string {
+	sum := sha256.Sum256([]byte(token + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// customerContactHash is the hash kept in a customer's phone_hash or
+// email_hash, or "" if the contact isn't a valid phone number or email.
+func customerContactHash(channel, contact string) string {
+	c, ok := normalizeContact(channel, contact)
+	if !ok {
+		return ""
+	}
+	return contactHash(c)
+}
+
+// findCustomerByContact returns the owner's customer with the given
+// normalized phone number or email, or 0. Contacts are encrypted with a
+// random nonce, so the match is on their phone_hash or email_hash.
+func findCustomerByContact(q queryer, ownerID int, channel, contact string) (int64, error) {
+	column := "phone_hash"
+	if channel == "email" {
+		column = "email_hash"
+	}
+	var id int64
+	err := q.QueryRow("SELECT id FROM customers WHERE owner_id = ? AND "+column+" = ? ORDER BY id LIMIT 1",
+		ownerID, contactHash(contact)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// trustedProxies are the reverse proxies, from the comma-separated IPs or
+// CIDRs in TRUSTED_PROXIES, whose forwarding headers are believed. They are
+// read on first use so a .env file has been loaded.
+var (
+	trustedProxies     []*net.IPNet
+	trustedProxiesOnce sync.Once
+)
+
+func parseTrustedProxies(s string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	trustedProxiesOnce.Do(func() {
+		trustedProxies = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	})
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the caller's address. X-Forwarded-For and X-Real-IP are set
+// by clients too, so they are only used when the connection comes from a
+// trusted proxy, taking the last forwarded address that isn't one.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !isTrustedProxy(ip) {
+		return host
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		if !isTrustedProxy(ip) {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// --- API: Get Online Booking Settings ---
+func APIGetOnlineBookingSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var slug, countryCode sql.NullString
+	var enabled bool
+	var notice, window int
+	err := database.GetDB().QueryRow(`
+        SELECT booking_slug, COALESCE(online_booking, 0), COALESCE(booking_notice_hours, 2), COALESCE(booking_window_days, 60),
+               phone_country_code
+        FROM owners WHERE id = ?`, ownerID).Scan(&slug, &enabled, &notice, &window, &countryCode)
+	if err != nil {
+		http.Error(w, "Failed to fetch online booking settings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":            enabled,
+		"slug":               slug.String,
+		"notice_hours":       notice,
+		"window_days":        window,
+		"phone_country_code": countryCode.String,
+	})
+}
+
+// --- API: Update Online Booking Settings ---
+// Body: {"enabled", "slug", "notice_hours", "window_days", "phone_country_code"}.
+// Customers can book from notice_hours ahead up to window_days ahead.
+// phone_country_code (e.g. "44") is used to put customer phone numbers
+// entered in national format into international form; setting it converts
+// existing customers' numbers too. It is left unchanged when omitted.
+func APIUpdateOnlineBookingSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	req := struct {
+		Enabled     bool    `json:"enabled"`
+		Slug        string  `json:"slug"`
+		NoticeHours int     `json:"notice_hours"`
+		WindowDays  int     `json:"window_days"`
+		CountryCode *string `json:"phone_country_code"`
+	}{NoticeHours: 2, WindowDays: 60}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+	if req.Slug != "" && (len(req.Slug) < 3 || len(req.Slug) > 60 || !bookingSlugRegex.MatchString(req.Slug)) {
+		http.Error(w, "Slug must be 3-60 lowercase letters, numbers and hyphens", http.StatusBadRequest)
+		return
+	}
+	if req.Enabled && req.Slug == "" {
+		http.Error(w, "A slug is required to take bookings online", http.StatusBadRequest)
+		return
+	}
+	if req.NoticeHours < 0 || req.NoticeHours > 168 {
+		http.Error(w, "Notice must be 0-168 hours", http.StatusBadRequest)
+		return
+	}
+	if req.WindowDays < 1 || req.WindowDays > 365 {
+		http.Error(w, "Booking window must be 1-365 days", http.StatusBadRequest)
+		return
+	}
+	if req.CountryCode != nil {
+		code := strings.TrimPrefix(strings.TrimSpace(*req.CountryCode), "+")
+		if code != "" && !countryCodeRegex.MatchString(code) {
+			http.Error(w, "Phone country code must be 1-3 digits, e.g. 44", http.StatusBadRequest)
+			return
+		}
+		req.CountryCode = &code
+	}
+	db := database.GetDB()
+	if req.Slug != "" {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM owners WHERE booking_slug = ? AND id != ?", req.Slug, ownerID).Scan(&count)
+		if count > 0 {
+			http.Error(w, "That slug is already taken", http.StatusConflict)
+			return
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to save online booking settings", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+        UPDATE owners SET online_booking = ?, booking_slug = ?, booking_notice_hours = ?, booking_window_days = ?, updated_at = ?
+        WHERE id = ?`,
+		req.Enabled, nullIfEmpty(req.Slug), req.NoticeHours, req.WindowDays, time.Now(), ownerID)
+	if err != nil {
+		http.Error(w, "Failed to save online booking settings", http.StatusInternalServerError)
+		return
+	}
+	if req.CountryCode != nil {
+		_, err = tx.Exec("UPDATE owners SET phone_country_code = ? WHERE id = ?", nullIfEmpty(*req.CountryCode), ownerID)
+		if err == nil && *req.CountryCode != "" {
+			_, err = normalizeCustomerPhones(tx, ownerID, *req.CountryCode)
+		}
+		if err != nil {
+			http.Error(w, "Failed to save online booking settings", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to save online booking settings", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- Public: Salon Booking Page ---
+// Returns the salon's details, its services bookable online and its staff.
+func BookingSalon(w http.ResponseWriter, r *http.Request) {
+	salon, ok := bookingSalonParam(w, r)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	type bookableService struct {
+		ID       int64   `json:"id"`
+		Name     string  `json:"name"`
+		Category string  `json:"category"`
+		Price    float64 `json:"price"`
+		Duration int     `json:"duration_minutes"`
+	}
+	services := []bookableService{}
+	rows, err := db.Query(`
+        SELECT id, name, COALESCE(category, ''), price, COALESCE(duration_minutes, 30) FROM services
+        WHERE owner_id = ? AND active = 1 AND online_booking = 1 ORDER BY category, name`, salon.ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch services", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s bookableService
+		if err := rows.Scan(&s.ID, &s.Name, &s.Category, &s.Price, &s.Duration); err != nil {
+			log.Printf("Failed to scan service: %v", err)
+			continue
+		}
+		services = append(services, s)
+	}
+
+	type bookableStaff struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	staff := []bookableStaff{}
+	staffRows, err := db.Query("SELECT id, name FROM staff WHERE owner_id = ? AND active = 1 ORDER BY name", salon.ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch staff", http.StatusInternalServerError)
+		return
+	}
+	defer staffRows.Close()
+	for staffRows.Next() {
+		var s bookableStaff
+		if err := staffRows.Scan(&s.ID, &s.Name); err != nil {
+			log.Printf("Failed to scan staff member: %v", err)
+			continue
+		}
+		staff = append(staff, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"salon_name":   salon.name,
+		"address":      salon.address,
+		"phone":        salon.phone,
+		"timezone":     salon.sched.loc.String(),
+		"notice_hours": salon.noticeHours,
+		"window_days":  salon.windowDays,
+		"services":     services,
+		"staff":        staff,
+	})
+}
+
+// --- Public: Availability ---
+// Like the staff availability search, for "service_id" and optionally
+// "staff_id", but limited to services bookable online and to the salon's
+// booking window.
+func BookingAvailability(w http.ResponseWriter, r *http.Request) {
+	salon, ok := bookingSalonParam(w, r)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	now := time.Now()
+	from, to, err := availabilityRange(r, salon.sched.loc, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serviceID, err := strconv.ParseInt(r.URL.Query().Get("service_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid service ID", http.StatusBadRequest)
+		return
+	}
+	var duration, buffer int
+	err = db.QueryRow(`
+        SELECT COALESCE(duration_minutes, 30), COALESCE(buffer_minutes, 0) FROM services
+        WHERE id = ? AND owner_id = ? AND active = 1 AND online_booking = 1`, serviceID, salon.ownerID).Scan(&duration, &buffer)
+	if err != nil {
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	needs, err := loadServiceResources(db, serviceID)
+	if err != nil {
+		http.Error(w, "Failed to work out availability", http.StatusInternalServerError)
+		return
+	}
+	var staffID int64
+	if v := r.URL.Query().Get("staff_id"); v != "" {
+		if staffID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "Invalid staff ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	today := now.In(salon.sched.loc)
+	last := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, salon.sched.loc).AddDate(0, 0, salon.windowDays)
+	if to.After(last) {
+		to = last
+	}
+	slots := []availableSlot{}
+	if !from.After(to) {
+		earliest := now.Add(time.Duration(salon.noticeHours) * time.Hour)
+		found, err := findAvailableSlots(db, salon.ownerID, salon.sched, staffID, duration, buffer, needs, from, to, earliest, 0)
+		if err != nil {
+			http.Error(w, "Failed to work out availability", http.StatusInternalServerError)
+			return
+		}
+		slots = append(slots, found...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"timezone":         salon.sched.loc.String(),
+		"duration_minutes": duration,
+		"slots":            slots,
+	})
+}
+
+// --- Public: Send Booking Code ---
+// Body: {"channel": "sms"|"email", "contact"}. Sends a six-digit code and
+// returns the verification_id to confirm it against.
+func SendBookingCode(w http.ResponseWriter, r *http.Request) {
+	salon, ok := bookingSalonParam(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Channel string `json:"channel"`
+		Contact string `json:"contact"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	contact, ok := normalizeContact(req.Channel, req.Contact)
+	if !ok {
+		http.Error(w, "Give a channel of sms with a phone number in international format, or email with an email address", http.StatusBadRequest)
+		return
+	}
+
+	db := database.GetDB()
+	now := time.Now()
+	hash := contactHash(contact)
+	ip := clientIP(r)
+	var perContact, perIP, perSalon int
+	db.QueryRow("SELECT COUNT(*) FROM booking_verifications WHERE owner_id = ? AND contact_hash = ? AND created_at > ?",
+		salon.ownerID, hash, now.Add(-time.Hour)).Scan(&perContact)
+	db.QueryRow("SELECT COUNT(*) FROM booking_verifications WHERE ip = ? AND created_at > ?", ip, now.Add(-time.Hour)).Scan(&perIP)
+	db.QueryRow("SELECT COUNT(*) FROM booking_verifications WHERE owner_id = ? AND created_at > ?",
+		salon.ownerID, now.Add(-time.Hour)).Scan(&perSalon)
+	if perContact >= bookingCodesPerContact || perIP >= bookingCodesPerIP || perSalon >= bookingCodesPerSalon {
+		http.Error(w, "Too many codes requested, please try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		http.Error(w, "Failed to send code", http.StatusInternalServerError)
+		return
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		http.Error(w, "Failed to send code", http.StatusInternalServerError)
+		return
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	encrypted, err := encryptField(contact)
+	if err != nil {
+		http.Error(w, "Failed to send code", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.Exec(`
+        INSERT INTO booking_verifications (owner_id, token, channel, contact, contact_hash, code_hash, ip, expires_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		salon.ownerID, token, req.Channel, encrypted, hash, hashBookingCode(token, code), ip, now.Add(bookingCodeTTL), now)
+	if err != nil {
+		http.Error(w, "Failed to send code", http.StatusInternalServerError)
+		return
+	}
+
+	message := fmt.Sprintf("Your %s booking code is %s. It expires in %d minutes.", salon.name, code, int(bookingCodeTTL.Minutes()))
+	if req.Channel == "sms" {
+		err = reminders.SendSMS(contact, message)
+	} else {
+		err = reminders.SendEmail(contact, "Your booking code", message)
+	}
+	if err != nil {
+		log.Printf("Failed to send booking code for owner %d: %v", salon.ownerID, err)
+		http.Error(w, "Failed to send code", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"verification_id": token,
+		"expires_in":      int(bookingCodeTTL.Seconds()),
+	})
+}
+
+// --- Public: Check Booking Code ---
+// Body: {"code"}. A correct code verifies the phone number or email for
+// bookingSessionTTL; known_customer tells the page whether to ask for a name.
+func CheckBookingCode(w http.ResponseWriter, r *http.Request) {
+	salon, ok := bookingSalonParam(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	token := chi.URLParam(r, "verificationID")
+	db := database.GetDB()
+	var id int64
+	var channel, codeHash string
+	var contact []byte
+	var expiresAt time.Time
+	var verifiedAt sql.NullTime
+	err := db.QueryRow(`
+        SELECT id, channel, contact, code_hash, expires_at, verified_at FROM booking_verifications
+        WHERE token = ? AND owner_id = ?`, token, salon.ownerID).Scan(
+		&id, &channel, &contact, &codeHash, &expiresAt, &verifiedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Verification not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	if verifiedAt.Valid {
+		http.Error(w, "This code has already been used", http.StatusBadRequest)
+		return
+	}
+	if now.After(expiresAt) {
+		http.Error(w, "This code has expired, please request a new one", http.StatusBadRequest)
+		return
+	}
+	// Count the attempt before comparing, so concurrent guesses can't get
+	// past the limit.
+	res, err := db.Exec("UPDATE booking_verifications SET attempts = attempts + 1 WHERE id = ? AND attempts < ?",
+		id, bookingCodeAttempts)
+	if err != nil {
+		http.Error(w, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		http.Error(w, "Failed to check code", http.StatusInternalServerError)
+		return
+	} else if n == 0 {
+		http.Error(w, "Too many attempts, please request a new code", http.StatusTooManyRequests)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hashBookingCode(token, strings.TrimSpace(req.Code))), []byte(codeHash)) != 1 {
+		http.Error(w, "Incorrect code", http.StatusBadRequest)
+		return
+	}
+
+	plain, err := decryptField(contact)
+	if err != nil {
+		http.Error(w, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	customerID, err := findCustomerByContact(db, salon.ownerID, channel, plain)
+	if err != nil {
+		http.Error(w, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	// From here on expires_at bounds how long the verified contact can be
+	// used to book.
+	_, err = db.Exec("UPDATE booking_verifications SET verified_at = ?, expires_at = ?, customer_id = ? WHERE id = ?",
+		now, now.Add(bookingSessionTTL), sql.NullInt64{Int64: customerID, Valid: customerID != 0}, id)
+	if err != nil {
+		http.Error(w, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"verified":       true,
+		"known_customer": customerID != 0,
+	})
+}
+
+// --- Public: Request Appointment ---
+// Body: {"verification_id", "name", "service_id", "staff_id", "start",
+// "notes"}. The name is only needed for new customers. The appointment is
+// held as pending until the salon confirms it.
+func CreateOnlineBooking(w http.ResponseWriter, r *http.Request) {
+	salon, ok := bookingSalonParam(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		VerificationID string `json:"verification_id"`
+		Name           string `json:"name"`
+		appointmentBooking
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Notes = strings.TrimSpace(req.Notes)
+
+	db := database.GetDB()
+	now := time.Now()
+	var verificationID int64
+	var channel string
+	var contact []byte
+	var customerID, appointmentID sql.NullInt64
+	var expiresAt time.Time
+	var verifiedAt sql.NullTime
+	err := db.QueryRow(`
+        SELECT id, channel, contact, customer_id, appointment_id, expires_at, verified_at FROM booking_verifications
+        WHERE token = ? AND owner_id = ?`, req.VerificationID, salon.ownerID).Scan(
+		&verificationID, &channel, &contact, &customerID, &appointmentID, &expiresAt, &verifiedAt)
+	if err == sql.ErrNoRows || (err == nil && !verifiedAt.Valid) {
+		http.Error(w, "Please verify your phone number or email first", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		return
+	}
+	if appointmentID.Valid {
+		http.Error(w, "This verification has already been used for a booking", http.StatusBadRequest)
+		return
+	}
+	if now.After(expiresAt) {
+		http.Error(w, "Your verification has expired, please request a new code", http.StatusUnauthorized)
+		return
+	}
+
+	var online bool
+	db.QueryRow("SELECT COALESCE(online_booking, 0) FROM services WHERE id = ? AND owner_id = ? AND active = 1",
+		req.ServiceID, salon.ownerID).Scan(&online)
+	if !online {
+		http.Error(w, "Service not found", http.StatusBadRequest)
+		return
+	}
+	start, err := parseSalonTime(req.Start, salon.sched.loc)
+	if err != nil {
+		http.Error(w, "Invalid start time", http.StatusBadRequest)
+		return
+	}
+	if start.Before(now.Add(time.Duration(salon.noticeHours) * time.Hour)) {
+		http.Error(w, fmt.Sprintf("Online bookings need at least %d hours' notice", salon.noticeHours), http.StatusBadRequest)
+		return
+	}
+	today := now.In(salon.sched.loc)
+	last := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, salon.sched.loc).AddDate(0, 0, salon.windowDays+1)
+	if !start.Before(last) {
+		http.Error(w, fmt.Sprintf("Online bookings can only be made up to %d days ahead", salon.windowDays), http.StatusBadRequest)
+		return
+	}
+
+	plain, err := decryptField(contact)
+	if err != nil {
+		http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if !customerID.Valid {
+		// The customer may have been added since the code was checked.
+		id, err := findCustomerByContact(tx, salon.ownerID, channel, plain)
+		if err != nil {
+			http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+			return
+		}
+		if id == 0 {
+			if req.Name == "" || len(req.Name) > 100 {
+				http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+				return
+			}
+			phone, email := "", ""
+			if channel == "sms" {
+				phone = plain
+			} else {
+				email = plain
+			}
+			encryptedPhone, err1 := encryptField(phone)
+			encryptedEmail, err2 := encryptField(email)
+			if err1 != nil || err2 != nil {
+				http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+				return
+			}
+			res, err := tx.Exec(
+				"INSERT INTO customers (name, phone, email, phone_hash, email_hash, birthday, anniversary, owner_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				req.Name, encryptedPhone, encryptedEmail, customerContactHash("sms", phone), customerContactHash("email", email),
+				"", "", salon.ownerID, now)
+			if err != nil {
+				http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+				return
+			}
+			id, _ = res.LastInsertId()
+		}
+		customerID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	var pending int
+	tx.QueryRow("SELECT COUNT(*) FROM appointments WHERE customer_id = ? AND status = 'pending' AND source = 'online'",
+		customerID.Int64).Scan(&pending)
+	if pending >= bookingPendingLimit {
+		http.Error(w, "You already have bookings waiting for the salon to confirm", http.StatusTooManyRequests)
+		return
+	}
+
+	b := req.appointmentBooking
+	b.CustomerID = customerID.Int64
+	id, deposit, err := bookAppointment(tx, salon.ownerID, salon.sched, b, "online", "pending", now)
+	if err != nil {
+		if _, ok := err.(invoiceInputError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else if err == errSlotUnavailable {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			log.Printf("Failed to book online appointment: %v", err)
+			http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		}
+		return
+	}
+	if _, err := tx.Exec("UPDATE booking_verifications SET customer_id = ?, appointment_id = ? WHERE id = ?",
+		customerID.Int64, id, verificationID); err != nil {
+		http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":               id,
+		"status":           "pending",
+		"start":            start.Format(time.RFC3339),
+		"deposit_required": deposit,
+	})
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"salon-management/internal/database"
+)
+
+func TestNormalizeContact(t *testing.T) {
+	tests := []struct {
+		channel, in string
+		want        string
+		ok          bool
+	}{
+		{"sms", "+1 (555) 000-1111", "+15550001111", true},
+		{"sms", " +447700900123 ", "+447700900123", true},
+		{"sms", "5550001111", "5550001111", false},
+		{"sms", "+0123456789", "+0123456789", false},
+		{"sms", "+1555", "+1555", false},
+		{"email", " Jane Doe <Jane@Example.COM> ", "jane@example.com", true},
+		{"email", "JANE@example.com", "jane@example.com", true},
+		{"email", "not an email", "", false},
+		{"fax", "+15550001111", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeContact(tt.channel, tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalizeContact(%q, %q) = %q, %v; want %q, %v", tt.channel, tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHashBookingCode(t *testing.T) {
+	h := hashBookingCode("token", "123456")
+	if h == hashBookingCode("token", "123457") || h == hashBookingCode("other", "123456") {
+		t.Error("hashBookingCode() collides across codes or tokens")
+	}
+	if h != hashBookingCode("token", "123456") {
+		t.Error("hashBookingCode() isn't deterministic")
+	}
+	if len(h) != 64 {
+		t.Errorf("hashBookingCode() = %q, want hex SHA-256", h)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxiesOnce.Do(func() {
+		trustedProxies = parseTrustedProxies("127.0.0.1, 10.0.0.0/8, bogus")
+	})
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "forwarding headers from an untrusted caller are ignored", remoteAddr: "203.0.113.7:5000",
+			forwarded: "198.51.100.1", realIP: "198.51.100.2", want: "203.0.113.7"},
+		{name: "through a trusted proxy", remoteAddr: "127.0.0.1:5000", forwarded: "198.51.100.1", want: "198.51.100.1"},
+		{name: "spoofed hops before the last untrusted one are ignored", remoteAddr: "127.0.0.1:5000",
+			forwarded: "192.0.2.99, 198.51.100.1, 10.1.2.3", want: "198.51.100.1"},
+		{name: "X-Real-IP from a trusted proxy", remoteAddr: "10.0.0.5:5000", realIP: "198.51.100.2", want: "198.51.100.2"},
+		{name: "trusted proxy without headers", remoteAddr: "127.0.0.1:5000", want: "127.0.0.1"},
+		{name: "unparseable hop", remoteAddr: "127.0.0.1:5000", forwarded: "unknown", want: "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/book/glow/verify", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("%s: clientIP() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckBookingCodeAttempts(t *testing.T) {
+	db := openTestDB(t)
+	contact, err := encryptField("+15550001111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db, `INSERT INTO owners (id, email, password_hash, booking_slug, online_booking) VALUES (1, 'a@b.com', 'x', 'glow', 1)`)
+	expires := time.Now().Add(10 * time.Minute)
+	for _, token := range []string{"guessed", "found", "expired"} {
+		e := expires
+		if token == "expired" {
+			e = time.Now().Add(-time.Minute)
+		}
+		_, err := db.Exec(`INSERT INTO booking_verifications (owner_id, token, channel, contact, contact_hash, code_hash, expires_at)
+			VALUES (1, ?, 'sms', ?, 'h', ?, ?)`, token, contact, hashBookingCode(token, "123456"), e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(token, code string) (int, string) {
+		r := httptest.NewRequest(http.MethodPost, "/api/book/glow/verify/"+token, strings.NewReader(`{"code": "`+code+`"}`))
+		r = withURLParams(r, "slug", "glow", "verificationID", token)
+		w := httptest.NewRecorder()
+		CheckBookingCode(w, r)
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	for i := 0; i < bookingCodeAttempts; i++ {
+		if code, body := check("guessed", "000000"); code != http.StatusBadRequest || body != "Incorrect code" {
+			t.Fatalf("attempt %d = %d %q, want 400 Incorrect code", i+1, code, body)
+		}
+	}
+	if code, _ := check("guessed", "123456"); code != http.StatusTooManyRequests {
+		t.Errorf("the right code after %d wrong ones = %d, want 429", bookingCodeAttempts, code)
+	}
+
+	check("found", "000000")
+	if code, body := check("found", " 123456 "); code != http.StatusOK {
+		t.Fatalf("the right code = %d %q, want 200", code, body)
+	}
+	var attempts int
+	var verified bool
+	db.QueryRow("SELECT attempts, verified_at IS NOT NULL FROM booking_verifications WHERE token = 'found'").Scan(&attempts, &verified)
+	if attempts != 2 || !verified {
+		t.Errorf("after verifying: attempts = %d, verified = %v", attempts, verified)
+	}
+	if code, body := check("found", "123456"); code != http.StatusBadRequest || body != "This code has already been used" {
+		t.Errorf("reusing a code = %d %q", code, body)
+	}
+
+	if code, _ := check("expired", "123456"); code != http.StatusBadRequest {
+		t.Errorf("expired code = %d, want 400", code)
+	}
+	if code, _ := check("unknown", "123456"); code != http.StatusNotFound {
+		t.Errorf("unknown verification = %d, want 404", code)
+	}
+}
+
+func TestFindCustomerByContact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "salon.db") + "?_synchronous=OFF"
+	db, err := database.InitDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Customers saved before contact hashes existed.
+	for _, c := range []struct {
+		id, ownerID  int
+		phone, email string
+	}{
+		{1, 1, "+1 (555) 000-1111", "Jane@Example.com"},
+		{2, 1, "", "john@example.com"},
+		{3, 2, "+15550001111", "jane@example.com"},
+	} {
+		phone, _ := encryptField(c.phone)
+		email, _ := encryptField(c.email)
+		if _, err := db.Exec("INSERT INTO customers (id, owner_id, name, phone, email) VALUES (?, ?, 'x', ?, ?)",
+			c.id, c.ownerID, phone, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	db, err = database.InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB didn't backfill contact hashes: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	tests := []struct {
+		ownerID          int
+		channel, contact string
+		want             int64
+	}{
+		{1, "sms", "+15550001111", 1},
+		{2, "sms", "+15550001111", 3},
+		{1, "email", "jane@example.com", 1},
+		{1, "email", "john@example.com", 2},
+		{1, "sms", "+15550002222", 0},
+		{3, "email", "jane@example.com", 0},
+	}
+	for _, tt := range tests {
+		got, err := findCustomerByContact(db, tt.ownerID, tt.channel, tt.contact)
+		if err != nil || got != tt.want {
+			t.Errorf("findCustomerByContact(%d, %s, %s) = %d (%v), want %d", tt.ownerID, tt.channel, tt.contact, got, err, tt.want)
+		}
+	}
+	var blank string
+	db.QueryRow("SELECT phone_hash FROM customers WHERE id = 2").Scan(&blank)
+	if blank != "" {
+		t.Errorf("customer without a phone got phone_hash %q", blank)
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone, countryCode string
+		want               string
+		ok                 bool
+	}{
+		{"+44 7700 900123", "", "+447700900123", true},
+		{"0044 7700 900123", "", "+447700900123", true},
+		{"07700 900123", "44", "+447700900123", true},
+		{"(555) 000-1111", "1", "+15550001111", true},
+		{"555.000.1111", "1", "+15550001111", true},
+		{"+1 555 000 1111", "44", "+15550001111", true},
+		{"07700 900123", "", "07700900123", false},
+		{"12", "44", "+4412", false},
+		{"call me", "44", "+44callme", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizePhone(tt.phone, tt.countryCode)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalizePhone(%q, %q) = %q, %v, want %q, %v", tt.phone, tt.countryCode, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAPIAddCustomerPhone(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO owners (id, email, password_hash, phone_country_code) VALUES
+		(1, 'a@example.com', 'x', NULL), (2, 'b@example.com', 'x', '44')`)
+
+	tests := []struct {
+		ownerID    int
+		phone      string
+		wantStatus int
+		wantPhone  string
+	}{
+		{1, "+44 7700 900123", http.StatusOK, "+447700900123"},
+		{1, "07700 900123", http.StatusBadRequest, ""},
+		{2, "07700 900123", http.StatusOK, "+447700900123"},
+		{2, "not a number", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		body := `{"name": "Jane", "phone": "` + tt.phone + `"}`
+		r := asOwner(httptest.NewRequest("POST", "/api/customers", strings.NewReader(body)), tt.ownerID)
+		w := httptest.NewRecorder()
+		APIAddCustomer(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("owner %d, phone %q: status = %d, want %d", tt.ownerID, tt.phone, w.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		got, err := findCustomerByContact(db, tt.ownerID, "sms", tt.wantPhone)
+		if err != nil || got == 0 {
+			t.Errorf("owner %d, phone %q: customer not found by %s (%v)", tt.ownerID, tt.phone, tt.wantPhone, err)
+			continue
+		}
+		var encrypted []byte
+		db.QueryRow("SELECT phone FROM customers WHERE id = ?", got).Scan(&encrypted)
+		if phone, _ := decryptField(encrypted); phone != tt.wantPhone {
+			t.Errorf("owner %d, phone %q: stored %q, want %q", tt.ownerID, tt.phone, phone, tt.wantPhone)
+		}
+	}
+}
+
+func TestAPIUpdateOnlineBookingSettingsCountryCode(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO owners (id, email, password_hash) VALUES (1, 'a@example.com', 'x'), (2, 'b@example.com', 'x')`)
+	for _, c := range []struct {
+		id, ownerID int
+		phone, hash string
+	}{
+		{1, 1, "07700 900123", ""},
+		{2, 1, "+15550001111", customerContactHash("sms", "+15550001111")},
+		{3, 1, "12", ""},
+		{4, 2, "07700 900456", ""},
+	} {
+		phone, _ := encryptField(c.phone)
+		if _, err := db.Exec("INSERT INTO customers (id, owner_id, name, phone, phone_hash) VALUES (?, ?, 'x', ?, ?)",
+			c.id, c.ownerID, phone, c.hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update := func(body string) int {
+		r := asOwner(httptest.NewRequest("PUT", "/api/online-booking", strings.NewReader(body)), 1)
+		w := httptest.NewRecorder()
+		APIUpdateOnlineBookingSettings(w, r)
+		return w.Code
+	}
+	if code := update(`{"phone_country_code": "4a"}`); code != http.StatusBadRequest {
+		t.Errorf("invalid country code: status = %d, want 400", code)
+	}
+	if code := update(`{"phone_country_code": "+44"}`); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if code, _ := loadPhoneCountryCode(db, 1); code != "44" {
+		t.Errorf("phone_country_code = %q, want 44", code)
+	}
+	for _, tt := range []struct {
+		ownerID int
+		phone   string
+		want    int64
+	}{
+		{1, "+447700900123", 1},
+		{1, "+15550001111", 2},
+		{2, "+447700900456", 0},
+	} {
+		if got, err := findCustomerByContact(db, tt.ownerID, "sms", tt.phone); err != nil || got != tt.want {
+			t.Errorf("findCustomerByContact(%d, %s) = %d (%v), want %d", tt.ownerID, tt.phone, got, err, tt.want)
+		}
+	}
+	var encrypted []byte
+	db.QueryRow("SELECT phone FROM customers WHERE id = 3").Scan(&encrypted)
+	if phone, _ := decryptField(encrypted); phone != "12" {
+		t.Errorf("unnormalizable phone = %q, want it left as 12", phone)
+	}
+
+	if code := update(`{"notice_hours": 4, "window_days": 30}`); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if code, _ := loadPhoneCountryCode(db, 1); code != "44" {
+		t.Errorf("phone_country_code = %q after omitting it, want 44 kept", code)
+	}
+}
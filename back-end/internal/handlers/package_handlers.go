@@ -0,0 +1,420 @@
+// internal/handlers/package_handlers.go
+// Handlers for prepaid service packages: the package catalogue, crediting
+// sessions when a package is sold and using them up on later invoices.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// servicePackage is a package in the salon's catalogue, e.g. ten blow-dries
+// valid for six months. A session can be used for any of its services.
+type servicePackage struct {
+	ID           int64   `json:"id"`
+	Name         string  `json:"name"`
+	Price        float64 `json:"price"`
+	Sessions     int     `json:"sessions"`
+	ValidityDays int     `json:"validity_days"`
+	ServiceIDs   []int64 `json:"service_ids"`
+	Active       bool    `json:"active"`
+}
+
+type customerPackageTransaction struct {
+	Kind           string `json:"kind"`
+	Sessions       int    `json:"sessions"`
+	RemainingAfter int    `json:"remaining_after"`
+	InvoiceID      *int64 `json:"invoice_id,omitempty"`
+	ServiceID      *int64 `json:"service_id,omitempty"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// customerPackage is a package a customer has bought, with its session
+// history.
+type customerPackage struct {
+	ID                int64                        `json:"id"`
+	PackageID         int64                        `json:"package_id"`
+	Name              string                       `json:"name"`
+	SessionsTotal     int                          `json:"sessions_total"`
+	SessionsRemaining int                          `json:"sessions_remaining"`
+	ExpiresOn         string                       `json:"expires_on,omitempty"`
+	Expired           bool                         `json:"expired"`
+	InvoiceID         *int64                       `json:"invoice_id,omitempty"`
+	CreatedAt         string                       `json:"created_at"`
+	Transactions      []customerPackageTransaction `json:"transactions"`
+}
+
+func loadPackageServices(q queryer, p *servicePackage) error {
+	rows, err := q.Query("SELECT service_id FROM package_services WHERE package_id = ? ORDER BY service_id", p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		p.ServiceIDs = append(p.ServiceIDs, id)
+	}
+	return rows.Err()
+}
+
+// resolvePackageSale fills in an invoice line selling a package from the
+// catalogue. Packages are sold one per line.
+func resolvePackageSale(q queryer, ownerID int, it *invoiceItemInput) error {
+	if it.ServiceID != 0 {
+		return invoiceInputError("An item can't be both a service and a package")
+	}
+	p := &servicePackage{ID: it.PackageID}
+	err := q.QueryRow(
+		"SELECT name, price, sessions, validity_days FROM packages WHERE id = ? AND owner_id = ? AND active = 1",
+		it.PackageID, ownerID,
+	).Scan(&p.Name, &p.Price, &p.Sessions, &p.ValidityDays)
+	if err == sql.ErrNoRows {
+		return invoiceInputError(fmt.Sprintf("Package %d not found", it.PackageID))
+	} else if err != nil {
+		return err
+	}
+	if it.Quantity != 1 {
+		return invoiceInputError("Packages are sold one per line")
+	}
+	if it.Description == "" {
+		it.Description = p.Name
+	}
+	if it.UnitPrice == nil {
+		it.UnitPrice = &p.Price
+	}
+	it.pkg = p
+	return nil
+}
+
+// issueCustomerPackage credits customerID with the sessions of a package sold
+// on invoiceID.
+func issueCustomerPackage(tx *sql.Tx, ownerID, customerID int, invoiceID int64, p *servicePackage, now time.Time) error {
+	expiresOn := now.AddDate(0, 0, p.ValidityDays).Format("2006-01-02")
+	res, err := tx.Exec(`
+        INSERT INTO customer_packages (owner_id, customer_id, package_id, name, sessions_total, sessions_remaining,
+            expires_on, invoice_id, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, customerID, p.ID, p.Name, p.Sessions, p.Sessions, expiresOn, invoiceID, now, now)
+	if err != nil {
+		return err
+	}
+	cpID, _ := res.LastInsertId()
+	_, err = tx.Exec(`
+        INSERT INTO customer_package_transactions (customer_package_id, kind, sessions, remaining_after, invoice_id, created_at)
+        VALUES (?, 'issue', ?, ?, ?, ?)`,
+		cpID, p.Sessions, p.Sessions, invoiceID, now)
+	return err
+}
+
+// redeemCustomerPackage uses sessions from the customer's package covering
+// serviceID, choosing the one that expires first. It returns an invalid
+// NullInt64 when no package covers the service.
+func redeemCustomerPackage(tx *sql.Tx, ownerID, customerID int, serviceID int64, sessions int, invoiceID int64, now time.Time) (sql.NullInt64, error) {
+	var cpID int64
+	var remaining int
+	err := tx.QueryRow(`
+        SELECT cp.id, cp.sessions_remaining
+        FROM customer_packages cp
+        JOIN package_services ps ON ps.package_id = cp.package_id
+        WHERE cp.owner_id = ? AND cp.customer_id = ? AND ps.service_id = ?
+            AND cp.sessions_remaining >= ? AND (cp.expires_on IS NULL OR date(cp.expires_on) >= ?)
+        ORDER BY cp.expires_on IS NULL, date(cp.expires_on), cp.id
+        LIMIT 1`,
+		ownerID, customerID, serviceID, sessions, now.Format("2006-01-02"),
+	).Scan(&cpID, &remaining)
+	if err == sql.ErrNoRows {
+		return sql.NullInt64{}, nil
+	} else if err != nil {
+		return sql.NullInt64{}, err
+	}
+	remaining -= sessions
+	if _, err := tx.Exec("UPDATE customer_packages SET sessions_remaining = ?, updated_at = ? WHERE id = ?", remaining, now, cpID); err != nil {
+		return sql.NullInt64{}, err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO customer_package_transactions (customer_package_id, kind, sessions, remaining_after, invoice_id, service_id, created_at)
+        VALUES (?, 'redeem', ?, ?, ?, ?, ?)`,
+		cpID, -sessions, remaining, invoiceID, serviceID, now)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	return sql.NullInt64{Int64: cpID, Valid: true}, nil
+}
+
+// loadCustomerPackages returns every package a customer has bought, newest
+// first, with its session history.
+func loadCustomerPackages(db *sql.DB, ownerID, customerID int) ([]customerPackage, error) {
+	rows, err := db.Query(`
+        SELECT id, package_id, name, sessions_total, sessions_remaining, date(expires_on), invoice_id, created_at
+        FROM customer_packages WHERE owner_id = ? AND customer_id = ?
+        ORDER BY created_at DESC`, ownerID, customerID)
+	if err != nil {
+		return nil, err
+	}
+	today := time.Now().Format("2006-01-02")
+	packages := []customerPackage{}
+	for rows.Next() {
+		var cp customerPackage
+		var expiresOn sql.NullString
+		var invoiceID sql.NullInt64
+		if err := rows.Scan(&cp.ID, &cp.PackageID, &cp.Name, &cp.SessionsTotal, &cp.SessionsRemaining,
+			&expiresOn, &invoiceID, &cp.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cp.ExpiresOn = expiresOn.String
+		cp.Expired = cp.ExpiresOn != "" && today > cp.ExpiresOn
+		if invoiceID.Valid {
+			cp.InvoiceID = &invoiceID.Int64
+		}
+		packages = append(packages, cp)
+	}
+	rows.Close()
+
+	for i := range packages {
+		cp := &packages[i]
+		cp.Transactions = []customerPackageTransaction{}
+		rows, err := db.Query(`
+            SELECT kind, sessions, remaining_after, invoice_id, service_id, created_at
+            FROM customer_package_transactions WHERE customer_package_id = ? ORDER BY id`, cp.ID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var t customerPackageTransaction
+			var invoiceID, serviceID sql.NullInt64
+			if err := rows.Scan(&t.Kind, &t.Sessions, &t.RemainingAfter, &invoiceID, &serviceID, &t.CreatedAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if invoiceID.Valid {
+				t.InvoiceID = &invoiceID.Int64
+			}
+			if serviceID.Valid {
+				t.ServiceID = &serviceID.Int64
+			}
+			cp.Transactions = append(cp.Transactions, t)
+		}
+		rows.Close()
+	}
+	return packages, nil
+}
+
+// --- API: List Packages ---
+func APIGetPackages(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query(
+		"SELECT id, name, price, sessions, validity_days, active FROM packages WHERE owner_id = ? ORDER BY name", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch packages", http.StatusInternalServerError)
+		return
+	}
+	packages := []*servicePackage{}
+	for rows.Next() {
+		p := &servicePackage{ServiceIDs: []int64{}}
+		if err := rows.Scan(&p.ID, &p.Name, &p.Price, &p.Sessions, &p.ValidityDays, &p.Active); err != nil {
+			log.Printf("Failed to scan package: %v", err)
+			continue
+		}
+		packages = append(packages, p)
+	}
+	rows.Close()
+	for _, p := range packages {
+		if err := loadPackageServices(db, p); err != nil {
+			log.Printf("Failed to load services for package %d: %v", p.ID, err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(packages)
+}
+
+// decodePackage reads and validates a package request body.
+func decodePackage(w http.ResponseWriter, r *http.Request, ownerID int) (*servicePackage, bool) {
+	p := &servicePackage{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return nil, false
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" || len(p.Name) > 100 {
+		http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+		return nil, false
+	}
+	if p.Price < 0 {
+		http.Error(w, "Price cannot be negative", http.StatusBadRequest)
+		return nil, false
+	}
+	if p.Sessions < 1 || p.ValidityDays < 1 {
+		http.Error(w, "Sessions and validity days must be positive", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(p.ServiceIDs) == 0 {
+		http.Error(w, "A package needs at least one service", http.StatusBadRequest)
+		return nil, false
+	}
+	db := database.GetDB()
+	for _, sid := range p.ServiceIDs {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM services WHERE id = ? AND owner_id = ?", sid, ownerID).Scan(&count)
+		if count == 0 {
+			http.Error(w, fmt.Sprintf("Service %d not found", sid), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+// savePackageServices replaces the services a package covers.
+func savePackageServices(tx *sql.Tx, p *servicePackage) error {
+	if _, err := tx.Exec("DELETE FROM package_services WHERE package_id = ?", p.ID); err != nil {
+		return err
+	}
+	for _, sid := range p.ServiceIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO package_services (package_id, service_id) VALUES (?, ?)", p.ID, sid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- API: Add Package ---
+func APIAddPackage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	p, ok := decodePackage(w, r, ownerID)
+	if !ok {
+		return
+	}
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		http.Error(w, "Failed to add package", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+        INSERT INTO packages (owner_id, name, price, sessions, validity_days, active, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, p.Name, p.Price, p.Sessions, p.ValidityDays, p.Active, time.Now(), time.Now())
+	if err != nil {
+		http.Error(w, "Failed to add package", http.StatusInternalServerError)
+		return
+	}
+	p.ID, _ = res.LastInsertId()
+	if err := savePackageServices(tx, p); err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to add package", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": p.ID})
+}
+
+// --- API: Update Package ---
+// Packages already sold keep their session count and expiry, but the
+// services they cover follow the catalogue.
+func APIUpdatePackage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid package ID", http.StatusBadRequest)
+		return
+	}
+	p, ok := decodePackage(w, r, ownerID)
+	if !ok {
+		return
+	}
+	p.ID = int64(id)
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		http.Error(w, "Failed to update package", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+        UPDATE packages SET name = ?, price = ?, sessions = ?, validity_days = ?, active = ?, updated_at = ?
+        WHERE id = ? AND owner_id = ?`,
+		p.Name, p.Price, p.Sessions, p.ValidityDays, p.Active, time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to update package", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Package not found", http.StatusNotFound)
+		return
+	}
+	if err := savePackageServices(tx, p); err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to update package", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Package ---
+// Packages are withdrawn from sale; customers who bought one can still use it.
+func APIDeletePackage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid package ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	_, err = db.Exec("UPDATE packages SET active = 0, updated_at = ? WHERE id = ? AND owner_id = ?", time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete package", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
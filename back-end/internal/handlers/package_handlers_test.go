@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestRedeemCustomerPackage(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO package_services (package_id, service_id) VALUES (1, 10), (1, 11), (2, 10)`,
+		// Customer 5 of salon 1 holds, in id order: a package expiring later,
+		// one expiring sooner, an expired one and one that never expires.
+		`INSERT INTO customer_packages (id, owner_id, customer_id, package_id, name, sessions_total, sessions_remaining, expires_on, created_at, updated_at) VALUES
+			(1, 1, 5, 1, 'Blow-dry x5', 5, 4, '2026-09-01', '2026-01-01', '2026-01-01'),
+			(2, 1, 5, 1, 'Blow-dry x5', 5, 2, '2026-04-01', '2026-01-01', '2026-01-01'),
+			(3, 1, 5, 1, 'Blow-dry x5', 5, 5, '2026-03-09', '2026-01-01', '2026-01-01'),
+			(4, 1, 5, 2, 'Cut x10', 10, 6, NULL, '2026-01-01', '2026-01-01')`,
+	)
+
+	tests := []struct {
+		name          string
+		ownerID       int
+		customerID    int
+		serviceID     int64
+		sessions      int
+		want          int64
+		wantRemaining int
+	}{
+		{name: "soonest expiry first", ownerID: 1, customerID: 5, serviceID: 11, sessions: 1, want: 2, wantRemaining: 1},
+		{name: "skips packages without enough sessions", ownerID: 1, customerID: 5, serviceID: 11, sessions: 3, want: 1, wantRemaining: 1},
+		{name: "packages that never expire are used last", ownerID: 1, customerID: 5, serviceID: 10, sessions: 1, want: 2, wantRemaining: 1},
+		{name: "falls back to a package that never expires", ownerID: 1, customerID: 5, serviceID: 10, sessions: 5, want: 4, wantRemaining: 1},
+		{name: "service not in any package", ownerID: 1, customerID: 5, serviceID: 12, sessions: 1},
+		{name: "another customer", ownerID: 1, customerID: 6, serviceID: 10, sessions: 1},
+		{name: "another salon", ownerID: 2, customerID: 5, serviceID: 10, sessions: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			got, err := redeemCustomerPackage(tx, tt.ownerID, tt.customerID, tt.serviceID, tt.sessions, 9, now)
+			if err != nil {
+				t.Fatalf("redeemCustomerPackage() error = %v", err)
+			}
+			if want := (sql.NullInt64{Int64: tt.want, Valid: tt.want != 0}); got != want {
+				t.Fatalf("redeemCustomerPackage() = %v, want %v", got, want)
+			}
+			if !got.Valid {
+				return
+			}
+			var remaining, ledger int
+			tx.QueryRow("SELECT sessions_remaining FROM customer_packages WHERE id = ?", got.Int64).Scan(&remaining)
+			if remaining != tt.wantRemaining {
+				t.Errorf("sessions_remaining = %d, want %d", remaining, tt.wantRemaining)
+			}
+			tx.QueryRow(`SELECT COUNT(*) FROM customer_package_transactions
+				WHERE customer_package_id = ? AND kind = 'redeem' AND sessions = ? AND remaining_after = ? AND invoice_id = 9`,
+				got.Int64, -tt.sessions, tt.wantRemaining).Scan(&ledger)
+			if ledger != 1 {
+				t.Errorf("%d redeem transactions recorded, want 1", ledger)
+			}
+		})
+	}
+}
@@ -0,0 +1,517 @@
+// internal/handlers/product_handlers.go
+// Handlers for retail products: the product catalogue, stock adjustments and
+// the stock taken off when a product is sold on an invoice.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// product is a retail product in the salon's catalogue. StockOnHand can only
+// be set when the product is added; after that it changes through sales and
+// stock adjustments so every change is in the stock ledger. Backroom products
+// are used up by services rather than sold, and count stock (and cost) in
+// Unit, e.g. grams of colour.
+type product struct {
+	ID                int64   `json:"id"`
+	Name              string  `json:"name"`
+	SKU               string  `json:"sku"`
+	Barcode           string  `json:"barcode"`
+	Category          string  `json:"category"`
+	CostPrice         float64 `json:"cost_price"`
+	RetailPrice       float64 `json:"retail_price"`
+	TaxRateID         *int64  `json:"tax_rate_id"`
+	SupplierID        *int64  `json:"supplier_id"`
+	Unit              string  `json:"unit"`
+	Backroom          bool    `json:"backroom"`
+	StockOnHand       int     `json:"stock_on_hand"`
+	LowStockThreshold *int    `json:"low_stock_threshold"`
+	LowStock          bool    `json:"low_stock"`
+	Active            bool    `json:"active"`
+}
+
+type stockMovement struct {
+	ID         int64  `json:"id"`
+	Kind       string `json:"kind"`
+	Quantity   int    `json:"quantity"`
+	StockAfter int    `json:"stock_after"`
+	Reason     string `json:"reason,omitempty"`
+	InvoiceID  *int64 `json:"invoice_id,omitempty"`
+	Note       string `json:"note,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// validStockReasons are the reasons a stock adjustment can be recorded with.
+var validStockReasons = map[string]bool{
+	"damage":       true,
+	"internal_use": true,
+	"theft":        true,
+	"return":       true,
+	"count":        true,
+	"other":        true,
+}
+
+const productColumns = `id, name, COALESCE(sku, ''), COALESCE(barcode, ''), COALESCE(category, ''), cost_price,
+    retail_price, tax_rate_id, supplier_id, COALESCE(unit, ''), COALESCE(backroom, 0), stock_on_hand, low_stock_threshold, active`
+
+func scanProduct(row rowScanner) (product, error) {
+	var p product
+	var taxRateID, supplierID, threshold sql.NullInt64
+	err := row.Scan(&p.ID, &p.Name, &p.SKU, &p.Barcode, &p.Category, &p.CostPrice,
+		&p.RetailPrice, &taxRateID, &supplierID, &p.Unit, &p.Backroom, &p.StockOnHand, &threshold, &p.Active)
+	if taxRateID.Valid {
+		p.TaxRateID = &taxRateID.Int64
+	}
+	if supplierID.Valid {
+		p.SupplierID = &supplierID.Int64
+	}
+	if threshold.Valid {
+		t := int(threshold.Int64)
+		p.LowStockThreshold = &t
+		p.LowStock = p.StockOnHand <= t
+	}
+	return p, err
+}
+
+// loadLowStockProducts lists active products at or below their low-stock
+// threshold.
+func loadLowStockProducts(q queryer, ownerID int) ([]product, error) {
+	rows, err := q.Query("SELECT "+productColumns+` FROM products
+        WHERE owner_id = ? AND active = 1 AND low_stock_threshold IS NOT NULL AND stock_on_hand <= low_stock_threshold
+        ORDER BY name`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	products := []product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
+
+// moveStock changes a product's stock on hand by quantity and records the
+// movement in the stock ledger. Stock can't go below zero, except through
+// backroom "usage", which is recorded after the fact.
+func moveStock(tx *sql.Tx, productID int64, quantity int, kind, reason string, invoiceID sql.NullInt64, note string, now time.Time) (int, error) {
+	res, err := tx.Exec(
+		"UPDATE products SET stock_on_hand = stock_on_hand + ?, updated_at = ? WHERE id = ? AND (? OR stock_on_hand + ? >= 0)",
+		quantity, now, productID, kind == "usage", quantity)
+	if err != nil {
+		return 0, err
+	}
+	var name string
+	var stock int
+	if err := tx.QueryRow("SELECT name, stock_on_hand FROM products WHERE id = ?", productID).Scan(&name, &stock); err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return stock, invoiceInputError(fmt.Sprintf("Only %d of %s in stock", stock, name))
+	}
+	_, err = tx.Exec(`
+        INSERT INTO stock_movements (product_id, kind, quantity, stock_after, reason, invoice_id, note, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		productID, kind, quantity, stock, nullIfEmpty(reason), invoiceID, nullIfEmpty(note), now)
+	return stock, err
+}
+
+// resolveProductSale fills in an invoice line selling a retail product from
+// the catalogue. Products are sold in whole units.
+func resolveProductSale(q queryer, ownerID int, it *invoiceItemInput) error {
+	if it.ServiceID != 0 || it.PackageID != 0 {
+		return invoiceInputError("An item can't be both a product and a service or package")
+	}
+	if it.Usage != nil {
+		return invoiceInputError("Product usage can only be recorded on services")
+	}
+	p, err := scanProduct(q.QueryRow("SELECT "+productColumns+" FROM products WHERE id = ? AND owner_id = ? AND active = 1",
+		it.ProductID, ownerID))
+	if err == sql.ErrNoRows {
+		return invoiceInputError(fmt.Sprintf("Product %d not found", it.ProductID))
+	} else if err != nil {
+		return err
+	}
+	if p.Backroom {
+		return invoiceInputError(fmt.Sprintf("%s is a backroom product and can't be sold", p.Name))
+	}
+	if it.Quantity < 1 || it.Quantity != math.Trunc(it.Quantity) {
+		return invoiceInputError("Products are sold in whole units")
+	}
+	if it.Description == "" {
+		it.Description = p.Name
+	}
+	if it.UnitPrice == nil {
+		it.UnitPrice = &p.RetailPrice
+	}
+	if it.TaxRateID == 0 && p.TaxRateID != nil {
+		it.TaxRateID = *p.TaxRateID
+	}
+	it.product = &p
+	return nil
+}
+
+// --- API: List Products ---
+// "q" searches name, SKU and barcode; low_stock=1 lists only products that
+// need reordering.
+func APIGetProducts(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	if r.URL.Query().Get("low_stock") == "1" {
+		products, err := loadLowStockProducts(db, ownerID)
+		if err != nil {
+			http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(products)
+		return
+	}
+	query := "SELECT " + productColumns + " FROM products WHERE owner_id = ?"
+	args := []interface{}{ownerID}
+	if search := strings.TrimSpace(r.URL.Query().Get("q")); search != "" {
+		query += " AND (name LIKE ? OR sku = ? OR barcode = ?)"
+		args = append(args, "%"+search+"%", search, search)
+	}
+	rows, err := db.Query(query+" ORDER BY name", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	products := []product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			log.Printf("Failed to scan product: %v", err)
+			continue
+		}
+		products = append(products, p)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(products)
+}
+
+// decodeProduct reads and validates a product request body. id is the
+// product being updated, or 0 for a new one.
+func decodeProduct(w http.ResponseWriter, r *http.Request, ownerID int, id int) (product, bool) {
+	p := product{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return p, false
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	p.SKU = strings.TrimSpace(p.SKU)
+	p.Barcode = strings.TrimSpace(p.Barcode)
+	p.Category = strings.TrimSpace(p.Category)
+	p.Unit = strings.TrimSpace(p.Unit)
+	if p.Name == "" || len(p.Name) > 100 {
+		http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+		return p, false
+	}
+	if len(p.SKU) > 50 || len(p.Barcode) > 50 || len(p.Category) > 50 {
+		http.Error(w, "SKU, barcode and category are limited to 50 characters", http.StatusBadRequest)
+		return p, false
+	}
+	if len(p.Unit) > 10 {
+		http.Error(w, "Unit is too long (max 10 characters)", http.StatusBadRequest)
+		return p, false
+	}
+	if p.CostPrice < 0 || p.RetailPrice < 0 {
+		http.Error(w, "Prices cannot be negative", http.StatusBadRequest)
+		return p, false
+	}
+	if p.StockOnHand < 0 || (p.LowStockThreshold != nil && *p.LowStockThreshold < 0) {
+		http.Error(w, "Stock levels cannot be negative", http.StatusBadRequest)
+		return p, false
+	}
+	db := database.GetDB()
+	if p.TaxRateID != nil {
+		if _, err := lookupTaxRate(db, ownerID, *p.TaxRateID); err != nil {
+			http.Error(w, "Tax rate not found", http.StatusBadRequest)
+			return p, false
+		}
+	}
+	if p.SupplierID != nil {
+		if err := lookupSupplier(db, ownerID, *p.SupplierID); err != nil {
+			http.Error(w, "Supplier not found", http.StatusBadRequest)
+			return p, false
+		}
+	}
+	if p.SKU != "" {
+		var existing int
+		err := db.QueryRow("SELECT id FROM products WHERE owner_id = ? AND sku = ? AND id != ?", ownerID, p.SKU, id).Scan(&existing)
+		if err == nil {
+			http.Error(w, "SKU is already used by another product", http.StatusBadRequest)
+			return p, false
+		} else if err != sql.ErrNoRows {
+			http.Error(w, "Failed to check SKU", http.StatusInternalServerError)
+			return p, false
+		}
+	}
+	return p, true
+}
+
+// --- API: Add Product ---
+func APIAddProduct(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	p, ok := decodeProduct(w, r, ownerID, 0)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to add product", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	res, err := tx.Exec(`
+        INSERT INTO products (owner_id, name, sku, barcode, category, cost_price, retail_price, tax_rate_id,
+            supplier_id, unit, backroom, low_stock_threshold, active, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, p.Name, nullIfEmpty(p.SKU), nullIfEmpty(p.Barcode), nullIfEmpty(p.Category), p.CostPrice, p.RetailPrice,
+		p.TaxRateID, p.SupplierID, nullIfEmpty(p.Unit), p.Backroom, p.LowStockThreshold, p.Active, now, now)
+	if err != nil {
+		http.Error(w, "Failed to add product", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	if p.StockOnHand > 0 {
+		if _, err := moveStock(tx, id, p.StockOnHand, "opening", "", sql.NullInt64{}, "", now); err != nil {
+			http.Error(w, "Failed to record opening stock", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to add product", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+}
+
+// --- API: Update Product ---
+func APIUpdateProduct(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	p, ok := decodeProduct(w, r, ownerID, id)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec(`
+        UPDATE products SET name = ?, sku = ?, barcode = ?, category = ?, cost_price = ?, retail_price = ?,
+            tax_rate_id = ?, supplier_id = ?, unit = ?, backroom = ?, low_stock_threshold = ?, active = ?, updated_at = ?
+        WHERE id = ? AND owner_id = ?`,
+		p.Name, nullIfEmpty(p.SKU), nullIfEmpty(p.Barcode), nullIfEmpty(p.Category), p.CostPrice, p.RetailPrice,
+		p.TaxRateID, p.SupplierID, nullIfEmpty(p.Unit), p.Backroom, p.LowStockThreshold, p.Active, time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to update product", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Product ---
+// Products are deactivated rather than removed so invoice and stock history
+// keeps them.
+func APIDeleteProduct(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	_, err = db.Exec("UPDATE products SET active = 0, updated_at = ? WHERE id = ? AND owner_id = ?", time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete product", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Adjust Stock ---
+// Adds or removes stock outside of sales, e.g. damaged or used in the salon.
+// quantity is signed; a "count" adjustment corrects stock after a stocktake.
+func APIAdjustStock(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Quantity int    `json:"quantity"`
+		Reason   string `json:"reason"`
+		Note     string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.ToLower(strings.TrimSpace(req.Reason))
+	req.Note = strings.TrimSpace(req.Note)
+	if req.Quantity == 0 {
+		http.Error(w, "Quantity cannot be zero", http.StatusBadRequest)
+		return
+	}
+	if !validStockReasons[req.Reason] {
+		http.Error(w, "Reason must be one of damage, internal_use, theft, return, count or other", http.StatusBadRequest)
+		return
+	}
+	if len(req.Note) > 200 {
+		http.Error(w, "Note is too long (max 200 characters)", http.StatusBadRequest)
+		return
+	}
+
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to adjust stock", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	var exists int
+	if err := tx.QueryRow("SELECT 1 FROM products WHERE id = ? AND owner_id = ?", id, ownerID).Scan(&exists); err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	stock, err := moveStock(tx, id, req.Quantity, "adjustment", req.Reason, sql.NullInt64{}, req.Note, time.Now())
+	if err != nil {
+		if _, ok := err.(invoiceInputError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to adjust stock", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to adjust stock", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"stock_on_hand": stock})
+}
+
+// --- API: Stock History ---
+func APIGetStockMovements(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query(`
+        SELECT m.id, m.kind, m.quantity, m.stock_after, COALESCE(m.reason, ''), m.invoice_id, COALESCE(m.note, ''), m.created_at
+        FROM stock_movements m
+        JOIN products p ON m.product_id = p.id
+        WHERE m.product_id = ? AND p.owner_id = ?
+        ORDER BY m.id DESC`, id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch stock history", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	movements := []stockMovement{}
+	for rows.Next() {
+		var m stockMovement
+		var invoiceID sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.Kind, &m.Quantity, &m.StockAfter, &m.Reason, &invoiceID, &m.Note, &m.CreatedAt); err != nil {
+			log.Printf("Failed to scan stock movement: %v", err)
+			continue
+		}
+		if invoiceID.Valid {
+			m.InvoiceID = &invoiceID.Int64
+		}
+		movements = append(movements, m)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(movements)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestMoveStock(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO products (id, owner_id, name, retail_price, stock_on_hand) VALUES (1, 1, 'Shampoo', 12, 5)`)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		quantity  int
+		wantStock int
+		wantErr   string
+	}{
+		{name: "sale", quantity: -2, wantStock: 3},
+		{name: "selling the last units", quantity: -5, wantStock: 0},
+		{name: "restock", quantity: 10, wantStock: 15},
+		{name: "more than is in stock", quantity: -6, wantStock: 5, wantErr: "Only 5 of Shampoo in stock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			stock, err := moveStock(tx, 1, tt.quantity, "sale", "", sql.NullInt64{Int64: 9, Valid: true}, "", now)
+			if tt.wantErr != "" {
+				if _, ok := err.(invoiceInputError); !ok || err.Error() != tt.wantErr {
+					t.Fatalf("moveStock() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("moveStock() error = %v", err)
+			}
+			if stock != tt.wantStock {
+				t.Errorf("moveStock() = %d, want %d", stock, tt.wantStock)
+			}
+			var onHand, movements int
+			tx.QueryRow("SELECT stock_on_hand FROM products WHERE id = 1").Scan(&onHand)
+			tx.QueryRow("SELECT COUNT(*) FROM stock_movements WHERE product_id = 1 AND quantity = ? AND stock_after = ?",
+				tt.quantity, tt.wantStock).Scan(&movements)
+			if onHand != tt.wantStock {
+				t.Errorf("stock_on_hand = %d, want %d", onHand, tt.wantStock)
+			}
+			if want := map[bool]int{true: 0, false: 1}[tt.wantErr != ""]; movements != want {
+				t.Errorf("%d stock movements recorded, want %d", movements, want)
+			}
+		})
+	}
+}
+
+func TestLoadLowStockProducts(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO products (id, owner_id, name, retail_price, stock_on_hand, low_stock_threshold, active) VALUES
+		(1, 1, 'Shampoo', 12, 3, 3, 1),
+		(2, 1, 'Conditioner', 12, 4, 3, 1),
+		(3, 1, 'Brush', 8, 0, NULL, 1),
+		(4, 1, 'Old gel', 5, 0, 2, 0),
+		(5, 1, 'Clay', 15, 1, 2, 1),
+		(6, 2, 'Wax', 9, 0, 2, 1)`)
+
+	products, err := loadLowStockProducts(db, 1)
+	if err != nil {
+		t.Fatalf("loadLowStockProducts() error = %v", err)
+	}
+	var names []string
+	for _, p := range products {
+		if !p.LowStock {
+			t.Errorf("%s isn't flagged as low on stock", p.Name)
+		}
+		names = append(names, p.Name)
+	}
+	if len(names) != 2 || names[0] != "Clay" || names[1] != "Shampoo" {
+		t.Errorf("low stock products = %v, want [Clay Shampoo]", names)
+	}
+}
+
+func TestResolveProductSale(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO products (id, owner_id, name, retail_price, tax_rate_id, active) VALUES
+		(1, 1, 'Shampoo', 12, 4, 1),
+		(2, 1, 'Old gel', 5, NULL, 0)`)
+
+	it := invoiceItemInput{ProductID: 1, Quantity: 2}
+	if err := resolveProductSale(db, 1, &it); err != nil {
+		t.Fatalf("resolveProductSale() error = %v", err)
+	}
+	if it.Description != "Shampoo" || *it.UnitPrice != 12 || it.TaxRateID != 4 || it.product == nil {
+		t.Errorf("resolved line = %+v", it)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		ownerID int
+		item    invoiceItemInput
+		wantErr string
+	}{
+		{"inactive product", 1, invoiceItemInput{ProductID: 2, Quantity: 1}, "Product 2 not found"},
+		{"another salon's product", 2, invoiceItemInput{ProductID: 1, Quantity: 1}, "Product 1 not found"},
+		{"part units", 1, invoiceItemInput{ProductID: 1, Quantity: 1.5}, "Products are sold in whole units"},
+		{"also a service", 1, invoiceItemInput{ProductID: 1, ServiceID: 3, Quantity: 1}, "An item can't be both a product and a service or package"},
+	} {
+		err := resolveProductSale(db, tt.ownerID, &tt.item)
+		if _, ok := err.(invoiceInputError); !ok || err.Error() != tt.wantErr {
+			t.Errorf("%s: resolveProductSale() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
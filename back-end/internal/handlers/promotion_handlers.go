@@ -0,0 +1,625 @@
+// internal/handlers/promotion_handlers.go
+// Handlers for owner-managed promotions and the rules that apply them to
+// invoices.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+type promotion struct {
+	ID                 int64   `json:"id"`
+	Name               string  `json:"name"`
+	Code               string  `json:"code,omitempty"`
+	Rule               string  `json:"rule"`
+	Kind               string  `json:"kind"`
+	Value              float64 `json:"value"`
+	StartsOn           string  `json:"starts_on,omitempty"`
+	EndsOn             string  `json:"ends_on,omitempty"`
+	MinSpend           float64 `json:"min_spend"`
+	MaxUses            *int    `json:"max_uses"`
+	MaxUsesPerCustomer *int    `json:"max_uses_per_customer"`
+	ServiceIDs         []int64 `json:"service_ids"`
+	Active             bool    `json:"active"`
+
+	// codeID is the promotion_codes row when a single-use code was entered.
+	codeID int64
+}
+
+// automaticRules are the promotion rules applied without a code. Each
+// reports whether the customer qualifies on the given day.
+var automaticRules = map[string]func(q queryer, customerID int, today time.Time) (bool, error){
+	"birthday_month": inBirthdayMonth,
+}
+
+var promoCodeRegex = regexp.MustCompile(`^[A-Z0-9_-]{3,30}$`)
+
+// singleUseRule is the rule of promotions that are only redeemed with codes
+// issued to one customer each, such as win-back offers.
+const singleUseRule = "single_use"
+
+// inBirthdayMonth reports whether today falls in the customer's birthday month.
+func inBirthdayMonth(q queryer, customerID int, today time.Time) (bool, error) {
+	var birthday sql.NullString
+	if err := q.QueryRow("SELECT birthday FROM customers WHERE id = ?", customerID).Scan(&birthday); err != nil {
+		return false, err
+	}
+	if len(birthday.String) < 10 {
+		return false, nil
+	}
+	b, err := time.Parse("2006-01-02", birthday.String[:10])
+	if err != nil {
+		return false, nil
+	}
+	return b.Month() == today.Month(), nil
+}
+
+const promotionColumns = `id, name, code, rule, kind, value, date(starts_on), date(ends_on), min_spend,
+    max_uses, max_uses_per_customer, active`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPromotion(row rowScanner) (*promotion, error) {
+	p := &promotion{ServiceIDs: []int64{}}
+	var code, startsOn, endsOn sql.NullString
+	var maxUses, maxPerCustomer sql.NullInt64
+	err := row.Scan(&p.ID, &p.Name, &code, &p.Rule, &p.Kind, &p.Value, &startsOn, &endsOn, &p.MinSpend,
+		&maxUses, &maxPerCustomer, &p.Active)
+	if err != nil {
+		return nil, err
+	}
+	p.Code, p.StartsOn, p.EndsOn = code.String, startsOn.String, endsOn.String
+	if maxUses.Valid {
+		n := int(maxUses.Int64)
+		p.MaxUses = &n
+	}
+	if maxPerCustomer.Valid {
+		n := int(maxPerCustomer.Int64)
+		p.MaxUsesPerCustomer = &n
+	}
+	return p, nil
+}
+
+// loadPromotionServices fills in the services a promotion is restricted to.
+func loadPromotionServices(q queryer, p *promotion) error {
+	rows, err := q.Query("SELECT service_id FROM promotion_services WHERE promotion_id = ? ORDER BY service_id", p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		p.ServiceIDs = append(p.ServiceIDs, id)
+	}
+	return rows.Err()
+}
+
+// appliesTo reports whether an invoice line is eligible for the promotion.
+// Gift card sales are never discounted.
+func (p *promotion) appliesTo(it invoiceItemInput) bool {
+	if it.GiftCard != nil {
+		return false
+	}
+	if len(p.ServiceIDs) == 0 {
+		return true
+	}
+	for _, id := range p.ServiceIDs {
+		if id == it.ServiceID {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAvailable verifies the promotion is running today and still has uses
+// left overall and for this customer.
+func (p *promotion) checkAvailable(q queryer, customerID int, today string) error {
+	if !p.Active || (p.StartsOn != "" && today < p.StartsOn) || (p.EndsOn != "" && today > p.EndsOn) {
+		return invoiceInputError(p.Name + " is not currently valid")
+	}
+	if p.MaxUses != nil {
+		var used int
+		if err := q.QueryRow("SELECT COUNT(*) FROM invoice_promotions WHERE promotion_id = ?", p.ID).Scan(&used); err != nil {
+			return err
+		}
+		if used >= *p.MaxUses {
+			return invoiceInputError(p.Name + " has been fully redeemed")
+		}
+	}
+	if p.MaxUsesPerCustomer != nil {
+		var used int
+		err := q.QueryRow("SELECT COUNT(*) FROM invoice_promotions WHERE promotion_id = ? AND customer_id = ?",
+			p.ID, customerID).Scan(&used)
+		if err != nil {
+			return err
+		}
+		if used >= *p.MaxUsesPerCustomer {
+			return invoiceInputError("This customer has already used " + p.Name)
+		}
+	}
+	return nil
+}
+
+// recordInvoicePromotion records the promotion used on an invoice. Uses
+// are counted again inside the invoice's transaction so two invoices saved
+// at once can't both take a promotion's last use.
+func recordInvoicePromotion(tx *sql.Tx, invoiceID int64, customerID int, p *promotion, amount float64, now time.Time) error {
+	if err := p.checkAvailable(tx, customerID, now.Format("2006-01-02")); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+        INSERT INTO invoice_promotions (invoice_id, promotion_id, customer_id, name, code, discount_amount, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		invoiceID, p.ID, customerID, p.Name, nullIfEmpty(p.Code), amount, now)
+	if err != nil || p.codeID == 0 {
+		return err
+	}
+	res, err := tx.Exec("UPDATE promotion_codes SET invoice_id = ?, used_at = ? WHERE id = ? AND invoice_id IS NULL",
+		invoiceID, now, p.codeID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return invoiceInputError("This code has already been used")
+	}
+	return nil
+}
+
+// discountFor works out the promotion's discount on items after the manual
+// discount percentage. Only lines for the promotion's services count towards
+// it; the minimum spend is checked against the subtotal excluding gift cards.
+func (p *promotion) discountFor(items []invoiceItemInput, discount float64) (float64, error) {
+	var subtotal, base float64
+	for _, it := range items {
+		if it.GiftCard != nil {
+			continue
+		}
+		line := round2(it.Quantity * *it.UnitPrice)
+		subtotal += line
+		if p.appliesTo(it) {
+			base += line * (1 - discount/100)
+		}
+	}
+	if subtotal < p.MinSpend {
+		return 0, invoiceInputError(fmt.Sprintf("%s needs a minimum spend of %s", p.Name, formatMoney(p.MinSpend)))
+	}
+	if base <= 0 {
+		return 0, invoiceInputError(p.Name + " doesn't apply to any item on this invoice")
+	}
+	amount := p.Value
+	if p.Kind == "percentage" {
+		amount = base * p.Value / 100
+	}
+	if amount > base {
+		amount = base
+	}
+	return round2(amount), nil
+}
+
+// allocate spreads amount over the eligible lines in proportion to their
+// value so each line's tax is calculated on its discounted price.
+func (p *promotion) allocate(items []invoiceItemInput, discount, amount float64) {
+	var base float64
+	var eligible []int
+	for i, it := range items {
+		if p.appliesTo(it) && *it.UnitPrice > 0 {
+			base += round2(it.Quantity**it.UnitPrice) * (1 - discount/100)
+			eligible = append(eligible, i)
+		}
+	}
+	remaining := amount
+	for n, i := range eligible {
+		share := remaining
+		if n < len(eligible)-1 {
+			share = round2(amount * round2(items[i].Quantity**items[i].UnitPrice) * (1 - discount/100) / base)
+		}
+		items[i].promoDiscount = share
+		remaining = round2(remaining - share)
+	}
+}
+
+// selectPromotion picks the promotion for a new invoice: the coupon code if
+// one was entered, otherwise the automatic rule that saves the customer the
+// most. Only one promotion applies per invoice. The discount is allocated to
+// the items before it returns.
+func selectPromotion(q queryer, ownerID, customerID int, code string, items []invoiceItemInput, discount float64, today time.Time) (*promotion, float64, error) {
+	day := today.Format("2006-01-02")
+	if code != "" {
+		p, err := scanPromotion(q.QueryRow(
+			"SELECT "+promotionColumns+" FROM promotions WHERE owner_id = ? AND code = ? AND rule = 'code'",
+			ownerID, strings.ToUpper(code)))
+		if err == sql.ErrNoRows {
+			p, err = singleUsePromotion(q, ownerID, customerID, strings.ToUpper(code), day)
+		}
+		if err == sql.ErrNoRows {
+			return nil, 0, invoiceInputError("Promotion code not found")
+		} else if err != nil {
+			return nil, 0, err
+		}
+		if err := loadPromotionServices(q, p); err != nil {
+			return nil, 0, err
+		}
+		if err := p.checkAvailable(q, customerID, day); err != nil {
+			return nil, 0, err
+		}
+		amount, err := p.discountFor(items, discount)
+		if err != nil {
+			return nil, 0, err
+		}
+		p.allocate(items, discount, amount)
+		return p, amount, nil
+	}
+
+	rows, err := q.Query("SELECT "+promotionColumns+" FROM promotions WHERE owner_id = ? AND rule != 'code' AND active = 1", ownerID)
+	if err != nil {
+		return nil, 0, err
+	}
+	var candidates []*promotion
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		candidates = append(candidates, p)
+	}
+	rows.Close()
+
+	var best *promotion
+	var bestAmount float64
+	for _, p := range candidates {
+		qualifies, ok := automaticRules[p.Rule]
+		if !ok {
+			continue
+		}
+		if yes, err := qualifies(q, customerID, today); err != nil {
+			return nil, 0, err
+		} else if !yes {
+			continue
+		}
+		if err := loadPromotionServices(q, p); err != nil {
+			return nil, 0, err
+		}
+		if err := p.checkAvailable(q, customerID, day); err != nil {
+			if _, ok := err.(invoiceInputError); ok {
+				continue
+			}
+			return nil, 0, err
+		}
+		amount, err := p.discountFor(items, discount)
+		if err != nil {
+			continue
+		}
+		if amount > bestAmount {
+			best, bestAmount = p, amount
+		}
+	}
+	if best != nil {
+		best.allocate(items, discount, bestAmount)
+	}
+	return best, bestAmount, nil
+}
+
+// singleUsePromotion looks up a code issued to one customer, checking it is
+// theirs, unused and unexpired. sql.ErrNoRows means there is no such code.
+func singleUsePromotion(q queryer, ownerID, customerID int, code, day string) (*promotion, error) {
+	var codeID, promotionID int64
+	var holder sql.NullInt64
+	var expiresOn sql.NullString
+	var invoiceID sql.NullInt64
+	err := q.QueryRow(`
+        SELECT id, promotion_id, customer_id, date(expires_on), invoice_id FROM promotion_codes
+        WHERE owner_id = ? AND code = ?`, ownerID, code).Scan(&codeID, &promotionID, &holder, &expiresOn, &invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case holder.Valid && holder.Int64 != int64(customerID):
+		return nil, invoiceInputError("This code was issued to another customer")
+	case invoiceID.Valid:
+		return nil, invoiceInputError("This code has already been used")
+	case expiresOn.Valid && day > expiresOn.String:
+		return nil, invoiceInputError("This code has expired")
+	}
+	p, err := scanPromotion(q.QueryRow("SELECT "+promotionColumns+" FROM promotions WHERE id = ?", promotionID))
+	if err != nil {
+		return nil, err
+	}
+	p.Code, p.codeID = code, codeID
+	return p, nil
+}
+
+// issuePromotionCode creates a single-use code for the promotion, valid for
+// customerID until expiresOn.
+func issuePromotionCode(db *sql.DB, ownerID int, promotionID, customerID int64, expiresOn string, now time.Time) (int64, string, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := newToken()
+		if err != nil {
+			return 0, "", err
+		}
+		code := "WB-" + strings.ToUpper(token[:8])
+		res, err := db.Exec(`
+            INSERT OR IGNORE INTO promotion_codes (owner_id, promotion_id, customer_id, code, expires_on, created_at)
+            VALUES (?, ?, ?, ?, ?, ?)`, ownerID, promotionID, customerID, code, expiresOn, now)
+		if err != nil {
+			return 0, "", err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			id, _ := res.LastInsertId()
+			return id, code, nil
+		}
+		if attempt == 5 {
+			return 0, "", fmt.Errorf("could not generate a unique promotion code")
+		}
+	}
+}
+
+// --- API: List Promotions ---
+func APIGetPromotions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query("SELECT "+promotionColumns+" FROM promotions WHERE owner_id = ? ORDER BY created_at DESC", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch promotions", http.StatusInternalServerError)
+		return
+	}
+	promotions := []*promotion{}
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			log.Printf("Failed to scan promotion: %v", err)
+			continue
+		}
+		promotions = append(promotions, p)
+	}
+	rows.Close()
+	for _, p := range promotions {
+		if err := loadPromotionServices(db, p); err != nil {
+			log.Printf("Failed to load services for promotion %d: %v", p.ID, err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(promotions)
+}
+
+// decodePromotion reads and validates a promotion request body.
+func decodePromotion(w http.ResponseWriter, r *http.Request, ownerID int) (*promotion, bool) {
+	p := &promotion{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return nil, false
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	p.Code = strings.ToUpper(strings.TrimSpace(p.Code))
+	if p.Rule == "" {
+		p.Rule = "code"
+	}
+	if p.Name == "" || len(p.Name) > 100 {
+		http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+		return nil, false
+	}
+	if p.Rule == "code" {
+		if !promoCodeRegex.MatchString(p.Code) {
+			http.Error(w, "Code must be 3-30 letters, digits, dashes or underscores", http.StatusBadRequest)
+			return nil, false
+		}
+	} else if _, ok := automaticRules[p.Rule]; ok || p.Rule == singleUseRule {
+		p.Code = ""
+	} else {
+		http.Error(w, "Invalid rule", http.StatusBadRequest)
+		return nil, false
+	}
+	switch p.Kind {
+	case "percentage":
+		if p.Value <= 0 || p.Value > 100 {
+			http.Error(w, "Percentage must be between 0 and 100", http.StatusBadRequest)
+			return nil, false
+		}
+	case "fixed":
+		if p.Value <= 0 {
+			http.Error(w, "Fixed discount must be positive", http.StatusBadRequest)
+			return nil, false
+		}
+	default:
+		http.Error(w, "Kind must be percentage or fixed", http.StatusBadRequest)
+		return nil, false
+	}
+	for _, d := range []string{p.StartsOn, p.EndsOn} {
+		if d != "" {
+			if _, err := time.Parse("2006-01-02", d); err != nil {
+				http.Error(w, "Invalid date format (YYYY-MM-DD)", http.StatusBadRequest)
+				return nil, false
+			}
+		}
+	}
+	if p.StartsOn != "" && p.EndsOn != "" && p.EndsOn < p.StartsOn {
+		http.Error(w, "End date is before start date", http.StatusBadRequest)
+		return nil, false
+	}
+	if p.MinSpend < 0 || (p.MaxUses != nil && *p.MaxUses < 1) || (p.MaxUsesPerCustomer != nil && *p.MaxUsesPerCustomer < 1) {
+		http.Error(w, "Minimum spend and usage limits must be positive", http.StatusBadRequest)
+		return nil, false
+	}
+	db := database.GetDB()
+	for _, sid := range p.ServiceIDs {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM services WHERE id = ? AND owner_id = ?", sid, ownerID).Scan(&count)
+		if count == 0 {
+			http.Error(w, fmt.Sprintf("Service %d not found", sid), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// savePromotionServices replaces the service restrictions of a promotion.
+func savePromotionServices(tx *sql.Tx, p *promotion) error {
+	if _, err := tx.Exec("DELETE FROM promotion_services WHERE promotion_id = ?", p.ID); err != nil {
+		return err
+	}
+	for _, sid := range p.ServiceIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO promotion_services (promotion_id, service_id) VALUES (?, ?)", p.ID, sid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- API: Add Promotion ---
+func APIAddPromotion(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	p, ok := decodePromotion(w, r, ownerID)
+	if !ok {
+		return
+	}
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		http.Error(w, "Failed to add promotion", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+        INSERT INTO promotions (owner_id, name, code, rule, kind, value, starts_on, ends_on, min_spend,
+            max_uses, max_uses_per_customer, active, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, p.Name, nullIfEmpty(p.Code), p.Rule, p.Kind, p.Value, nullIfEmpty(p.StartsOn), nullIfEmpty(p.EndsOn),
+		p.MinSpend, p.MaxUses, p.MaxUsesPerCustomer, p.Active, time.Now(), time.Now())
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			http.Error(w, "A promotion with this code already exists", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to add promotion", http.StatusInternalServerError)
+		}
+		return
+	}
+	p.ID, _ = res.LastInsertId()
+	if err := savePromotionServices(tx, p); err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to add promotion", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": p.ID})
+}
+
+// --- API: Update Promotion ---
+func APIUpdatePromotion(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid promotion ID", http.StatusBadRequest)
+		return
+	}
+	p, ok := decodePromotion(w, r, ownerID)
+	if !ok {
+		return
+	}
+	p.ID = int64(id)
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		http.Error(w, "Failed to update promotion", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+        UPDATE promotions SET name = ?, code = ?, rule = ?, kind = ?, value = ?, starts_on = ?, ends_on = ?,
+            min_spend = ?, max_uses = ?, max_uses_per_customer = ?, active = ?, updated_at = ?
+        WHERE id = ? AND owner_id = ?`,
+		p.Name, nullIfEmpty(p.Code), p.Rule, p.Kind, p.Value, nullIfEmpty(p.StartsOn), nullIfEmpty(p.EndsOn),
+		p.MinSpend, p.MaxUses, p.MaxUsesPerCustomer, p.Active, time.Now(), id, ownerID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			http.Error(w, "A promotion with this code already exists", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to update promotion", http.StatusInternalServerError)
+		}
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Promotion not found", http.StatusNotFound)
+		return
+	}
+	if err := savePromotionServices(tx, p); err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to update promotion", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Promotion ---
+// Promotions are deactivated so invoices that used them still report by name.
+func APIDeletePromotion(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid promotion ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	_, err = db.Exec("UPDATE promotions SET active = 0, updated_at = ? WHERE id = ? AND owner_id = ?", time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete promotion", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
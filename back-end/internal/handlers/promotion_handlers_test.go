@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPromotionDiscountFor(t *testing.T) {
+	items := []invoiceItemInput{
+		{ServiceID: 1, Quantity: 1, UnitPrice: price(100)},
+		{ServiceID: 2, Quantity: 2, UnitPrice: price(25)},
+	}
+	tests := []struct {
+		name     string
+		promo    promotion
+		items    []invoiceItemInput
+		discount float64
+		want     float64
+		wantErr  bool
+	}{
+		{
+			name:  "percentage of every line",
+			promo: promotion{Kind: "percentage", Value: 10},
+			items: items,
+			want:  15,
+		},
+		{
+			name:  "fixed amount",
+			promo: promotion{Kind: "fixed", Value: 20},
+			items: items,
+			want:  20,
+		},
+		{
+			name:  "fixed amount is capped at the eligible total",
+			promo: promotion{Kind: "fixed", Value: 500},
+			items: items,
+			want:  150,
+		},
+		{
+			name:  "only the promotion's services count",
+			promo: promotion{Kind: "percentage", Value: 10, ServiceIDs: []int64{2}},
+			items: items,
+			want:  5,
+		},
+		{
+			name:     "manual discount is taken first",
+			promo:    promotion{Kind: "percentage", Value: 10},
+			items:    items,
+			discount: 10,
+			want:     13.5,
+		},
+		{
+			name:  "minimum spend met",
+			promo: promotion{Kind: "fixed", Value: 10, MinSpend: 150},
+			items: items,
+			want:  10,
+		},
+		{
+			name:    "minimum spend not met",
+			promo:   promotion{Name: "Spend 200", Kind: "fixed", Value: 10, MinSpend: 200},
+			items:   items,
+			wantErr: true,
+		},
+		{
+			name:  "gift cards don't count towards the minimum spend",
+			promo: promotion{Kind: "fixed", Value: 10, MinSpend: 50},
+			items: []invoiceItemInput{
+				{ServiceID: 1, Quantity: 1, UnitPrice: price(20)},
+				{Quantity: 1, UnitPrice: price(100), GiftCard: &giftCardSale{}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no eligible items",
+			promo:   promotion{Kind: "percentage", Value: 10, ServiceIDs: []int64{3}},
+			items:   items,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.promo.discountFor(tt.items, tt.discount)
+			if tt.wantErr {
+				if _, ok := err.(invoiceInputError); !ok {
+					t.Fatalf("discountFor() error = %v, want an invoiceInputError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("discountFor() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("discountFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromotionAllocate(t *testing.T) {
+	tests := []struct {
+		name     string
+		promo    promotion
+		items    []invoiceItemInput
+		discount float64
+		amount   float64
+		want     []float64
+	}{
+		{
+			name:   "in proportion to line value",
+			items:  []invoiceItemInput{{Quantity: 1, UnitPrice: price(30)}, {Quantity: 1, UnitPrice: price(70)}},
+			amount: 10,
+			want:   []float64{3, 7},
+		},
+		{
+			name: "rounding remainder goes to the last line",
+			items: []invoiceItemInput{
+				{Quantity: 1, UnitPrice: price(10)},
+				{Quantity: 1, UnitPrice: price(10)},
+				{Quantity: 1, UnitPrice: price(10)},
+			},
+			amount: 10,
+			want:   []float64{3.33, 3.33, 3.34},
+		},
+		{
+			name:  "ineligible, free and gift card lines get nothing",
+			promo: promotion{ServiceIDs: []int64{1, 2}},
+			items: []invoiceItemInput{
+				{ServiceID: 1, Quantity: 1, UnitPrice: price(40)},
+				{ServiceID: 3, Quantity: 1, UnitPrice: price(40)},
+				{ServiceID: 2, Quantity: 1, UnitPrice: price(0)},
+				{ServiceID: 1, Quantity: 1, UnitPrice: price(20), GiftCard: &giftCardSale{}},
+				{ServiceID: 2, Quantity: 2, UnitPrice: price(10)},
+			},
+			amount: 6,
+			want:   []float64{4, 0, 0, 0, 2},
+		},
+		{
+			name:     "shares are of the manually discounted prices",
+			items:    []invoiceItemInput{{Quantity: 1, UnitPrice: price(50)}, {Quantity: 3, UnitPrice: price(50)}},
+			discount: 20,
+			amount:   8,
+			want:     []float64{2, 6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.promo.allocate(tt.items, tt.discount, tt.amount)
+			var got []float64
+			for _, it := range tt.items {
+				got = append(got, it.promoDiscount)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allocate() shares = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSingleUsePromotion(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db,
+		`INSERT INTO promotions (id, owner_id, name, rule, kind, value) VALUES (1, 1, 'Welcome back', 'single_use', 'percentage', 15)`,
+		`INSERT INTO promotion_codes (id, owner_id, promotion_id, customer_id, code, expires_on, invoice_id) VALUES
+			(1, 1, 1, 5, 'WB-AAAA', '2026-11-30', NULL),
+			(2, 1, 1, 5, 'WB-USED', '2026-11-30', 9),
+			(3, 1, 1, 5, 'WB-OLD', '2026-10-31', NULL),
+			(4, 1, 1, NULL, 'WB-ANYONE', NULL, NULL)`,
+	)
+	tests := []struct {
+		name       string
+		ownerID    int
+		customerID int
+		code       string
+		wantCodeID int64
+		wantErr    string
+	}{
+		{name: "issued to the customer", ownerID: 1, customerID: 5, code: "WB-AAAA", wantCodeID: 1},
+		{name: "not issued to anyone", ownerID: 1, customerID: 6, code: "WB-ANYONE", wantCodeID: 4},
+		{name: "another customer's", ownerID: 1, customerID: 6, code: "WB-AAAA", wantErr: "This code was issued to another customer"},
+		{name: "used", ownerID: 1, customerID: 5, code: "WB-USED", wantErr: "This code has already been used"},
+		{name: "expired", ownerID: 1, customerID: 5, code: "WB-OLD", wantErr: "This code has expired"},
+		{name: "another salon's", ownerID: 2, customerID: 5, code: "WB-AAAA", wantErr: "sql: no rows in result set"},
+	}
+	for _, tt := range tests {
+		p, err := singleUsePromotion(db, tt.ownerID, tt.customerID, tt.code, "2026-11-01")
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || p.codeID != tt.wantCodeID || p.Code != tt.code || p.ID != 1 {
+			t.Errorf("%s: singleUsePromotion() = %+v (%v), want promotion 1 with code %d", tt.name, p, err, tt.wantCodeID)
+		}
+	}
+}
+
+func TestRecordInvoicePromotion(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 11, 2, 9, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO promotions (id, owner_id, name, rule, kind, value, max_uses, max_uses_per_customer) VALUES
+			(1, 1, 'Opening week', 'code', 'percentage', 10, 2, 1),
+			(2, 1, 'Welcome back', 'single_use', 'percentage', 15, NULL, NULL)`,
+		`INSERT INTO promotion_codes (id, owner_id, promotion_id, customer_id, code) VALUES (1, 1, 2, 5, 'WB-AAAA')`,
+	)
+	opening, err := scanPromotion(db.QueryRow("SELECT " + promotionColumns + " FROM promotions WHERE id = 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	welcome, err := singleUsePromotion(db, 1, 5, "WB-AAAA", "2026-11-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each promotion is checked when the invoice is priced, then again as
+	// the invoice is saved in case another invoice took the last use.
+	tests := []struct {
+		name       string
+		invoiceID  int64
+		customerID int
+		promo      *promotion
+		wantErr    string
+	}{
+		{"first use", 1, 5, opening, ""},
+		{"customer's second use", 2, 5, opening, "This customer has already used Opening week"},
+		{"another customer", 3, 6, opening, ""},
+		{"fully redeemed", 4, 7, opening, "Opening week has been fully redeemed"},
+		{"single-use code", 5, 5, welcome, ""},
+		{"single-use code again", 6, 5, welcome, "This code has already been used"},
+	}
+	for _, tt := range tests {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = recordInvoicePromotion(tx, tt.invoiceID, tt.customerID, tt.promo, 5, now)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			tx.Commit()
+			continue
+		}
+		if _, ok := err.(invoiceInputError); !ok || err.Error() != tt.wantErr {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+		tx.Rollback()
+	}
+	var used int
+	db.QueryRow("SELECT COUNT(*) FROM invoice_promotions").Scan(&used)
+	if used != 3 {
+		t.Errorf("%d promotions recorded, want 3", used)
+	}
+}
@@ -0,0 +1,531 @@
+// internal/handlers/purchase_order_handlers.go
+// Handlers for purchase orders: ordering stock from suppliers and receiving
+// it into the product catalogue.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+type purchaseOrderItem struct {
+	ID               int64    `json:"id"`
+	ProductID        int64    `json:"product_id"`
+	Name             string   `json:"name"`
+	SKU              string   `json:"sku"`
+	Quantity         int      `json:"quantity"`
+	QuantityReceived int      `json:"quantity_received"`
+	UnitCost         *float64 `json:"unit_cost"`
+	LineTotal        float64  `json:"line_total"`
+}
+
+type purchaseOrder struct {
+	ID           int64               `json:"id"`
+	Number       string              `json:"po_number"`
+	SupplierID   int64               `json:"supplier_id"`
+	SupplierName string              `json:"supplier_name"`
+	Status       string              `json:"status"`
+	OrderDate    string              `json:"order_date"`
+	ExpectedOn   string              `json:"expected_on"`
+	Notes        string              `json:"notes"`
+	Total        float64             `json:"total"`
+	SentAt       string              `json:"sent_at,omitempty"`
+	Items        []purchaseOrderItem `json:"items,omitempty"`
+}
+
+// purchaseOrderReceipt is one line of a delivery being received.
+type purchaseOrderReceipt struct {
+	ProductID int64    `json:"product_id"`
+	Quantity  int      `json:"quantity"`
+	UnitCost  *float64 `json:"unit_cost"`
+}
+
+const purchaseOrderColumns = `po.id, COALESCE(po.po_number, ''), po.supplier_id, s.name, po.status, date(po.order_date),
+    COALESCE(date(po.expected_on), ''), COALESCE(po.notes, ''), po.total, COALESCE(po.sent_at, '')`
+
+func scanPurchaseOrder(row rowScanner) (*purchaseOrder, error) {
+	po := &purchaseOrder{}
+	err := row.Scan(&po.ID, &po.Number, &po.SupplierID, &po.SupplierName, &po.Status, &po.OrderDate,
+		&po.ExpectedOn, &po.Notes, &po.Total, &po.SentAt)
+	return po, err
+}
+
+// loadPurchaseOrder reads a purchase order with its lines. It returns
+// sql.ErrNoRows if the order doesn't belong to ownerID.
+func loadPurchaseOrder(q queryer, ownerID int, id int64) (*purchaseOrder, error) {
+	po, err := scanPurchaseOrder(q.QueryRow("SELECT "+purchaseOrderColumns+` FROM purchase_orders po
+        JOIN suppliers s ON po.supplier_id = s.id WHERE po.id = ? AND po.owner_id = ?`, id, ownerID))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(`
+        SELECT i.id, i.product_id, p.name, COALESCE(p.sku, ''), i.quantity_ordered, i.quantity_received, i.unit_cost
+        FROM purchase_order_items i
+        JOIN products p ON i.product_id = p.id
+        WHERE i.purchase_order_id = ? ORDER BY i.id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	po.Items = []purchaseOrderItem{}
+	for rows.Next() {
+		var it purchaseOrderItem
+		var cost float64
+		if err := rows.Scan(&it.ID, &it.ProductID, &it.Name, &it.SKU, &it.Quantity, &it.QuantityReceived, &cost); err != nil {
+			return nil, err
+		}
+		it.UnitCost = &cost
+		it.LineTotal = round2(cost * float64(it.Quantity))
+		po.Items = append(po.Items, it)
+	}
+	return po, rows.Err()
+}
+
+// decodePurchaseOrder reads and validates a purchase order request body.
+// Lines without a unit cost are ordered at the product's current cost price.
+func decodePurchaseOrder(w http.ResponseWriter, r *http.Request, ownerID int) (*purchaseOrder, bool) {
+	po := &purchaseOrder{}
+	if err := json.NewDecoder(r.Body).Decode(po); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return nil, false
+	}
+	po.Notes = strings.TrimSpace(po.Notes)
+	po.ExpectedOn = strings.TrimSpace(po.ExpectedOn)
+	db := database.GetDB()
+	if err := lookupSupplier(db, ownerID, po.SupplierID); err != nil {
+		http.Error(w, "Supplier not found", http.StatusBadRequest)
+		return nil, false
+	}
+	if po.ExpectedOn != "" {
+		if _, err := time.Parse("2006-01-02", po.ExpectedOn); err != nil {
+			http.Error(w, "Invalid expected date (YYYY-MM-DD)", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	if len(po.Notes) > 500 {
+		http.Error(w, "Notes are too long (max 500 characters)", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(po.Items) == 0 {
+		http.Error(w, "A purchase order needs at least one product", http.StatusBadRequest)
+		return nil, false
+	}
+	seen := map[int64]bool{}
+	po.Total = 0
+	for i := range po.Items {
+		it := &po.Items[i]
+		if seen[it.ProductID] {
+			http.Error(w, "Each product can only appear once on a purchase order", http.StatusBadRequest)
+			return nil, false
+		}
+		seen[it.ProductID] = true
+		var cost float64
+		err := db.QueryRow("SELECT name, COALESCE(sku, ''), cost_price FROM products WHERE id = ? AND owner_id = ? AND active = 1",
+			it.ProductID, ownerID).Scan(&it.Name, &it.SKU, &cost)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Product %d not found", it.ProductID), http.StatusBadRequest)
+			return nil, false
+		}
+		if it.Quantity <= 0 {
+			http.Error(w, "Quantities must be positive", http.StatusBadRequest)
+			return nil, false
+		}
+		if it.UnitCost == nil {
+			it.UnitCost = &cost
+		}
+		if *it.UnitCost < 0 {
+			http.Error(w, "Unit cost cannot be negative", http.StatusBadRequest)
+			return nil, false
+		}
+		it.LineTotal = round2(*it.UnitCost * float64(it.Quantity))
+		po.Total += it.LineTotal
+	}
+	po.Total = round2(po.Total)
+	return po, true
+}
+
+// savePurchaseOrderItems replaces the lines of a draft purchase order.
+func savePurchaseOrderItems(tx *sql.Tx, po *purchaseOrder) error {
+	if _, err := tx.Exec("DELETE FROM purchase_order_items WHERE purchase_order_id = ?", po.ID); err != nil {
+		return err
+	}
+	for _, it := range po.Items {
+		_, err := tx.Exec(
+			"INSERT INTO purchase_order_items (purchase_order_id, product_id, quantity_ordered, unit_cost) VALUES (?, ?, ?, ?)",
+			po.ID, it.ProductID, it.Quantity, *it.UnitCost)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- API: List Purchase Orders ---
+// Filter with "status" and "supplier_id".
+func APIGetPurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	query := "SELECT " + purchaseOrderColumns + " FROM purchase_orders po JOIN suppliers s ON po.supplier_id = s.id WHERE po.owner_id = ?"
+	args := []interface{}{ownerID}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query += " AND po.status = ?"
+		args = append(args, status)
+	}
+	if supplierID := r.URL.Query().Get("supplier_id"); supplierID != "" {
+		query += " AND po.supplier_id = ?"
+		args = append(args, supplierID)
+	}
+	db := database.GetDB()
+	rows, err := db.Query(query+" ORDER BY po.id DESC", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch purchase orders", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	orders := []*purchaseOrder{}
+	for rows.Next() {
+		po, err := scanPurchaseOrder(rows)
+		if err != nil {
+			log.Printf("Failed to scan purchase order: %v", err)
+			continue
+		}
+		orders = append(orders, po)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orders)
+}
+
+// --- API: Get Purchase Order ---
+func APIGetPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid purchase order ID", http.StatusBadRequest)
+		return
+	}
+	po, err := loadPurchaseOrder(database.GetDB(), ownerID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Purchase order not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch purchase order", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(po)
+}
+
+// --- API: Add Purchase Order ---
+// New purchase orders start as drafts.
+func APIAddPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	po, ok := decodePurchaseOrder(w, r, ownerID)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to add purchase order", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	res, err := tx.Exec(`
+        INSERT INTO purchase_orders (owner_id, supplier_id, status, order_date, expected_on, notes, total, created_at, updated_at)
+        VALUES (?, ?, 'draft', ?, ?, ?, ?, ?, ?)`,
+		ownerID, po.SupplierID, now.Format("2006-01-02"), nullIfEmpty(po.ExpectedOn), nullIfEmpty(po.Notes), po.Total, now, now)
+	if err != nil {
+		http.Error(w, "Failed to add purchase order", http.StatusInternalServerError)
+		return
+	}
+	po.ID, _ = res.LastInsertId()
+	po.Number = fmt.Sprintf("PO-%04d", po.ID)
+	if _, err := tx.Exec("UPDATE purchase_orders SET po_number = ? WHERE id = ?", po.Number, po.ID); err != nil {
+		http.Error(w, "Failed to add purchase order", http.StatusInternalServerError)
+		return
+	}
+	if err := savePurchaseOrderItems(tx, po); err != nil {
+		http.Error(w, "Failed to save purchase order items", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to add purchase order", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": po.ID, "po_number": po.Number})
+}
+
+// --- API: Update Purchase Order ---
+// Only drafts can be edited.
+func APIUpdatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid purchase order ID", http.StatusBadRequest)
+		return
+	}
+	po, ok := decodePurchaseOrder(w, r, ownerID)
+	if !ok {
+		return
+	}
+	po.ID = id
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update purchase order", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	var status string
+	err = tx.QueryRow("SELECT status FROM purchase_orders WHERE id = ? AND owner_id = ?", id, ownerID).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Purchase order not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to update purchase order", http.StatusInternalServerError)
+		return
+	}
+	if status != "draft" {
+		http.Error(w, "Only draft purchase orders can be edited", http.StatusBadRequest)
+		return
+	}
+	_, err = tx.Exec(`
+        UPDATE purchase_orders SET supplier_id = ?, expected_on = ?, notes = ?, total = ?, updated_at = ?
+        WHERE id = ?`,
+		po.SupplierID, nullIfEmpty(po.ExpectedOn), nullIfEmpty(po.Notes), po.Total, time.Now(), id)
+	if err != nil {
+		http.Error(w, "Failed to update purchase order", http.StatusInternalServerError)
+		return
+	}
+	if err := savePurchaseOrderItems(tx, po); err != nil {
+		http.Error(w, "Failed to save purchase order items", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update purchase order", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Change Purchase Order Status ---
+// Handles /api/purchase-orders/{id}/{action} where action is send or cancel.
+// Orders can only be cancelled before any stock has been received.
+func APIChangePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid purchase order ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var status string
+	err = db.QueryRow("SELECT status FROM purchase_orders WHERE id = ? AND owner_id = ?", id, ownerID).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Purchase order not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch purchase order", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	action := chi.URLParam(r, "action")
+	var query string
+	var args []interface{}
+	switch {
+	case action == "send" && status == "draft":
+		query = "UPDATE purchase_orders SET status = 'sent', sent_at = ?, updated_at = ? WHERE id = ?"
+		args = []interface{}{now, now, id}
+	case action == "cancel" && (status == "draft" || status == "sent"):
+		query = "UPDATE purchase_orders SET status = 'cancelled', updated_at = ? WHERE id = ?"
+		args = []interface{}{now, id}
+	case action == "send" || action == "cancel":
+		http.Error(w, fmt.Sprintf("Can't %s a purchase order that is %s", action, strings.ReplaceAll(status, "_", " ")), http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	if _, err := db.Exec(query, args...); err != nil {
+		http.Error(w, "Failed to update purchase order", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Receive Purchase Order ---
+// Books delivered stock in. Each line names the product, the quantity that
+// arrived and optionally the invoiced unit cost, which becomes the product's
+// cost price. With no lines, everything still outstanding is received.
+func APIReceivePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid purchase order ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Items []purchaseOrderReceipt `json:"items"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to receive purchase order", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	po, err := loadPurchaseOrder(tx, ownerID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Purchase order not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch purchase order", http.StatusInternalServerError)
+		return
+	}
+	if po.Status != "sent" && po.Status != "partially_received" {
+		http.Error(w, fmt.Sprintf("Can't receive a purchase order that is %s", strings.ReplaceAll(po.Status, "_", " ")), http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 {
+		for _, it := range po.Items {
+			if outstanding := it.Quantity - it.QuantityReceived; outstanding > 0 {
+				req.Items = append(req.Items, purchaseOrderReceipt{ProductID: it.ProductID, Quantity: outstanding})
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, rec := range req.Items {
+		var line *purchaseOrderItem
+		for i := range po.Items {
+			if po.Items[i].ProductID == rec.ProductID {
+				line = &po.Items[i]
+			}
+		}
+		if line == nil {
+			http.Error(w, fmt.Sprintf("Product %d is not on this purchase order", rec.ProductID), http.StatusBadRequest)
+			return
+		}
+		if rec.Quantity <= 0 || rec.Quantity > line.Quantity-line.QuantityReceived {
+			http.Error(w, fmt.Sprintf("Only %d of %s are still to be received", line.Quantity-line.QuantityReceived, line.Name), http.StatusBadRequest)
+			return
+		}
+		cost := *line.UnitCost
+		if rec.UnitCost != nil {
+			if *rec.UnitCost < 0 {
+				http.Error(w, "Unit cost cannot be negative", http.StatusBadRequest)
+				return
+			}
+			cost = *rec.UnitCost
+		}
+		line.QuantityReceived += rec.Quantity
+		if _, err := tx.Exec("UPDATE purchase_order_items SET quantity_received = ? WHERE id = ?", line.QuantityReceived, line.ID); err != nil {
+			http.Error(w, "Failed to receive purchase order", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec("UPDATE products SET cost_price = ?, updated_at = ? WHERE id = ?", cost, now, line.ProductID); err != nil {
+			http.Error(w, "Failed to update cost price", http.StatusInternalServerError)
+			return
+		}
+		if _, err := moveStock(tx, line.ProductID, rec.Quantity, "receive", "", sql.NullInt64{}, "Received on "+po.Number, now); err != nil {
+			http.Error(w, "Failed to update stock", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	po.Status = "received"
+	for _, it := range po.Items {
+		if it.QuantityReceived < it.Quantity {
+			po.Status = "partially_received"
+		}
+	}
+	if _, err := tx.Exec("UPDATE purchase_orders SET status = ?, updated_at = ? WHERE id = ?", po.Status, now, id); err != nil {
+		http.Error(w, "Failed to receive purchase order", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to receive purchase order", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(po)
+}
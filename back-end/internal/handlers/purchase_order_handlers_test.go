@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIReceivePurchaseOrder(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db,
+		`INSERT INTO suppliers (id, owner_id, name) VALUES (1, 1, 'Wholesale Co')`,
+		`INSERT INTO products (id, owner_id, name, cost_price, retail_price, stock_on_hand) VALUES
+			(1, 1, 'Shampoo', 2, 12, 0),
+			(2, 1, 'Conditioner', 3, 12, 1),
+			(3, 1, 'Brush', 4, 8, 0)`,
+		`INSERT INTO purchase_orders (id, owner_id, supplier_id, po_number, status, order_date) VALUES (1, 1, 1, 'PO-0001', 'sent', '2026-03-01')`,
+		`INSERT INTO purchase_order_items (purchase_order_id, product_id, quantity_ordered, unit_cost) VALUES (1, 1, 10, 2), (1, 2, 5, 3)`,
+	)
+
+	// Each step builds on the deliveries received before it.
+	steps := []struct {
+		name       string
+		ownerID    int
+		body       string
+		wantStatus int
+		wantBody   string
+		wantPO     string
+		wantStock  [2]int
+		wantCost   float64
+	}{
+		{name: "partial delivery at a new cost", ownerID: 1, body: `{"items": [{"product_id": 1, "quantity": 4, "unit_cost": 2.5}]}`,
+			wantStatus: http.StatusOK, wantPO: "partially_received", wantStock: [2]int{4, 1}, wantCost: 2.5},
+		{name: "more than is outstanding", ownerID: 1, body: `{"items": [{"product_id": 1, "quantity": 7}]}`,
+			wantStatus: http.StatusBadRequest, wantBody: "Only 6 of Shampoo are still to be received",
+			wantPO: "partially_received", wantStock: [2]int{4, 1}, wantCost: 2.5},
+		{name: "product not on the order", ownerID: 1, body: `{"items": [{"product_id": 3, "quantity": 1}]}`,
+			wantStatus: http.StatusBadRequest, wantBody: "Product 3 is not on this purchase order",
+			wantPO: "partially_received", wantStock: [2]int{4, 1}, wantCost: 2.5},
+		{name: "another salon's order", ownerID: 2,
+			wantStatus: http.StatusNotFound, wantPO: "partially_received", wantStock: [2]int{4, 1}, wantCost: 2.5},
+		{name: "everything outstanding at the ordered cost", ownerID: 1,
+			wantStatus: http.StatusOK, wantPO: "received", wantStock: [2]int{10, 6}, wantCost: 2},
+		{name: "already received", ownerID: 1,
+			wantStatus: http.StatusBadRequest, wantBody: "Can't receive a purchase order that is received",
+			wantPO: "received", wantStock: [2]int{10, 6}, wantCost: 2},
+	}
+	for _, tt := range steps {
+		r := httptest.NewRequest(http.MethodPost, "/api/purchase-orders/1/receive", strings.NewReader(tt.body))
+		r = withURLParams(asOwner(r, tt.ownerID), "id", "1")
+		w := httptest.NewRecorder()
+		APIReceivePurchaseOrder(w, r)
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d (%s), want %d", tt.name, w.Code, strings.TrimSpace(w.Body.String()), tt.wantStatus)
+		}
+		if tt.wantBody != "" && strings.TrimSpace(w.Body.String()) != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.name, strings.TrimSpace(w.Body.String()), tt.wantBody)
+		}
+
+		var status string
+		var stock [2]int
+		var cost float64
+		db.QueryRow("SELECT status FROM purchase_orders WHERE id = 1").Scan(&status)
+		db.QueryRow("SELECT stock_on_hand, cost_price FROM products WHERE id = 1").Scan(&stock[0], &cost)
+		db.QueryRow("SELECT stock_on_hand FROM products WHERE id = 2").Scan(&stock[1])
+		if status != tt.wantPO || stock != tt.wantStock || cost != tt.wantCost {
+			t.Errorf("%s: order %s, stock %v, shampoo cost %v; want %s, %v, %v",
+				tt.name, status, stock, cost, tt.wantPO, tt.wantStock, tt.wantCost)
+		}
+	}
+
+	var movements int
+	db.QueryRow("SELECT COUNT(*) FROM stock_movements WHERE kind = 'receive' AND note = 'Received on PO-0001'").Scan(&movements)
+	if movements != 3 {
+		t.Errorf("%d receive movements recorded, want 3", movements)
+	}
+}
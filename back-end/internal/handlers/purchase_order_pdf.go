@@ -0,0 +1,204 @@
+// internal/handlers/purchase_order_pdf.go
+// Purchase order documents for sending to suppliers, as PDF or CSV.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+	"salon-management/internal/pdf"
+)
+
+// purchaseOrderDocument is a purchase order with the salon and supplier
+// details printed on it.
+type purchaseOrderDocument struct {
+	*purchaseOrder
+	SalonName    string
+	SalonAddress string
+	SalonPhone   string
+	SalonEmail   string
+	Logo         []byte
+	Supplier     supplier
+}
+
+func loadPurchaseOrderDocument(db *sql.DB, ownerID int, id int64) (*purchaseOrderDocument, error) {
+	po, err := loadPurchaseOrder(db, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	d := &purchaseOrderDocument{purchaseOrder: po}
+	var salonName, address, phone sql.NullString
+	err = db.QueryRow("SELECT salon_name, address, phone, email, logo FROM owners WHERE id = ?", ownerID).Scan(
+		&salonName, &address, &phone, &d.SalonEmail, &d.Logo)
+	if err != nil {
+		return nil, err
+	}
+	d.SalonName, d.SalonAddress, d.SalonPhone = salonName.String, address.String, phone.String
+	d.Supplier, err = scanSupplier(db.QueryRow("SELECT "+supplierColumns+" FROM suppliers WHERE id = ?", po.SupplierID))
+	return d, err
+}
+
+// --- API: Export Purchase Order ---
+// Returns the purchase order as a PDF, or as CSV with format=csv.
+func APIExportPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid purchase order ID", http.StatusBadRequest)
+		return
+	}
+	doc, err := loadPurchaseOrderDocument(database.GetDB(), ownerID, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Purchase order not found", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to load purchase order %d: %v", id, err)
+			http.Error(w, "Failed to fetch purchase order", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", doc.Number+".csv"))
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"PO number", "Supplier", "Order date", "Expected", "SKU", "Product", "Quantity", "Unit cost", "Amount"})
+		for _, it := range doc.Items {
+			cw.Write([]string{doc.Number, doc.Supplier.Name, doc.OrderDate, doc.ExpectedOn, it.SKU, it.Name,
+				strconv.Itoa(it.Quantity), formatMoney(*it.UnitCost), formatMoney(it.LineTotal)})
+		}
+		cw.Write([]string{doc.Number, doc.Supplier.Name, doc.OrderDate, doc.ExpectedOn, "", "Total", "", "", formatMoney(doc.Total)})
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", doc.Number+".pdf"))
+	w.Write(renderPurchaseOrderPDF(doc))
+}
+
+// renderPurchaseOrderPDF lays out a purchase order in the same style as the
+// invoice PDF.
+func renderPurchaseOrderPDF(po *purchaseOrderDocument) []byte {
+	const (
+		left   = 50.0
+		right  = pdf.PageWidth - 50
+		bottom = pdf.PageHeight - 90
+	)
+	doc := pdf.New()
+
+	y := 50.0
+	textX := left
+	if len(po.Logo) > 0 {
+		if w, _, err := doc.Image(po.Logo, left, y, 120, 60); err == nil {
+			textX = left + w + 12
+		} else {
+			log.Printf("Skipping unreadable logo on purchase order %d: %v", po.ID, err)
+		}
+	}
+	doc.SetFont(true, 16)
+	doc.Text(textX, y+16, fitText(doc, po.SalonName, 300))
+	doc.SetFont(false, 9)
+	line := y + 30
+	for _, s := range []string{po.SalonAddress, po.SalonPhone, po.SalonEmail} {
+		if s != "" {
+			doc.Text(textX, line, fitText(doc, s, 300))
+			line += 12
+		}
+	}
+
+	doc.SetFont(true, 20)
+	doc.TextRight(right, y+16, "PURCHASE ORDER")
+	doc.SetFont(false, 9)
+	doc.TextRight(right, y+32, "PO no: "+po.Number)
+	doc.TextRight(right, y+44, "Date: "+po.OrderDate)
+	if po.ExpectedOn != "" {
+		doc.TextRight(right, y+56, "Delivery by: "+po.ExpectedOn)
+	}
+
+	// Supplier block.
+	y = 140
+	if line > y {
+		y = line + 10
+	}
+	doc.SetFont(true, 10)
+	doc.Text(left, y, "Supplier")
+	doc.SetFont(false, 10)
+	y += 14
+	doc.Text(left, y, po.Supplier.Name)
+	for _, s := range []string{po.Supplier.ContactName, po.Supplier.Address, po.Supplier.Phone, po.Supplier.Email} {
+		if s != "" {
+			y += 12
+			doc.Text(left, y, fitText(doc, s, 300))
+		}
+	}
+
+	const (
+		colSKU   = 130.0
+		colQty   = 360.0
+		colPrice = 450.0
+	)
+	tableHeader := func() {
+		doc.FillRect(left, y, right-left, 18, 0.9)
+		doc.SetFont(true, 9)
+		doc.Text(left+6, y+12, "SKU")
+		doc.Text(colSKU, y+12, "Product")
+		doc.TextRight(colQty, y+12, "Qty")
+		doc.TextRight(colPrice, y+12, "Unit cost")
+		doc.TextRight(right-6, y+12, "Amount")
+		doc.SetFont(false, 9)
+		y += 32
+	}
+	y += 24
+	tableHeader()
+	for _, it := range po.Items {
+		if y > bottom {
+			doc.AddPage()
+			y = 50
+			tableHeader()
+		}
+		doc.Text(left+6, y, fitText(doc, it.SKU, colSKU-left-12))
+		doc.Text(colSKU, y, fitText(doc, it.Name, colQty-colSKU-40))
+		doc.TextRight(colQty, y, strconv.Itoa(it.Quantity))
+		doc.TextRight(colPrice, y, formatMoney(*it.UnitCost))
+		doc.TextRight(right-6, y, formatMoney(it.LineTotal))
+		y += 16
+	}
+	doc.Line(left, y-8, right, y-8)
+	if y+40 > bottom {
+		doc.AddPage()
+		y = 50
+	}
+	doc.SetFont(true, 11)
+	doc.Text(colQty-40, y+10, "Total")
+	doc.TextRight(right-6, y+10, formatMoney(po.Total))
+	y += 40
+
+	if po.Notes != "" {
+		doc.SetFont(true, 10)
+		doc.Text(left, y, "Notes")
+		doc.SetFont(false, 9)
+		for _, s := range strings.Split(po.Notes, "\n") {
+			y += 12
+			doc.Text(left, y, fitText(doc, strings.TrimSpace(s), right-left))
+		}
+	}
+	return doc.Bytes()
+}
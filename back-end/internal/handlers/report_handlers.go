@@ -2,6 +2,19 @@
 // Handlers for generating and displaying reports.
 package handlers
 
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"salon-management/internal/database"
+)
+
 // "salon-management/views"
 
 // ShowReportsPage displays the reporting interface.
@@ -86,3 +99,453 @@ package handlers
 
 // 	views.ReportResults(reportType, results).Render(r.Context(), w)
 // }
+
+// reportDateRange reads the "start" and "end" query parameters (YYYY-MM-DD),
+// defaulting to the current month.
+func reportDateRange(r *http.Request) (string, string, bool) {
+	now := time.Now()
+	start := r.URL.Query().Get("start")
+	end := r.URL.Query().Get("end")
+	if start == "" {
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Format("2006-01-02")
+	}
+	if end == "" {
+		end = now.Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", start); err != nil {
+		return "", "", false
+	}
+	if _, err := time.Parse("2006-01-02", end); err != nil {
+		return "", "", false
+	}
+	return start, end, true
+}
+
+// APITaxReport summarises tax collected per rate between "start" and "end"
+// for filing returns. Add format=csv to download it as a spreadsheet.
+func APITaxReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	start, end, ok := reportDateRange(r)
+	if !ok {
+		http.Error(w, "Invalid date range (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query(`
+        SELECT t.name, t.rate, COUNT(DISTINCT i.id), SUM(t.taxable_amount), SUM(t.tax_amount)
+        FROM invoice_taxes t
+        JOIN invoices i ON t.invoice_id = i.id
+        WHERE i.owner_id = ? AND i.invoice_date BETWEEN ? AND ?
+        GROUP BY t.name, t.rate
+        ORDER BY t.name, t.rate`, ownerID, start, end)
+	if err != nil {
+		http.Error(w, "Failed to generate report", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type taxReportRow struct {
+		Name     string  `json:"name"`
+		Rate     float64 `json:"rate"`
+		Invoices int     `json:"invoices"`
+		Taxable  float64 `json:"taxable_amount"`
+		Tax      float64 `json:"tax_amount"`
+	}
+	report := struct {
+		Start    string         `json:"start"`
+		End      string         `json:"end"`
+		Rates    []taxReportRow `json:"rates"`
+		TotalTax float64        `json:"total_tax"`
+	}{Start: start, End: end, Rates: []taxReportRow{}}
+	for rows.Next() {
+		var row taxReportRow
+		if err := rows.Scan(&row.Name, &row.Rate, &row.Invoices, &row.Taxable, &row.Tax); err != nil {
+			log.Printf("Failed to scan tax report row: %v", err)
+			continue
+		}
+		row.Taxable, row.Tax = round2(row.Taxable), round2(row.Tax)
+		report.TotalTax += row.Tax
+		report.Rates = append(report.Rates, row)
+	}
+	report.TotalTax = round2(report.TotalTax)
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=tax_"+start+"_"+end+".csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"Tax", "Rate %", "Invoices", "Taxable amount", "Tax amount"})
+		for _, row := range report.Rates {
+			cw.Write([]string{row.Name, formatQuantity(row.Rate), strconv.Itoa(row.Invoices), formatMoney(row.Taxable), formatMoney(row.Tax)})
+		}
+		cw.Write([]string{"Total", "", "", "", formatMoney(report.TotalTax)})
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// APIPromotionReport shows how often each promotion was used between "start"
+// and "end", the discount given and the invoice revenue it brought in.
+func APIPromotionReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	start, end, ok := reportDateRange(r)
+	if !ok {
+		http.Error(w, "Invalid date range (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query(`
+        SELECT p.promotion_id, p.name, COALESCE(p.code, ''), COUNT(*), COUNT(DISTINCT p.customer_id),
+            SUM(p.discount_amount), SUM(i.total_amount)
+        FROM invoice_promotions p
+        JOIN invoices i ON p.invoice_id = i.id
+        WHERE i.owner_id = ? AND i.invoice_date BETWEEN ? AND ?
+        GROUP BY p.promotion_id, p.name, p.code
+        ORDER BY SUM(p.discount_amount) DESC`, ownerID, start, end)
+	if err != nil {
+		http.Error(w, "Failed to generate report", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type promotionReportRow struct {
+		PromotionID int64   `json:"promotion_id"`
+		Name        string  `json:"name"`
+		Code        string  `json:"code,omitempty"`
+		Uses        int     `json:"uses"`
+		Customers   int     `json:"customers"`
+		Discount    float64 `json:"discount_given"`
+		Revenue     float64 `json:"invoice_revenue"`
+	}
+	results := []promotionReportRow{}
+	for rows.Next() {
+		var row promotionReportRow
+		if err := rows.Scan(&row.PromotionID, &row.Name, &row.Code, &row.Uses, &row.Customers, &row.Discount, &row.Revenue); err != nil {
+			log.Printf("Failed to scan promotion report row: %v", err)
+			continue
+		}
+		row.Discount, row.Revenue = round2(row.Discount), round2(row.Revenue)
+		results = append(results, row)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// APIGiftCardReport shows the salon's gift card liability: the unspent
+// balance on cards that can still be redeemed, balances lost to expiry, and
+// how much was sold and redeemed between "start" and "end".
+func APIGiftCardReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	start, end, ok := reportDateRange(r)
+	if !ok {
+		http.Error(w, "Invalid date range (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	today := time.Now().Format("2006-01-02")
+
+	var report struct {
+		OutstandingCards   int     `json:"outstanding_cards"`
+		OutstandingBalance float64 `json:"outstanding_balance"`
+		ExpiredCards       int     `json:"expired_cards"`
+		ExpiredBalance     float64 `json:"expired_balance"`
+		Start              string  `json:"start"`
+		End                string  `json:"end"`
+		Sold               float64 `json:"sold"`
+		Redeemed           float64 `json:"redeemed"`
+	}
+	report.Start, report.End = start, end
+	db := database.GetDB()
+	err := db.QueryRow(`
+        SELECT
+            COALESCE(SUM(CASE WHEN expires_on IS NULL OR expires_on >= ? THEN 1 ELSE 0 END), 0),
+            COALESCE(SUM(CASE WHEN expires_on IS NULL OR expires_on >= ? THEN balance ELSE 0 END), 0),
+            COALESCE(SUM(CASE WHEN expires_on < ? THEN 1 ELSE 0 END), 0),
+            COALESCE(SUM(CASE WHEN expires_on < ? THEN balance ELSE 0 END), 0)
+        FROM gift_cards
+        WHERE owner_id = ? AND status = 'active' AND balance > 0`,
+		today, today, today, today, ownerID,
+	).Scan(&report.OutstandingCards, &report.OutstandingBalance, &report.ExpiredCards, &report.ExpiredBalance)
+	if err != nil {
+		http.Error(w, "Failed to generate report", http.StatusInternalServerError)
+		return
+	}
+	err = db.QueryRow(`
+        SELECT
+            COALESCE(SUM(CASE WHEN t.kind = 'issue' THEN t.amount ELSE 0 END), 0),
+            COALESCE(SUM(CASE WHEN t.kind = 'redeem' THEN -t.amount ELSE 0 END), 0)
+        FROM gift_card_transactions t
+        JOIN gift_cards g ON t.gift_card_id = g.id
+        WHERE g.owner_id = ? AND date(t.created_at) BETWEEN ? AND ?`,
+		ownerID, start, end,
+	).Scan(&report.Sold, &report.Redeemed)
+	if err != nil {
+		http.Error(w, "Failed to generate report", http.StatusInternalServerError)
+		return
+	}
+	report.OutstandingBalance = round2(report.OutstandingBalance)
+	report.ExpiredBalance = round2(report.ExpiredBalance)
+	report.Sold, report.Redeemed = round2(report.Sold), round2(report.Redeemed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// APICommissionReport works out each staff member's commission and tips for
+// the pay period between "start" and "end". Add format=csv to download it for
+// payroll.
+func APICommissionReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	start, end, ok := reportDateRange(r)
+	if !ok {
+		http.Error(w, "Invalid date range (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	staff, err := calculateCommissions(database.GetDB(), ownerID, start, end)
+	if err != nil {
+		http.Error(w, "Failed to generate report", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=commission_"+start+"_"+end+".csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"Staff ID", "Staff", "Sales", "Commission", "Tips", "Total pay"})
+		for _, s := range staff {
+			cw.Write([]string{strconv.FormatInt(s.StaffID, 10), s.Name, formatMoney(s.Sales),
+				formatMoney(s.Commission), formatMoney(s.Tips), formatMoney(s.Total)})
+		}
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"start": start, "end": end, "staff": staff})
+}
+
+// APIReorderReport suggests what to reorder from how fast each product was
+// sold or used up in the backroom over the last "days" (default 30). The
+// suggestion tops stock up to cover the next "cover_days" (default 30) at that
+// rate plus the low-stock threshold, allowing for stock already on open
+// purchase orders.
+func APIReorderReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	days, cover := 30, 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 365 {
+			http.Error(w, "Invalid days (1-365)", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	if v := r.URL.Query().Get("cover_days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 365 {
+			http.Error(w, "Invalid cover_days (1-365)", http.StatusBadRequest)
+			return
+		}
+		cover = n
+	}
+	since := time.Now().AddDate(0, 0, -days).Format("2006-01-02")
+	db := database.GetDB()
+	rows, err := db.Query(`
+        SELECT p.id, p.name, COALESCE(p.sku, ''), p.supplier_id, COALESCE(s.name, ''), p.stock_on_hand,
+            COALESCE(p.low_stock_threshold, 0), p.cost_price,
+            COALESCE((SELECT SUM(ii.quantity) FROM invoice_items ii JOIN invoices i ON ii.invoice_id = i.id
+                WHERE ii.product_id = p.id AND i.invoice_date > ?), 0),
+            COALESCE((SELECT SUM(u.amount) FROM invoice_item_usage u JOIN invoices i ON u.invoice_id = i.id
+                WHERE u.product_id = p.id AND i.invoice_date > ?), 0),
+            COALESCE((SELECT SUM(poi.quantity_ordered - poi.quantity_received) FROM purchase_order_items poi
+                JOIN purchase_orders po ON poi.purchase_order_id = po.id
+                WHERE poi.product_id = p.id AND po.status IN ('draft', 'sent', 'partially_received')), 0)
+        FROM products p
+        LEFT JOIN suppliers s ON p.supplier_id = s.id
+        WHERE p.owner_id = ? AND p.active = 1
+        ORDER BY s.name, p.name`, since, since, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to generate report", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type reorderRow struct {
+		ProductID    int64    `json:"product_id"`
+		Name         string   `json:"name"`
+		SKU          string   `json:"sku"`
+		SupplierID   *int64   `json:"supplier_id"`
+		SupplierName string   `json:"supplier_name"`
+		StockOnHand  int      `json:"stock_on_hand"`
+		OnOrder      int      `json:"on_order"`
+		Sold         float64  `json:"sold"`
+		Used         float64  `json:"used"`
+		DailySales   float64  `json:"daily_sales"`
+		DaysLeft     *float64 `json:"days_of_stock_left"`
+		Suggested    int      `json:"suggested_quantity"`
+		EstCost      float64  `json:"estimated_cost"`
+	}
+	suggestions := []reorderRow{}
+	for rows.Next() {
+		var row reorderRow
+		var supplierID sql.NullInt64
+		var threshold int
+		var cost float64
+		if err := rows.Scan(&row.ProductID, &row.Name, &row.SKU, &supplierID, &row.SupplierName, &row.StockOnHand,
+			&threshold, &cost, &row.Sold, &row.Used, &row.OnOrder); err != nil {
+			log.Printf("Failed to scan reorder report row: %v", err)
+			continue
+		}
+		if supplierID.Valid {
+			row.SupplierID = &supplierID.Int64
+		}
+		daily := (row.Sold + row.Used) / float64(days)
+		row.DailySales = round2(daily)
+		if daily > 0 {
+			left := round2(float64(row.StockOnHand) / daily)
+			row.DaysLeft = &left
+		}
+		target := daily*float64(cover) + float64(threshold)
+		row.Suggested = int(math.Ceil(target - float64(row.StockOnHand+row.OnOrder) - 1e-9))
+		if row.Suggested <= 0 {
+			continue
+		}
+		row.EstCost = round2(float64(row.Suggested) * cost)
+		suggestions = append(suggestions, row)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"days": days, "cover_days": cover, "products": suggestions})
+}
+
+// APIServiceMarginReport shows, per service invoiced between "start" and
+// "end", the revenue after discounts, the cost of the backroom products used
+// and the margin left. Add format=csv to download it as a spreadsheet.
+func APIServiceMarginReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	start, end, ok := reportDateRange(r)
+	if !ok {
+		http.Error(w, "Invalid date range (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query(`
+        SELECT s.id, s.name, SUM(ii.quantity), SUM(COALESCE(ii.net_amount, ii.line_total)),
+            SUM(COALESCE((SELECT SUM(u.amount * u.unit_cost) FROM invoice_item_usage u WHERE u.invoice_item_id = ii.id), 0))
+        FROM invoice_items ii
+        JOIN invoices i ON ii.invoice_id = i.id
+        JOIN services s ON ii.service_id = s.id
+        WHERE i.owner_id = ? AND i.invoice_date BETWEEN ? AND ?
+        GROUP BY s.id, s.name
+        ORDER BY s.name`, ownerID, start, end)
+	if err != nil {
+		http.Error(w, "Failed to generate report", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type serviceMarginRow struct {
+		ServiceID     int64    `json:"service_id"`
+		Name          string   `json:"name"`
+		Quantity      float64  `json:"quantity"`
+		Revenue       float64  `json:"revenue"`
+		ProductCost   float64  `json:"product_cost"`
+		CostPerUnit   float64  `json:"cost_per_service"`
+		Margin        float64  `json:"margin"`
+		MarginPercent *float64 `json:"margin_percent"`
+	}
+	results := []serviceMarginRow{}
+	for rows.Next() {
+		var row serviceMarginRow
+		if err := rows.Scan(&row.ServiceID, &row.Name, &row.Quantity, &row.Revenue, &row.ProductCost); err != nil {
+			log.Printf("Failed to scan service margin row: %v", err)
+			continue
+		}
+		row.Revenue, row.ProductCost = round2(row.Revenue), round2(row.ProductCost)
+		row.Margin = round2(row.Revenue - row.ProductCost)
+		if row.Quantity > 0 {
+			row.CostPerUnit = round2(row.ProductCost / row.Quantity)
+		}
+		if row.Revenue > 0 {
+			pct := round2(row.Margin / row.Revenue * 100)
+			row.MarginPercent = &pct
+		}
+		results = append(results, row)
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=service_margin_"+start+"_"+end+".csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"Service", "Quantity", "Revenue", "Product cost", "Cost per service", "Margin", "Margin %"})
+		for _, row := range results {
+			pct := ""
+			if row.MarginPercent != nil {
+				pct = formatQuantity(*row.MarginPercent)
+			}
+			cw.Write([]string{row.Name, formatQuantity(row.Quantity), formatMoney(row.Revenue), formatMoney(row.ProductCost),
+				formatMoney(row.CostPerUnit), formatMoney(row.Margin), pct})
+		}
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"start": start, "end": end, "services": results})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIReorderReport(t *testing.T) {
+	db := openTestDB(t)
+	recent := time.Now().AddDate(0, 0, -5).Format("2006-01-02")
+	old := time.Now().AddDate(0, 0, -45).Format("2006-01-02")
+	mustExec(t, db,
+		`INSERT INTO products (id, owner_id, name, cost_price, retail_price, backroom, stock_on_hand, low_stock_threshold) VALUES
+			(1, 1, 'Shampoo', 4, 12, 0, 5, 2),
+			(2, 1, 'Colour', 0.2, 0, 1, 100, NULL)`,
+		`INSERT INTO invoices (id, owner_id, customer_id, invoice_date, total_amount, payment_status) VALUES
+			(1, 1, 5, '`+recent+`', 0, 'Paid'),
+			(2, 1, 5, '`+old+`', 0, 'Paid')`,
+		`INSERT INTO invoice_items (id, invoice_id, product_id, service_id, description, quantity, unit_price, line_total) VALUES
+			(1, 1, 1, NULL, 'Shampoo', 15, 12, 180),
+			(2, 1, NULL, 10, 'Colour', 1, 80, 80),
+			(3, 2, 1, NULL, 'Shampoo', 30, 12, 360),
+			(4, 2, NULL, 10, 'Colour', 1, 80, 80)`,
+		`INSERT INTO invoice_item_usage (invoice_item_id, invoice_id, product_id, amount, unit_cost) VALUES
+			(2, 1, 2, 300, 0.2),
+			(4, 2, 2, 900, 0.2)`,
+	)
+
+	r := asOwner(httptest.NewRequest(http.MethodGet, "/api/reports/reorder", nil), 1)
+	w := httptest.NewRecorder()
+	APIReorderReport(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", w.Code, w.Body.String())
+	}
+	var report struct {
+		Products []struct {
+			ProductID  int64   `json:"product_id"`
+			Sold       float64 `json:"sold"`
+			Used       float64 `json:"used"`
+			DailySales float64 `json:"daily_sales"`
+			Suggested  int     `json:"suggested_quantity"`
+		} `json:"products"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	got := map[int64][4]float64{}
+	for _, p := range report.Products {
+		got[p.ProductID] = [4]float64{p.Sold, p.Used, p.DailySales, float64(p.Suggested)}
+	}
+	// 15 shampoos sold in 30 days: 15 to cover the next 30 plus 2 spare,
+	// less the 5 in stock.
+	if want := [4]float64{15, 0, 0.5, 12}; got[1] != want {
+		t.Errorf("shampoo = %v, want %v", got[1], want)
+	}
+	// 300 g of colour used: 300 to cover the next 30 days, less the 100 in
+	// stock.
+	if want := [4]float64{0, 300, 10, 200}; got[2] != want {
+		t.Errorf("colour = %v, want %v", got[2], want)
+	}
+}
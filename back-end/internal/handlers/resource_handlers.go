@@ -0,0 +1,407 @@
+// internal/handlers/resource_handlers.go
+// Bookable resources: rooms, stations and equipment with a capacity that
+// services can require alongside a stylist. Appointments reserve the
+// resources their service needs so the availability engine never books more
+// than a resource's capacity at once.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+var validResourceKinds = map[string]bool{"room": true, "station": true, "equipment": true}
+
+// resource is a room, station or piece of equipment. Capacity is how many
+// appointments can use it at the same time.
+type resource struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Capacity int    `json:"capacity"`
+	Active   bool   `json:"active"`
+}
+
+// resourceNeed is how many units of a resource a service takes for its
+// duration and cleanup buffer.
+type resourceNeed struct {
+	ResourceID int64  `json:"resource_id"`
+	Name       string `json:"name,omitempty"`
+	Quantity   int    `json:"quantity"`
+	Capacity   int    `json:"capacity,omitempty"`
+}
+
+// resourceBooking is a stored interval during which quantity units of a
+// resource are reserved.
+type resourceBooking struct {
+	span     busySpan
+	quantity int
+}
+
+// loadServiceResources lists the active resources a service needs.
+func loadServiceResources(q queryer, serviceID int64) ([]resourceNeed, error) {
+	rows, err := q.Query(`
+        SELECT sr.resource_id, r.name, sr.quantity, r.capacity
+        FROM service_resources sr JOIN resources r ON sr.resource_id = r.id
+        WHERE sr.service_id = ? AND r.active = 1
+        ORDER BY r.name`, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	needs := []resourceNeed{}
+	for rows.Next() {
+		var n resourceNeed
+		if err := rows.Scan(&n.ResourceID, &n.Name, &n.Quantity, &n.Capacity); err != nil {
+			return nil, err
+		}
+		needs = append(needs, n)
+	}
+	return needs, rows.Err()
+}
+
+// loadResourceBookings reads the reservations of each needed resource that
+// overlap rangeStart to rangeEnd, cleanup included, ignoring one
+// appointment.
+func loadResourceBookings(q queryer, needs []resourceNeed, rangeStart, rangeEnd string, exclude int64) (map[int64][]resourceBooking, error) {
+	booked := map[int64][]resourceBooking{}
+	for _, n := range needs {
+		rows, err := q.Query(`
+            SELECT a.starts_at, strftime('%Y-%m-%d %H:%M', a.ends_at, '+' || a.buffer_minutes || ' minutes'), ar.quantity
+            FROM appointment_resources ar JOIN appointments a ON ar.appointment_id = a.id
+            WHERE ar.resource_id = ? AND a.status IN ('booked', 'pending') AND a.id != ? AND a.starts_at < ? AND a.ends_at >= ?`,
+			n.ResourceID, exclude, rangeEnd, rangeStart)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var b resourceBooking
+			if err := rows.Scan(&b.span.start, &b.span.end, &b.quantity); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			booked[n.ResourceID] = append(booked[n.ResourceID], b)
+		}
+		rows.Close()
+	}
+	return booked, nil
+}
+
+// resourcesFree reports whether every need fits alongside the existing
+// reservations for the minutes start to end of the day beginning at the
+// wall-clock midnight.
+func resourcesFree(needs []resourceNeed, booked map[int64][]resourceBooking, midnight time.Time, start, end int) bool {
+	for _, n := range needs {
+		type use struct {
+			r        timeRange
+			quantity int
+		}
+		var uses []use
+		// Use only goes up where the slot or a reservation within it starts.
+		points := []int{start}
+		for _, b := range booked[n.ResourceID] {
+			if r, ok := b.span.onDay(midnight); ok && r.start < end && r.end > start {
+				uses = append(uses, use{r, b.quantity})
+				if r.start > start {
+					points = append(points, r.start)
+				}
+			}
+		}
+		for _, p := range points {
+			used := n.Quantity
+			for _, u := range uses {
+				if u.r.start <= p && p < u.r.end {
+					used += u.quantity
+				}
+			}
+			if used > n.Capacity {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// reserveResources replaces the resources held by an appointment.
+func reserveResources(tx *sql.Tx, appointmentID int64, needs []resourceNeed) error {
+	if _, err := tx.Exec("DELETE FROM appointment_resources WHERE appointment_id = ?", appointmentID); err != nil {
+		return err
+	}
+	for _, n := range needs {
+		_, err := tx.Exec("INSERT INTO appointment_resources (appointment_id, resource_id, quantity) VALUES (?, ?, ?)",
+			appointmentID, n.ResourceID, n.Quantity)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- API: List Resources ---
+func APIGetResources(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	rows, err := database.GetDB().Query("SELECT id, name, kind, capacity, active FROM resources WHERE owner_id = ? ORDER BY kind, name", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch resources", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	resources := []resource{}
+	for rows.Next() {
+		var res resource
+		if err := rows.Scan(&res.ID, &res.Name, &res.Kind, &res.Capacity, &res.Active); err != nil {
+			log.Printf("Failed to scan resource: %v", err)
+			continue
+		}
+		resources = append(resources, res)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resources)
+}
+
+// decodeResource reads and validates a resource request body.
+func decodeResource(w http.ResponseWriter, r *http.Request) (resource, bool) {
+	res := resource{Kind: "room", Capacity: 1, Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return res, false
+	}
+	res.Name = strings.TrimSpace(res.Name)
+	res.Kind = strings.ToLower(strings.TrimSpace(res.Kind))
+	if res.Name == "" || len(res.Name) > 100 {
+		http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+		return res, false
+	}
+	if !validResourceKinds[res.Kind] {
+		http.Error(w, "Kind must be room, station or equipment", http.StatusBadRequest)
+		return res, false
+	}
+	if res.Capacity < 1 || res.Capacity > 100 {
+		http.Error(w, "Capacity must be 1-100", http.StatusBadRequest)
+		return res, false
+	}
+	return res, true
+}
+
+// --- API: Add Resource ---
+func APIAddResource(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	res, ok := decodeResource(w, r)
+	if !ok {
+		return
+	}
+	result, err := database.GetDB().Exec(
+		"INSERT INTO resources (owner_id, name, kind, capacity, active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		ownerID, res.Name, res.Kind, res.Capacity, res.Active, time.Now(), time.Now(),
+	)
+	if err != nil {
+		http.Error(w, "Failed to add resource", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+}
+
+// --- API: Update Resource ---
+// Lowering the capacity doesn't touch appointments already booked.
+func APIUpdateResource(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid resource ID", http.StatusBadRequest)
+		return
+	}
+	res, ok := decodeResource(w, r)
+	if !ok {
+		return
+	}
+	result, err := database.GetDB().Exec(
+		"UPDATE resources SET name = ?, kind = ?, capacity = ?, active = ?, updated_at = ? WHERE id = ? AND owner_id = ?",
+		res.Name, res.Kind, res.Capacity, res.Active, time.Now(), id, ownerID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to update resource", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Resource not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Resource ---
+// Resources are deactivated, after which services no longer need them.
+func APIDeleteResource(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid resource ID", http.StatusBadRequest)
+		return
+	}
+	_, err = database.GetDB().Exec("UPDATE resources SET active = 0, updated_at = ? WHERE id = ? AND owner_id = ?", time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete resource", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Get Service Resources ---
+func APIGetServiceResources(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid service ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var exists int
+	if err := db.QueryRow("SELECT 1 FROM services WHERE id = ? AND owner_id = ?", id, ownerID).Scan(&exists); err != nil {
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	needs, err := loadServiceResources(db, id)
+	if err != nil {
+		http.Error(w, "Failed to fetch service resources", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(needs)
+}
+
+// --- API: Update Service Resources ---
+// Replaces the list of resources, with quantities, that a service needs.
+// Existing appointments keep the resources they reserved.
+func APIUpdateServiceResources(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid service ID", http.StatusBadRequest)
+		return
+	}
+	var needs []resourceNeed
+	if err := json.NewDecoder(r.Body).Decode(&needs); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var exists int
+	if err := db.QueryRow("SELECT 1 FROM services WHERE id = ? AND owner_id = ?", id, ownerID).Scan(&exists); err != nil {
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	seen := map[int64]bool{}
+	for _, n := range needs {
+		var name string
+		var capacity int
+		err := db.QueryRow("SELECT name, capacity FROM resources WHERE id = ? AND owner_id = ? AND active = 1", n.ResourceID, ownerID).
+			Scan(&name, &capacity)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Resource %d not found", n.ResourceID), http.StatusBadRequest)
+			return
+		}
+		if seen[n.ResourceID] {
+			http.Error(w, name+" is listed twice", http.StatusBadRequest)
+			return
+		}
+		seen[n.ResourceID] = true
+		if n.Quantity < 1 || n.Quantity > capacity {
+			http.Error(w, fmt.Sprintf("%s needs a quantity of 1-%d", name, capacity), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update service resources", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM service_resources WHERE service_id = ?", id); err != nil {
+		http.Error(w, "Failed to update service resources", http.StatusInternalServerError)
+		return
+	}
+	for _, n := range needs {
+		if _, err := tx.Exec("INSERT INTO service_resources (service_id, resource_id, quantity) VALUES (?, ?, ?)", id, n.ResourceID, n.Quantity); err != nil {
+			http.Error(w, "Failed to update service resources", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update service resources", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,556 @@
+// internal/handlers/schedule_handlers.go
+// Handlers for salon opening hours and closures, and for staff working hours
+// and time off.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// weeklyHours is one working period on a day of the week, 0 being Sunday.
+type weeklyHours struct {
+	Weekday int    `json:"weekday"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+}
+
+// validateWeeklyHours checks each period is on a real day, has "HH:MM"
+// times in order, and doesn't overlap another period on the same day.
+func validateWeeklyHours(hours []weeklyHours) string {
+	byDay := map[int][]timeRange{}
+	for _, h := range hours {
+		if h.Weekday < 0 || h.Weekday > 6 {
+			return "Weekday must be 0 (Sunday) to 6 (Saturday)"
+		}
+		s, err1 := parseClock(h.Start)
+		e, err2 := parseClock(h.End)
+		if err1 != nil || err2 != nil || s >= e {
+			return "Times must be HH:MM with the start before the end"
+		}
+		for _, r := range byDay[h.Weekday] {
+			if s < r.end && r.start < e {
+				return "Working periods on the same day can't overlap"
+			}
+		}
+		byDay[h.Weekday] = append(byDay[h.Weekday], timeRange{s, e})
+	}
+	return ""
+}
+
+// --- API: Get Opening Hours ---
+func APIGetSalonHours(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch opening hours", http.StatusInternalServerError)
+		return
+	}
+	rows, err := db.Query("SELECT weekday, opens, closes FROM salon_hours WHERE owner_id = ? ORDER BY weekday, opens", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch opening hours", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	hours := []weeklyHours{}
+	for rows.Next() {
+		var h weeklyHours
+		if err := rows.Scan(&h.Weekday, &h.Start, &h.End); err != nil {
+			log.Printf("Failed to scan opening hours: %v", err)
+			continue
+		}
+		hours = append(hours, h)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"timezone":      sched.loc.String(),
+		"slot_interval": sched.interval,
+		"hours":         hours,
+	})
+}
+
+// --- API: Update Opening Hours ---
+// Replaces the weekly opening hours and sets the salon's time zone (an IANA
+// name such as "Europe/London") and the interval between bookable slots.
+func APIUpdateSalonHours(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		Timezone     string        `json:"timezone"`
+		SlotInterval int           `json:"slot_interval"`
+		Hours        []weeklyHours `json:"hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Timezone = strings.TrimSpace(req.Timezone)
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			http.Error(w, "Unknown time zone", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.SlotInterval == 0 {
+		req.SlotInterval = 15
+	}
+	if req.SlotInterval < 5 || req.SlotInterval > 120 {
+		http.Error(w, "Slot interval must be 5-120 minutes", http.StatusBadRequest)
+		return
+	}
+	if msg := validateWeeklyHours(req.Hours); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to save opening hours", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE owners SET timezone = ?, slot_interval = ?, updated_at = ? WHERE id = ?",
+		nullIfEmpty(req.Timezone), req.SlotInterval, time.Now(), ownerID); err != nil {
+		http.Error(w, "Failed to save opening hours", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM salon_hours WHERE owner_id = ?", ownerID); err != nil {
+		http.Error(w, "Failed to save opening hours", http.StatusInternalServerError)
+		return
+	}
+	for _, h := range req.Hours {
+		if _, err := tx.Exec("INSERT INTO salon_hours (owner_id, weekday, opens, closes) VALUES (?, ?, ?, ?)",
+			ownerID, h.Weekday, h.Start, h.End); err != nil {
+			http.Error(w, "Failed to save opening hours", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to save opening hours", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type salonClosure struct {
+	ID       int64  `json:"id"`
+	StartsOn string `json:"starts_on"`
+	EndsOn   string `json:"ends_on"`
+	Reason   string `json:"reason"`
+}
+
+// --- API: List Closures ---
+// Lists closures that haven't ended yet; all=1 includes past ones.
+func APIGetClosures(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	since := time.Now().Format("2006-01-02")
+	if r.URL.Query().Get("all") == "1" {
+		since = ""
+	}
+	db := database.GetDB()
+	rows, err := db.Query(`
+        SELECT id, starts_on, ends_on, COALESCE(reason, '') FROM salon_closures
+        WHERE owner_id = ? AND ends_on >= ? ORDER BY starts_on`, ownerID, since)
+	if err != nil {
+		http.Error(w, "Failed to fetch closures", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	closures := []salonClosure{}
+	for rows.Next() {
+		var c salonClosure
+		if err := rows.Scan(&c.ID, &c.StartsOn, &c.EndsOn, &c.Reason); err != nil {
+			log.Printf("Failed to scan closure: %v", err)
+			continue
+		}
+		closures = append(closures, c)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(closures)
+}
+
+// --- API: Add Closure ---
+// Closes the salon for whole days, e.g. public holidays.
+func APIAddClosure(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var c salonClosure
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	c.Reason = strings.TrimSpace(c.Reason)
+	if c.EndsOn == "" {
+		c.EndsOn = c.StartsOn
+	}
+	_, err1 := time.Parse("2006-01-02", c.StartsOn)
+	_, err2 := time.Parse("2006-01-02", c.EndsOn)
+	if err1 != nil || err2 != nil || c.EndsOn < c.StartsOn {
+		http.Error(w, "Give starts_on and ends_on as YYYY-MM-DD, in order", http.StatusBadRequest)
+		return
+	}
+	if len(c.Reason) > 200 {
+		http.Error(w, "Reason is too long (max 200 characters)", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec("INSERT INTO salon_closures (owner_id, starts_on, ends_on, reason, created_at) VALUES (?, ?, ?, ?, ?)",
+		ownerID, c.StartsOn, c.EndsOn, nullIfEmpty(c.Reason), time.Now())
+	if err != nil {
+		http.Error(w, "Failed to add closure", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+}
+
+// --- API: Delete Closure ---
+func APIDeleteClosure(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid closure ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec("DELETE FROM salon_closures WHERE id = ? AND owner_id = ?", id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete closure", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Closure not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// staffIDParam reads the {id} URL parameter and checks the staff member
+// belongs to ownerID, writing the error response if not.
+func staffIDParam(w http.ResponseWriter, r *http.Request, ownerID int) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid staff ID", http.StatusBadRequest)
+		return 0, false
+	}
+	var name string
+	err = database.GetDB().QueryRow("SELECT name FROM staff WHERE id = ? AND owner_id = ?", id, ownerID).Scan(&name)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Staff member not found", http.StatusNotFound)
+		return 0, false
+	} else if err != nil {
+		http.Error(w, "Failed to fetch staff member", http.StatusInternalServerError)
+		return 0, false
+	}
+	return id, true
+}
+
+// --- API: Get Staff Schedule ---
+func APIGetStaffSchedule(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	staffID, ok := staffIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query("SELECT weekday, starts, ends FROM staff_schedules WHERE staff_id = ? ORDER BY weekday, starts", staffID)
+	if err != nil {
+		http.Error(w, "Failed to fetch schedule", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	schedule := []weeklyHours{}
+	for rows.Next() {
+		var h weeklyHours
+		if err := rows.Scan(&h.Weekday, &h.Start, &h.End); err != nil {
+			log.Printf("Failed to scan schedule: %v", err)
+			continue
+		}
+		schedule = append(schedule, h)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// --- API: Update Staff Schedule ---
+// Replaces the staff member's regular weekly working hours.
+func APIUpdateStaffSchedule(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	staffID, ok := staffIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	var schedule []weeklyHours
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if msg := validateWeeklyHours(schedule); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to save schedule", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM staff_schedules WHERE staff_id = ?", staffID); err != nil {
+		http.Error(w, "Failed to save schedule", http.StatusInternalServerError)
+		return
+	}
+	for _, h := range schedule {
+		if _, err := tx.Exec("INSERT INTO staff_schedules (staff_id, weekday, starts, ends) VALUES (?, ?, ?, ?)",
+			staffID, h.Weekday, h.Start, h.End); err != nil {
+			http.Error(w, "Failed to save schedule", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to save schedule", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// validTimeOffKinds are the kinds of schedule exception a staff member can
+// have.
+var validTimeOffKinds = map[string]bool{
+	"holiday": true,
+	"sick":    true,
+	"other":   true,
+}
+
+type staffTimeOff struct {
+	ID       int64  `json:"id"`
+	Kind     string `json:"kind"`
+	StartsAt string `json:"starts_at"`
+	EndsAt   string `json:"ends_at"`
+	Reason   string `json:"reason"`
+	Imported bool   `json:"imported"`
+}
+
+// --- API: List Staff Time Off ---
+// Lists time off that hasn't ended yet; all=1 includes past time off.
+func APIGetStaffTimeOff(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	staffID, ok := staffIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	since := ""
+	if r.URL.Query().Get("all") != "1" {
+		if sched, err := loadSalonSchedule(db, ownerID); err == nil {
+			since = time.Now().In(sched.loc).Format(dateTimeLayout)
+		}
+	}
+	rows, err := db.Query(`
+        SELECT id, kind, starts_at, ends_at, COALESCE(reason, ''), source_uid IS NOT NULL FROM staff_time_off
+        WHERE staff_id = ? AND ends_at > ? ORDER BY starts_at`, staffID, since)
+	if err != nil {
+		http.Error(w, "Failed to fetch time off", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	timeOff := []staffTimeOff{}
+	for rows.Next() {
+		var t staffTimeOff
+		if err := rows.Scan(&t.ID, &t.Kind, &t.StartsAt, &t.EndsAt, &t.Reason, &t.Imported); err != nil {
+			log.Printf("Failed to scan time off: %v", err)
+			continue
+		}
+		timeOff = append(timeOff, t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeOff)
+}
+
+// --- API: Add Staff Time Off ---
+// starts_at and ends_at are "YYYY-MM-DD HH:MM" in the salon's time zone, or
+// plain dates for whole days (ends_at being the last day off).
+func APIAddStaffTimeOff(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	staffID, ok := staffIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	var t staffTimeOff
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	t.Kind = strings.ToLower(strings.TrimSpace(t.Kind))
+	t.Reason = strings.TrimSpace(t.Reason)
+	if t.Kind == "" {
+		t.Kind = "holiday"
+	}
+	if !validTimeOffKinds[t.Kind] {
+		http.Error(w, "Kind must be holiday, sick or other", http.StatusBadRequest)
+		return
+	}
+	if len(t.Reason) > 200 {
+		http.Error(w, "Reason is too long (max 200 characters)", http.StatusBadRequest)
+		return
+	}
+	if d, err := time.Parse("2006-01-02", t.StartsAt); err == nil {
+		t.StartsAt = d.Format(dateTimeLayout)
+	}
+	if d, err := time.Parse("2006-01-02", t.EndsAt); err == nil {
+		t.EndsAt = d.AddDate(0, 0, 1).Format(dateTimeLayout)
+	}
+	_, err1 := time.Parse(dateTimeLayout, t.StartsAt)
+	_, err2 := time.Parse(dateTimeLayout, t.EndsAt)
+	if err1 != nil || err2 != nil || t.EndsAt <= t.StartsAt {
+		http.Error(w, "Give starts_at and ends_at as YYYY-MM-DD or YYYY-MM-DD HH:MM, in order", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec(`
+        INSERT INTO staff_time_off (staff_id, kind, starts_at, ends_at, reason, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		staffID, t.Kind, t.StartsAt, t.EndsAt, nullIfEmpty(t.Reason), time.Now())
+	if err != nil {
+		http.Error(w, "Failed to add time off", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+}
+
+// --- API: Delete Staff Time Off ---
+func APIDeleteStaffTimeOff(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	staffID, ok := staffIDParam(w, r, ownerID)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "timeOffID"))
+	if err != nil {
+		http.Error(w, "Invalid time off ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec("DELETE FROM staff_time_off WHERE id = ? AND staff_id = ?", id, staffID)
+	if err != nil {
+		http.Error(w, "Failed to delete time off", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Time off not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,205 @@
+// internal/handlers/service_handlers.go
+// Handlers for the salon's service catalogue.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+type service struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	Category  string  `json:"category"`
+	Price     float64 `json:"price"`
+	TaxRateID *int64  `json:"tax_rate_id"`
+	Active    bool    `json:"active"`
+	// Duration and Buffer are in minutes; the buffer is cleanup time after
+	// the service during which the stylist can't be booked.
+	Duration int `json:"duration_minutes"`
+	Buffer   int `json:"buffer_minutes"`
+	// OnlineBooking lists the service on the public booking portal.
+	OnlineBooking bool `json:"online_booking"`
+}
+
+// --- API: List Services ---
+func APIGetServices(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query(`SELECT id, name, COALESCE(category, ''), price, tax_rate_id, active, COALESCE(duration_minutes, 30),
+        COALESCE(buffer_minutes, 0), COALESCE(online_booking, 0) FROM services WHERE owner_id = ? ORDER BY name`, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch services", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	services := []service{}
+	for rows.Next() {
+		var s service
+		var taxRateID sql.NullInt64
+		if err := rows.Scan(&s.ID, &s.Name, &s.Category, &s.Price, &taxRateID, &s.Active, &s.Duration, &s.Buffer, &s.OnlineBooking); err != nil {
+			log.Printf("Failed to scan service: %v", err)
+			continue
+		}
+		if taxRateID.Valid {
+			s.TaxRateID = &taxRateID.Int64
+		}
+		services = append(services, s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services)
+}
+
+// decodeService reads and validates a service request body.
+func decodeService(w http.ResponseWriter, r *http.Request, ownerID int) (service, bool) {
+	s := service{Active: true, Duration: 30}
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return s, false
+	}
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" || len(s.Name) > 100 {
+		http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+		return s, false
+	}
+	s.Category = strings.TrimSpace(s.Category)
+	if len(s.Category) > 50 {
+		http.Error(w, "Category is too long (max 50 characters)", http.StatusBadRequest)
+		return s, false
+	}
+	if s.Price < 0 {
+		http.Error(w, "Price cannot be negative", http.StatusBadRequest)
+		return s, false
+	}
+	if s.Duration <= 0 || s.Duration > 720 || s.Buffer < 0 || s.Buffer > 240 {
+		http.Error(w, "Duration must be 1-720 minutes and buffer 0-240 minutes", http.StatusBadRequest)
+		return s, false
+	}
+	if s.TaxRateID != nil {
+		if _, err := lookupTaxRate(database.GetDB(), ownerID, *s.TaxRateID); err != nil {
+			http.Error(w, "Tax rate not found", http.StatusBadRequest)
+			return s, false
+		}
+	}
+	return s, true
+}
+
+// --- API: Add Service ---
+func APIAddService(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	s, ok := decodeService(w, r, ownerID)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec(
+		`INSERT INTO services (owner_id, name, category, price, tax_rate_id, active, duration_minutes, buffer_minutes, online_booking,
+            created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, s.Name, nullIfEmpty(s.Category), s.Price, s.TaxRateID, s.Active, s.Duration, s.Buffer, s.OnlineBooking, time.Now(), time.Now(),
+	)
+	if err != nil {
+		http.Error(w, "Failed to add service", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+}
+
+// --- API: Update Service ---
+func APIUpdateService(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid service ID", http.StatusBadRequest)
+		return
+	}
+	s, ok := decodeService(w, r, ownerID)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec(
+		`UPDATE services SET name = ?, category = ?, price = ?, tax_rate_id = ?, active = ?, duration_minutes = ?, buffer_minutes = ?,
+            online_booking = ?, updated_at = ?
+        WHERE id = ? AND owner_id = ?`,
+		s.Name, nullIfEmpty(s.Category), s.Price, s.TaxRateID, s.Active, s.Duration, s.Buffer, s.OnlineBooking, time.Now(), id, ownerID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to update service", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Service ---
+// Services are deactivated rather than removed so invoice history keeps them.
+func APIDeleteService(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid service ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	_, err = db.Exec("UPDATE services SET active = 0, updated_at = ? WHERE id = ? AND owner_id = ?", time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete service", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -2,8 +2,14 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"io"
 	"net/http"
 	"salon-management/internal/database"
+	"strings"
+	"time"
 	// "salon-management/views"
 )
 
@@ -31,7 +37,16 @@ import (
 
 // UpdateReminderTemplate saves the new reminder message.
 func UpdateReminderTemplate(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(UserIDKey).(int)
+	var userID int
+	switch v := r.Context().Value(UserIDKey).(type) {
+	case int:
+		userID = v
+	case int64:
+		userID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
 	db := database.GetDB()
 	r.ParseForm()
 	eventType := r.FormValue("event_type")
@@ -50,3 +65,77 @@ func UpdateReminderTemplate(w http.ResponseWriter, r *http.Request) {
 
 	w.Write([]byte(`<div class="text-green-500 mt-2">Template saved!</div>`))
 }
+
+// APIUpdateInvoiceSettings saves the logo and footer text printed on PDF
+// receipts. It takes a multipart form with an optional "logo" file (PNG,
+// JPEG or GIF, up to 1 MB), "footer" text and "remove_logo=true". Fields
+// left out keep their current value.
+func APIUpdateInvoiceSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseMultipartForm(2 << 20); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	_, hasFooter := r.PostForm["footer"]
+	footer := strings.TrimSpace(r.PostFormValue("footer"))
+	if len(footer) > 500 {
+		http.Error(w, "Footer is too long (max 500 characters)", http.StatusBadRequest)
+		return
+	}
+
+	var logo []byte
+	file, _, err := r.FormFile("logo")
+	if err == nil {
+		defer file.Close()
+		logo, err = io.ReadAll(io.LimitReader(file, 1<<20+1))
+		if err != nil {
+			http.Error(w, "Failed to read logo", http.StatusBadRequest)
+			return
+		}
+		if len(logo) > 1<<20 {
+			http.Error(w, "Logo is too large (max 1 MB)", http.StatusBadRequest)
+			return
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(logo))
+		if err != nil || (format != "png" && format != "jpeg" && format != "gif") {
+			http.Error(w, "Logo must be a PNG, JPEG or GIF image", http.StatusBadRequest)
+			return
+		}
+		if cfg.Width > 2000 || cfg.Height > 2000 {
+			http.Error(w, "Logo is too large (max 2000x2000 pixels)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	db := database.GetDB()
+	if hasFooter {
+		if _, err := db.Exec("UPDATE owners SET invoice_footer = ?, updated_at = ? WHERE id = ?", footer, time.Now(), ownerID); err != nil {
+			http.Error(w, "Failed to save invoice settings", http.StatusInternalServerError)
+			return
+		}
+	}
+	switch {
+	case logo != nil:
+		_, err = db.Exec("UPDATE owners SET logo = ? WHERE id = ?", logo, ownerID)
+	case r.FormValue("remove_logo") == "true":
+		_, err = db.Exec("UPDATE owners SET logo = NULL WHERE id = ?", ownerID)
+	default:
+		err = nil
+	}
+	if err != nil {
+		http.Error(w, "Failed to save logo", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invoice settings saved"})
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIUpdateInvoiceSettings(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO owners (id, email, password_hash) VALUES (1, 'a@example.com', 'x')`)
+	var logo bytes.Buffer
+	png.Encode(&logo, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+
+	update := func(fields map[string]string, withLogo bool) int {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for name, value := range fields {
+			form.WriteField(name, value)
+		}
+		if withLogo {
+			part, _ := form.CreateFormFile("logo", "logo.png")
+			part.Write(logo.Bytes())
+		}
+		form.Close()
+		r := httptest.NewRequest(http.MethodPost, "/api/settings/invoice", &body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		APIUpdateInvoiceSettings(w, asOwner(r, 1))
+		return w.Code
+	}
+	saved := func() (footer string, hasLogo bool) {
+		var logo []byte
+		db.QueryRow("SELECT COALESCE(invoice_footer, ''), logo FROM owners WHERE id = 1").Scan(&footer, &logo)
+		return footer, logo != nil
+	}
+
+	if code := update(map[string]string{"footer": " Thanks for visiting! "}, false); code != http.StatusOK {
+		t.Fatalf("saving footer: status %d", code)
+	}
+	if footer, _ := saved(); footer != "Thanks for visiting!" {
+		t.Errorf("footer = %q", footer)
+	}
+	// Uploading a logo on its own keeps the footer.
+	if code := update(nil, true); code != http.StatusOK {
+		t.Fatalf("uploading logo: status %d", code)
+	}
+	if footer, hasLogo := saved(); footer != "Thanks for visiting!" || !hasLogo {
+		t.Errorf("after logo upload footer = %q, logo %v", footer, hasLogo)
+	}
+	if code := update(map[string]string{"remove_logo": "true"}, false); code != http.StatusOK {
+		t.Fatalf("removing logo: status %d", code)
+	}
+	if footer, hasLogo := saved(); footer != "Thanks for visiting!" || hasLogo {
+		t.Errorf("after removing logo footer = %q, logo %v", footer, hasLogo)
+	}
+	// An empty footer field clears it.
+	update(map[string]string{"footer": ""}, false)
+	if footer, _ := saved(); footer != "" {
+		t.Errorf("after clearing footer = %q", footer)
+	}
+}
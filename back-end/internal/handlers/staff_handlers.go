@@ -0,0 +1,233 @@
+// internal/handlers/staff_handlers.go
+// Handlers for the salon's staff members.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// staffMember is a stylist or other employee. Contact details are stored
+// encrypted like customer contact details.
+type staffMember struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Email  string `json:"email,omitempty"`
+	Phone  string `json:"phone,omitempty"`
+	Active bool   `json:"active"`
+}
+
+// lookupStaff checks that staffID is an active staff member of ownerID.
+func lookupStaff(q queryer, ownerID int, staffID int64) error {
+	var name string
+	return q.QueryRow("SELECT name FROM staff WHERE id = ? AND owner_id = ? AND active = 1", staffID, ownerID).Scan(&name)
+}
+
+// --- API: List Staff ---
+func APIGetStaff(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query("SELECT id, name, email, phone, active FROM staff WHERE owner_id = ? ORDER BY name", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch staff", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	staff := []staffMember{}
+	for rows.Next() {
+		var s staffMember
+		var email, phone []byte
+		if err := rows.Scan(&s.ID, &s.Name, &email, &phone, &s.Active); err != nil {
+			log.Printf("Failed to scan staff member: %v", err)
+			continue
+		}
+		if len(email) > 0 {
+			s.Email, _ = decryptField(email)
+		}
+		if len(phone) > 0 {
+			s.Phone, _ = decryptField(phone)
+		}
+		staff = append(staff, s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(staff)
+}
+
+// decodeStaff reads and validates a staff request body and returns the
+// encrypted email and phone.
+func decodeStaff(w http.ResponseWriter, r *http.Request) (staffMember, []byte, []byte, bool) {
+	s := staffMember{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return s, nil, nil, false
+	}
+	s.Name = strings.TrimSpace(s.Name)
+	s.Email = strings.TrimSpace(s.Email)
+	s.Phone = strings.TrimSpace(s.Phone)
+	if s.Name == "" || len(s.Name) > 100 {
+		http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+		return s, nil, nil, false
+	}
+	if s.Email != "" {
+		if _, err := mail.ParseAddress(s.Email); err != nil {
+			http.Error(w, "Invalid email", http.StatusBadRequest)
+			return s, nil, nil, false
+		}
+	}
+	if s.Phone != "" && !regexp.MustCompile(`^[0-9 +()-]*$`).MatchString(s.Phone) {
+		http.Error(w, "Invalid phone number", http.StatusBadRequest)
+		return s, nil, nil, false
+	}
+	var email, phone []byte
+	var err error
+	if s.Email != "" {
+		if email, err = encryptField(s.Email); err != nil {
+			http.Error(w, "Failed to encrypt email", http.StatusInternalServerError)
+			return s, nil, nil, false
+		}
+	}
+	if s.Phone != "" {
+		if phone, err = encryptField(s.Phone); err != nil {
+			http.Error(w, "Failed to encrypt phone", http.StatusInternalServerError)
+			return s, nil, nil, false
+		}
+	}
+	return s, email, phone, true
+}
+
+// --- API: Add Staff Member ---
+func APIAddStaff(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	s, email, phone, ok := decodeStaff(w, r)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec(
+		"INSERT INTO staff (owner_id, name, email, phone, active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		ownerID, s.Name, email, phone, s.Active, time.Now(), time.Now(),
+	)
+	if err != nil {
+		http.Error(w, "Failed to add staff member", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+}
+
+// --- API: Update Staff Member ---
+func APIUpdateStaff(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid staff ID", http.StatusBadRequest)
+		return
+	}
+	s, email, phone, ok := decodeStaff(w, r)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec(
+		"UPDATE staff SET name = ?, email = ?, phone = ?, active = ?, updated_at = ? WHERE id = ? AND owner_id = ?",
+		s.Name, email, phone, s.Active, time.Now(), id, ownerID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to update staff member", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Staff member not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Staff Member ---
+// Staff are deactivated so their sales and commission history is kept.
+func APIDeleteStaff(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid staff ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	_, err = db.Exec("UPDATE staff SET active = 0, updated_at = ? WHERE id = ? AND owner_id = ?", time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete staff member", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// staffNames maps staff IDs to names for reports.
+func staffNames(db *sql.DB, ownerID int) (map[int64]string, error) {
+	rows, err := db.Query("SELECT id, name FROM staff WHERE owner_id = ?", ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,206 @@
+// internal/handlers/supplier_handlers.go
+// Handlers for the suppliers retail products are bought from.
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+type supplier struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	ContactName string `json:"contact_name"`
+	Email       string `json:"email"`
+	Phone       string `json:"phone"`
+	Address     string `json:"address"`
+	Notes       string `json:"notes"`
+	Active      bool   `json:"active"`
+}
+
+const supplierColumns = `id, name, COALESCE(contact_name, ''), COALESCE(email, ''), COALESCE(phone, ''),
+    COALESCE(address, ''), COALESCE(notes, ''), active`
+
+func scanSupplier(row rowScanner) (supplier, error) {
+	var s supplier
+	err := row.Scan(&s.ID, &s.Name, &s.ContactName, &s.Email, &s.Phone, &s.Address, &s.Notes, &s.Active)
+	return s, err
+}
+
+// lookupSupplier checks that supplierID is an active supplier of ownerID.
+func lookupSupplier(q queryer, ownerID int, supplierID int64) error {
+	var name string
+	return q.QueryRow("SELECT name FROM suppliers WHERE id = ? AND owner_id = ? AND active = 1", supplierID, ownerID).Scan(&name)
+}
+
+// --- API: List Suppliers ---
+func APIGetSuppliers(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query("SELECT "+supplierColumns+" FROM suppliers WHERE owner_id = ? ORDER BY name", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch suppliers", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	suppliers := []supplier{}
+	for rows.Next() {
+		s, err := scanSupplier(rows)
+		if err != nil {
+			log.Printf("Failed to scan supplier: %v", err)
+			continue
+		}
+		suppliers = append(suppliers, s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suppliers)
+}
+
+// decodeSupplier reads and validates a supplier request body.
+func decodeSupplier(w http.ResponseWriter, r *http.Request) (supplier, bool) {
+	s := supplier{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return s, false
+	}
+	s.Name = strings.TrimSpace(s.Name)
+	s.ContactName = strings.TrimSpace(s.ContactName)
+	s.Email = strings.TrimSpace(s.Email)
+	s.Phone = strings.TrimSpace(s.Phone)
+	s.Address = strings.TrimSpace(s.Address)
+	s.Notes = strings.TrimSpace(s.Notes)
+	if s.Name == "" || len(s.Name) > 100 {
+		http.Error(w, "Name is required (max 100 characters)", http.StatusBadRequest)
+		return s, false
+	}
+	if len(s.ContactName) > 100 || len(s.Phone) > 30 || len(s.Address) > 200 || len(s.Notes) > 500 {
+		http.Error(w, "Supplier details are too long", http.StatusBadRequest)
+		return s, false
+	}
+	if s.Email != "" {
+		if _, err := mail.ParseAddress(s.Email); err != nil {
+			http.Error(w, "Invalid email", http.StatusBadRequest)
+			return s, false
+		}
+	}
+	return s, true
+}
+
+// --- API: Add Supplier ---
+func APIAddSupplier(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	s, ok := decodeSupplier(w, r)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec(`
+        INSERT INTO suppliers (owner_id, name, contact_name, email, phone, address, notes, active, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, s.Name, nullIfEmpty(s.ContactName), nullIfEmpty(s.Email), nullIfEmpty(s.Phone),
+		nullIfEmpty(s.Address), nullIfEmpty(s.Notes), s.Active, time.Now(), time.Now())
+	if err != nil {
+		http.Error(w, "Failed to add supplier", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+}
+
+// --- API: Update Supplier ---
+func APIUpdateSupplier(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid supplier ID", http.StatusBadRequest)
+		return
+	}
+	s, ok := decodeSupplier(w, r)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec(`
+        UPDATE suppliers SET name = ?, contact_name = ?, email = ?, phone = ?, address = ?, notes = ?, active = ?,
+            updated_at = ?
+        WHERE id = ? AND owner_id = ?`,
+		s.Name, nullIfEmpty(s.ContactName), nullIfEmpty(s.Email), nullIfEmpty(s.Phone),
+		nullIfEmpty(s.Address), nullIfEmpty(s.Notes), s.Active, time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to update supplier", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Supplier not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Supplier ---
+// Suppliers are deactivated so purchase orders keep them.
+func APIDeleteSupplier(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid supplier ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	_, err = db.Exec("UPDATE suppliers SET active = 0, updated_at = ? WHERE id = ? AND owner_id = ?", time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete supplier", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
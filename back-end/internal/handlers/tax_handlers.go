@@ -0,0 +1,215 @@
+// internal/handlers/tax_handlers.go
+// Handlers for owner-defined tax rates and tax pricing settings.
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+type taxRate struct {
+	ID     int64   `json:"id"`
+	Name   string  `json:"name"`
+	Rate   float64 `json:"rate"`
+	Active bool    `json:"active"`
+}
+
+// --- API: List Tax Rates ---
+func APIGetTaxRates(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	rows, err := db.Query("SELECT id, name, rate, active FROM tax_rates WHERE owner_id = ? ORDER BY name", ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch tax rates", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	rates := []taxRate{}
+	for rows.Next() {
+		var t taxRate
+		if err := rows.Scan(&t.ID, &t.Name, &t.Rate, &t.Active); err != nil {
+			log.Printf("Failed to scan tax rate: %v", err)
+			continue
+		}
+		rates = append(rates, t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rates)
+}
+
+// decodeTaxRate reads and validates a tax rate request body.
+func decodeTaxRate(w http.ResponseWriter, r *http.Request) (taxRate, bool) {
+	t := taxRate{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return t, false
+	}
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" || len(t.Name) > 50 {
+		http.Error(w, "Name is required (max 50 characters)", http.StatusBadRequest)
+		return t, false
+	}
+	if t.Rate < 0 || t.Rate > 100 {
+		http.Error(w, "Rate must be between 0 and 100", http.StatusBadRequest)
+		return t, false
+	}
+	return t, true
+}
+
+// --- API: Add Tax Rate ---
+func APIAddTaxRate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	t, ok := decodeTaxRate(w, r)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec(
+		"INSERT INTO tax_rates (owner_id, name, rate, active, created_at, updated_at) VALUES (?, ?, ?, 1, ?, ?)",
+		ownerID, t.Name, t.Rate, time.Now(), time.Now(),
+	)
+	if err != nil {
+		http.Error(w, "Failed to add tax rate", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+}
+
+// --- API: Update Tax Rate ---
+// Issued invoices keep the name and rate they were created with.
+func APIUpdateTaxRate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid tax rate ID", http.StatusBadRequest)
+		return
+	}
+	t, ok := decodeTaxRate(w, r)
+	if !ok {
+		return
+	}
+	db := database.GetDB()
+	res, err := db.Exec(
+		"UPDATE tax_rates SET name = ?, rate = ?, active = ?, updated_at = ? WHERE id = ? AND owner_id = ?",
+		t.Name, t.Rate, t.Active, time.Now(), id, ownerID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to update tax rate", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Tax rate not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Delete Tax Rate ---
+// Rates are archived rather than removed so past invoices still reference them.
+func APIDeleteTaxRate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid tax rate ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	_, err = db.Exec("UPDATE tax_rates SET active = 0, updated_at = ? WHERE id = ? AND owner_id = ?", time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to delete tax rate", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// lookupTaxRate returns an active tax rate belonging to ownerID.
+func lookupTaxRate(q queryer, ownerID int, taxRateID int64) (taxRate, error) {
+	t := taxRate{ID: taxRateID}
+	err := q.QueryRow(
+		"SELECT name, rate, active FROM tax_rates WHERE id = ? AND owner_id = ? AND active = 1",
+		taxRateID, ownerID,
+	).Scan(&t.Name, &t.Rate, &t.Active)
+	return t, err
+}
+
+// --- API: Tax Settings ---
+// Body: {"prices_include_tax": true}. When set, service and item prices are
+// treated as already including tax and invoices show the tax contained in
+// the total instead of adding it on top.
+func APIUpdateTaxSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		PricesIncludeTax bool `json:"prices_include_tax"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	if _, err := db.Exec("UPDATE owners SET prices_include_tax = ?, updated_at = ? WHERE id = ?", req.PricesIncludeTax, time.Now(), ownerID); err != nil {
+		http.Error(w, "Failed to save tax settings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
@@ -0,0 +1,716 @@
+// internal/handlers/waitlist_handlers.go
+// The waitlist: customers waiting for a slot with a service, optionally a
+// stylist, and the window of times they can make. When an appointment is
+// cancelled the freed slot is offered to matching entries in the order they
+// joined, and the first to accept is booked into it.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+	"salon-management/internal/reminders"
+)
+
+// errOfferClosed is returned by acceptWaitlistOffer for offers that have
+// expired, already been answered, or whose entry is no longer waiting.
+var errOfferClosed = errors.New("This offer has expired or is no longer available")
+
+type waitlistEntry struct {
+	ID            int64     `json:"id"`
+	CustomerID    int64     `json:"customer_id"`
+	CustomerName  string    `json:"customer_name"`
+	ServiceID     int64     `json:"service_id"`
+	ServiceName   string    `json:"service_name"`
+	StaffID       *int64    `json:"staff_id"`
+	StaffName     string    `json:"staff_name,omitempty"`
+	Earliest      string    `json:"earliest"`
+	Latest        string    `json:"latest"`
+	Notes         string    `json:"notes"`
+	Status        string    `json:"status"`
+	AppointmentID *int64    `json:"appointment_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type waitlistOffer struct {
+	ID          int64      `json:"id"`
+	StaffID     int64      `json:"staff_id"`
+	Start       string     `json:"start"`
+	Channel     string     `json:"channel,omitempty"`
+	Recipient   string     `json:"recipient,omitempty"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// offerFreedSlot offers the slot a cancelled appointment has freed to the
+// waitlist. Entries are taken in the order they joined, up to the owner's
+// limit, and each must accept that stylist, have the start time in its
+// window and want a service that fits the gap. Errors are only logged, as
+// this runs after the cancellation has been saved.
+func offerFreedSlot(db *sql.DB, ownerID int, appointmentID int64, now time.Time) {
+	var staffID, customerID int64
+	var startsAt string
+	err := db.QueryRow("SELECT staff_id, customer_id, starts_at FROM appointments WHERE id = ? AND owner_id = ?",
+		appointmentID, ownerID).Scan(&staffID, &customerID, &startsAt)
+	if err != nil {
+		log.Printf("Failed to load cancelled appointment %d for the waitlist: %v", appointmentID, err)
+		return
+	}
+	var minutes, maxOffers int
+	var salonName string
+	err = db.QueryRow(`
+        SELECT COALESCE(waitlist_offer_minutes, 30), COALESCE(waitlist_max_offers, 5), COALESCE(salon_name, '')
+        FROM owners WHERE id = ?`, ownerID).Scan(&minutes, &maxOffers, &salonName)
+	if err != nil || maxOffers == 0 {
+		return
+	}
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		log.Printf("Failed to load schedule for owner %d: %v", ownerID, err)
+		return
+	}
+	start, err := time.ParseInLocation(dateTimeLayout, startsAt, sched.loc)
+	if err != nil || !start.After(now) {
+		return
+	}
+
+	rows, err := db.Query(`
+        SELECT w.id, w.service_id, sv.name, c.name, c.phone, c.email, `+optOutColumns+`, st.name
+        FROM waitlist_entries w
+        JOIN customers c ON w.customer_id = c.id
+        JOIN services sv ON w.service_id = sv.id
+        JOIN staff st ON st.id = ?
+        WHERE w.owner_id = ? AND w.status = 'waiting' AND (w.staff_id IS NULL OR w.staff_id = ?)
+            AND w.earliest <= ? AND w.latest >= ? AND w.customer_id != ?
+        ORDER BY w.created_at, w.id`, staffID, ownerID, staffID, startsAt, startsAt, customerID)
+	if err != nil {
+		log.Printf("Failed to query the waitlist: %v", err)
+		return
+	}
+	type candidate struct {
+		entryID, serviceID int64
+		notice             appointmentNotice
+	}
+	var candidates []candidate
+	for rows.Next() {
+		c := candidate{notice: appointmentNotice{ownerID: ownerID, salonName: salonName, start: start}}
+		if err := rows.Scan(&c.entryID, &c.serviceID, &c.notice.service, &c.notice.customerName,
+			&c.notice.phone, &c.notice.email, &c.notice.smsOptOut, &c.notice.emailOptOut, &c.notice.stylist); err != nil {
+			log.Printf("Failed to scan waitlist entry: %v", err)
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, sched.loc)
+	expires := now.Add(time.Duration(minutes) * time.Minute)
+	if expires.After(start) {
+		expires = start
+	}
+	offered := 0
+	for _, c := range candidates {
+		if offered >= maxOffers {
+			break
+		}
+		_, duration, buffer, err := loadServiceTiming(db, ownerID, c.serviceID)
+		if err != nil {
+			continue
+		}
+		needs, err := loadServiceResources(db, c.serviceID)
+		if err != nil {
+			log.Printf("Failed to load resources for waitlist entry %d: %v", c.entryID, err)
+			continue
+		}
+		slots, err := findAvailableSlots(db, ownerID, sched, staffID, duration, buffer, needs, day, day, now, 0)
+		if err != nil {
+			log.Printf("Failed to check availability for waitlist entry %d: %v", c.entryID, err)
+			continue
+		}
+		fits := false
+		for _, s := range slots {
+			if s.Start == start.Format(time.RFC3339) {
+				fits = true
+				break
+			}
+		}
+		if !fits {
+			continue
+		}
+		if err := sendWaitlistOffer(db, ownerID, c.entryID, staffID, startsAt, c.notice, expires, now); err != nil {
+			log.Printf("Failed to offer slot to waitlist entry %d: %v", c.entryID, err)
+		}
+		offered++
+	}
+}
+
+// sendWaitlistOffer records an offer of the slot to a waitlist entry and
+// sends it. Failed sends are recorded and not retried.
+func sendWaitlistOffer(db *sql.DB, ownerID int, entryID, staffID int64, startsAt string, a appointmentNotice,
+	expires, now time.Time) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec(`
+        INSERT INTO waitlist_offers (owner_id, entry_id, token, staff_id, starts_at, status, expires_at, created_at)
+        VALUES (?, ?, ?, ?, ?, 'sending', ?, ?)`, ownerID, entryID, token, staffID, startsAt, expires, now)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+
+	channel, to := a.contact()
+	status, errText := "sent", ""
+	if to == "" {
+		status, errText = "skipped", a.unreachable()
+	} else {
+		fields := a.fields()
+		fields["Link"] = publicBaseURL() + "/waitlist/" + token
+		fields["Minutes"] = strconv.Itoa(int(expires.Sub(now).Minutes()))
+		message := reminders.FillTemplate(loadReminderTemplate(db, ownerID, "waitlist_offer"), fields)
+		if channel == "sms" {
+			err = reminders.SendSMS(to, message)
+		} else {
+			err = reminders.SendEmail(to, "A slot has opened at "+a.salonName, message)
+		}
+		if err != nil {
+			status, errText = "failed", err.Error()
+		}
+	}
+	_, err = db.Exec("UPDATE waitlist_offers SET channel = ?, recipient = ?, recipient_hash = ?, status = ?, error = ? WHERE id = ?",
+		nullIfEmpty(channel), nullIfEmpty(maskContact(to)), nullIfEmpty(contactHashIfSet(to)), status, nullIfEmpty(errText), id)
+	return err
+}
+
+// acceptWaitlistOffer books the offered slot for the entry's customer and
+// closes the other offers of the same slot. The booking is left pending when
+// the cancellation policy asks for a deposit. If someone else has taken the
+// slot in the meantime the offer is marked missed and errSlotUnavailable
+// returned.
+func acceptWaitlistOffer(db *sql.DB, offerID int64, now time.Time) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var ownerID int
+	var entryID, customerID, serviceID, staffID int64
+	var startsAt, status, entryStatus string
+	var expiresAt time.Time
+	err = tx.QueryRow(`
+        SELECT o.owner_id, o.entry_id, w.customer_id, w.service_id, o.staff_id, o.starts_at, o.status, o.expires_at, w.status
+        FROM waitlist_offers o JOIN waitlist_entries w ON o.entry_id = w.id
+        WHERE o.id = ?`, offerID).Scan(&ownerID, &entryID, &customerID, &serviceID, &staffID, &startsAt, &status, &expiresAt, &entryStatus)
+	if err != nil {
+		return 0, err
+	}
+	if status != "sent" || !now.Before(expiresAt) || entryStatus != "waiting" {
+		return 0, errOfferClosed
+	}
+	sched, err := loadSalonSchedule(tx, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	policy, err := loadCancellationPolicy(tx, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	deposit, err := depositFor(tx, customerID, serviceID, policy)
+	if err != nil {
+		return 0, err
+	}
+	appointmentStatus := "booked"
+	if deposit > 0 {
+		appointmentStatus = "pending"
+	}
+	b := appointmentBooking{CustomerID: customerID, ServiceID: serviceID, StaffID: staffID, Start: startsAt}
+	appointmentID, _, err := bookAppointment(tx, ownerID, sched, b, "waitlist", appointmentStatus, now)
+	if err == errSlotUnavailable {
+		tx.Rollback()
+		if _, err := db.Exec("UPDATE waitlist_offers SET status = 'missed', responded_at = ? WHERE id = ?", now, offerID); err != nil {
+			return 0, err
+		}
+		return 0, errSlotUnavailable
+	} else if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("UPDATE waitlist_offers SET status = 'accepted', responded_at = ? WHERE id = ?", now, offerID); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`
+        UPDATE waitlist_offers SET status = 'missed' WHERE owner_id = ? AND staff_id = ? AND starts_at = ? AND status = 'sent'`,
+		ownerID, staffID, startsAt)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("UPDATE waitlist_entries SET status = 'booked', appointment_id = ?, updated_at = ? WHERE id = ?",
+		appointmentID, now, entryID)
+	if err != nil {
+		return 0, err
+	}
+	return appointmentID, tx.Commit()
+}
+
+// handleWaitlistReply accepts the latest open offer texted to a phone
+// number, returning the acknowledgement to send back. The acknowledgement
+// of a booking is recorded as its confirmation so it isn't sent twice.
+func handleWaitlistReply(db *sql.DB, phone string, now time.Time) (string, error) {
+	var offerID int64
+	var ownerID int
+	err := db.QueryRow(`
+        SELECT id, owner_id FROM waitlist_offers
+        WHERE recipient_hash = ? AND channel = 'sms' AND status = 'sent' AND expires_at > ?
+        ORDER BY id DESC LIMIT 1`, contactHash(phone), now).Scan(&offerID, &ownerID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		return "", err
+	}
+
+	appointmentID, err := acceptWaitlistOffer(db, offerID, now)
+	if err == errSlotUnavailable || err == errOfferClosed {
+		a, err := scanWaitlistOfferNotice(db, offerID, sched.loc)
+		if err != nil {
+			return "", err
+		}
+		return reminders.FillTemplate(loadReminderTemplate(db, ownerID, "waitlist_taken"), a.fields()), nil
+	} else if err != nil {
+		return "", err
+	}
+
+	a, err := scanAppointmentNotice(db.QueryRow("SELECT "+appointmentNoticeColumns+" WHERE a.id = ?", appointmentID), sched.loc)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`
+        INSERT OR IGNORE INTO appointment_notifications
+            (appointment_id, kind, offset_minutes, channel, recipient, recipient_hash, status, created_at)
+        VALUES (?, 'confirmation', 0, 'sms', ?, ?, 'sent', ?)`, appointmentID, maskContact(phone), contactHash(phone), now)
+	if err != nil {
+		return "", err
+	}
+	return reminders.FillTemplate(loadReminderTemplate(db, ownerID, "waitlist_booked"), a.fields()), nil
+}
+
+// scanWaitlistOfferNotice loads what's needed to message a customer about
+// an offer.
+func scanWaitlistOfferNotice(db *sql.DB, offerID int64, loc *time.Location) (appointmentNotice, error) {
+	a := appointmentNotice{id: offerID}
+	var startsAt string
+	err := db.QueryRow(`
+        SELECT o.owner_id, COALESCE(ow.salon_name, ''), c.name, c.phone, c.email, sv.name, st.name, o.starts_at
+        FROM waitlist_offers o
+        JOIN waitlist_entries w ON o.entry_id = w.id
+        JOIN owners ow ON o.owner_id = ow.id
+        JOIN customers c ON w.customer_id = c.id
+        JOIN services sv ON w.service_id = sv.id
+        JOIN staff st ON o.staff_id = st.id
+        WHERE o.id = ?`, offerID).Scan(&a.ownerID, &a.salonName, &a.customerName, &a.phone, &a.email, &a.service, &a.stylist, &startsAt)
+	if err != nil {
+		return a, err
+	}
+	a.start, err = time.ParseInLocation(dateTimeLayout, startsAt, loc)
+	return a, err
+}
+
+// --- API: List Waitlist ---
+// Lists entries with the given "status" (waiting by default, or "all").
+// Waiting entries whose window has passed are shown as expired.
+func APIGetWaitlist(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch waitlist", http.StatusInternalServerError)
+		return
+	}
+	query := `
+        SELECT w.id, w.customer_id, c.name, w.service_id, sv.name, w.staff_id, COALESCE(st.name, ''), w.earliest, w.latest,
+            COALESCE(w.notes, ''), w.status, w.appointment_id, w.created_at
+        FROM waitlist_entries w
+        JOIN customers c ON w.customer_id = c.id
+        JOIN services sv ON w.service_id = sv.id
+        LEFT JOIN staff st ON w.staff_id = st.id
+        WHERE w.owner_id = ?`
+	args := []interface{}{ownerID}
+	switch status := r.URL.Query().Get("status"); status {
+	case "all":
+	case "":
+		query += " AND w.status = 'waiting'"
+	default:
+		query += " AND w.status = ?"
+		args = append(args, status)
+	}
+	rows, err := db.Query(query+" ORDER BY w.created_at, w.id", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch waitlist", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	nowLocal := time.Now().In(sched.loc).Format(dateTimeLayout)
+	entries := []waitlistEntry{}
+	for rows.Next() {
+		var e waitlistEntry
+		var staffID, appointmentID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.CustomerID, &e.CustomerName, &e.ServiceID, &e.ServiceName, &staffID, &e.StaffName,
+			&e.Earliest, &e.Latest, &e.Notes, &e.Status, &appointmentID, &e.CreatedAt); err != nil {
+			log.Printf("Failed to scan waitlist entry: %v", err)
+			continue
+		}
+		if staffID.Valid {
+			e.StaffID = &staffID.Int64
+		}
+		if appointmentID.Valid {
+			e.AppointmentID = &appointmentID.Int64
+		}
+		if e.Status == "waiting" && e.Latest < nowLocal {
+			e.Status = "expired"
+		}
+		if t, err := time.ParseInLocation(dateTimeLayout, e.Earliest, sched.loc); err == nil {
+			e.Earliest = t.Format(time.RFC3339)
+		}
+		if t, err := time.ParseInLocation(dateTimeLayout, e.Latest, sched.loc); err == nil {
+			e.Latest = t.Format(time.RFC3339)
+		}
+		entries = append(entries, e)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// --- API: Add Waitlist Entry ---
+// Body: {"customer_id", "service_id", "staff_id" (optional), "earliest",
+// "latest", "notes"}. Any slot starting between earliest and latest can be
+// offered.
+func APIAddWaitlistEntry(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		CustomerID int64  `json:"customer_id"`
+		ServiceID  int64  `json:"service_id"`
+		StaffID    int64  `json:"staff_id"`
+		Earliest   string `json:"earliest"`
+		Latest     string `json:"latest"`
+		Notes      string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Notes = strings.TrimSpace(req.Notes)
+	if len(req.Notes) > 500 {
+		http.Error(w, "Notes are too long (max 500 characters)", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM customers WHERE id = ? AND owner_id = ?", req.CustomerID, ownerID).Scan(&count)
+	if count == 0 {
+		http.Error(w, "Customer not found", http.StatusBadRequest)
+		return
+	}
+	if _, _, _, err := loadServiceTiming(db, ownerID, req.ServiceID); err != nil {
+		http.Error(w, "Service not found", http.StatusBadRequest)
+		return
+	}
+	var staffID sql.NullInt64
+	if req.StaffID != 0 {
+		db.QueryRow("SELECT COUNT(*) FROM staff WHERE id = ? AND owner_id = ? AND active = 1", req.StaffID, ownerID).Scan(&count)
+		if count == 0 {
+			http.Error(w, "Staff member not found", http.StatusBadRequest)
+			return
+		}
+		staffID = sql.NullInt64{Int64: req.StaffID, Valid: true}
+	}
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to add to waitlist", http.StatusInternalServerError)
+		return
+	}
+	earliest, err1 := parseSalonTime(req.Earliest, sched.loc)
+	latest, err2 := parseSalonTime(req.Latest, sched.loc)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid earliest or latest time", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if !latest.After(earliest) || !latest.After(now) {
+		http.Error(w, "The latest time must be after the earliest and in the future", http.StatusBadRequest)
+		return
+	}
+	res, err := db.Exec(`
+        INSERT INTO waitlist_entries (owner_id, customer_id, service_id, staff_id, earliest, latest, notes, status, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, 'waiting', ?, ?)`,
+		ownerID, req.CustomerID, req.ServiceID, staffID, earliest.Format(dateTimeLayout), latest.Format(dateTimeLayout),
+		nullIfEmpty(req.Notes), now, now)
+	if err != nil {
+		http.Error(w, "Failed to add to waitlist", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+}
+
+// --- API: Remove Waitlist Entry ---
+func APIDeleteWaitlistEntry(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid waitlist entry ID", http.StatusBadRequest)
+		return
+	}
+	res, err := database.GetDB().Exec(`
+        UPDATE waitlist_entries SET status = 'cancelled', updated_at = ? WHERE id = ? AND owner_id = ? AND status = 'waiting'`,
+		time.Now(), id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to remove waitlist entry", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Waitlist entry not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Waitlist Entry Offers ---
+func APIGetWaitlistOffers(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid waitlist entry ID", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch offers", http.StatusInternalServerError)
+		return
+	}
+	rows, err := db.Query(`
+        SELECT id, staff_id, starts_at, COALESCE(channel, ''), COALESCE(recipient, ''), status, COALESCE(error, ''),
+            expires_at, responded_at, created_at
+        FROM waitlist_offers WHERE entry_id = ? AND owner_id = ? ORDER BY id`, id, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch offers", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	now := time.Now()
+	offers := []waitlistOffer{}
+	for rows.Next() {
+		var o waitlistOffer
+		var respondedAt sql.NullTime
+		if err := rows.Scan(&o.ID, &o.StaffID, &o.Start, &o.Channel, &o.Recipient, &o.Status, &o.Error,
+			&o.ExpiresAt, &respondedAt, &o.CreatedAt); err != nil {
+			log.Printf("Failed to scan waitlist offer: %v", err)
+			continue
+		}
+		if respondedAt.Valid {
+			o.RespondedAt = &respondedAt.Time
+		}
+		if o.Status == "sent" && !now.Before(o.ExpiresAt) {
+			o.Status = "expired"
+		}
+		if t, err := time.ParseInLocation(dateTimeLayout, o.Start, sched.loc); err == nil {
+			o.Start = t.Format(time.RFC3339)
+		}
+		offers = append(offers, o)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(offers)
+}
+
+// --- API: Get Waitlist Settings ---
+func APIGetWaitlistSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var minutes, maxOffers int
+	err := database.GetDB().QueryRow(`
+        SELECT COALESCE(waitlist_offer_minutes, 30), COALESCE(waitlist_max_offers, 5) FROM owners WHERE id = ?`,
+		ownerID).Scan(&minutes, &maxOffers)
+	if err != nil {
+		http.Error(w, "Failed to fetch waitlist settings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"offer_minutes": minutes, "max_offers": maxOffers})
+}
+
+// --- API: Update Waitlist Settings ---
+// Body: {"offer_minutes", "max_offers"}. offer_minutes is how long an offer
+// stays open; max_offers is how many entries each freed slot is offered to
+// at once, 0 turning offers off.
+func APIUpdateWaitlistSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		OfferMinutes int `json:"offer_minutes"`
+		MaxOffers    int `json:"max_offers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.OfferMinutes < 5 || req.OfferMinutes > 1440 {
+		http.Error(w, "Offers must stay open for 5-1440 minutes", http.StatusBadRequest)
+		return
+	}
+	if req.MaxOffers < 0 || req.MaxOffers > 20 {
+		http.Error(w, "Each slot can be offered to at most 20 entries", http.StatusBadRequest)
+		return
+	}
+	_, err := database.GetDB().Exec("UPDATE owners SET waitlist_offer_minutes = ?, waitlist_max_offers = ?, updated_at = ? WHERE id = ?",
+		req.OfferMinutes, req.MaxOffers, time.Now(), ownerID)
+	if err != nil {
+		http.Error(w, "Failed to save waitlist settings", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- Public: View Waitlist Offer ---
+// The link in an offer message. Shows the slot and whether it can still be
+// taken.
+func ViewWaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	db := database.GetDB()
+	var offerID int64
+	var ownerID int
+	var status string
+	var expiresAt time.Time
+	err := db.QueryRow("SELECT id, owner_id, status, expires_at FROM waitlist_offers WHERE token = ?",
+		chi.URLParam(r, "token")).Scan(&offerID, &ownerID, &status, &expiresAt)
+	if err != nil {
+		http.Error(w, "This offer link is invalid", http.StatusNotFound)
+		return
+	}
+	sched, err := loadSalonSchedule(db, ownerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch offer", http.StatusInternalServerError)
+		return
+	}
+	a, err := scanWaitlistOfferNotice(db, offerID, sched.loc)
+	if err != nil {
+		http.Error(w, "Failed to fetch offer", http.StatusInternalServerError)
+		return
+	}
+	if status == "sent" && !time.Now().Before(expiresAt) {
+		status = "expired"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"salon":      a.salonName,
+		"service":    a.service,
+		"stylist":    a.stylist,
+		"start":      a.start.Format(time.RFC3339),
+		"expires_at": expiresAt,
+		"status":     status,
+	})
+}
+
+// --- Public: Accept Waitlist Offer ---
+// Books the offered slot if it's still free; the first customer to accept
+// gets it.
+func AcceptWaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	db := database.GetDB()
+	var offerID int64
+	err := db.QueryRow("SELECT id FROM waitlist_offers WHERE token = ?", chi.URLParam(r, "token")).Scan(&offerID)
+	if err != nil {
+		http.Error(w, "This offer link is invalid", http.StatusNotFound)
+		return
+	}
+	appointmentID, err := acceptWaitlistOffer(db, offerID, time.Now())
+	if err != nil {
+		switch err {
+		case errOfferClosed:
+			http.Error(w, err.Error(), http.StatusGone)
+		case errSlotUnavailable:
+			http.Error(w, "Sorry, this slot has already been taken", http.StatusConflict)
+		default:
+			log.Printf("Failed to accept waitlist offer %d: %v", offerID, err)
+			http.Error(w, "Failed to book appointment", http.StatusInternalServerError)
+		}
+		return
+	}
+	var status string
+	db.QueryRow("SELECT status FROM appointments WHERE id = ?", appointmentID).Scan(&status)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": appointmentID, "status": status})
+}
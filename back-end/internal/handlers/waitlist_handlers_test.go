@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAcceptWaitlistOffer(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 11, 2, 8, 0, 0, 0, time.UTC)
+	expires := now.Add(30 * time.Minute)
+	mustExec(t, db,
+		`INSERT INTO owners (id, email, password_hash, timezone) VALUES (1, 'a@example.com', 'x', 'UTC')`,
+		`INSERT INTO salon_hours (owner_id, weekday, opens, closes) VALUES (1, 1, '09:00', '17:00')`,
+		`INSERT INTO staff (id, owner_id, name) VALUES (1, 1, 'Ann')`,
+		`INSERT INTO staff_schedules (staff_id, weekday, starts, ends) VALUES (1, 1, '09:00', '17:00')`,
+		`INSERT INTO services (id, owner_id, name, price, duration_minutes) VALUES (1, 1, 'Cut', 30, 60)`,
+		`INSERT INTO customers (id, owner_id, name) VALUES (1, 1, 'Jane'), (2, 1, 'John'), (3, 1, 'Jill')`,
+		`INSERT INTO waitlist_entries (id, owner_id, customer_id, service_id, earliest, latest) VALUES
+			(1, 1, 1, 1, '2026-11-02 09:00', '2026-11-02 17:00'),
+			(2, 1, 2, 1, '2026-11-02 09:00', '2026-11-02 17:00'),
+			(3, 1, 3, 1, '2026-11-02 09:00', '2026-11-02 17:00')`,
+	)
+	for _, o := range []struct {
+		id, entryID int
+		startsAt    string
+		expiresAt   time.Time
+	}{
+		{1, 1, "2026-11-02 10:00", expires},
+		{2, 2, "2026-11-02 10:00", expires},
+		{3, 3, "2026-11-02 14:00", now.Add(-time.Minute)},
+		{4, 3, "2026-11-02 15:00", expires},
+	} {
+		if _, err := db.Exec(`INSERT INTO waitlist_offers (id, owner_id, entry_id, token, staff_id, starts_at, status, expires_at)
+            VALUES (?, 1, ?, ?, 1, ?, 'sent', ?)`, o.id, o.entryID, o.id, o.startsAt, o.expiresAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offerStatus := func(id int) string {
+		var status string
+		db.QueryRow("SELECT status FROM waitlist_offers WHERE id = ?", id).Scan(&status)
+		return status
+	}
+
+	appointmentID, err := acceptWaitlistOffer(db, 1, now)
+	if err != nil {
+		t.Fatalf("acceptWaitlistOffer() error = %v", err)
+	}
+	var customerID int64
+	var startsAt, source string
+	db.QueryRow("SELECT customer_id, starts_at, source FROM appointments WHERE id = ?", appointmentID).Scan(&customerID, &startsAt, &source)
+	if customerID != 1 || startsAt != "2026-11-02 10:00" || source != "waitlist" {
+		t.Errorf("booked customer %d at %s from %s, want 1 at 2026-11-02 10:00 from waitlist", customerID, startsAt, source)
+	}
+	var entryStatus string
+	var entryAppointment int64
+	db.QueryRow("SELECT status, appointment_id FROM waitlist_entries WHERE id = 1").Scan(&entryStatus, &entryAppointment)
+	if entryStatus != "booked" || entryAppointment != appointmentID {
+		t.Errorf("entry = %s with appointment %d, want booked with %d", entryStatus, entryAppointment, appointmentID)
+	}
+	if got := offerStatus(1); got != "accepted" {
+		t.Errorf("accepted offer status = %q, want accepted", got)
+	}
+	if got := offerStatus(2); got != "missed" {
+		t.Errorf("other offer of the slot = %q, want missed", got)
+	}
+
+	if _, err := acceptWaitlistOffer(db, 2, now); err != errOfferClosed {
+		t.Errorf("accepting a missed offer: error = %v, want errOfferClosed", err)
+	}
+	if _, err := acceptWaitlistOffer(db, 3, now); err != errOfferClosed {
+		t.Errorf("accepting an expired offer: error = %v, want errOfferClosed", err)
+	}
+
+	mustExec(t, db, `INSERT INTO appointments (owner_id, customer_id, staff_id, service_id, starts_at, ends_at)
+		VALUES (1, 2, 1, 1, '2026-11-02 15:00', '2026-11-02 16:00')`)
+	if _, err := acceptWaitlistOffer(db, 4, now); err != errSlotUnavailable {
+		t.Errorf("accepting a slot taken since: error = %v, want errSlotUnavailable", err)
+	}
+	if got := offerStatus(4); got != "missed" {
+		t.Errorf("offer of a taken slot = %q, want missed", got)
+	}
+}
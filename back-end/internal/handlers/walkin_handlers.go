@@ -0,0 +1,738 @@
+// internal/handlers/walkin_handlers.go
+// Walk-in queue: customers join at reception, are called by a stylist and
+// start service, with estimated waits and a live feed for a reception screen.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"salon-management/internal/database"
+)
+
+// How often the live queue is resent while nothing changes, so estimated
+// waits keep counting down, and how long one stream stays open before the
+// client reconnects. Streams end before the router's request timeout.
+const (
+	walkInStreamRefresh  = 30 * time.Second
+	walkInStreamLifetime = 50 * time.Second
+)
+
+type walkIn struct {
+	ID                int64      `json:"id"`
+	Position          int        `json:"position,omitempty"`
+	CustomerID        *int64     `json:"customer_id"`
+	Name              string     `json:"name"`
+	DisplayName       string     `json:"display_name"`
+	ServiceID         int64      `json:"service_id"`
+	ServiceName       string     `json:"service_name"`
+	StaffID           *int64     `json:"staff_id"`
+	StaffName         string     `json:"staff_name,omitempty"`
+	AssignedStaffID   *int64     `json:"assigned_staff_id"`
+	AssignedStaffName string     `json:"assigned_staff_name,omitempty"`
+	AppointmentID     *int64     `json:"appointment_id"`
+	Status            string     `json:"status"`
+	Notes             string     `json:"notes"`
+	JoinedAt          time.Time  `json:"joined_at"`
+	CalledAt          *time.Time `json:"called_at"`
+	StartedAt         *time.Time `json:"started_at"`
+	EstimatedWait     *int       `json:"estimated_wait_minutes"`
+	EstimatedStart    string     `json:"estimated_start,omitempty"`
+	EstimatedStaffID  *int64     `json:"estimated_staff_id,omitempty"`
+
+	duration, buffer int
+}
+
+// displayName is how a walk-in is shown on the reception screen: first name
+// and last initial.
+func displayName(name string) string {
+	f := strings.Fields(name)
+	switch len(f) {
+	case 0:
+		return ""
+	case 1:
+		return f[0]
+	}
+	return f[0] + " " + string([]rune(f[len(f)-1])[0]) + "."
+}
+
+// loadWalkInQueue lists today's walk-ins being served, called and waiting,
+// in that order, with estimated waits for those still waiting. Entries left
+// over from an earlier day are ignored.
+func loadWalkInQueue(q queryer, ownerID int, now time.Time) ([]walkIn, error) {
+	sched, err := loadSalonSchedule(q, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	nowLocal := now.In(sched.loc)
+	today := time.Date(nowLocal.Year(), nowLocal.Month(), nowLocal.Day(), 0, 0, 0, 0, sched.loc)
+	rows, err := q.Query(`
+        SELECT w.id, w.customer_id, w.name, w.service_id, sv.name, COALESCE(sv.duration_minutes, 30),
+            COALESCE(sv.buffer_minutes, 0), w.staff_id, COALESCE(ps.name, ''), w.assigned_staff_id,
+            COALESCE(asg.name, ''), w.appointment_id, w.status, COALESCE(w.notes, ''), w.joined_at,
+            w.called_at, w.started_at
+        FROM walk_ins w
+        JOIN services sv ON w.service_id = sv.id
+        LEFT JOIN staff ps ON w.staff_id = ps.id
+        LEFT JOIN staff asg ON w.assigned_staff_id = asg.id
+        WHERE w.owner_id = ? AND w.status IN ('waiting', 'called', 'in_service')
+        ORDER BY CASE w.status WHEN 'in_service' THEN 0 WHEN 'called' THEN 1 ELSE 2 END, w.joined_at, w.id`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	queue := []walkIn{}
+	position := 0
+	for rows.Next() {
+		var e walkIn
+		var customerID, staffID, assignedID, apptID sql.NullInt64
+		var calledAt, startedAt sql.NullTime
+		if err := rows.Scan(&e.ID, &customerID, &e.Name, &e.ServiceID, &e.ServiceName, &e.duration, &e.buffer,
+			&staffID, &e.StaffName, &assignedID, &e.AssignedStaffName, &apptID, &e.Status, &e.Notes, &e.JoinedAt,
+			&calledAt, &startedAt); err != nil {
+			return nil, err
+		}
+		if e.JoinedAt.Before(today) {
+			continue
+		}
+		for _, p := range []struct {
+			dst **int64
+			src sql.NullInt64
+		}{{&e.CustomerID, customerID}, {&e.StaffID, staffID}, {&e.AssignedStaffID, assignedID}, {&e.AppointmentID, apptID}} {
+			if p.src.Valid {
+				v := p.src.Int64
+				*p.dst = &v
+			}
+		}
+		if calledAt.Valid {
+			e.CalledAt = &calledAt.Time
+		}
+		if startedAt.Valid {
+			e.StartedAt = &startedAt.Time
+		}
+		e.DisplayName = displayName(e.Name)
+		if e.Status == "waiting" {
+			position++
+			e.Position = position
+		}
+		queue = append(queue, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := estimateWalkInWaits(q, ownerID, sched, queue, nowLocal); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// estimateWalkInWaits fills in when each waiting walk-in is likely to be
+// seen. Every staff member working today is free for what's left of their
+// shift outside time off, booked and in-progress appointments, and anyone
+// they've already called. Walk-ins are then placed in queue order with the
+// stylist they asked for, or whoever is free first, each taking their
+// service's time out of that stylist's day. Walk-ins that won't fit in today
+// get no estimate.
+func estimateWalkInWaits(q queryer, ownerID int, sched *salonSchedule, queue []walkIn, now time.Time) error {
+	day := now.Format("2006-01-02")
+	var closed int
+	if err := q.QueryRow("SELECT COUNT(*) FROM salon_closures WHERE owner_id = ? AND starts_on <= ? AND ends_on >= ?",
+		ownerID, day, day).Scan(&closed); err != nil {
+		return err
+	}
+	if closed > 0 {
+		return nil
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	nowMin := now.Hour()*60 + now.Minute()
+	dayStart := now.AddDate(0, 0, -1).Format(dateTimeLayout)
+	dayEnd := now.AddDate(0, 0, 1).Format(dateTimeLayout)
+
+	rows, err := q.Query("SELECT id FROM staff WHERE owner_id = ? AND active = 1 ORDER BY name", ownerID)
+	if err != nil {
+		return err
+	}
+	var staff []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		staff = append(staff, id)
+	}
+	rows.Close()
+
+	free := map[int64][]timeRange{}
+	for _, id := range staff {
+		var shift []timeRange
+		rows, err := q.Query("SELECT starts, ends FROM staff_schedules WHERE staff_id = ? AND weekday = ?", id, int(now.Weekday()))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var starts, ends string
+			if err := rows.Scan(&starts, &ends); err != nil {
+				rows.Close()
+				return err
+			}
+			st, err1 := parseClock(starts)
+			en, err2 := parseClock(ends)
+			if err1 == nil && err2 == nil {
+				shift = append(shift, timeRange{st, en})
+			}
+		}
+		rows.Close()
+		f := subtractRange(intersectRanges(sched.hours[now.Weekday()], shift), timeRange{0, nowMin})
+
+		rows, err = q.Query(`
+            SELECT starts_at, ends_at FROM staff_time_off WHERE staff_id = ? AND starts_at < ? AND ends_at > ?
+            UNION ALL
+            SELECT starts_at, strftime('%Y-%m-%d %H:%M', ends_at, '+' || buffer_minutes || ' minutes')
+            FROM appointments
+            WHERE staff_id = ? AND status IN ('booked', 'pending') AND starts_at < ? AND ends_at >= ?`,
+			id, dayEnd, dayStart, id, dayEnd, dayStart)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var b busySpan
+			if err := rows.Scan(&b.start, &b.end); err != nil {
+				rows.Close()
+				return err
+			}
+			if r, ok := b.onDay(midnight); ok {
+				f = subtractRange(f, r)
+			}
+		}
+		rows.Close()
+		free[id] = f
+	}
+
+	// earliest is the start of staff member id's first free gap of length
+	// minutes, or -1.
+	earliest := func(id int64, length int) int {
+		for _, f := range free[id] {
+			if f.end-f.start >= length {
+				return f.start
+			}
+		}
+		return -1
+	}
+	for _, e := range queue {
+		if e.Status == "called" && e.AssignedStaffID != nil {
+			free[*e.AssignedStaffID] = subtractRange(free[*e.AssignedStaffID], timeRange{nowMin, nowMin + e.duration + e.buffer})
+		}
+	}
+	for i := range queue {
+		e := &queue[i]
+		if e.Status == "called" {
+			zero := 0
+			e.EstimatedWait = &zero
+			continue
+		}
+		if e.Status != "waiting" {
+			continue
+		}
+		candidates := staff
+		if e.StaffID != nil {
+			candidates = []int64{*e.StaffID}
+		}
+		best, bestStart := int64(0), -1
+		for _, id := range candidates {
+			if start := earliest(id, e.duration+e.buffer); start >= 0 && (bestStart < 0 || start < bestStart) {
+				best, bestStart = id, start
+			}
+		}
+		if bestStart < 0 {
+			continue
+		}
+		free[best] = subtractRange(free[best], timeRange{bestStart, bestStart + e.duration + e.buffer})
+		wait := max(bestStart-nowMin, 0)
+		staffID := best
+		e.EstimatedWait = &wait
+		e.EstimatedStaffID = &staffID
+		e.EstimatedStart = now.Add(time.Duration(wait) * time.Minute).Format(time.RFC3339)
+	}
+	return nil
+}
+
+// walkInListeners holds the live queue streams open for each owner.
+var walkInListeners = struct {
+	sync.Mutex
+	byOwner map[int]map[chan struct{}]bool
+}{byOwner: map[int]map[chan struct{}]bool{}}
+
+func listenWalkIns(ownerID int) chan struct{} {
+	ch := make(chan struct{}, 1)
+	walkInListeners.Lock()
+	defer walkInListeners.Unlock()
+	if walkInListeners.byOwner[ownerID] == nil {
+		walkInListeners.byOwner[ownerID] = map[chan struct{}]bool{}
+	}
+	walkInListeners.byOwner[ownerID][ch] = true
+	return ch
+}
+
+func unlistenWalkIns(ownerID int, ch chan struct{}) {
+	walkInListeners.Lock()
+	defer walkInListeners.Unlock()
+	delete(walkInListeners.byOwner[ownerID], ch)
+	if len(walkInListeners.byOwner[ownerID]) == 0 {
+		delete(walkInListeners.byOwner, ownerID)
+	}
+}
+
+// notifyWalkIns tells open queue streams that the owner's queue changed.
+func notifyWalkIns(ownerID int) {
+	walkInListeners.Lock()
+	defer walkInListeners.Unlock()
+	for ch := range walkInListeners.byOwner[ownerID] {
+		select {
+		case ch <- struct{}{}:
+		default: // an update is already pending
+		}
+	}
+}
+
+// --- API: List Walk-ins ---
+func APIGetWalkIns(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	queue, err := loadWalkInQueue(database.GetDB(), ownerID, time.Now())
+	if err != nil {
+		log.Printf("Failed to load walk-in queue for owner %d: %v", ownerID, err)
+		http.Error(w, "Failed to fetch walk-ins", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
+
+// --- API: Walk-in Queue Stream ---
+// Server-Sent Events feed of the queue for a reception screen. A "queue"
+// event carrying the same list as GET /api/walk-ins is sent on connect,
+// whenever the queue changes and every 30 seconds. Streams close after
+// under a minute; EventSource clients reconnect on their own.
+func APIWalkInStream(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	updates := listenWalkIns(ownerID)
+	defer unlistenWalkIns(ownerID, updates)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	db := database.GetDB()
+	send := func() {
+		queue, err := loadWalkInQueue(db, ownerID, time.Now())
+		if err != nil {
+			log.Printf("Failed to load walk-in queue for owner %d: %v", ownerID, err)
+			return
+		}
+		data, _ := json.Marshal(queue)
+		fmt.Fprintf(w, "event: queue\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+	send()
+	refresh := time.NewTicker(walkInStreamRefresh)
+	defer refresh.Stop()
+	lifetime := time.NewTimer(walkInStreamLifetime)
+	defer lifetime.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-lifetime.C:
+			return
+		case <-updates:
+			send()
+		case <-refresh.C:
+			send()
+		}
+	}
+}
+
+// walkInStaff checks a staff member is active and belongs to ownerID.
+func walkInStaff(q queryer, ownerID int, staffID int64) error {
+	var id int64
+	return q.QueryRow("SELECT id FROM staff WHERE id = ? AND owner_id = ? AND active = 1", staffID, ownerID).Scan(&id)
+}
+
+// --- API: Add Walk-in ---
+// Adds a walk-in to the end of the queue, either an existing "customer_id"
+// or just a "name".
+func APIAddWalkIn(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		CustomerID int64  `json:"customer_id"`
+		Name       string `json:"name"`
+		ServiceID  int64  `json:"service_id"`
+		StaffID    int64  `json:"staff_id"`
+		Notes      string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Notes = strings.TrimSpace(req.Notes)
+	db := database.GetDB()
+	if req.CustomerID != 0 {
+		err := db.QueryRow("SELECT name FROM customers WHERE id = ? AND owner_id = ?", req.CustomerID, ownerID).Scan(&req.Name)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Customer not found", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to fetch customer", http.StatusInternalServerError)
+			return
+		}
+	} else if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Give a customer_id or a name (max 100 characters)", http.StatusBadRequest)
+		return
+	}
+	if len(req.Notes) > 500 {
+		http.Error(w, "Notes are too long (max 500 characters)", http.StatusBadRequest)
+		return
+	}
+	if _, _, _, err := loadServiceTiming(db, ownerID, req.ServiceID); err == sql.ErrNoRows {
+		http.Error(w, "Service not found", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch service", http.StatusInternalServerError)
+		return
+	}
+	var staffID sql.NullInt64
+	if req.StaffID != 0 {
+		if err := walkInStaff(db, ownerID, req.StaffID); err == sql.ErrNoRows {
+			http.Error(w, "Staff member not found", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to fetch staff member", http.StatusInternalServerError)
+			return
+		}
+		staffID = sql.NullInt64{Int64: req.StaffID, Valid: true}
+	}
+	var customerID sql.NullInt64
+	if req.CustomerID != 0 {
+		customerID = sql.NullInt64{Int64: req.CustomerID, Valid: true}
+	}
+	res, err := db.Exec(`
+        INSERT INTO walk_ins (owner_id, customer_id, name, service_id, staff_id, status, notes, joined_at)
+        VALUES (?, ?, ?, ?, ?, 'waiting', ?, ?)`,
+		ownerID, customerID, req.Name, req.ServiceID, staffID, nullIfEmpty(req.Notes), time.Now())
+	if err != nil {
+		http.Error(w, "Failed to add walk-in", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	notifyWalkIns(ownerID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+}
+
+// --- API: Call Next Walk-in ---
+// Calls the longest-waiting walk-in who asked for "staff_id" or for no one
+// in particular.
+func APICallNextWalkIn(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		StaffID int64 `json:"staff_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	if err := walkInStaff(db, ownerID, req.StaffID); err == sql.ErrNoRows {
+		http.Error(w, "Staff member not found", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch staff member", http.StatusInternalServerError)
+		return
+	}
+	queue, err := loadWalkInQueue(db, ownerID, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to fetch walk-ins", http.StatusInternalServerError)
+		return
+	}
+	for _, e := range queue {
+		if e.Status != "waiting" || (e.StaffID != nil && *e.StaffID != req.StaffID) {
+			continue
+		}
+		res, err := db.Exec(`
+            UPDATE walk_ins SET status = 'called', assigned_staff_id = ?, called_at = ?
+            WHERE id = ? AND status = 'waiting'`, req.StaffID, time.Now(), e.ID)
+		if err != nil {
+			http.Error(w, "Failed to call walk-in", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue // called by someone else meanwhile
+		}
+		notifyWalkIns(ownerID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": e.ID, "name": e.Name, "service_name": e.ServiceName})
+		return
+	}
+	http.Error(w, "No one is waiting for this staff member", http.StatusNotFound)
+}
+
+// errWalkInConflict is returned when starting a walk-in would overlap an
+// appointment the stylist already has.
+var errWalkInConflict = errors.New("stylist has an appointment")
+
+// startWalkIn begins service for a walk-in with staffID: a customer record is
+// created for walk-ins who only gave a name, and the service is booked as a
+// walk-in appointment from now so availability and invoicing see it. Unless
+// force is set, errWalkInConflict is returned if that would overlap one of
+// the stylist's appointments.
+func startWalkIn(tx *sql.Tx, ownerID int, id, staffID int64, force bool, now time.Time) (int64, error) {
+	var customerID sql.NullInt64
+	var name, notes string
+	var serviceID int64
+	err := tx.QueryRow(`
+        SELECT customer_id, name, service_id, COALESCE(notes, '') FROM walk_ins WHERE id = ?`, id).
+		Scan(&customerID, &name, &serviceID, &notes)
+	if err != nil {
+		return 0, err
+	}
+	_, duration, buffer, err := loadServiceTiming(tx, ownerID, serviceID)
+	if err != nil {
+		return 0, err
+	}
+	sched, err := loadSalonSchedule(tx, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	start := now.In(sched.loc).Truncate(time.Minute)
+	startsAt := start.Format(dateTimeLayout)
+	endsAt := start.Add(time.Duration(duration) * time.Minute).Format(dateTimeLayout)
+	if !force {
+		var clash int
+		if err := tx.QueryRow(`
+            SELECT COUNT(*) FROM appointments
+            WHERE staff_id = ? AND status IN ('booked', 'pending') AND starts_at < ?
+                AND strftime('%Y-%m-%d %H:%M', ends_at, '+' || buffer_minutes || ' minutes') > ?`,
+			staffID, endsAt, startsAt).Scan(&clash); err != nil {
+			return 0, err
+		}
+		if clash > 0 {
+			return 0, errWalkInConflict
+		}
+	}
+	if !customerID.Valid {
+		encryptedPhone, err1 := encryptField("")
+		encryptedEmail, err2 := encryptField("")
+		if err1 != nil || err2 != nil {
+			return 0, errors.Join(err1, err2)
+		}
+		res, err := tx.Exec(
+			"INSERT INTO customers (name, phone, email, phone_hash, email_hash, birthday, anniversary, owner_id, created_at) VALUES (?, ?, ?, '', '', ?, ?, ?, ?)",
+			name, encryptedPhone, encryptedEmail, "", "", ownerID, now)
+		if err != nil {
+			return 0, err
+		}
+		customerID.Int64, _ = res.LastInsertId()
+	}
+	res, err := tx.Exec(`
+        INSERT INTO appointments (owner_id, customer_id, staff_id, service_id, starts_at, ends_at, buffer_minutes,
+            status, source, notes, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, 'booked', 'walk_in', ?, ?, ?)`,
+		ownerID, customerID.Int64, staffID, serviceID, startsAt, endsAt, buffer, nullIfEmpty(notes), now, now)
+	if err != nil {
+		return 0, err
+	}
+	apptID, _ := res.LastInsertId()
+	needs, err := loadServiceResources(tx, serviceID)
+	if err != nil {
+		return 0, err
+	}
+	if err := reserveResources(tx, apptID, needs); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`
+        UPDATE walk_ins SET status = 'in_service', customer_id = ?, assigned_staff_id = ?, appointment_id = ?,
+            started_at = ?
+        WHERE id = ?`, customerID.Int64, staffID, apptID, now, id)
+	return apptID, err
+}
+
+// --- API: Change Walk-in ---
+// Actions: "call" a waiting walk-in for "staff_id"; "start" service with
+// "staff_id" (defaulting to whoever called them or was asked for, and
+// refusing with 409 over one of the stylist's appointments unless "force");
+// "finish" service, which completes the appointment; and "leave" for
+// walk-ins who go before being seen.
+func APIChangeWalkIn(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid walk-in ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		StaffID int64 `json:"staff_id"`
+		Force   bool  `json:"force"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	db := database.GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update walk-in", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	var status string
+	var preferred, assigned, apptID sql.NullInt64
+	err = tx.QueryRow(`
+        SELECT status, staff_id, assigned_staff_id, appointment_id FROM walk_ins WHERE id = ? AND owner_id = ?`,
+		id, ownerID).Scan(&status, &preferred, &assigned, &apptID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Walk-in not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch walk-in", http.StatusInternalServerError)
+		return
+	}
+	staffID := req.StaffID
+	if staffID == 0 {
+		if assigned.Valid {
+			staffID = assigned.Int64
+		} else {
+			staffID = preferred.Int64
+		}
+	}
+
+	now := time.Now()
+	action := chi.URLParam(r, "action")
+	waiting := status == "waiting" || status == "called"
+	switch {
+	case (action == "call" && status == "waiting") || (action == "start" && waiting):
+		if staffID == 0 {
+			http.Error(w, "Choose the staff member", http.StatusBadRequest)
+			return
+		}
+		if err := walkInStaff(tx, ownerID, staffID); err == sql.ErrNoRows {
+			http.Error(w, "Staff member not found", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to fetch staff member", http.StatusInternalServerError)
+			return
+		}
+		if action == "call" {
+			_, err = tx.Exec("UPDATE walk_ins SET status = 'called', assigned_staff_id = ?, called_at = ? WHERE id = ?", staffID, now, id)
+		} else {
+			var appt int64
+			appt, err = startWalkIn(tx, ownerID, id, staffID, req.Force, now)
+			if errors.Is(err, errWalkInConflict) {
+				http.Error(w, "The staff member has an appointment in that time; start anyway with force", http.StatusConflict)
+				return
+			} else if err == sql.ErrNoRows {
+				http.Error(w, "The walk-in's service is no longer offered", http.StatusBadRequest)
+				return
+			}
+			apptID = sql.NullInt64{Int64: appt, Valid: true}
+		}
+	case action == "finish" && status == "in_service":
+		_, err = tx.Exec("UPDATE walk_ins SET status = 'done', finished_at = ? WHERE id = ?", now, id)
+		if err == nil && apptID.Valid {
+			_, err = tx.Exec("UPDATE appointments SET status = 'completed', updated_at = ? WHERE id = ? AND status = 'booked'", now, apptID.Int64)
+		}
+	case action == "leave" && waiting:
+		_, err = tx.Exec("UPDATE walk_ins SET status = 'left', finished_at = ? WHERE id = ?", now, id)
+	case action == "call" || action == "start" || action == "finish" || action == "leave":
+		http.Error(w, fmt.Sprintf("Can't %s a walk-in who is %s", action, strings.ReplaceAll(status, "_", " ")), http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to %s walk-in %d: %v", action, id, err)
+		http.Error(w, "Failed to update walk-in", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update walk-in", http.StatusInternalServerError)
+		return
+	}
+	notifyWalkIns(ownerID)
+	resp := map[string]interface{}{"id": id}
+	if apptID.Valid {
+		resp["appointment_id"] = apptID.Int64
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDisplayName(t *testing.T) {
+	tests := map[string]string{
+		"Jane Smith":       "Jane S.",
+		"Mary Ann de Vere": "Mary V.",
+		"  Cher ":          "Cher",
+		"Zoë Łukasz":       "Zoë Ł.",
+		"":                 "",
+	}
+	for name, want := range tests {
+		if got := displayName(name); got != want {
+			t.Errorf("displayName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoadWalkInQueue(t *testing.T) {
+	db := openTestDB(t)
+	// Monday 2 November 2026, 10:00. Ann has an appointment until 11:00 and
+	// Bea has already called a walk-in.
+	now := time.Date(2026, 11, 2, 10, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO owners (id, email, password_hash, timezone) VALUES (1, 'a@example.com', 'x', 'UTC')`,
+		`INSERT INTO salon_hours (owner_id, weekday, opens, closes) VALUES (1, 1, '09:00', '17:00')`,
+		`INSERT INTO staff (id, owner_id, name) VALUES (1, 1, 'Ann'), (2, 1, 'Bea')`,
+		`INSERT INTO staff_schedules (staff_id, weekday, starts, ends) VALUES (1, 1, '09:00', '17:00'), (2, 1, '09:00', '17:00')`,
+		`INSERT INTO services (id, owner_id, name, price, duration_minutes) VALUES (1, 1, 'Cut', 30, 30)`,
+		`INSERT INTO appointments (owner_id, customer_id, staff_id, service_id, starts_at, ends_at)
+			VALUES (1, 1, 1, 1, '2026-11-02 10:00', '2026-11-02 11:00')`,
+	)
+	for _, w := range []struct {
+		id       int
+		name     string
+		staffID  interface{}
+		assigned interface{}
+		status   string
+		joined   time.Time
+	}{
+		{1, "Left Over", nil, nil, "waiting", now.AddDate(0, 0, -1)},
+		{2, "Cal Led", nil, 2, "called", now.Add(-20 * time.Minute)},
+		{3, "First Waiting", nil, nil, "waiting", now.Add(-10 * time.Minute)},
+		{4, "Wants Ann", 1, nil, "waiting", now.Add(-5 * time.Minute)},
+		{5, "Third Waiting", nil, nil, "waiting", now.Add(-2 * time.Minute)},
+		{6, "All Done", nil, 1, "done", now.Add(-time.Hour)},
+	} {
+		if _, err := db.Exec(`INSERT INTO walk_ins (id, owner_id, name, service_id, staff_id, assigned_staff_id, status, joined_at)
+            VALUES (?, 1, ?, 1, ?, ?, ?, ?)`, w.id, w.name, w.staffID, w.assigned, w.status, w.joined); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queue, err := loadWalkInQueue(db, 1, now)
+	if err != nil {
+		t.Fatalf("loadWalkInQueue() error = %v", err)
+	}
+	want := []struct {
+		id       int64
+		position int
+		wait     int
+		staffID  int64
+	}{
+		{2, 0, 0, 0},
+		{3, 1, 30, 2},
+		{4, 2, 60, 1},
+		{5, 3, 60, 2},
+	}
+	if len(queue) != len(want) {
+		t.Fatalf("queue has %d walk-ins, want %d", len(queue), len(want))
+	}
+	for i, w := range want {
+		e := queue[i]
+		if e.ID != w.id || e.Position != w.position || e.EstimatedWait == nil || *e.EstimatedWait != w.wait {
+			t.Errorf("queue[%d] = walk-in %d at %d waiting %v, want %d at %d waiting %d",
+				i, e.ID, e.Position, e.EstimatedWait, w.id, w.position, w.wait)
+		}
+		if w.staffID != 0 && (e.EstimatedStaffID == nil || *e.EstimatedStaffID != w.staffID) {
+			t.Errorf("walk-in %d estimated with staff %v, want %d", e.ID, e.EstimatedStaffID, w.staffID)
+		}
+	}
+	if got := queue[1].DisplayName; got != "First W." {
+		t.Errorf("DisplayName = %q, want First W.", got)
+	}
+}
@@ -0,0 +1,439 @@
+// internal/handlers/winback_handlers.go
+// Win-back campaigns: a message, optionally with a single-use discount code,
+// to customers who haven't been back for a while, throttled per day, with a
+// report of how many returned.
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"salon-management/internal/database"
+	"salon-management/internal/reminders"
+)
+
+const (
+	// winBackReturnDays is how long after a win-back message a visit counts
+	// as the customer returning.
+	winBackReturnDays = 30
+	// winBackSendFrom and winBackSendUntil bound the salon-local hours in
+	// which win-back messages go out.
+	winBackSendFrom  = 10
+	winBackSendUntil = 18
+)
+
+// winBackSettings are an owner's win-back campaign settings.
+type winBackSettings struct {
+	Enabled       bool   `json:"enabled"`
+	AfterDays     int    `json:"after_days"`
+	DailyLimit    int    `json:"daily_limit"`
+	PromotionID   *int64 `json:"promotion_id"`
+	CodeValidDays int    `json:"code_valid_days"`
+}
+
+// StartWinBackCampaigns messages lapsed customers every hour.
+func StartWinBackCampaigns(db *sql.DB) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		sendWinBackMessages(database.GetDB(), time.Now())
+		<-ticker.C
+	}
+}
+
+// sendWinBackMessages messages customers whose last invoice is older than
+// the owner's threshold, most recently lapsed first, up to the owner's daily
+// limit. Customers who have an upcoming appointment or were already
+// messaged since their last visit are left out, and those who opted out of
+// every channel they have are recorded as skipped.
+func sendWinBackMessages(db *sql.DB, now time.Time) {
+	rows, err := db.Query(`
+        SELECT id, COALESCE(winback_after_days, 90), COALESCE(winback_daily_limit, 20), winback_promotion_id,
+            COALESCE(winback_code_days, 30)
+        FROM owners WHERE winback_enabled = 1`)
+	if err != nil {
+		log.Printf("Error querying win-back settings: %v", err)
+		return
+	}
+	type ownerSettings struct {
+		id                      int
+		afterDays, limit, valid int
+		promotionID             sql.NullInt64
+	}
+	var owners []ownerSettings
+	for rows.Next() {
+		var o ownerSettings
+		if err := rows.Scan(&o.id, &o.afterDays, &o.limit, &o.promotionID, &o.valid); err == nil {
+			owners = append(owners, o)
+		}
+	}
+	rows.Close()
+
+	for _, o := range owners {
+		schedule, err := loadSalonSchedule(db, o.id)
+		if err != nil {
+			log.Printf("Failed to load schedule for win-back of owner %d: %v", o.id, err)
+			continue
+		}
+		local := now.In(schedule.loc)
+		if local.Hour() < winBackSendFrom || local.Hour() >= winBackSendUntil {
+			continue
+		}
+		midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, schedule.loc)
+		var sentToday int
+		if err := db.QueryRow(`
+            SELECT COUNT(*) FROM winback_messages
+            WHERE owner_id = ? AND status IN ('sent', 'failed') AND created_at >= ?`,
+			o.id, midnight.In(time.Local)).Scan(&sentToday); err != nil {
+			log.Printf("Failed to count win-back messages for owner %d: %v", o.id, err)
+			continue
+		}
+		remaining := o.limit - sentToday
+		if remaining <= 0 {
+			continue
+		}
+
+		rows, err := db.Query(`
+            SELECT l.id, l.name, l.phone, l.email, l.sms_opt_out, l.email_opt_out, l.salon_name, l.last_visit
+            FROM (SELECT c.id, c.name, c.phone, c.email, `+optOutColumns+`, COALESCE(ow.salon_name, '') AS salon_name,
+                    MAX(substr(i.invoice_date, 1, 10)) AS last_visit
+                FROM customers c
+                JOIN owners ow ON c.owner_id = ow.id
+                JOIN invoices i ON i.customer_id = c.id
+                WHERE c.owner_id = ?
+                    AND NOT EXISTS (SELECT 1 FROM appointments a
+                        WHERE a.customer_id = c.id AND a.status IN ('booked', 'pending') AND a.starts_at >= ?)
+                GROUP BY c.id) l
+            WHERE l.last_visit < ?
+                AND NOT EXISTS (SELECT 1 FROM winback_messages m
+                    WHERE m.customer_id = l.id AND substr(m.last_visit, 1, 10) >= l.last_visit)
+            ORDER BY l.last_visit DESC`,
+			o.id, local.Format(dateTimeLayout), local.AddDate(0, 0, -o.afterDays).Format("2006-01-02"))
+		if err != nil {
+			log.Printf("Error querying lapsed customers: %v", err)
+			continue
+		}
+		type lapsed struct {
+			customerID int64
+			lastVisit  string
+			notice     appointmentNotice
+		}
+		var customers []lapsed
+		for rows.Next() {
+			var c lapsed
+			if err := rows.Scan(&c.customerID, &c.notice.customerName, &c.notice.phone, &c.notice.email,
+				&c.notice.smsOptOut, &c.notice.emailOptOut, &c.notice.salonName, &c.lastVisit); err != nil {
+				log.Printf("Failed to scan lapsed customer: %v", err)
+				continue
+			}
+			customers = append(customers, c)
+		}
+		rows.Close()
+
+		for _, c := range customers {
+			if remaining == 0 {
+				break
+			}
+			var expiresOn string
+			if o.promotionID.Valid {
+				expiresOn = local.AddDate(0, 0, o.valid).Format("2006-01-02")
+			}
+			counted, err := sendWinBackMessage(db, o.id, c.customerID, c.lastVisit, o.promotionID, expiresOn, c.notice, now)
+			if err != nil {
+				log.Printf("Failed to send win-back message to customer %d: %v", c.customerID, err)
+			}
+			if counted {
+				remaining--
+			}
+		}
+	}
+}
+
+// winBackOffer is the sentence describing a win-back discount, or "" when
+// the message has no code.
+func winBackOffer(p *promotion, code, expires string) string {
+	if p == nil || code == "" {
+		return ""
+	}
+	amount := formatMoney(p.Value) + " off"
+	if p.Kind == "percentage" {
+		amount = formatQuantity(p.Value) + "% off"
+	}
+	return fmt.Sprintf(" Use code %s for %s your next visit by %s.", code, amount, expires)
+}
+
+// sendWinBackMessage records and sends one win-back message, issuing a code
+// for the promotion if one is set and still active. It reports whether the
+// message counts towards the daily limit, i.e. whether a send was attempted.
+// Failed sends are recorded and not retried.
+func sendWinBackMessage(db *sql.DB, ownerID int, customerID int64, lastVisit string, promotionID sql.NullInt64,
+	expiresOn string, a appointmentNotice, now time.Time) (bool, error) {
+	res, err := db.Exec(`
+        INSERT INTO winback_messages (owner_id, customer_id, last_visit, status, created_at)
+        VALUES (?, ?, ?, 'sending', ?)`, ownerID, customerID, lastVisit, now)
+	if err != nil {
+		return false, err
+	}
+	id, _ := res.LastInsertId()
+
+	channel, to := a.contact()
+	status, errText := "sent", ""
+	var codeID sql.NullInt64
+	if to == "" {
+		status, errText = "skipped", a.unreachable()
+	} else {
+		var p *promotion
+		var code, expires string
+		if promotionID.Valid {
+			p, err = scanPromotion(db.QueryRow("SELECT "+promotionColumns+" FROM promotions WHERE id = ? AND owner_id = ? AND active = 1",
+				promotionID.Int64, ownerID))
+			if err == nil {
+				codeID.Int64, code, err = issuePromotionCode(db, ownerID, p.ID, customerID, expiresOn, now)
+				codeID.Valid = err == nil
+			}
+			if err != nil && err != sql.ErrNoRows {
+				log.Printf("Failed to issue win-back code for customer %d: %v", customerID, err)
+			}
+			if d, err := time.Parse("2006-01-02", expiresOn); err == nil {
+				expires = d.Format("Mon 2 Jan")
+			}
+		}
+		var visited string
+		if d, err := time.Parse("2006-01-02", lastVisit); err == nil {
+			visited = d.Format("2 January 2006")
+		}
+		message := reminders.FillTemplate(loadReminderTemplate(db, ownerID, "winback"), map[string]string{
+			"CustomerName": a.customerName,
+			"SalonName":    a.salonName,
+			"LastVisit":    visited,
+			"Code":         code,
+			"Expires":      expires,
+			"Offer":        winBackOffer(p, code, expires),
+		})
+		if channel == "sms" {
+			err = reminders.SendSMS(to, message)
+		} else {
+			err = reminders.SendEmail(to, "We miss you at "+a.salonName, message)
+		}
+		if err != nil {
+			status, errText = "failed", err.Error()
+		}
+	}
+	_, err = db.Exec(`
+        UPDATE winback_messages SET promotion_code_id = ?, channel = ?, recipient = ?, recipient_hash = ?, status = ?, error = ?
+        WHERE id = ?`, codeID, nullIfEmpty(channel), nullIfEmpty(maskContact(to)), nullIfEmpty(contactHashIfSet(to)),
+		status, nullIfEmpty(errText), id)
+	return status != "skipped", err
+}
+
+// --- API: Get Win-back Settings ---
+func APIGetWinBackSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var settings winBackSettings
+	var promotionID sql.NullInt64
+	err := database.GetDB().QueryRow(`
+        SELECT COALESCE(winback_enabled, 0), COALESCE(winback_after_days, 90), COALESCE(winback_daily_limit, 20),
+            winback_promotion_id, COALESCE(winback_code_days, 30)
+        FROM owners WHERE id = ?`, ownerID).Scan(&settings.Enabled, &settings.AfterDays, &settings.DailyLimit,
+		&promotionID, &settings.CodeValidDays)
+	if err != nil {
+		http.Error(w, "Failed to fetch win-back settings", http.StatusInternalServerError)
+		return
+	}
+	if promotionID.Valid {
+		settings.PromotionID = &promotionID.Int64
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// --- API: Update Win-back Settings ---
+// Body: {"enabled", "after_days" since the last invoice before a customer
+// counts as lapsed, "daily_limit" on messages sent, "promotion_id" of a
+// single_use promotion to issue codes for or null for no code, and
+// "code_valid_days"}.
+func APIUpdateWinBackSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	var req winBackSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.AfterDays < 30 || req.AfterDays > 730 {
+		http.Error(w, "Lapsed after must be from 30 to 730 days", http.StatusBadRequest)
+		return
+	}
+	if req.DailyLimit < 1 || req.DailyLimit > 500 {
+		http.Error(w, "Daily limit must be from 1 to 500 messages", http.StatusBadRequest)
+		return
+	}
+	if req.CodeValidDays == 0 {
+		req.CodeValidDays = 30
+	}
+	if req.CodeValidDays < 1 || req.CodeValidDays > 365 {
+		http.Error(w, "Codes must be valid for 1 to 365 days", http.StatusBadRequest)
+		return
+	}
+	db := database.GetDB()
+	if req.PromotionID != nil {
+		var rule string
+		err := db.QueryRow("SELECT rule FROM promotions WHERE id = ? AND owner_id = ?", *req.PromotionID, ownerID).Scan(&rule)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Promotion not found", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to save win-back settings", http.StatusInternalServerError)
+			return
+		}
+		if rule != singleUseRule {
+			http.Error(w, "Win-back codes need a single_use promotion", http.StatusBadRequest)
+			return
+		}
+	}
+	_, err := db.Exec(`
+        UPDATE owners SET winback_enabled = ?, winback_after_days = ?, winback_daily_limit = ?, winback_promotion_id = ?,
+            winback_code_days = ?, updated_at = ?
+        WHERE id = ?`, req.Enabled, req.AfterDays, req.DailyLimit, req.PromotionID, req.CodeValidDays, time.Now(), ownerID)
+	if err != nil {
+		http.Error(w, "Failed to save win-back settings", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- API: Win-back Report ---
+// Win-back messages sent between "start" and "end", and how many of those
+// customers came back within 30 days, what they spent and how many codes
+// were redeemed. Add format=csv to download the messages as a spreadsheet.
+func APIWinBackReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey)
+	var ownerID int
+	switch v := userID.(type) {
+	case int:
+		ownerID = v
+	case int64:
+		ownerID = int(v)
+	default:
+		http.Error(w, "Invalid user ID type", http.StatusInternalServerError)
+		return
+	}
+	start, end, ok := reportDateRange(r)
+	if !ok {
+		http.Error(w, "Dates must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	window := "+" + strconv.Itoa(winBackReturnDays) + " days"
+	rows, err := database.GetDB().Query(`
+        SELECT m.id, m.customer_id, c.name, substr(m.last_visit, 1, 10), substr(m.created_at, 1, 10),
+            COALESCE(m.channel, ''), COALESCE(pc.code, ''), pc.invoice_id IS NOT NULL,
+            (SELECT MIN(substr(i.invoice_date, 1, 10)) FROM invoices i
+             WHERE i.customer_id = m.customer_id AND substr(i.invoice_date, 1, 10) >= substr(m.created_at, 1, 10)
+                AND substr(i.invoice_date, 1, 10) <= date(substr(m.created_at, 1, 10), ?)),
+            (SELECT COALESCE(SUM(i.total_amount), 0) FROM invoices i
+             WHERE i.customer_id = m.customer_id AND substr(i.invoice_date, 1, 10) >= substr(m.created_at, 1, 10)
+                AND substr(i.invoice_date, 1, 10) <= date(substr(m.created_at, 1, 10), ?))
+        FROM winback_messages m
+        JOIN customers c ON m.customer_id = c.id
+        LEFT JOIN promotion_codes pc ON m.promotion_code_id = pc.id
+        WHERE m.owner_id = ? AND m.status = 'sent' AND substr(m.created_at, 1, 10) BETWEEN ? AND ?
+        ORDER BY m.created_at DESC`, window, window, ownerID, start, end)
+	if err != nil {
+		http.Error(w, "Failed to build win-back report", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	type messageRow struct {
+		ID           int64   `json:"id"`
+		CustomerID   int64   `json:"customer_id"`
+		CustomerName string  `json:"customer_name"`
+		LastVisit    string  `json:"last_visit"`
+		SentOn       string  `json:"sent_on"`
+		Channel      string  `json:"channel"`
+		Code         string  `json:"code,omitempty"`
+		CodeRedeemed bool    `json:"code_redeemed"`
+		ReturnedOn   string  `json:"returned_on,omitempty"`
+		Revenue      float64 `json:"revenue"`
+	}
+	var summary struct {
+		Sent          int     `json:"sent"`
+		Returned      int     `json:"returned"`
+		ReturnRate    float64 `json:"return_rate"`
+		CodesRedeemed int     `json:"codes_redeemed"`
+		Revenue       float64 `json:"revenue"`
+		Awaiting      int     `json:"awaiting"`
+	}
+	awaitingSince := time.Now().AddDate(0, 0, -winBackReturnDays).Format("2006-01-02")
+	messages := []messageRow{}
+	for rows.Next() {
+		var m messageRow
+		var returnedOn sql.NullString
+		if err := rows.Scan(&m.ID, &m.CustomerID, &m.CustomerName, &m.LastVisit, &m.SentOn, &m.Channel, &m.Code,
+			&m.CodeRedeemed, &returnedOn, &m.Revenue); err != nil {
+			log.Printf("Failed to scan win-back message: %v", err)
+			continue
+		}
+		m.ReturnedOn, m.Revenue = returnedOn.String, round2(m.Revenue)
+		summary.Sent++
+		switch {
+		case m.ReturnedOn != "":
+			summary.Returned++
+			summary.Revenue = round2(summary.Revenue + m.Revenue)
+		case m.SentOn > awaitingSince:
+			summary.Awaiting++
+		}
+		if m.CodeRedeemed {
+			summary.CodesRedeemed++
+		}
+		messages = append(messages, m)
+	}
+	if summary.Sent > 0 {
+		summary.ReturnRate = round2(float64(summary.Returned) * 100 / float64(summary.Sent))
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=winback_"+start+"_"+end+".csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"Customer", "Last visit", "Sent on", "Channel", "Code", "Code redeemed", "Returned on", "Revenue"})
+		for _, m := range messages {
+			redeemed := "No"
+			if m.CodeRedeemed {
+				redeemed = "Yes"
+			}
+			cw.Write([]string{m.CustomerName, m.LastVisit, m.SentOn, strings.ToUpper(m.Channel), m.Code, redeemed,
+				m.ReturnedOn, formatMoney(m.Revenue)})
+		}
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"start": start, "end": end, "summary": summary, "messages": messages})
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWinBackOffer(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *promotion
+		code string
+		want string
+	}{
+		{"percentage", &promotion{Kind: "percentage", Value: 15}, "WB-AAAA", " Use code WB-AAAA for 15% off your next visit by Mon 30 Nov."},
+		{"amount", &promotion{Kind: "fixed", Value: 10}, "WB-AAAA", " Use code WB-AAAA for 10.00 off your next visit by Mon 30 Nov."},
+		{"no promotion", nil, "", ""},
+		{"code not issued", &promotion{Kind: "percentage", Value: 15}, "", ""},
+	}
+	for _, tt := range tests {
+		if got := winBackOffer(tt.p, tt.code, "Mon 30 Nov"); got != tt.want {
+			t.Errorf("%s: winBackOffer() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSendWinBackMessages(t *testing.T) {
+	db := openTestDB(t)
+	// Monday 2 November 2026 at 11:00; customers lapse after 90 days, i.e.
+	// with no visit since 4 August.
+	now := time.Date(2026, 11, 2, 11, 0, 0, 0, time.UTC)
+	mustExec(t, db,
+		`INSERT INTO owners (id, email, password_hash, timezone, winback_enabled, winback_after_days) VALUES (1, 'a@example.com', 'x', 'UTC', 1, 90)`,
+		`INSERT INTO customers (id, owner_id, name) VALUES
+			(1, 1, 'Lapsed'), (2, 1, 'Recent'), (3, 1, 'Opted Out'), (4, 1, 'Booked'), (5, 1, 'Messaged'), (6, 1, 'Back Again')`,
+		`INSERT INTO customer_consents (owner_id, customer_id, channel, status, source) VALUES (1, 3, 'email', 'opted_out', 'front_desk')`,
+		`INSERT INTO invoices (owner_id, customer_id, invoice_date, total_amount, payment_status) VALUES
+			(1, 1, '2026-07-01', 50, 'Paid'), (1, 1, '2026-05-01', 50, 'Paid'),
+			(1, 2, '2026-07-01', 50, 'Paid'), (1, 2, '2026-10-01', 50, 'Paid'),
+			(1, 3, '2026-06-15', 50, 'Paid'),
+			(1, 4, '2026-07-01', 50, 'Paid'),
+			(1, 5, '2026-07-01', 50, 'Paid'),
+			(1, 6, '2026-03-01', 50, 'Paid'), (1, 6, '2026-06-01', 50, 'Paid')`,
+		`INSERT INTO appointments (owner_id, customer_id, staff_id, service_id, starts_at, ends_at) VALUES (1, 4, 1, 1, '2026-11-20 10:00', '2026-11-20 11:00')`,
+		`INSERT INTO winback_messages (owner_id, customer_id, last_visit, status) VALUES (1, 5, '2026-07-01', 'sent'), (1, 6, '2026-03-01', 'sent')`,
+	)
+	email, _ := encryptField("opted.out@example.com")
+	if _, err := db.Exec("UPDATE customers SET email = ? WHERE id = 3", email); err != nil {
+		t.Fatal(err)
+	}
+	messaged := func() string {
+		rows, err := db.Query("SELECT customer_id, last_visit, status, error FROM winback_messages WHERE id > 2 ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var got []string
+		for rows.Next() {
+			var customerID, lastVisit, status, errText string
+			rows.Scan(&customerID, &lastVisit, &status, &errText)
+			got = append(got, customerID+" "+lastVisit[:10]+" "+status+" ("+errText+")")
+		}
+		return strings.Join(got, ", ")
+	}
+
+	sendWinBackMessages(db, now.Add(-3*time.Hour))
+	if got := messaged(); got != "" {
+		t.Errorf("messages sent at 08:00 = %q, want none before %d:00", got, winBackSendFrom)
+	}
+	// No customer can be reached here, so messages are skipped rather than
+	// sent.
+	sendWinBackMessages(db, now)
+	want := "1 2026-07-01 skipped (Customer has no phone number or email), " +
+		"3 2026-06-15 skipped (Customer opted out of messages), " +
+		"6 2026-06-01 skipped (Customer has no phone number or email)"
+	if got := messaged(); got != want {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+}
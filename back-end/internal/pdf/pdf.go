@@ -0,0 +1,259 @@
+// internal/pdf/pdf.go
+// A small, dependency-free PDF writer used for receipts and other printable
+// documents. It only supports what the app needs: the standard Helvetica
+// fonts, lines, filled rectangles and raster images on A4 pages.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"  // register GIF decoder for logos
+	_ "image/jpeg" // register JPEG decoder for logos
+	_ "image/png"  // register PNG decoder for logos
+	"io"
+	"strings"
+)
+
+// A4 page size in points.
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Document is a PDF being built page by page. Coordinates passed to the
+// drawing methods are in points measured from the top-left corner of the
+// page, which is easier to lay out than PDF's native bottom-left origin.
+type Document struct {
+	pages  []*bytes.Buffer
+	images [][]byte // encoded image XObject dictionaries + streams
+	bold   bool
+	size   float64
+}
+
+// New returns an empty document with one blank page.
+func New() *Document {
+	d := &Document{size: 10}
+	d.AddPage()
+	return d
+}
+
+// AddPage starts a new page; subsequent drawing goes to it.
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+// PageCount returns the number of pages started so far.
+func (d *Document) PageCount() int {
+	return len(d.pages)
+}
+
+func (d *Document) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// SetFont selects Helvetica (or Helvetica-Bold) at the given size.
+func (d *Document) SetFont(bold bool, size float64) {
+	d.bold = bold
+	d.size = size
+}
+
+// Text draws s with its baseline at (x, y).
+func (d *Document) Text(x, y float64, s string) {
+	font := "F1"
+	if d.bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n",
+		font, d.size, x, PageHeight-y, escape(encode(s)))
+}
+
+// TextRight draws s so that it ends at x.
+func (d *Document) TextRight(x, y float64, s string) {
+	d.Text(x-d.StringWidth(s), y, s)
+}
+
+// StringWidth returns the width of s in points using the current font.
+func (d *Document) StringWidth(s string) float64 {
+	widths := &helvetica
+	if d.bold {
+		widths = &helveticaBold
+	}
+	var w int
+	for _, b := range encode(s) {
+		if b >= 32 && b <= 126 {
+			w += widths[b-32]
+		} else {
+			w += 556
+		}
+	}
+	return float64(w) * d.size / 1000
+}
+
+// Line draws a thin line from (x1, y1) to (x2, y2).
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n",
+		x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// FillRect fills a rectangle with a gray level between 0 (black) and 1 (white).
+func (d *Document) FillRect(x, y, w, h, gray float64) {
+	fmt.Fprintf(d.page(), "q %.2f g %.2f %.2f %.2f %.2f re f Q\n",
+		gray, x, PageHeight-y-h, w, h)
+}
+
+// Image decodes a PNG, JPEG or GIF and draws it into the box at (x, y)
+// scaled to fit within maxW x maxH while keeping its aspect ratio. It
+// returns the width and height actually used.
+func (d *Document) Image(data []byte, x, y, maxW, maxH float64) (float64, float64, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	b := img.Bounds()
+	pw, ph := b.Dx(), b.Dy()
+	if pw == 0 || ph == 0 {
+		return 0, 0, fmt.Errorf("pdf: empty image")
+	}
+
+	// Flatten onto white; PDF soft masks are not worth the complexity here.
+	raw := make([]byte, 0, pw*ph*3)
+	for py := b.Min.Y; py < b.Max.Y; py++ {
+		for px := b.Min.X; px < b.Max.X; px++ {
+			c := color.NRGBAModel.Convert(img.At(px, py)).(color.NRGBA)
+			a := uint32(c.A)
+			raw = append(raw,
+				byte((uint32(c.R)*a+255*(255-a))/255),
+				byte((uint32(c.G)*a+255*(255-a))/255),
+				byte((uint32(c.B)*a+255*(255-a))/255))
+		}
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(raw)
+	zw.Close()
+
+	var obj bytes.Buffer
+	fmt.Fprintf(&obj, "<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n",
+		pw, ph, z.Len())
+	obj.Write(z.Bytes())
+	obj.WriteString("\nendstream")
+	d.images = append(d.images, obj.Bytes())
+
+	scale := maxW / float64(pw)
+	if s := maxH / float64(ph); s < scale {
+		scale = s
+	}
+	w, h := float64(pw)*scale, float64(ph)*scale
+	fmt.Fprintf(d.page(), "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n",
+		w, h, x, PageHeight-y-h, len(d.images))
+	return w, h, nil
+}
+
+// WriteTo serialises the document.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Fixed object numbers: 1 catalog, 2 page tree, 3-4 fonts, then images,
+	// then a page + content stream pair per page.
+	firstImage := 5
+	firstPage := firstImage + len(d.images)
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for _, img := range d.images {
+		obj(string(img))
+	}
+
+	var xobjects strings.Builder
+	for i := range d.images {
+		fmt.Fprintf(&xobjects, " /Im%d %d 0 R", i+1, firstImage+i)
+	}
+	resources := "<< /Font << /F1 3 0 R /F2 4 0 R >>"
+	if xobjects.Len() > 0 {
+		resources += " /XObject <<" + xobjects.String() + " >>"
+	}
+	resources += " >>"
+
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R >>",
+			PageWidth, PageHeight, resources, firstPage+2*i+1))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", p.Len(), p.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.WriteTo(w)
+}
+
+// Bytes returns the serialised document.
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	d.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// encode maps s to WinAnsi (Latin-1 for the printable range); characters
+// the standard fonts cannot show become '?'.
+func encode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			out = append(out, ' ')
+		case r >= 32 && r <= 126, r >= 0xA0 && r <= 0xFF:
+			out = append(out, byte(r))
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+func escape(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		if c == '\\' || c == '(' || c == ')' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// Glyph widths for characters 32-126, from the standard Adobe AFM files.
+var helvetica = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBold = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
@@ -0,0 +1,101 @@
+package pdf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Invoice #12", "Invoice #12"},
+		{"Café", "Caf\xe9"},
+		{"line\nbreak\ttab", "line break tab"},
+		{"₹100 – paid", "?100 ? paid"},
+	}
+	for _, tt := range tests {
+		if got := string(encode(tt.in)); got != tt.want {
+			t.Errorf("encode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if got, want := escape([]byte(`a (b) \c`)), `a \(b\) \\c`; got != want {
+		t.Errorf("escape() = %q, want %q", got, want)
+	}
+}
+
+func TestStringWidth(t *testing.T) {
+	d := New()
+	d.SetFont(false, 10)
+	// "Hi" is 722 + 222 thousandths of the font size in Helvetica.
+	if got := d.StringWidth("Hi"); got != 9.44 {
+		t.Errorf("StringWidth(Hi) = %v, want 9.44", got)
+	}
+	d.SetFont(true, 10)
+	if got := d.StringWidth("Hi"); got != 10 {
+		t.Errorf("bold StringWidth(Hi) = %v, want 10", got)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	d := New()
+	d.Text(50, 50, "Page (one)")
+	d.AddPage()
+	d.TextRight(500, 50, "Page two")
+
+	var img bytes.Buffer
+	m := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	m.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	if err := png.Encode(&img, m); err != nil {
+		t.Fatal(err)
+	}
+	w, h, err := d.Image(img.Bytes(), 10, 10, 100, 100)
+	if err != nil {
+		t.Fatalf("Image() error = %v", err)
+	}
+	if w != 100 || h != 50 {
+		t.Errorf("Image() size = %vx%v, want 100x50", w, h)
+	}
+	if _, _, err := d.Image([]byte("not an image"), 0, 0, 10, 10); err == nil {
+		t.Error("Image() accepted invalid data")
+	}
+
+	out := d.Bytes()
+	s := string(out)
+	if !strings.HasPrefix(s, "%PDF-1.4") || !strings.HasSuffix(s, "%%EOF\n") {
+		t.Fatal("missing PDF header or trailer")
+	}
+	if !strings.Contains(s, "/Count 2") {
+		t.Error("page tree doesn't count two pages")
+	}
+	if !strings.Contains(s, `(Page \(one\)) Tj`) {
+		t.Error("text wasn't escaped into the content stream")
+	}
+	if !strings.Contains(s, "/XObject << /Im1 5 0 R >>") {
+		t.Error("image isn't in the page resources")
+	}
+
+	// Every xref entry must point at the start of its object.
+	sx := regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(s)
+	if sx == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(sx[1])
+	entries := strings.Split(strings.TrimSpace(s[xref:strings.Index(s, "trailer")]), "\n")[3:]
+	for i, e := range entries {
+		off, _ := strconv.Atoi(e[:10])
+		if want := strconv.Itoa(i+1) + " 0 obj"; !strings.HasPrefix(s[off:], want) {
+			t.Errorf("xref entry %d points at %q, want %q", i+1, s[off:off+10], want)
+		}
+	}
+}
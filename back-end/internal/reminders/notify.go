@@ -0,0 +1,100 @@
+// internal/reminders/notify.go
+// Outbound SMS and email delivery shared by the reminder job and handlers.
+package reminders
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/twilio/twilio-go"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// SendSMS sends a text message through the salon's Twilio account, configured
+// with TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_PHONE_NUMBER.
+func SendSMS(to, body string) error {
+	client := twilio.NewRestClientWithParams(twilio.ClientParams{
+		Username: os.Getenv("TWILIO_ACCOUNT_SID"),
+		Password: os.Getenv("TWILIO_AUTH_TOKEN"),
+	})
+	params := &openapi.CreateMessageParams{}
+	params.SetTo(to)
+	params.SetFrom(os.Getenv("TWILIO_PHONE_NUMBER"))
+	params.SetBody(body)
+	_, err := client.Api.CreateMessage(params)
+	return err
+}
+
+// Attachment is a file sent along with an email.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// SendEmail sends a plain-text email over SMTP, configured with SMTP_HOST,
+// SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM.
+func SendEmail(to, subject, body string, attachments ...Attachment) error {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return errors.New("email is not configured (SMTP_HOST is not set)")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	username := os.Getenv("SMTP_USERNAME")
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = username
+	}
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	msg := buildEmail(from, to, subject, body, attachments)
+	return smtp.SendMail(host+":"+port, auth, from, []string{to}, msg)
+}
+
+// buildEmail assembles a MIME message, using multipart/mixed only when there
+// are attachments.
+func buildEmail(from, to, subject, body string, attachments []Attachment) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", " ")
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", clean.Replace(from))
+	fmt.Fprintf(&buf, "To: %s\r\n", clean.Replace(to))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", clean.Replace(subject)))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(attachments) == 0 {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		buf.WriteString(body)
+		return buf.Bytes()
+	}
+
+	boundary := fmt.Sprintf("salon-%d", time.Now().UnixNano())
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, body)
+	for _, a := range attachments {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s\r\n", a.ContentType)
+		fmt.Fprintf(&buf, "Content-Disposition: attachment; filename=%q\r\n", clean.Replace(a.Filename))
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		enc := base64.StdEncoding.EncodeToString(a.Data)
+		for len(enc) > 76 {
+			buf.WriteString(enc[:76] + "\r\n")
+			enc = enc[76:]
+		}
+		buf.WriteString(enc + "\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes()
+}
@@ -0,0 +1,83 @@
+package reminders
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestBuildEmailPlain(t *testing.T) {
+	raw := buildEmail("salon@example.com", "jane@example.com\r\nBcc: all@example.com", "Your receipt\nBcc: x", "Thanks!", nil)
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if bcc := msg.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("header injection added Bcc: %q", bcc)
+	}
+	if got := msg.Header.Get("To"); got != "jane@example.com Bcc: all@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Your receipt Bcc: x" {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+	if ct := msg.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(msg.Body)
+	if string(body) != "Thanks!" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestBuildEmailAttachment(t *testing.T) {
+	pdf := bytes.Repeat([]byte("%PDF-1.4 receipt "), 20)
+	raw := buildEmail("salon@example.com", "jane@example.com", "Receipt", "See attached.", []Attachment{
+		{Filename: "INV-0001.pdf", ContentType: "application/pdf", Data: pdf},
+	})
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q (%v)", msg.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := io.ReadAll(part)
+	if strings.TrimSpace(string(text)) != "See attached." {
+		t.Errorf("text part = %q", text)
+	}
+
+	part, err = mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part.FileName() != "INV-0001.pdf" {
+		t.Errorf("filename = %q", part.FileName())
+	}
+	encoded, _ := io.ReadAll(part)
+	for _, line := range strings.Split(strings.TrimSpace(string(encoded)), "\r\n") {
+		if len(line) > 76 {
+			t.Fatalf("base64 line of %d characters", len(line))
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || !bytes.Equal(data, pdf) {
+		t.Errorf("attachment didn't round-trip (%v)", err)
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expected two parts, got more (%v)", err)
+	}
+}
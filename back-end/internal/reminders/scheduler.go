@@ -8,94 +8,143 @@ import (
 	"strings"
 	"time"
 
-	"os"
 	"salon-management/internal/database"
-
-	"github.com/twilio/twilio-go"
-	openapi "github.com/twilio/twilio-go/rest/api/v2010"
 )
 
 // StartReminderService kicks off a daily check for upcoming events.
 func StartReminderService(db *sql.DB) {
 	// For demonstration, this ticker runs every minute.
 	// In production, it should be changed to run once a day. e.g., time.NewTicker(24 * time.Hour)
+	// Either way each greeting goes out once: sends are recorded in
+	// reminder_sends.
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
 	for range ticker.C {
 		log.Println("Running daily reminder check...")
-		checkAndSendReminders(database.GetDB())
+		checkAndSendReminders(database.GetDB(), time.Now())
 	}
 }
 
+// sendSMS sends a text; tests replace it.
+var sendSMS = SendSMS
+
+// upcomingEvent is a customer's birthday or anniversary due a reminder.
+type upcomingEvent struct {
+	customerID            int64
+	customerName          string
+	encryptedPhone        []byte
+	salonName             string
+	ownerID               int
+	birthday, anniversary sql.NullString
+}
+
 // checkAndSendReminders queries the DB for events and 'sends' reminders.
-func checkAndSendReminders(db *sql.DB) {
-	targetDate := time.Now().Add(7 * 24 * time.Hour).Format("01-02") // MM-DD
+func checkAndSendReminders(db *sql.DB, now time.Time) {
+	target := now.Add(7 * 24 * time.Hour)
+	targetDate := target.Format("01-02") // MM-DD
 
-	// Find customers with a birthday or anniversary on the target date
+	// Find customers with a birthday or anniversary on the target date who
+	// haven't opted out of texts
 	query := `
-        SELECT c.name, c.phone, o.salon_name, c.owner_id, c.birthday, c.anniversary
+        SELECT c.id, c.name, c.phone, o.salon_name, c.owner_id, c.birthday, c.anniversary
         FROM customers c
         JOIN owners o ON c.owner_id = o.id
-        WHERE strftime('%m-%d', c.birthday) = ? OR strftime('%m-%d', c.anniversary) = ?
+        WHERE (strftime('%m-%d', c.birthday) = ? OR strftime('%m-%d', c.anniversary) = ?)
+            AND NOT EXISTS (SELECT 1 FROM customer_consents cc
+                WHERE cc.customer_id = c.id AND cc.channel = 'sms' AND cc.status = 'opted_out')
     `
 	rows, err := db.Query(query, targetDate, targetDate)
 	if err != nil {
 		log.Printf("Error querying for reminders: %v", err)
 		return
 	}
-	defer rows.Close()
-
+	var events []upcomingEvent
 	for rows.Next() {
-		var customerName, phone, salonName string
-		var ownerID int
-		var birthday, anniversary string
-		if err := rows.Scan(&customerName, &phone, &salonName, &ownerID, &birthday, &anniversary); err != nil {
+		var e upcomingEvent
+		if err := rows.Scan(&e.customerID, &e.customerName, &e.encryptedPhone, &e.salonName, &e.ownerID, &e.birthday, &e.anniversary); err != nil {
 			log.Printf("Error scanning reminder data: %v", err)
 			continue
 		}
+		events = append(events, e)
+	}
+	rows.Close()
 
-		// Determine event type
-		eventType := ""
-		if birthday != "" && strings.HasPrefix(birthday, targetDate) {
-			eventType = "birthday"
-		} else if anniversary != "" && strings.HasPrefix(anniversary, targetDate) {
-			eventType = "anniversary"
-		} else {
-			eventType = "custom"
+	for _, e := range events {
+		// Phone numbers are stored encrypted
+		phone, err := database.DecryptField(e.encryptedPhone)
+		if err != nil || phone == "" {
+			continue
 		}
 
-		// Fetch the correct template for this owner and event type
-		var template string
-		err = db.QueryRow(
-			"SELECT template FROM reminder_templates WHERE owner_id = ? AND event_type = ?",
-			ownerID, eventType,
-		).Scan(&template)
-		if err != nil || template == "" {
-			// fallback to a default template if not found
-			template = "Dear [CustomerName], greetings from [SalonName] on your [Event]!"
+		for _, event := range []struct {
+			eventType string
+			date      sql.NullString
+		}{{"birthday", e.birthday}, {"anniversary", e.anniversary}} {
+			eventType := event.eventType
+			if monthDay(event.date.String) != targetDate {
+				continue
+			}
+			// Claim the greeting before sending so later checks skip it
+			res, err := db.Exec("INSERT OR IGNORE INTO reminder_sends (customer_id, event_type, year, sent_at) VALUES (?, ?, ?, ?)",
+				e.customerID, eventType, target.Year(), now)
+			if err != nil {
+				log.Printf("Error recording reminder: %v", err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				continue
+			}
+
+			// Fetch the correct template for this owner and event type
+			var template string
+			err = db.QueryRow(
+				"SELECT template FROM reminder_templates WHERE owner_id = ? AND event_type = ?",
+				e.ownerID, eventType,
+			).Scan(&template)
+			if err != nil || template == "" {
+				// fallback to a default template if not found
+				template = "Dear [CustomerName], greetings from [SalonName] on your [Event]!"
+			}
+
+			if err := sendTwilioReminder(phone, e.customerName, e.salonName, template); err != nil {
+				log.Printf("Twilio send error: %v", err)
+				// Let the next check try again
+				db.Exec("DELETE FROM reminder_sends WHERE customer_id = ? AND event_type = ? AND year = ?",
+					e.customerID, eventType, target.Year())
+			}
 		}
+	}
+}
 
-		sendTwilioReminder(phone, customerName, salonName, template)
+// monthDay returns the MM-DD of a YYYY-MM-DD date, or "" if it isn't one.
+func monthDay(date string) string {
+	if len(date) < 10 {
+		return ""
 	}
+	t, err := time.Parse("2006-01-02", date[:10])
+	if err != nil {
+		return ""
+	}
+	return t.Format("01-02")
 }
 
 // sendTwilioReminder sends an SMS or WhatsApp message using Twilio.
-func sendTwilioReminder(phone, customerName, salonName, template string) {
-	client := twilio.NewRestClientWithParams(twilio.ClientParams{
-		Username: os.Getenv("TWILIO_ACCOUNT_SID"),
-		Password: os.Getenv("TWILIO_AUTH_TOKEN"),
+func sendTwilioReminder(phone, customerName, salonName, template string) error {
+	message := FillTemplate(template, map[string]string{
+		"CustomerName": customerName,
+		"SalonName":    salonName,
+		"Event":        "special day",
 	})
-	message := strings.ReplaceAll(template, "[CustomerName]", customerName)
-	message = strings.ReplaceAll(message, "[SalonName]", salonName)
-	message = strings.ReplaceAll(message, "[Event]", "special day")
+	return sendSMS(phone, message)
+}
 
-	params := &openapi.CreateMessageParams{}
-	params.SetTo(phone)
-	params.SetFrom(os.Getenv("TWILIO_PHONE_NUMBER"))
-	params.SetBody(message)
-	_, err := client.Api.CreateMessage(params)
-	if err != nil {
-		log.Printf("Twilio send error: %v", err)
+// FillTemplate replaces the [Placeholder] fields of a message template, such
+// as [CustomerName] or [SalonName], with their values.
+func FillTemplate(template string, fields map[string]string) string {
+	var pairs []string
+	for name, value := range fields {
+		pairs = append(pairs, "["+name+"]", value)
 	}
+	return strings.NewReplacer(pairs...).Replace(template)
 }
@@ -0,0 +1,83 @@
+package reminders
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"salon-management/internal/database"
+)
+
+func TestCheckAndSendReminders(t *testing.T) {
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "salon.db") + "?_synchronous=OFF")
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer db.Close()
+	database.DecryptField = func(ciphertext []byte) (string, error) { return string(ciphertext), nil }
+	defer func() { database.DecryptField = nil }()
+
+	for _, stmt := range []string{
+		`INSERT INTO owners (id, email, password_hash, salon_name) VALUES (1, 'a@example.com', 'x', 'Shear Joy')`,
+		`INSERT INTO customers (id, owner_id, name, phone, birthday, anniversary) VALUES
+			(1, 1, 'Ann', CAST('+15550001111' AS BLOB), '1990-11-09', NULL),
+			(2, 1, 'Bea', CAST('+15550002222' AS BLOB), NULL, '2015-11-09'),
+			(3, 1, 'Cy', CAST('+15550003333' AS BLOB), '1992-11-09', NULL),
+			(4, 1, 'Di', NULL, '1988-11-09', NULL),
+			(5, 1, 'Ed', CAST('+15550005555' AS BLOB), '1990-11-10', NULL)`,
+		`INSERT INTO customer_consents (owner_id, customer_id, channel, status, source) VALUES (1, 3, 'sms', 'opted_out', 'sms_reply')`,
+		`INSERT INTO reminder_templates (owner_id, event_type, template) VALUES (1, 'anniversary', 'Happy anniversary [CustomerName]!')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	var sent []string
+	fail := false
+	sendSMS = func(to, body string) error {
+		if fail {
+			return errors.New("twilio down")
+		}
+		sent = append(sent, to+": "+body)
+		return nil
+	}
+	defer func() { sendSMS = SendSMS }()
+
+	now := time.Date(2026, 11, 2, 9, 0, 0, 0, time.UTC)
+	fail = true
+	checkAndSendReminders(db, now)
+	fail = false
+	checkAndSendReminders(db, now)
+	checkAndSendReminders(db, now.Add(time.Minute))
+	want := []string{
+		"+15550001111: Dear Ann, greetings from Shear Joy on your special day!",
+		"+15550002222: Happy anniversary Bea!",
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+
+	// The same day next year is a new greeting.
+	sent = nil
+	checkAndSendReminders(db, now.AddDate(1, 0, 0))
+	if len(sent) != 2 {
+		t.Errorf("next year sent %q, want 2 greetings", sent)
+	}
+}
+
+func TestMonthDay(t *testing.T) {
+	tests := map[string]string{
+		"1990-11-09":          "11-09",
+		"1990-11-09 00:00:00": "11-09",
+		"":                    "",
+		"11-09":               "",
+	}
+	for in, want := range tests {
+		if got := monthDay(in); got != want {
+			t.Errorf("monthDay(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -68,6 +68,7 @@ func main() {
 	if err != nil {
 		log.Println("Warning: .env file not loaded, relying on system environment variables")
 	}
+	handlers.LoadEncryptionKey()
 
 	// Initialize the database connection and run migrations
 	db, err := database.InitDB("salon.db")
@@ -81,6 +82,21 @@ func main() {
 	go reminders.StartReminderService(db)
 	log.Println("Reminder service started.")
 
+	// Start the background job that raises recurring membership invoices
+	go handlers.StartMembershipBilling(db)
+
+	// Start the background job that sends appointment confirmations and reminders
+	go handlers.StartAppointmentReminders(db)
+
+	// Start the background job that sends post-visit feedback surveys
+	go handlers.StartFeedbackRequests(db)
+
+	// Start the background job that messages lapsed customers
+	go handlers.StartWinBackCampaigns(db)
+
+	// Start the background job that sends broadcast campaigns
+	go handlers.StartCampaigns(db)
+
 	go func() {
 		for {
 			err := database.BackupDB()
@@ -99,7 +115,6 @@ func main() {
 	// --- Middleware ---
 	// Basic middleware for logging, panic recovery, and request IDs
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(60 * time.Second))
@@ -115,6 +130,28 @@ func main() {
 	r.Post("/api/login", handlers.Login)
 	// r.Get("/register", handlers.ShowRegisterPage)
 	r.Post("/api/register", handlers.Register)
+	r.Get("/receipts/{token}", handlers.ViewReceipt)
+
+	// Public online booking, scoped by the salon's booking slug
+	r.Get("/api/book/{slug}", handlers.BookingSalon)
+	r.Get("/api/book/{slug}/availability", handlers.BookingAvailability)
+	r.Post("/api/book/{slug}/verify", handlers.SendBookingCode)
+	r.Post("/api/book/{slug}/verify/{verificationID}", handlers.CheckBookingCode)
+	r.Post("/api/book/{slug}/appointments", handlers.CreateOnlineBooking)
+
+	// Slots offered to waitlisted customers, by the token in the offer link
+	r.Get("/waitlist/{token}", handlers.ViewWaitlistOffer)
+	r.Post("/waitlist/{token}/accept", handlers.AcceptWaitlistOffer)
+
+	// iCalendar subscription feeds, by the secret token in the feed URL
+	r.Get("/calendar/{token}.ics", handlers.ViewCalendarFeed)
+
+	// Post-visit feedback surveys, by the signed token in the survey link
+	r.Get("/feedback/{token}", handlers.ViewFeedbackSurvey)
+	r.Post("/feedback/{token}", handlers.SubmitFeedback)
+
+	// Incoming SMS from Twilio, e.g. replies to appointment reminders
+	r.Post("/webhooks/twilio/sms", handlers.TwilioIncomingSMS)
 	// r.Get("/api/logout", handlers.Logout)
 
 	// http.HandleFunc("/api/login", handlers.Login)
@@ -144,15 +181,184 @@ func main() {
 		// r.Get("/invoices", handlers.ShowInvoicesPage)
 		// r.Get("/invoices/new", handlers.ShowNewInvoicePage)
 		r.Post("/invoices", handlers.CreateInvoice)
+		r.Get("/api/invoices/{id}/pdf", handlers.APIInvoicePDF)
+		r.Post("/api/invoices/{id}/send", handlers.APISendInvoice)
+		r.Get("/api/invoices/{id}/deliveries", handlers.APIGetInvoiceDeliveries)
+		r.Put("/api/invoices/{id}/items/{itemID}/usage", handlers.APIUpdateInvoiceItemUsage)
 		// r.Get("/invoices/{id}", handlers.GetInvoiceDetails)
 
+		// Services and tax rates
+		r.Get("/api/services", handlers.APIGetServices)
+		r.Post("/api/services", handlers.APIAddService)
+		r.Put("/api/services/{id}", handlers.APIUpdateService)
+		r.Delete("/api/services/{id}", handlers.APIDeleteService)
+		r.Get("/api/services/{id}/recipe", handlers.APIGetServiceRecipe)
+		r.Put("/api/services/{id}/recipe", handlers.APIUpdateServiceRecipe)
+		r.Get("/api/services/{id}/resources", handlers.APIGetServiceResources)
+		r.Put("/api/services/{id}/resources", handlers.APIUpdateServiceResources)
+		r.Get("/api/tax-rates", handlers.APIGetTaxRates)
+		r.Post("/api/tax-rates", handlers.APIAddTaxRate)
+		r.Put("/api/tax-rates/{id}", handlers.APIUpdateTaxRate)
+		r.Delete("/api/tax-rates/{id}", handlers.APIDeleteTaxRate)
+		r.Post("/api/settings/tax", handlers.APIUpdateTaxSettings)
+
+		// Promotions
+		r.Get("/api/promotions", handlers.APIGetPromotions)
+		r.Post("/api/promotions", handlers.APIAddPromotion)
+		r.Put("/api/promotions/{id}", handlers.APIUpdatePromotion)
+		r.Delete("/api/promotions/{id}", handlers.APIDeletePromotion)
+
+		// Gift cards
+		r.Get("/api/gift-cards", handlers.APIGetGiftCards)
+		r.Get("/api/gift-cards/{code}", handlers.APIGetGiftCard)
+		r.Post("/api/gift-cards/{code}/transfer", handlers.APITransferGiftCard)
+
+		// Prepaid packages
+		r.Get("/api/packages", handlers.APIGetPackages)
+		r.Post("/api/packages", handlers.APIAddPackage)
+		r.Put("/api/packages/{id}", handlers.APIUpdatePackage)
+		r.Delete("/api/packages/{id}", handlers.APIDeletePackage)
+
+		// Memberships
+		r.Get("/api/membership-plans", handlers.APIGetMembershipPlans)
+		r.Post("/api/membership-plans", handlers.APIAddMembershipPlan)
+		r.Put("/api/membership-plans/{id}", handlers.APIUpdateMembershipPlan)
+		r.Delete("/api/membership-plans/{id}", handlers.APIDeleteMembershipPlan)
+		r.Get("/api/memberships", handlers.APIGetMemberships)
+		r.Post("/api/memberships", handlers.APIAddMembership)
+		r.Post("/api/memberships/{id}/{action}", handlers.APIChangeMembership)
+
+		// Retail products
+		r.Get("/api/products", handlers.APIGetProducts)
+		r.Post("/api/products", handlers.APIAddProduct)
+		r.Put("/api/products/{id}", handlers.APIUpdateProduct)
+		r.Delete("/api/products/{id}", handlers.APIDeleteProduct)
+		r.Post("/api/products/{id}/adjust", handlers.APIAdjustStock)
+		r.Get("/api/products/{id}/stock", handlers.APIGetStockMovements)
+
+		// Suppliers and purchase orders
+		r.Get("/api/suppliers", handlers.APIGetSuppliers)
+		r.Post("/api/suppliers", handlers.APIAddSupplier)
+		r.Put("/api/suppliers/{id}", handlers.APIUpdateSupplier)
+		r.Delete("/api/suppliers/{id}", handlers.APIDeleteSupplier)
+		r.Get("/api/purchase-orders", handlers.APIGetPurchaseOrders)
+		r.Post("/api/purchase-orders", handlers.APIAddPurchaseOrder)
+		r.Get("/api/purchase-orders/{id}", handlers.APIGetPurchaseOrder)
+		r.Put("/api/purchase-orders/{id}", handlers.APIUpdatePurchaseOrder)
+		r.Get("/api/purchase-orders/{id}/export", handlers.APIExportPurchaseOrder)
+		r.Post("/api/purchase-orders/{id}/receive", handlers.APIReceivePurchaseOrder)
+		r.Post("/api/purchase-orders/{id}/{action}", handlers.APIChangePurchaseOrder)
+
+		// Staff and commission
+		r.Get("/api/staff", handlers.APIGetStaff)
+		r.Post("/api/staff", handlers.APIAddStaff)
+		r.Put("/api/staff/{id}", handlers.APIUpdateStaff)
+		r.Delete("/api/staff/{id}", handlers.APIDeleteStaff)
+		r.Get("/api/resources", handlers.APIGetResources)
+		r.Post("/api/resources", handlers.APIAddResource)
+		r.Put("/api/resources/{id}", handlers.APIUpdateResource)
+		r.Delete("/api/resources/{id}", handlers.APIDeleteResource)
+		r.Get("/api/commission-rules", handlers.APIGetCommissionRules)
+		r.Post("/api/commission-rules", handlers.APIAddCommissionRule)
+		r.Put("/api/commission-rules/{id}", handlers.APIUpdateCommissionRule)
+		r.Delete("/api/commission-rules/{id}", handlers.APIDeleteCommissionRule)
+
+		// Scheduling and appointments
+		r.Get("/api/settings/hours", handlers.APIGetSalonHours)
+		r.Put("/api/settings/hours", handlers.APIUpdateSalonHours)
+		r.Get("/api/closures", handlers.APIGetClosures)
+		r.Post("/api/closures", handlers.APIAddClosure)
+		r.Delete("/api/closures/{id}", handlers.APIDeleteClosure)
+		r.Get("/api/staff/{id}/schedule", handlers.APIGetStaffSchedule)
+		r.Put("/api/staff/{id}/schedule", handlers.APIUpdateStaffSchedule)
+		r.Get("/api/staff/{id}/time-off", handlers.APIGetStaffTimeOff)
+		r.Post("/api/staff/{id}/time-off", handlers.APIAddStaffTimeOff)
+		r.Delete("/api/staff/{id}/time-off/{timeOffID}", handlers.APIDeleteStaffTimeOff)
+		r.Post("/api/staff/{id}/time-off/import", handlers.APIImportStaffTimeOff)
+		r.Post("/api/staff/{id}/calendar-feed", handlers.APIResetStaffCalendarFeed)
+		r.Delete("/api/staff/{id}/calendar-feed", handlers.APIDeleteStaffCalendarFeed)
+		r.Get("/api/calendar-feeds", handlers.APIGetCalendarFeeds)
+		r.Post("/api/calendar-feeds/salon", handlers.APIResetSalonCalendarFeed)
+		r.Delete("/api/calendar-feeds/salon", handlers.APIDeleteSalonCalendarFeed)
+		r.Get("/api/availability", handlers.APIGetAvailability)
+		r.Get("/api/settings/online-booking", handlers.APIGetOnlineBookingSettings)
+		r.Put("/api/settings/online-booking", handlers.APIUpdateOnlineBookingSettings)
+		r.Get("/api/appointments", handlers.APIGetAppointments)
+		r.Post("/api/appointments", handlers.APIAddAppointment)
+		r.Put("/api/appointments/{id}", handlers.APIUpdateAppointment)
+		r.Get("/api/appointment-series", handlers.APIGetAppointmentSeries)
+		r.Post("/api/appointment-series", handlers.APICreateAppointmentSeries)
+		r.Post("/api/appointment-series/{id}/cancel", handlers.APICancelAppointmentSeries)
+		r.Get("/api/appointments/{id}/notifications", handlers.APIGetAppointmentNotifications)
+		r.Post("/api/appointments/{id}/deposit", handlers.APITakeAppointmentDeposit)
+		r.Post("/api/appointments/{id}/{action}", handlers.APIChangeAppointment)
+		r.Get("/api/settings/appointment-reminders", handlers.APIGetAppointmentReminderSettings)
+		r.Put("/api/settings/appointment-reminders", handlers.APIUpdateAppointmentReminderSettings)
+		r.Get("/api/settings/reminder-templates", handlers.APIGetReminderTemplates)
+		r.Put("/api/settings/reminder-templates/{event}", handlers.APIUpdateReminderTemplate)
+		r.Get("/api/waitlist", handlers.APIGetWaitlist)
+		r.Post("/api/waitlist", handlers.APIAddWaitlistEntry)
+		r.Delete("/api/waitlist/{id}", handlers.APIDeleteWaitlistEntry)
+		r.Get("/api/waitlist/{id}/offers", handlers.APIGetWaitlistOffers)
+		r.Get("/api/walk-ins", handlers.APIGetWalkIns)
+		r.Post("/api/walk-ins", handlers.APIAddWalkIn)
+		r.Get("/api/walk-ins/stream", handlers.APIWalkInStream)
+		r.Post("/api/walk-ins/call-next", handlers.APICallNextWalkIn)
+		r.Post("/api/walk-ins/{id}/{action}", handlers.APIChangeWalkIn)
+		r.Get("/api/settings/waitlist", handlers.APIGetWaitlistSettings)
+		r.Put("/api/settings/waitlist", handlers.APIUpdateWaitlistSettings)
+		r.Get("/api/settings/cancellation-policy", handlers.APIGetCancellationPolicy)
+		r.Put("/api/settings/cancellation-policy", handlers.APIUpdateCancellationPolicy)
+		r.Get("/api/feedback", handlers.APIGetFeedback)
+		r.Get("/api/settings/feedback", handlers.APIGetFeedbackSettings)
+		r.Put("/api/settings/feedback", handlers.APIUpdateFeedbackSettings)
+		r.Get("/api/customers/{id}/attendance", handlers.APIGetCustomerAttendance)
+		r.Put("/api/customers/{id}/deposit-flag", handlers.APIUpdateCustomerDepositFlag)
+		r.Get("/api/settings/winback", handlers.APIGetWinBackSettings)
+		r.Put("/api/settings/winback", handlers.APIUpdateWinBackSettings)
+		r.Get("/api/customers/{id}/consent", handlers.APIGetCustomerConsent)
+		r.Put("/api/customers/{id}/consent", handlers.APIUpdateCustomerConsent)
+		r.Get("/api/consent-log", handlers.APIGetConsentLog)
+		r.Get("/api/customers/{id}/tags", handlers.APIGetCustomerTags)
+		r.Put("/api/customers/{id}/tags", handlers.APIUpdateCustomerTags)
+		r.Get("/api/customer-tags", handlers.APIGetTags)
+
+		// Campaigns
+		r.Get("/api/campaigns", handlers.APIGetCampaigns)
+		r.Post("/api/campaigns", handlers.APICreateCampaign)
+		r.Post("/api/campaigns/preview", handlers.APIPreviewCampaign)
+		r.Get("/api/campaigns/{id}", handlers.APIGetCampaign)
+		r.Put("/api/campaigns/{id}", handlers.APIUpdateCampaign)
+		r.Delete("/api/campaigns/{id}", handlers.APIDeleteCampaign)
+		r.Post("/api/campaigns/{id}/test", handlers.APITestCampaign)
+		r.Post("/api/campaigns/{id}/send", handlers.APISendCampaign)
+		r.Post("/api/campaigns/{id}/cancel", handlers.APICancelCampaign)
+		r.Get("/api/campaigns/{id}/recipients", handlers.APIGetCampaignRecipients)
+
+		// Loyalty
+		r.Get("/api/loyalty/settings", handlers.APIGetLoyaltySettings)
+		r.Put("/api/loyalty/settings", handlers.APIUpdateLoyaltySettings)
+		r.Get("/api/customers/{id}/loyalty", handlers.APIGetCustomerLoyalty)
+		r.Post("/api/customers/{id}/loyalty/adjust", handlers.APIAdjustCustomerLoyalty)
+
 		// Reporting
+		r.Get("/api/reports/tax", handlers.APITaxReport)
+		r.Get("/api/reports/nps", handlers.APINPSReport)
+		r.Get("/api/reports/stylist-satisfaction", handlers.APIStylistSatisfactionReport)
+		r.Get("/api/reports/promotions", handlers.APIPromotionReport)
+		r.Get("/api/reports/winback", handlers.APIWinBackReport)
+		r.Get("/api/reports/gift-cards", handlers.APIGiftCardReport)
+		r.Get("/api/reports/commission", handlers.APICommissionReport)
+		r.Get("/api/reports/reorder", handlers.APIReorderReport)
+		r.Get("/api/reports/service-margin", handlers.APIServiceMarginReport)
+		r.Get("/api/reports/no-shows", handlers.APINoShowReport)
 		// r.Get("/reports", handlers.ShowReportsPage)
 		// r.Post("/reports/generate", handlers.GenerateReport)
 
 		// // Settings for reminders
 		// r.Get("/settings", handlers.ShowSettingsPage)
 		r.Post("/settings/reminders", handlers.UpdateReminderTemplate)
+		r.Post("/api/settings/invoice", handlers.APIUpdateInvoiceSettings)
 
 		// Only admins can access sensitive reports
 		// r.With(handlers.AdminOnly).Get("/admin/reports", handlers.ShowAdminReports)
@@ -160,6 +366,7 @@ func main() {
 		// --- API Routes ---
 		r.Get("/api/customers", handlers.APIGetCustomers)
 		r.Post("/api/customers", handlers.APIAddCustomer)
+		r.Get("/api/customers/{id}", handlers.APIGetCustomer)
 		r.Put("/api/customers/{id}", handlers.APIUpdateCustomer)
 		r.Delete("/api/customers/{id}", handlers.APIDeleteCustomer)
 		// Add PUT for update if needed